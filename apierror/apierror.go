@@ -0,0 +1,149 @@
+package apierror
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"net/http"
+
+	"gopkg.in/olivere/elastic.v5"
+)
+
+/*
+Errors returned by the API all have the same body: a stable code clients can branch on, a message meant for people,
+the id of the request to quote when reporting a problem and, for validation errors, the fields that failed:
+
+	{"code": "validation_failed", "message": "...", "request_id": "...", "fields": [{"field": "email", "message": "..."}]}
+
+Errors that are not an *Error are never shown to clients as is, as their text may expose internals such as the
+queries sent to the databases. They are logged and answered with upstream_unavailable or internal.
+*/
+
+// Error codes
+const (
+	NotFound            = "not_found"
+	ValidationFailed    = "validation_failed"
+	Conflict            = "conflict"
+	Unauthorized        = "unauthorized"
+	MethodNotAllowed    = "method_not_allowed"
+	PayloadTooLarge     = "payload_too_large"
+	UpstreamUnavailable = "upstream_unavailable"
+	Internal            = "internal"
+)
+
+// Codes lists the error codes
+var Codes = []string{NotFound, ValidationFailed, Conflict, Unauthorized, MethodNotAllowed, PayloadTooLarge, UpstreamUnavailable, Internal}
+
+// FieldError tells why the value of a request field or param is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is an error with the code and message to answer the request with
+type Error struct {
+	Code    string
+	Message string
+	Fields  []FieldError
+	// Cause is the error behind an upstream_unavailable or internal error, it is logged but not returned
+	Cause error
+}
+
+func (e *Error) Error() string {
+	if e.Cause != nil {
+		return e.Message + ": " + e.Cause.Error()
+	}
+	return e.Message
+}
+
+// Unwrap returns the cause of the error
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// Status returns the HTTP status code of the error
+func (e *Error) Status() int {
+	switch e.Code {
+	case NotFound:
+		return http.StatusNotFound
+	case ValidationFailed:
+		return http.StatusBadRequest
+	case Conflict:
+		return http.StatusConflict
+	case Unauthorized:
+		return http.StatusUnauthorized
+	case MethodNotAllowed:
+		return http.StatusMethodNotAllowed
+	case PayloadTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case UpstreamUnavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// CodeForStatus returns the code of the errors answered with an HTTP status code
+func CodeForStatus(status int) string {
+	switch {
+	case status == http.StatusNotFound:
+		return NotFound
+	case status == http.StatusConflict:
+		return Conflict
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return Unauthorized
+	case status == http.StatusMethodNotAllowed:
+		return MethodNotAllowed
+	case status == http.StatusRequestEntityTooLarge:
+		return PayloadTooLarge
+	case status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout:
+		return UpstreamUnavailable
+	case status >= 500:
+		return Internal
+	}
+	return ValidationFailed
+}
+
+// New returns an error with a code and a message
+func New(code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Validation returns a validation_failed error on the given fields
+func Validation(message string, fields ...FieldError) *Error {
+	return &Error{Code: ValidationFailed, Message: message, Fields: fields}
+}
+
+// Field returns the error of one field
+func Field(field, message string) FieldError {
+	return FieldError{Field: field, Message: message}
+}
+
+// From returns err as an *Error. Errors of unreachable or failing databases become upstream_unavailable
+// and any other error internal.
+func From(err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	if isUpstream(err) {
+		return &Error{Code: UpstreamUnavailable, Message: "a database the service depends on is unavailable, retry later", Cause: err}
+	}
+	return &Error{Code: Internal, Message: "Internal server error", Cause: err}
+}
+
+// isUpstream reports whether err comes from a database that cannot be reached or cannot serve the request right now
+func isUpstream(err error) bool {
+	if elastic.IsConnErr(err) || elastic.IsTimeout(err) || errors.Is(err, driver.ErrBadConn) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var elasticErr *elastic.Error
+	if errors.As(err, &elasticErr) {
+		return elasticErr.Status == http.StatusTooManyRequests || elasticErr.Status >= 500
+	}
+	return false
+}
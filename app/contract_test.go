@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/raazcrzy/imdb/openapi"
+	"github.com/raazcrzy/imdb/utils"
+)
+
+// contractStep is a request of the contract check. endpoint is the route it is sent to, as registered,
+// and status the status code it must get. {movie_id} in the path or body is replaced with the id of a stored movie.
+type contractStep struct {
+	endpoint  string
+	path      string
+	body      string
+	anonymous bool
+	status    int
+}
+
+// contractSteps cover the users and movies routes, which are the ones available without postgres and elasticsearch
+var contractSteps = []contractStep{
+	{endpoint: "GET /v1/openapi.json", path: "/v1/openapi.json", anonymous: true, status: 200},
+	{endpoint: "POST /v2/users", path: "/v2/users", status: 201,
+		body: `{"email": "contract@example.com", "name": "Contract", "user_name": "contract", "user_password": "secret"}`},
+	{endpoint: "POST /v2/users", path: "/v2/users", status: 409,
+		body: `{"email": "contract@example.com", "name": "Contract", "user_name": "contract", "user_password": "secret"}`},
+	{endpoint: "POST /v1/add/user", path: "/v1/add/user", status: 400, body: `{"email": "not an email", "role": "owner"}`},
+	{endpoint: "POST /v2/movies", path: "/v2/movies", status: 201,
+		body: `{"name": "Star Wars", "director": "George Lucas", "genre": ["Sci-Fi", "Action"], "imdb_score": 8.8, "99popularity": 88}`},
+	{endpoint: "POST /v1/add/movie", path: "/v1/add/movie", status: 201,
+		body: `{"name": "Psycho", "director": "Alfred Hitchcock", "genre": ["Horror"], "imdb_score": 8.5, "99popularity": 85}`},
+	{endpoint: "POST /v2/movies", path: "/v2/movies", status: 400, body: `{"name": "Psycho", "year": 1960}`},
+	{endpoint: "POST /v2/movies", path: "/v2/movies", anonymous: true, status: 401, body: `{}`},
+	{endpoint: "GET /v2/movies", path: "/v2/movies?name=star&facets=true&highlight=true&explain=true", status: 200},
+	{endpoint: "GET /v1/get/movie", path: "/v1/get/movie?genre=Horror&size=1", status: 200},
+	{endpoint: "GET /v1/get/movie", path: "/v1/get/movie?q=score>=x", status: 400},
+	{endpoint: "GET /v2/movies/:id", path: "/v2/movies/{movie_id}", status: 200},
+	{endpoint: "POST /graphql", path: "/graphql", status: 200,
+		body: `{"query": "query($first: Int) { movies(filter: {genres: [\"Sci-Fi\"]}, first: $first) { totalCount nodes { id name directedBy { movies(first: 5) { totalCount } } } pageInfo { endCursor hasNextPage } } }", "variables": {"first": 1}}`},
+	{endpoint: "POST /graphql", path: "/graphql", status: 200,
+		body: `{"query": "mutation { addMovie(input: {name: \"Vertigo\", director: \"Alfred Hitchcock\", genres: [\"Mystery\"]}) { id } deleteMovie(id: \"missing\") }"}`},
+	{endpoint: "GET /graphql", path: "/graphql?query=%7Bme%7Bemail%20role%7D%20movie(id:%22{movie_id}%22)%7Bname%7D%7D", status: 200},
+	{endpoint: "POST /graphql", path: "/graphql", status: 400, body: `{"variables": {}}`},
+	{endpoint: "GET /v2/movies/:id", path: "/v2/movies/missing", status: 404},
+	{endpoint: "PUT /v2/movies/:id", path: "/v2/movies/{movie_id}", status: 200,
+		body: `{"name": "Star Wars: A New Hope", "director": "George Lucas", "genre": ["Sci-Fi"], "imdb_score": 8.6, "99popularity": 90}`},
+	{endpoint: "PUT /v1/update/movie", path: "/v1/update/movie", status: 200,
+		body: `{"movie_id": "{movie_id}", "name": "Star Wars", "director": "George Lucas", "genre": ["Sci-Fi"], "imdb_score": 8.6, "99popularity": 90}`},
+	{endpoint: "PUT /v2/movies/:id", path: "/v2/movies/missing", status: 404,
+		body: `{"name": "Missing", "director": "Nobody", "genre": ["Drama"]}`},
+	{endpoint: "DELETE /v2/movies/:id", path: "/v2/movies/{movie_id}", status: 200},
+	{endpoint: "DELETE /v1/remove/movie", path: "/v1/remove/movie?movie_id={movie_id}", status: 404},
+	{endpoint: "DELETE /v2/users/:email", path: "/v2/users/contract@example.com", status: 200},
+	{endpoint: "DELETE /v1/remove/user", path: "/v1/remove/user", status: 400, body: `{"email": ""}`},
+}
+
+// newTestServer returns a server keeping users and movies in memory.
+// The admin created by newStores signs in as foox:barx.
+func newTestServer(t *testing.T) *server {
+	t.Helper()
+	emailKey, categoryKey = "email", "category"
+	utils.UserStorage, utils.MovieStorage = utils.MemoryStorage, utils.MemoryStorage
+	utils.Admins = []string{"admin@example.com"}
+	utils.CursorSecret = "test"
+	Log.SetOutput(ioutil.Discard)
+	return newServer(newStores())
+}
+
+// newTestRoutes returns the routes of a test server, along with their OpenAPI document
+func newTestRoutes(t *testing.T) (http.Handler, *openapi.Document) {
+	router, spec := newRoutes(newTestServer(t))
+	return withRequestID(router), spec
+}
+
+// TestContract sends the contract steps to a server keeping users and movies in memory, and checks every response
+// against the status of its step and the OpenAPI document
+func TestContract(t *testing.T) {
+	routes, spec := newTestRoutes(t)
+	movieID := ""
+	for _, step := range contractSteps {
+		path := strings.Replace(step.path, "{movie_id}", movieID, -1)
+		body := strings.Replace(step.body, "{movie_id}", movieID, -1)
+		parts := strings.SplitN(step.endpoint, " ", 2)
+		r := httptest.NewRequest(parts[0], path, strings.NewReader(body))
+		if !step.anonymous {
+			r.SetBasicAuth("foox", "barx")
+		}
+		w := httptest.NewRecorder()
+		routes.ServeHTTP(w, r)
+
+		for _, problem := range checkContract(spec, step, w) {
+			t.Errorf("%s %s: %s", parts[0], path, problem)
+		}
+		if movieID == "" {
+			var result struct {
+				Movies []struct {
+					ID string `json:"movie_id"`
+				} `json:"movies"`
+			}
+			if json.Unmarshal(w.Body.Bytes(), &result) == nil && len(result.Movies) > 0 {
+				movieID = result.Movies[0].ID
+			}
+		}
+	}
+}
+
+// checkContract returns how the response to a contract step differs from the expected status and the document
+func checkContract(spec *openapi.Document, step contractStep, w *httptest.ResponseRecorder) []string {
+	parts := strings.SplitN(step.endpoint, " ", 2)
+	operation := spec.Paths[openAPIPath(parts[1])][strings.ToLower(parts[0])]
+	if operation == nil {
+		return []string{step.endpoint + " is not in the document"}
+	}
+	problems := []string{}
+	if w.Code != step.status {
+		problems = append(problems, fmt.Sprintf("status %d, expected %d: %s", w.Code, step.status, strings.TrimSpace(w.Body.String())))
+	}
+	response, ok := operation.Responses[strconv.Itoa(w.Code)]
+	if !ok {
+		return append(problems, fmt.Sprintf("status %d (%s) is not documented", w.Code, http.StatusText(w.Code)))
+	}
+	var body interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &body)
+	if err != nil {
+		return append(problems, "body is not JSON: "+err.Error())
+	}
+	return append(problems, spec.Validate(response.Content["application/json"].Schema, body)...)
+}
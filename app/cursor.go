@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/raazcrzy/imdb/utils"
+)
+
+/*
+Search cursors let clients page through results deeper than from/size allows.
+A cursor holds the sort values of the last hit of a page, which are passed to search_after
+to fetch the next one, along with a fingerprint of the search params it was issued for.
+It is signed with CursorSecret so that clients cannot forge or alter it.
+*/
+
+// cursorIgnoredParams are the search params that may change between the pages of a cursor
+var cursorIgnoredParams = []string{"cursor", "from", "size", "facets", "highlight", "explain"}
+
+type searchCursor struct {
+	SortValues  []interface{} `json:"s"`
+	Fingerprint string        `json:"f"`
+}
+
+// searchFingerprint identifies the query and sort order of a search
+func searchFingerprint(params url.Values) string {
+	values := url.Values{}
+	for key, value := range params {
+		values[key] = value
+	}
+	for _, key := range cursorIgnoredParams {
+		values.Del(key)
+	}
+	sum := sha256.Sum256([]byte(values.Encode()))
+	return hex.EncodeToString(sum[:8])
+}
+
+// encodeCursor returns the signed token for the sort values of the last hit of a page
+func encodeCursor(sortValues []interface{}, fingerprint string) (string, error) {
+	payload, err := json.Marshal(searchCursor{SortValues: sortValues, Fingerprint: fingerprint})
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + signCursor(encoded), nil
+}
+
+// decodeCursor verifies a cursor token and returns the sort values to search after
+func decodeCursor(token, fingerprint string) ([]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(signCursor(parts[0]))) {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	cursor := searchCursor{}
+	d := json.NewDecoder(strings.NewReader(string(payload)))
+	d.UseNumber()
+	err = d.Decode(&cursor)
+	if err != nil || len(cursor.SortValues) == 0 {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	if cursor.Fingerprint != fingerprint {
+		return nil, fmt.Errorf("cursor was issued for different search params")
+	}
+	return cursor.SortValues, nil
+}
+
+func signCursor(encoded string) string {
+	mac := hmac.New(sha256.New, []byte(utils.CursorSecret))
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
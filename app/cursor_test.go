@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/raazcrzy/imdb/utils"
+)
+
+// signed returns a token of the payload with a valid signature
+func signed(payload string) string {
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return encoded + "." + signCursor(encoded)
+}
+
+// flipSignatureByte changes the first character of the signature of a token to another one of the alphabet
+func flipSignatureByte(token string) string {
+	dot := strings.Index(token, ".")
+	flipped := byte('A')
+	if token[dot+1] == 'A' {
+		flipped = 'B'
+	}
+	return token[:dot+1] + string(flipped) + token[dot+2:]
+}
+
+func TestCursor(t *testing.T) {
+	utils.CursorSecret = "test"
+	horror := searchFingerprint(url.Values{"genre": {"Horror"}, "sort": {"imdb_score:desc"}})
+	drama := searchFingerprint(url.Values{"genre": {"Drama"}, "sort": {"imdb_score:desc"}})
+	sortValues := []interface{}{8.5, "Psycho"}
+	token, err := encodeCursor(sortValues, horror)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.CursorSecret = "another"
+	foreign, err := encodeCursor(sortValues, horror)
+	utils.CursorSecret = "test"
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		token       string
+		fingerprint string
+		err         string
+	}{
+		{"round trip", token, horror, ""},
+		{"flipped signature byte", flipSignatureByte(token), horror, "invalid cursor"},
+		{"signature of another secret", foreign, horror, "invalid cursor"},
+		{"altered payload", signed(`{"s":[9.9,"Psycho"],"f":"` + horror + `"}`)[:10] + token[10:], horror, "invalid cursor"},
+		{"different filters", token, drama, "cursor was issued for different search params"},
+		{"no signature", strings.Split(token, ".")[0], horror, "invalid cursor"},
+		{"extra part", token + ".x", horror, "invalid cursor"},
+		{"malformed base64", "!!!." + signCursor("!!!"), horror, "invalid cursor"},
+		{"payload not JSON", signed("Psycho"), horror, "invalid cursor"},
+		{"no sort values", signed(`{"s":[],"f":"` + horror + `"}`), horror, "invalid cursor"},
+		{"empty", "", horror, "invalid cursor"},
+	}
+	for _, test := range tests {
+		values, err := decodeCursor(test.token, test.fingerprint)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: got %v, want %s", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		// numbers come back as json.Number, to keep the precision of long sort values
+		if want := []interface{}{json.Number("8.5"), "Psycho"}; !reflect.DeepEqual(values, want) {
+			t.Errorf("%s: got %#v, want %#v", test.name, values, want)
+		}
+	}
+}
+
+func TestSearchFingerprint(t *testing.T) {
+	base := searchFingerprint(url.Values{"genre": {"Horror", "Thriller"}, "sort": {"name:asc"}})
+	tests := []struct {
+		name   string
+		params url.Values
+		same   bool
+	}{
+		{"page params", url.Values{"genre": {"Horror", "Thriller"}, "sort": {"name:asc"}, "cursor": {"c"}, "from": {"10"},
+			"size": {"5"}, "facets": {"true"}, "highlight": {"true"}, "explain": {"true"}}, true},
+		{"another filter", url.Values{"genre": {"Horror", "Thriller"}, "sort": {"name:asc"}, "director": {"hitchcock"}}, false},
+		{"another genre", url.Values{"genre": {"Horror", "Drama"}, "sort": {"name:asc"}}, false},
+		{"another sort", url.Values{"genre": {"Horror", "Thriller"}, "sort": {"name:desc"}}, false},
+	}
+	for _, test := range tests {
+		if same := searchFingerprint(test.params) == base; same != test.same {
+			t.Errorf("%s: got the same fingerprint %v, want %v", test.name, same, test.same)
+		}
+	}
+}
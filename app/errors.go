@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"regexp"
+
+	"github.com/raazcrzy/imdb/apierror"
+)
+
+// requestIDHeader carries the id of a request, set by withRequestID on every response and quoted in error bodies
+const requestIDHeader = "X-Request-ID"
+
+var requestIDRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// withRequestID gives every request an id, the one sent by the client in X-Request-ID when it is valid
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !requestIDRegexp.MatchString(id) {
+			random := make([]byte, 16)
+			_, err := rand.Read(random)
+			if err != nil {
+				Log.Errorln(err)
+			}
+			id = hex.EncodeToString(random)
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeError writes the body of an API error, the cause of upstream and internal errors is only logged
+func writeError(w http.ResponseWriter, apiErr *apierror.Error, extra map[string]interface{}) {
+	requestID := w.Header().Get(requestIDHeader)
+	if apiErr.Cause != nil {
+		Log.Errorln("request", requestID, apiErr.Code, apiErr.Cause)
+	}
+	body := map[string]interface{}{}
+	for key, value := range extra {
+		body[key] = value
+	}
+	body["code"] = apiErr.Code
+	body["message"] = apiErr.Message
+	body["request_id"] = requestID
+	if len(apiErr.Fields) > 0 {
+		body["fields"] = apiErr.Fields
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.Status())
+	json.NewEncoder(w).Encode(body)
+}
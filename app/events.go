@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/raazcrzy/imdb/apierror"
+	"github.com/raazcrzy/imdb/dbConnections"
+	"github.com/raazcrzy/imdb/models"
+	"github.com/raazcrzy/imdb/utils"
+)
+
+const (
+	// eventsBatch is the number of events the stream reads from the event store at a time
+	eventsBatch = 100
+	// eventsPoll is how often the stream looks for the events recorded by other servers
+	eventsPoll = time.Second
+	// eventsKeepAlive is how often an idle stream sends a comment, so that proxies do not close it
+	eventsKeepAlive = 15 * time.Second
+)
+
+// eventNotifier wakes the /v1/events streams when the server records an event
+type eventNotifier struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+func newEventNotifier() *eventNotifier {
+	return &eventNotifier{ch: make(chan struct{})}
+}
+
+// wait returns a channel closed by the next notify
+func (n *eventNotifier) wait() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.ch
+}
+
+func (n *eventNotifier) notify() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	close(n.ch)
+	n.ch = make(chan struct{})
+}
+
+// movieEventRecorded wakes the /v1/events streams and the webhook worker once a movie write committed, along with
+// its event
+func (s *server) movieEventRecorded() {
+	s.notifier.notify()
+	if utils.MoviesInPostgres() {
+		dbConnections.WakeWebhooks()
+	}
+}
+
+// eventsHandler streams the movie events as Server-Sent Events. The stream starts after the event of the
+// Last-Event-ID header, sent by EventSource clients when they reconnect, or of the last_event_id param, and with
+// the events recorded from then on when there is neither. The type param, repeated, keeps the events of these types.
+func (s *server) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	var returnMsg map[string]interface{}
+	var err error
+	_, reqCategory, ok, err := basicAuth(r)
+	if err != nil {
+		Log.Errorln(err)
+		returnMsg = map[string]interface{}{
+			"message": "Internal server error",
+			"status":  http.StatusInternalServerError,
+		}
+		writeBack(w, returnMsg, err)
+		return
+	}
+	if ok {
+		if reqCategory != "users" {
+			returnMsg := map[string]interface{}{
+				"message": "Unauthorized",
+				"status":  http.StatusUnauthorized,
+			}
+			writeBack(w, returnMsg, nil)
+			return
+		}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeBack(w, nil, fmt.Errorf("%T cannot stream", w))
+		return
+	}
+	known := map[string]bool{}
+	for _, eventType := range models.MovieEventTypes {
+		known[eventType] = true
+	}
+	types := map[string]bool{}
+	for _, eventType := range r.URL.Query()["type"] {
+		if !known[eventType] {
+			writeBack(w, nil, apierror.Validation("unknown event type "+eventType, apierror.Field("type", "must be one of: "+strings.Join(models.MovieEventTypes, ", "))))
+			return
+		}
+		types[eventType] = true
+	}
+	lastID, err := s.lastEventID(r)
+	if err != nil {
+		writeBack(w, nil, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// keeps nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	// EventSource clients reconnect after 3 seconds
+	fmt.Fprint(w, "retry: 3000\n\n")
+	flusher.Flush()
+
+	poll := time.NewTicker(eventsPoll)
+	defer poll.Stop()
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		// taken before reading, so that an event recorded meanwhile is not waited for
+		recorded := s.notifier.wait()
+		events, err := s.events.EventsAfter(lastID, eventsBatch)
+		if err != nil {
+			Log.Errorln("cannot read the movie events after", lastID, ":", err)
+			return
+		}
+		for _, event := range events {
+			lastID = event.ID
+			if len(types) > 0 && !types[event.Type] {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				Log.Errorln(err)
+				return
+			}
+			_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+			if err != nil {
+				return
+			}
+		}
+		if len(events) > 0 {
+			flusher.Flush()
+			keepAlive.Reset(eventsKeepAlive)
+		}
+		if len(events) == eventsBatch {
+			continue
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-recorded:
+		case <-poll.C:
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// lastEventID returns the id of the event a stream starts after
+func (s *server) lastEventID(r *http.Request) (int64, error) {
+	value, field := r.Header.Get("Last-Event-ID"), "Last-Event-ID"
+	if value == "" {
+		value, field = r.URL.Query().Get("last_event_id"), "last_event_id"
+	}
+	if value == "" {
+		return s.events.LastEventID()
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id < 0 {
+		return 0, apierror.Validation(field+" must be an event id", apierror.Field(field, "must be an event id"))
+	}
+	return id, nil
+}
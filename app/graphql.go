@@ -0,0 +1,479 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/raazcrzy/imdb/apierror"
+	"github.com/raazcrzy/imdb/graphql"
+	"github.com/raazcrzy/imdb/models"
+	"github.com/raazcrzy/imdb/store"
+	"github.com/raazcrzy/imdb/validation"
+)
+
+// graphqlMaxDepth and graphqlMaxComplexity bound the work of a /graphql request. The complexity counts the fields
+// of a page once per movie, movies(first: 100) { nodes { 8 fields } } is 1 + 100 * 9 = 901.
+const (
+	graphqlMaxDepth      = 8
+	graphqlMaxComplexity = 1000
+)
+
+// graphqlBody is the request body of POST /graphql
+type graphqlBody struct {
+	Query         string                 `json:"query" validate:"required"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+	Extensions    map[string]interface{} `json:"extensions"`
+}
+
+// viewer is the user a /graphql request is made by, passed to the resolvers in the request context
+type viewer struct {
+	email    string
+	userName string
+}
+
+type viewerKey struct{}
+
+func viewerFrom(ctx context.Context) viewer {
+	v, _ := ctx.Value(viewerKey{}).(viewer)
+	return v
+}
+
+// graphqlHandler executes the GraphQL query of a POST body, or of the query, operationName and variables URL params
+// of a GET request, which cannot run mutations. GraphQL errors are part of a 200 response, only requests without a query
+// get an error body.
+func (s *server) graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	var returnMsg map[string]interface{}
+	var err error
+	email, reqCategory, ok, err := basicAuth(r)
+	if err != nil {
+		Log.Errorln(err)
+		returnMsg = map[string]interface{}{
+			"message": "Internal server error",
+			"status":  http.StatusInternalServerError,
+		}
+		writeBack(w, returnMsg, err)
+		return
+	}
+	if ok {
+		if reqCategory != "users" {
+			returnMsg := map[string]interface{}{
+				"message": "Unauthorized",
+				"status":  http.StatusUnauthorized,
+			}
+			writeBack(w, returnMsg, nil)
+			return
+		}
+	}
+	body := graphqlBody{}
+	if r.Method == "GET" {
+		body.Query = r.URL.Query().Get("query")
+		body.OperationName = r.URL.Query().Get("operationName")
+		if variables := r.URL.Query().Get("variables"); variables != "" {
+			err = json.Unmarshal([]byte(variables), &body.Variables)
+			if err != nil {
+				writeBack(w, nil, apierror.Validation("variables must be a JSON object", apierror.Field("variables", "must be a JSON object")))
+				return
+			}
+		}
+	} else {
+		err = validation.DecodeBody(w, r, &body)
+		if err != nil {
+			writeBack(w, nil, err)
+			return
+		}
+	}
+	err = validation.Check(body)
+	if err != nil {
+		writeBack(w, nil, err)
+		return
+	}
+
+	userName, _, _ := r.BasicAuth()
+	ctx := context.WithValue(r.Context(), viewerKey{}, viewer{email: email, userName: userName})
+	resp := s.schema.Execute(ctx, graphql.Request{
+		Query:         body.Query,
+		OperationName: body.OperationName,
+		Variables:     body.Variables,
+		ReadOnly:      r.Method == "GET",
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// graphqlError turns an error of a resolver into the message and extensions of a GraphQL error, the same way
+// writeBack answers it: with the code of the error and, for validation errors, the fields that failed
+func graphqlError(err error) (string, map[string]interface{}) {
+	apiErr := apierror.From(err)
+	if apiErr.Cause != nil {
+		Log.Errorln(apiErr.Cause)
+	}
+	extensions := map[string]interface{}{"code": apiErr.Code}
+	if len(apiErr.Fields) > 0 {
+		extensions["fields"] = apiErr.Fields
+	}
+	return apiErr.Message, extensions
+}
+
+// graphqlSchema returns the GraphQL schema of /graphql. Movies are searched with the same params and rules as
+// GET /v2/movies, and the mutations check the same roles as the handlers of the matching routes.
+func (s *server) graphqlSchema() *graphql.Schema {
+	nonNull := func(t graphql.Type) graphql.Type { return &graphql.NonNull{Of: t} }
+	listOf := func(t graphql.Type) graphql.Type {
+		return &graphql.NonNull{Of: &graphql.List{Of: &graphql.NonNull{Of: t}}}
+	}
+	pageSize := func(args map[string]interface{}) int {
+		first, ok := args["first"].(int)
+		if !ok {
+			return 20
+		}
+		if first < 1 {
+			return 1
+		}
+		return first
+	}
+
+	pageInfo := &graphql.Object{Name: "PageInfo", Fields: map[string]*graphql.Field{
+		"endCursor":   {Type: graphql.String, Description: "Cursor to pass as after to get the next page"},
+		"hasNextPage": {Type: nonNull(graphql.Boolean)},
+	}}
+	movie := &graphql.Object{Name: "Movie", Fields: map[string]*graphql.Field{
+		"id":         {Type: nonNull(graphql.ID)},
+		"name":       {Type: nonNull(graphql.String)},
+		"director":   {Type: nonNull(graphql.String)},
+		"genres":     {Type: listOf(graphql.String)},
+		"imdbScore":  {Type: nonNull(graphql.Float), Description: "imdb_score, from 0 to 10"},
+		"popularity": {Type: nonNull(graphql.Float), Description: "99popularity, from 0 to 99"},
+		"score":      {Type: graphql.Float, Description: "Relevance of the movie to the search, for text searches"},
+	}}
+	connection := &graphql.Object{Name: "MovieConnection", Fields: map[string]*graphql.Field{
+		"totalCount": {Type: nonNull(graphql.Int)},
+		"nodes":      {Type: listOf(movie)},
+		"pageInfo":   {Type: nonNull(pageInfo)},
+	}}
+	pageArgs := func(args map[string]*graphql.Argument) map[string]*graphql.Argument {
+		args["first"] = &graphql.Argument{Type: graphql.Int, Default: 20, Description: "Number of movies, from 1 to 100"}
+		args["after"] = &graphql.Argument{Type: graphql.String, Description: "endCursor of the previous page"}
+		args["sort"] = &graphql.Argument{Type: graphql.String, Description: "Comma separated field:order pairs, fields: relevance, imdb_score, 99popularity, name"}
+		return args
+	}
+	person := &graphql.Object{Name: "Person", Description: "A director of movies of the catalogue", Fields: map[string]*graphql.Field{
+		"name": {Type: nonNull(graphql.String)},
+		"movies": {Type: nonNull(connection), Description: "Movies directed by the person",
+			Args: pageArgs(map[string]*graphql.Argument{}), Multiplier: pageSize,
+			Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				params, err := movieSearchValues(args)
+				if err != nil {
+					return nil, err
+				}
+				params.Set("director_facet", source.(map[string]interface{})["name"].(string))
+				return s.resolveMovies(ctx, params)
+			}},
+	}}
+	movie.Fields["directedBy"] = &graphql.Field{Type: nonNull(person),
+		Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return map[string]interface{}{"name": source.(map[string]interface{})["director"]}, nil
+		}}
+	role := &graphql.Enum{Name: "Role", Values: []string{"admin", "user"}}
+	user := &graphql.Object{Name: "User", Fields: map[string]*graphql.Field{
+		"email": {Type: nonNull(graphql.String)},
+		"role":  {Type: nonNull(role)},
+	}}
+
+	filter := &graphql.InputObject{Name: "MovieFilter", Fields: map[string]*graphql.Argument{
+		"name":           {Type: graphql.String, Description: "Text searched in the movie names"},
+		"director":       {Type: graphql.String, Description: "Text searched in the director names"},
+		"genres":         {Type: &graphql.List{Of: nonNull(graphql.String)}},
+		"matchAllGenres": {Type: graphql.Boolean, Description: "Movies need every genre instead of any"},
+		"excludeGenres":  {Type: &graphql.List{Of: nonNull(graphql.String)}},
+		"minScore":       {Type: graphql.Float},
+		"maxScore":       {Type: graphql.Float},
+		"minPopularity":  {Type: graphql.Float},
+		"maxPopularity":  {Type: graphql.Float},
+	}}
+	movieInput := &graphql.InputObject{Name: "MovieInput", Fields: map[string]*graphql.Argument{
+		"name":       {Type: nonNull(graphql.String)},
+		"director":   {Type: nonNull(graphql.String)},
+		"genres":     {Type: listOf(graphql.String)},
+		"imdbScore":  {Type: nonNull(graphql.Float), Default: 0.0},
+		"popularity": {Type: nonNull(graphql.Float), Default: 0.0},
+	}}
+	userInput := &graphql.InputObject{Name: "UserInput", Fields: map[string]*graphql.Argument{
+		"email":    {Type: nonNull(graphql.String)},
+		"name":     {Type: nonNull(graphql.String)},
+		"userName": {Type: nonNull(graphql.String)},
+		"password": {Type: nonNull(graphql.String)},
+		"role":     {Type: nonNull(role), Default: "user", Description: "Only admins can add admins"},
+	}}
+
+	query := &graphql.Object{Name: "Query", Fields: map[string]*graphql.Field{
+		"movie": {Type: movie, Args: map[string]*graphql.Argument{"id": {Type: nonNull(graphql.ID)}},
+			Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				found, err := s.movies.GetMovie(args["id"].(string))
+				if errors.Is(err, store.ErrMovieNotFound) {
+					return nil, nil
+				}
+				if err != nil {
+					return nil, err
+				}
+				return movieSource(found, nil), nil
+			}},
+		"movies": {Type: nonNull(connection), Description: "Movies matching a search, as GET /v2/movies",
+			Args: pageArgs(map[string]*graphql.Argument{
+				"filter":  {Type: filter},
+				"q":       {Type: graphql.String, Description: `Query language, for example director:"george lucas" score>=8`},
+				"ranking": {Type: graphql.String, Description: "Ranking profile boosting the relevance"},
+			}),
+			Multiplier: pageSize,
+			Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				params, err := movieSearchValues(args)
+				if err != nil {
+					return nil, err
+				}
+				return s.resolveMovies(ctx, params)
+			}},
+		"person": {Type: person, Description: "The director of that exact name, null when no movie has it",
+			Args: map[string]*graphql.Argument{"name": {Type: nonNull(graphql.String)}},
+			Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				search, err := s.parseMovieSearch(url.Values{"director_facet": {args["name"].(string)}, "size": {"1"}}, viewerFrom(ctx).email)
+				if err != nil {
+					return nil, err
+				}
+				results, _, err := s.searchMovies(search)
+				if err != nil || len(results.Hits) == 0 {
+					return nil, err
+				}
+				return map[string]interface{}{"name": results.Hits[0].Director}, nil
+			}},
+		"me": {Type: nonNull(user), Description: "The user making the request",
+			Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				email := viewerFrom(ctx).email
+				role, err := s.users.Role(email)
+				if err != nil {
+					return nil, err
+				}
+				if isSuperAdmin(email) {
+					role = "admin"
+				}
+				return map[string]interface{}{"email": email, "role": role}, nil
+			}},
+	}}
+
+	mutation := &graphql.Object{Name: "Mutation", Fields: map[string]*graphql.Field{
+		"addMovie": {Type: nonNull(movie), Description: "Adds a movie, admins only",
+			Args: map[string]*graphql.Argument{"input": {Type: nonNull(movieInput)}},
+			Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				if !s.isAdminViewer(ctx) {
+					return nil, apierror.New(apierror.Unauthorized, "Not Authorized")
+				}
+				body := movieFromInput(args["input"])
+				err := validation.Check(body)
+				if err != nil {
+					return nil, err
+				}
+				returnMsg, err := s.addMovie(body)
+				if err != nil {
+					return nil, err
+				}
+				body.ID = returnMsg["movie_id"].(string)
+				return movieSource(body, nil), nil
+			}},
+		"updateMovie": {Type: nonNull(movie), Description: "Replaces a movie, admins only",
+			Args: map[string]*graphql.Argument{"id": {Type: nonNull(graphql.ID)}, "input": {Type: nonNull(movieInput)}},
+			Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				if !s.isAdminViewer(ctx) {
+					return nil, apierror.New(apierror.Unauthorized, "Not Authorized")
+				}
+				body := movieFromInput(args["input"])
+				body.ID = args["id"].(string)
+				err := validation.Check(body)
+				if err != nil {
+					return nil, err
+				}
+				_, err = s.editMovie(body)
+				if err != nil {
+					return nil, err
+				}
+				return movieSource(body, nil), nil
+			}},
+		"deleteMovie": {Type: nonNull(graphql.ID), Description: "Deletes a movie and returns its id, admins only",
+			Args: map[string]*graphql.Argument{"id": {Type: nonNull(graphql.ID)}},
+			Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				if !s.isAdminViewer(ctx) {
+					return nil, apierror.New(apierror.Unauthorized, "Not Authorized")
+				}
+				_, err := s.deleteMovie(args["id"].(string))
+				if err != nil {
+					return nil, err
+				}
+				return args["id"], nil
+			}},
+		"addUser": {Type: nonNull(user), Description: "Adds a user, only admins can add admins",
+			Args: map[string]*graphql.Argument{"input": {Type: nonNull(userInput)}},
+			Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				input := args["input"].(map[string]interface{})
+				body := models.User{
+					Email:        input["email"].(string),
+					Name:         input["name"].(string),
+					Role:         input["role"].(string),
+					UserName:     input["userName"].(string),
+					UserPassword: input["password"].(string),
+				}
+				if body.Role == "admin" && !s.isAdminViewer(ctx) {
+					return nil, apierror.New(apierror.Unauthorized, "Not Authorized")
+				}
+				err := validation.Check(body)
+				if err != nil {
+					return nil, err
+				}
+				body.CreatedAt = time.Now().Unix()
+				_, err = s.createUser(body)
+				if err != nil {
+					return nil, err
+				}
+				return map[string]interface{}{"email": body.Email, "role": body.Role}, nil
+			}},
+		"deleteUser": {Type: nonNull(graphql.String), Description: "Deletes a user and returns its email, users can only delete themselves",
+			Args: map[string]*graphql.Argument{"email": {Type: nonNull(graphql.String)}},
+			Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				email := args["email"].(string)
+				err := validation.Check(removeUserBody{Email: email})
+				if err != nil {
+					return nil, err
+				}
+				if !s.isAdminViewer(ctx) && !s.isAuthorizedUser(email, viewerFrom(ctx).userName) {
+					return nil, apierror.New(apierror.Unauthorized, "Not Authorized")
+				}
+				_, err = s.deleteUser(email)
+				if err != nil {
+					return nil, err
+				}
+				return email, nil
+			}},
+	}}
+
+	return &graphql.Schema{
+		Query:         query,
+		Mutation:      mutation,
+		MaxDepth:      graphqlMaxDepth,
+		MaxComplexity: graphqlMaxComplexity,
+		FormatError:   graphqlError,
+	}
+}
+
+// isAdminViewer checks if the user making a /graphql request is an admin or a super admin
+func (s *server) isAdminViewer(ctx context.Context) bool {
+	email := viewerFrom(ctx).email
+	return s.isAdmin(email) || isSuperAdmin(email)
+}
+
+// resolveMovies runs the movie search of URL params for the user of a /graphql request and returns a MovieConnection
+func (s *server) resolveMovies(ctx context.Context, params url.Values) (interface{}, error) {
+	search, err := s.parseMovieSearch(params, viewerFrom(ctx).email)
+	if syntaxErr, ok := err.(*querySyntaxError); ok {
+		return nil, apierror.Validation(err.Error(), apierror.Field("q", fmt.Sprintf("is invalid at position %d", syntaxErr.position)))
+	}
+	if apiErr, ok := err.(*apierror.Error); ok {
+		// the fields are named after the URL params, some of which are arguments of other names
+		for i, field := range apiErr.Fields {
+			if field.Field == "cursor" {
+				apiErr.Fields[i].Field = "after"
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	results, nextCursor, err := s.searchMovies(search)
+	if err != nil {
+		return nil, err
+	}
+	nodes := []interface{}{}
+	for _, hit := range results.Hits {
+		nodes = append(nodes, movieSource(hit.Movie, hit.Score))
+	}
+	info := map[string]interface{}{"hasNextPage": nextCursor != ""}
+	if nextCursor != "" {
+		info["endCursor"] = nextCursor
+	}
+	return map[string]interface{}{"totalCount": results.Total, "nodes": nodes, "pageInfo": info}, nil
+}
+
+// movieSearchValues turns the arguments of a movies field into the URL params of GET /v2/movies
+func movieSearchValues(args map[string]interface{}) (url.Values, error) {
+	params := url.Values{}
+	for arg, param := range map[string]string{"q": "q", "sort": "sort", "ranking": "ranking", "after": "cursor"} {
+		if value, ok := args[arg].(string); ok && value != "" {
+			params.Set(param, value)
+		}
+	}
+	if first, ok := args["first"].(int); ok {
+		if first < 1 || first > 100 {
+			return nil, apierror.Validation("first must be between 1 and 100", apierror.Field("first", "must be between 1 and 100"))
+		}
+		params.Set("size", strconv.Itoa(first))
+	}
+	filter, _ := args["filter"].(map[string]interface{})
+	for arg, param := range map[string]string{"name": "name", "director": "director"} {
+		if value, ok := filter[arg].(string); ok && value != "" {
+			params.Set(param, value)
+		}
+	}
+	for arg, param := range map[string]string{"genres": "genre", "excludeGenres": "-genre"} {
+		values, _ := filter[arg].([]interface{})
+		for _, value := range values {
+			params.Add(param, value.(string))
+		}
+	}
+	if all, _ := filter["matchAllGenres"].(bool); all {
+		params.Set("genre_match", "all")
+	}
+	for arg, param := range map[string]string{
+		"minScore":      "imdb_score_gte",
+		"maxScore":      "imdb_score_lte",
+		"minPopularity": "99popularity_gte",
+		"maxPopularity": "99popularity_lte",
+	} {
+		if value, ok := filter[arg].(float64); ok {
+			params.Set(param, strconv.FormatFloat(value, 'f', -1, 64))
+		}
+	}
+	return params, nil
+}
+
+// movieSource is the value the fields of a GraphQL Movie are read from
+func movieSource(movie models.Movie, score *float64) map[string]interface{} {
+	source := map[string]interface{}{
+		"id":         movie.ID,
+		"name":       movie.Name,
+		"director":   movie.Director,
+		"genres":     movie.Genre,
+		"imdbScore":  movie.IMDBScore,
+		"popularity": movie.Popularity,
+	}
+	if score != nil {
+		source["score"] = *score
+	}
+	return source
+}
+
+// movieFromInput returns the movie of a MovieInput
+func movieFromInput(input interface{}) models.Movie {
+	fields := input.(map[string]interface{})
+	movie := models.Movie{
+		Name:       fields["name"].(string),
+		Director:   fields["director"].(string),
+		IMDBScore:  float32(fields["imdbScore"].(float64)),
+		Popularity: float32(fields["popularity"].(float64)),
+	}
+	for _, genre := range fields["genres"].([]interface{}) {
+		movie.Genre = append(movie.Genre, genre.(string))
+	}
+	return movie
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+
+	"github.com/raazcrzy/imdb/apierror"
+	"github.com/raazcrzy/imdb/models"
+	"github.com/raazcrzy/imdb/rpc"
+	"github.com/raazcrzy/imdb/store"
+	"github.com/raazcrzy/imdb/validation"
+)
+
+// catalogService is the prefix of the methods of CatalogService, described in proto/catalog.proto
+const catalogService = "/imdb.catalog.v1.CatalogService/"
+
+// grpcFieldNames are the message fields of the JSON fields and URL params named by validation errors
+var grpcFieldNames = map[string]string{
+	"movie_id":         "id",
+	"genre":            "genres",
+	"99popularity":     "popularity",
+	"-genre":           "exclude_genres",
+	"genre_match":      "match_all_genres",
+	"imdb_score_gte":   "min_score",
+	"imdb_score_lte":   "max_score",
+	"99popularity_gte": "min_popularity",
+	"99popularity_lte": "max_popularity",
+}
+
+// grpcServer returns the gRPC server of CatalogService, its methods call the same code as the /v2 movie handlers
+func (s *server) grpcServer() *rpc.Server {
+	grpcServer := rpc.NewServer()
+	grpcServer.Unary(catalogService+"Search", s.grpcSearch)
+	grpcServer.Unary(catalogService+"Get", s.grpcGet)
+	grpcServer.Unary(catalogService+"Create", s.grpcCreate)
+	grpcServer.Unary(catalogService+"Update", s.grpcUpdate)
+	grpcServer.Unary(catalogService+"Delete", s.grpcDelete)
+	grpcServer.ServerStream(catalogService+"Export", s.grpcExport)
+	return grpcServer
+}
+
+// grpcEmail returns the email of the user whose credentials are in the authorization metadata of a call,
+// checked the same way populateSession checks them
+func (s *server) grpcEmail(call *rpc.Call) (string, error) {
+	username, password, ok := call.Request.BasicAuth()
+	if !ok {
+		return "", rpc.Errorf(rpc.Unauthenticated, "Not Logged In, No Token")
+	}
+	email, err := s.fetchEmailForUser(username, password)
+	if err != nil || email == "" {
+		Log.Errorln("cannot fetch email for gRPC user", username, err)
+		return "", rpc.Errorf(rpc.Unauthenticated, "Unauthorized")
+	}
+	return email, nil
+}
+
+// grpcAdmin checks that the user of a call is an admin
+func (s *server) grpcAdmin(call *rpc.Call) error {
+	email, err := s.grpcEmail(call)
+	if err != nil {
+		return err
+	}
+	if !(s.isAdmin(email) || isSuperAdmin(email)) {
+		return rpc.Errorf(rpc.PermissionDenied, "Not Authorized")
+	}
+	return nil
+}
+
+// grpcStatus turns an error of the business logic into the status of a call, the same way writeBack answers it
+func grpcStatus(err error) error {
+	if syntaxErr, ok := err.(*querySyntaxError); ok {
+		return &rpc.Status{Code: rpc.InvalidArgument, Message: err.Error(), Violations: []rpc.FieldViolation{{Field: "q", Description: syntaxErr.Error()}}}
+	}
+	apiErr := apierror.From(err)
+	if apiErr.Cause != nil {
+		Log.Errorln("gRPC", apiErr.Code, apiErr.Cause)
+	}
+	status := &rpc.Status{Message: apiErr.Message}
+	switch apiErr.Code {
+	case apierror.NotFound:
+		status.Code = rpc.NotFound
+	case apierror.ValidationFailed:
+		status.Code = rpc.InvalidArgument
+	case apierror.Conflict:
+		status.Code = rpc.AlreadyExists
+	case apierror.Unauthorized:
+		status.Code = rpc.PermissionDenied
+	case apierror.PayloadTooLarge:
+		status.Code = rpc.ResourceExhausted
+	case apierror.UpstreamUnavailable:
+		status.Code = rpc.Unavailable
+	default:
+		status.Code = rpc.Internal
+	}
+	for _, field := range apiErr.Fields {
+		name := field.Field
+		if grpcName, ok := grpcFieldNames[name]; ok {
+			name = grpcName
+		}
+		status.Violations = append(status.Violations, rpc.FieldViolation{Field: name, Description: field.Message})
+	}
+	return status
+}
+
+// invalidMessage is the status of a request message that cannot be decoded
+func invalidMessage(err error) error {
+	return rpc.Errorf(rpc.InvalidArgument, "%s", err)
+}
+
+// grpcSearch answers Search with a page of the movies matching the search, like GET /v2/movies
+func (s *server) grpcSearch(ctx context.Context, call *rpc.Call) ([]byte, error) {
+	email, err := s.grpcEmail(call)
+	if err != nil {
+		return nil, err
+	}
+	req, err := decodeSearchRequest(call.Message)
+	if err != nil {
+		return nil, invalidMessage(err)
+	}
+	search, err := s.parseMovieSearch(req.values(), email)
+	if err != nil {
+		return nil, grpcStatus(err)
+	}
+	results, nextCursor, err := s.searchMovies(search)
+	if err != nil {
+		return nil, grpcStatus(err)
+	}
+	return encodeSearchResponse(results, nextCursor), nil
+}
+
+// grpcGet answers Get with a movie, like GET /v2/movies/{id}
+func (s *server) grpcGet(ctx context.Context, call *rpc.Call) ([]byte, error) {
+	_, err := s.grpcEmail(call)
+	if err != nil {
+		return nil, err
+	}
+	id, err := decodeID(call.Message)
+	if err != nil {
+		return nil, invalidMessage(err)
+	}
+	returnMsg, err := s.getMovie(id)
+	if err != nil {
+		return nil, grpcStatus(err)
+	}
+	return encodeMovie(returnMsg["movie"].(models.Movie)), nil
+}
+
+// grpcCreate answers Create with the movie it added, like POST /v2/movies
+func (s *server) grpcCreate(ctx context.Context, call *rpc.Call) ([]byte, error) {
+	err := s.grpcAdmin(call)
+	if err != nil {
+		return nil, err
+	}
+	movie, err := decodeMovie(call.Message)
+	if err != nil {
+		return nil, invalidMessage(err)
+	}
+	err = validation.Check(movie)
+	if err != nil {
+		return nil, grpcStatus(err)
+	}
+	returnMsg, err := s.addMovie(movie)
+	if err != nil {
+		return nil, grpcStatus(err)
+	}
+	movie.ID = returnMsg["movie_id"].(string)
+	return encodeMovie(movie), nil
+}
+
+// grpcUpdate answers Update with the movie it replaced, like PUT /v2/movies/{id}
+func (s *server) grpcUpdate(ctx context.Context, call *rpc.Call) ([]byte, error) {
+	err := s.grpcAdmin(call)
+	if err != nil {
+		return nil, err
+	}
+	movie, err := decodeMovie(call.Message)
+	if err != nil {
+		return nil, invalidMessage(err)
+	}
+	fields := validation.Struct(movie)
+	if movie.ID == "" {
+		fields = append([]apierror.FieldError{apierror.Field("movie_id", "is required")}, fields...)
+	}
+	if len(fields) > 0 {
+		return nil, grpcStatus(apierror.Validation("one or more fields are invalid", fields...))
+	}
+	_, err = s.editMovie(movie)
+	if err != nil {
+		return nil, grpcStatus(err)
+	}
+	return encodeMovie(movie), nil
+}
+
+// grpcDelete answers Delete with an empty message once the movie is deleted, like DELETE /v2/movies/{id}
+func (s *server) grpcDelete(ctx context.Context, call *rpc.Call) ([]byte, error) {
+	err := s.grpcAdmin(call)
+	if err != nil {
+		return nil, err
+	}
+	id, err := decodeID(call.Message)
+	if err != nil {
+		return nil, invalidMessage(err)
+	}
+	if id == "" {
+		return nil, grpcStatus(apierror.Validation("id is required", apierror.Field("movie_id", "is required")))
+	}
+	_, err = s.deleteMovie(id)
+	if err != nil {
+		return nil, grpcStatus(err)
+	}
+	return []byte{}, nil
+}
+
+// grpcExport streams every movie matching a search, fetching them a page at a time as a client following
+// next_cursor would
+func (s *server) grpcExport(ctx context.Context, call *rpc.Call, send func([]byte) error) error {
+	email, err := s.grpcEmail(call)
+	if err != nil {
+		return err
+	}
+	req, err := decodeSearchRequest(call.Message)
+	if err != nil {
+		return invalidMessage(err)
+	}
+	req.size, req.cursor = exportPageSize, ""
+	search, err := s.parseMovieSearch(req.values(), email)
+	if err != nil {
+		return grpcStatus(err)
+	}
+	var sendErr error
+	err = s.exportMovies(search, func(results store.MovieResults) error {
+		for _, hit := range results.Hits {
+			sendErr = send(encodeMovie(hit.Movie))
+			if sendErr != nil {
+				return sendErr
+			}
+		}
+		return nil
+	})
+	if err != nil && err != sendErr {
+		return grpcStatus(err)
+	}
+	return err
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/raazcrzy/imdb/rpc"
+)
+
+// Movie messages, as protoc generated code encodes them
+const (
+	psycho = "\x12\x06Psycho" +
+		"\x1a\x10Alfred Hitchcock" +
+		"\x22\x06Horror\x22\x08Thriller" +
+		"\x2d\x00\x00\x08\x41" + // imdb_score 8.5
+		"\x35\x00\x00\xaa\x42" // popularity 85
+	vertigo = "\x12\x07Vertigo" +
+		"\x1a\x10Alfred Hitchcock" +
+		"\x22\x07Mystery\x22\x08Thriller" +
+		"\x2d\x00\x00\x00\x41" + // imdb_score 8
+		"\x35\x00\x00\xa0\x42" // popularity 80
+	jaws = "\x12\x04Jaws" +
+		"\x1a\x10Steven Spielberg" +
+		"\x22\x08Thriller" +
+		"\x2d\x00\x00\x00\x41" + // imdb_score 8
+		"\x35\x00\x00\xa0\x42" // popularity 80
+)
+
+// horror is psycho without the Thriller genre
+var horror = strings.Replace(psycho, "\x22\x08Thriller", "", 1)
+
+// grpcCall sends a message to a method of CatalogService and returns the messages and the status of the response
+func grpcCall(t *testing.T, srv *httptest.Server, method, message string, signedIn bool) ([]string, string) {
+	t.Helper()
+	body := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(message)))
+	r, err := http.NewRequest("POST", srv.URL+catalogService+method, bytes.NewReader(append(body, message...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/grpc")
+	if signedIn {
+		r.SetBasicAuth("foox", "barx")
+	}
+	resp, err := srv.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	messages := []string{}
+	prefix := make([]byte, 5)
+	for {
+		_, err := io.ReadFull(resp.Body, prefix)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		message := make([]byte, binary.BigEndian.Uint32(prefix[1:]))
+		_, err = io.ReadFull(resp.Body, message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, string(message))
+	}
+	if len(messages) == 0 {
+		return messages, resp.Header.Get("Grpc-Status")
+	}
+	return messages, resp.Trailer.Get("Grpc-Status")
+}
+
+// TestCatalogService calls the methods of CatalogService over HTTP/2 on a server keeping movies in memory.
+// Each step runs on the state left by the previous ones, {id} is the id of the movie created first, 20 bytes long.
+func TestCatalogService(t *testing.T) {
+	srv := httptest.NewUnstartedServer(withRequestID(newTestServer(t).grpcServer()))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	created, status := grpcCall(t, srv, "Create", psycho, true)
+	if status != "0" || len(created) != 1 {
+		t.Fatalf("create: got status %s and %d messages", status, len(created))
+	}
+	movie, err := decodeMovie([]byte(created[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := movie.ID
+	if created[0] != "\x0a\x14"+id+psycho {
+		t.Fatalf("create: got %q, want the created movie with its id", created[0])
+	}
+
+	steps := []struct {
+		name     string
+		method   string
+		message  string
+		signedIn bool
+		status   string
+		messages []string
+	}{
+		{"get", "Get", "\x0a\x14{id}", true, "0", []string{"\x0a\x14{id}" + psycho}},
+		{"get signed out", "Get", "\x0a\x14{id}", false, "16", []string{}},
+		{"get a missing movie", "Get", "\x0a\x07missing", true, "5", []string{}},
+		{"get with an invalid message", "Get", "\x0a\x14", true, "3", []string{}},
+		{"create without a name", "Create", "\x1a\x06Nobody", true, "3", []string{}},
+		{"create another", "Create", vertigo, true, "0", nil},
+		{"search", "Search", "\x1a\x08Thriller\x5a\x0fimdb_score:desc", true, "0", nil},
+		{"search with a syntax error", "Search", "\x52\x08score>=x", true, "3", []string{}},
+		{"export", "Export", "\x12\x09hitchcock\x5a\x08name:asc", true, "0", nil},
+		{"update", "Update", "\x0a\x14{id}" + horror, true, "0", []string{"\x0a\x14{id}" + horror}},
+		{"update without an id", "Update", psycho, true, "3", []string{}},
+		{"delete", "Delete", "\x0a\x14{id}", true, "0", []string{""}},
+		{"get the deleted movie", "Get", "\x0a\x14{id}", true, "5", []string{}},
+		{"unknown method", "Rate", "", true, "12", []string{}},
+	}
+	for _, step := range steps {
+		messages, status := grpcCall(t, srv, step.method, strings.Replace(step.message, "{id}", id, -1), step.signedIn)
+		if status != step.status {
+			t.Errorf("%s: got status %s, want %s", step.name, status, step.status)
+		}
+		if step.messages == nil {
+			continue
+		}
+		for i := range step.messages {
+			step.messages[i] = strings.Replace(step.messages[i], "{id}", id, -1)
+		}
+		if !reflect.DeepEqual(messages, step.messages) {
+			t.Errorf("%s: got %q, want %q", step.name, messages, step.messages)
+		}
+	}
+}
+
+// TestSearchAndExportMessages checks the movies of a SearchResponse and the stream of Export
+func TestSearchAndExportMessages(t *testing.T) {
+	srv := httptest.NewUnstartedServer(withRequestID(newTestServer(t).grpcServer()))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+	for _, movie := range []string{psycho, vertigo, jaws} {
+		if _, status := grpcCall(t, srv, "Create", movie, true); status != "0" {
+			t.Fatalf("cannot create %q: status %s", movie, status)
+		}
+	}
+
+	names := func(messages []string) []string {
+		names := []string{}
+		for _, message := range messages {
+			movie, err := decodeMovie([]byte(message))
+			if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, movie.Name)
+		}
+		return names
+	}
+
+	responses, status := grpcCall(t, srv, "Search", "\x12\x09hitchcock\x5a\x0fimdb_score:desc\x68\x01", true)
+	if status != "0" || len(responses) != 1 {
+		t.Fatalf("search: got status %s and %d messages", status, len(responses))
+	}
+	movies, total, cursor := []string{}, int64(0), ""
+	d := rpc.NewDecoder([]byte(responses[0]))
+	for d.Next() {
+		switch d.Field() {
+		case 1:
+			movies = append(movies, string(d.Bytes()))
+		case 2:
+			total = d.Int64()
+		case 3:
+			cursor = d.String()
+		}
+	}
+	if d.Err() != nil {
+		t.Fatal(d.Err())
+	}
+	if !reflect.DeepEqual(names(movies), []string{"Psycho"}) || total != 2 || cursor == "" {
+		t.Errorf("search: got %v of %d, cursor %q", names(movies), total, cursor)
+	}
+
+	exported, status := grpcCall(t, srv, "Export", "\x1a\x08Thriller\x5a\x08name:asc", true)
+	if want := []string{"Jaws", "Psycho", "Vertigo"}; status != "0" || !reflect.DeepEqual(names(exported), want) {
+		t.Errorf("export: got status %s and %v, want %v", status, names(exported), want)
+	}
+}
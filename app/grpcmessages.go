@@ -0,0 +1,165 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+
+	"github.com/raazcrzy/imdb/models"
+	"github.com/raazcrzy/imdb/rpc"
+	"github.com/raazcrzy/imdb/store"
+)
+
+// The messages of proto/catalog.proto, encoded and decoded by hand with the field numbers of the file
+
+// encodeMovie encodes a models.Movie as a Movie message
+func encodeMovie(movie models.Movie) []byte {
+	e := &rpc.Encoder{}
+	e.String(1, movie.ID)
+	e.String(2, movie.Name)
+	e.String(3, movie.Director)
+	e.Strings(4, movie.Genre)
+	e.Float(5, movie.IMDBScore)
+	e.Float(6, movie.Popularity)
+	return e.Bytes()
+}
+
+// decodeMovie decodes a Movie message
+func decodeMovie(data []byte) (models.Movie, error) {
+	movie := models.Movie{}
+	d := rpc.NewDecoder(data)
+	for d.Next() {
+		switch d.Field() {
+		case 1:
+			movie.ID = d.String()
+		case 2:
+			movie.Name = d.String()
+		case 3:
+			movie.Director = d.String()
+		case 4:
+			movie.Genre = append(movie.Genre, d.String())
+		case 5:
+			movie.IMDBScore = d.Float()
+		case 6:
+			movie.Popularity = d.Float()
+		default:
+			d.Skip()
+		}
+	}
+	return movie, d.Err()
+}
+
+// decodeID decodes a GetMovieRequest or a DeleteMovieRequest, which only have the id of a movie
+func decodeID(data []byte) (string, error) {
+	id := ""
+	d := rpc.NewDecoder(data)
+	for d.Next() {
+		if d.Field() == 1 {
+			id = d.String()
+			continue
+		}
+		d.Skip()
+	}
+	return id, d.Err()
+}
+
+// searchRequest is a SearchRequest message
+type searchRequest struct {
+	name, director, q, sort, ranking, cursor string
+	genres, excludeGenres                    []string
+	matchAllGenres                           bool
+	minScore, maxScore                       *float64
+	minPopularity, maxPopularity             *float64
+	size                                     int64
+}
+
+// decodeSearchRequest decodes a SearchRequest message
+func decodeSearchRequest(data []byte) (searchRequest, error) {
+	req := searchRequest{}
+	d := rpc.NewDecoder(data)
+	double := func() *float64 {
+		v := d.Double()
+		return &v
+	}
+	for d.Next() {
+		switch d.Field() {
+		case 1:
+			req.name = d.String()
+		case 2:
+			req.director = d.String()
+		case 3:
+			req.genres = append(req.genres, d.String())
+		case 4:
+			req.matchAllGenres = d.Bool()
+		case 5:
+			req.excludeGenres = append(req.excludeGenres, d.String())
+		case 6:
+			req.minScore = double()
+		case 7:
+			req.maxScore = double()
+		case 8:
+			req.minPopularity = double()
+		case 9:
+			req.maxPopularity = double()
+		case 10:
+			req.q = d.String()
+		case 11:
+			req.sort = d.String()
+		case 12:
+			req.ranking = d.String()
+		case 13:
+			req.size = d.Int64()
+		case 14:
+			req.cursor = d.String()
+		default:
+			d.Skip()
+		}
+	}
+	return req, d.Err()
+}
+
+// values returns the URL params of GET /v2/movies doing the same search
+func (req searchRequest) values() url.Values {
+	params := url.Values{}
+	for param, value := range map[string]string{
+		"name":     req.name,
+		"director": req.director,
+		"q":        req.q,
+		"sort":     req.sort,
+		"ranking":  req.ranking,
+		"cursor":   req.cursor,
+	} {
+		if value != "" {
+			params.Set(param, value)
+		}
+	}
+	params["genre"] = req.genres
+	params["-genre"] = req.excludeGenres
+	if req.matchAllGenres {
+		params.Set("genre_match", "all")
+	}
+	for param, value := range map[string]*float64{
+		"imdb_score_gte":   req.minScore,
+		"imdb_score_lte":   req.maxScore,
+		"99popularity_gte": req.minPopularity,
+		"99popularity_lte": req.maxPopularity,
+	} {
+		if value != nil {
+			params.Set(param, strconv.FormatFloat(*value, 'f', -1, 64))
+		}
+	}
+	if req.size != 0 {
+		params.Set("size", strconv.FormatInt(req.size, 10))
+	}
+	return params
+}
+
+// encodeSearchResponse encodes a page of results as a SearchResponse message
+func encodeSearchResponse(results store.MovieResults, nextCursor string) []byte {
+	e := &rpc.Encoder{}
+	for _, hit := range results.Hits {
+		e.Message(1, encodeMovie(hit.Movie))
+	}
+	e.Int64(2, results.Total)
+	e.String(3, nextCursor)
+	return e.Bytes()
+}
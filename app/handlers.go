@@ -4,16 +4,24 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"regexp"
 	"strconv"
 	"time"
 
-	"gopkg.in/olivere/elastic.v5"
-
+	"github.com/raazcrzy/imdb/apierror"
+	"github.com/raazcrzy/imdb/dbConnections"
 	"github.com/raazcrzy/imdb/models"
+	"github.com/raazcrzy/imdb/validation"
 )
 
-var emailRegexp = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+// removeUserBody is the request body of DELETE /v1/remove/user
+type removeUserBody struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// synonymsBody is the request body of PUT /v1/update/synonyms
+type synonymsBody struct {
+	Synonyms []string `json:"synonyms"`
+}
 
 /* addUserHandler handles the incoming requests to create a new user
 The expected request body structure is:
@@ -29,17 +37,9 @@ type User struct {
 
 If role is specified as admin, the the request maker must be an existing admin/super admin.
 */
-func addUserHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) addUserHandler(w http.ResponseWriter, r *http.Request) {
 	var returnMsg map[string]interface{}
 	var err error
-	if r.Method != "POST" {
-		returnMsg = map[string]interface{}{
-			"message": "Invalid HTTP method, allowed POST",
-			"status":  http.StatusBadRequest,
-		}
-		writeBack(w, returnMsg, err)
-		return
-	}
 	email, reqCategory, ok, err := basicAuth(r)
 	if err != nil {
 		Log.Errorln(err)
@@ -60,59 +60,26 @@ func addUserHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	d := json.NewDecoder(r.Body)
 	body := models.User{}
-	err = d.Decode(&body)
+	err = validation.DecodeBody(w, r, &body)
 	if err != nil {
-		Log.Errorln("decoding err: ", err)
-		returnMsg = map[string]interface{}{
-			"message": "Unable to decode request body",
-			"status":  400,
-		}
-		writeBack(w, returnMsg, nil)
+		writeBack(w, nil, err)
 		return
 	}
 
 	// check if request make is admin before creating another admin user
-	ok = (isAdmin(email) || isSuperAdmin(email) || body.Role != "admin")
+	ok = (s.isAdmin(email) || isSuperAdmin(email) || body.Role != "admin")
 	if body.Role == "" {
 		body.Role = "user"
 	}
 	if ok {
-		if body.Email == "" || body.UserName == "" || body.UserPassword == "" || body.Name == "" {
-			returnMsg = map[string]interface{}{
-				"message": "one or more fields missing in request body",
-				"status":  400,
-			}
-			writeBack(w, returnMsg, nil)
-			return
-		}
-		if !emailRegexp.MatchString(body.Email) {
-			returnMsg = map[string]interface{}{
-				"message": "invalid email present in the request body",
-				"status":  400,
-			}
-			writeBack(w, returnMsg, nil)
-			return
-		}
-		if len(body.UserName) > 32 || len(body.UserPassword) > 32 {
-			returnMsg = map[string]interface{}{
-				"message": "user_name and password has a max limit of 32 characters",
-				"status":  400,
-			}
-			writeBack(w, returnMsg, nil)
-			return
-		}
-		if !(body.Role == "admin" || body.Role == "user") {
-			returnMsg = map[string]interface{}{
-				"message": "invalid role provided, valid roles: admin, user",
-				"status":  400,
-			}
-			writeBack(w, returnMsg, nil)
+		err = validation.Check(body)
+		if err != nil {
+			writeBack(w, nil, err)
 			return
 		}
 		body.CreatedAt = time.Now().Unix()
-		returnMsg, err = createUser(body)
+		returnMsg, err = s.createUser(body)
 	} else {
 		returnMsg = map[string]interface{}{
 			"message": "Not Authorized",
@@ -123,17 +90,9 @@ func addUserHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // removeUserHandler deletes an existing user from the database
-func removeUserHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) removeUserHandler(w http.ResponseWriter, r *http.Request) {
 	var returnMsg map[string]interface{}
 	var err error
-	if r.Method != "DELETE" {
-		returnMsg = map[string]interface{}{
-			"message": "Invalid HTTP method, allowed DELETE",
-			"status":  http.StatusBadRequest,
-		}
-		writeBack(w, returnMsg, err)
-		return
-	}
 	email, reqCategory, ok, err := basicAuth(r)
 	if err != nil {
 		Log.Errorln(err)
@@ -155,39 +114,71 @@ func removeUserHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	userID, _, _ := r.BasicAuth()
-	d := json.NewDecoder(r.Body)
-	var body struct {
-		Email string `json:"email"`
+	body := removeUserBody{}
+	// the /v2 route has the email in its path, the /v1 one in the body
+	body.Email = pathParam(r, "email")
+	if body.Email == "" {
+		err = validation.DecodeBody(w, r, &body)
+		if err != nil {
+			writeBack(w, nil, err)
+			return
+		}
 	}
-	err = d.Decode(&body)
+	err = validation.Check(body)
 	if err != nil {
-		Log.Errorln("decoding err: ", err)
-		returnMsg = map[string]interface{}{
-			"message": "Unable to decode request body",
-			"status":  400,
-		}
-		writeBack(w, returnMsg, nil)
+		writeBack(w, nil, err)
 		return
 	}
-	if body.Email == "" {
+	ok = (s.isAdmin(email) || isSuperAdmin(email) || s.isAuthorizedUser(body.Email, userID))
+	if ok {
+		returnMsg, err = s.deleteUser(body.Email)
+	} else {
 		returnMsg = map[string]interface{}{
-			"message": "one or more fields missing in request body",
-			"status":  400,
+			"message": "Not Authorized",
+			"status":  401,
 		}
-		writeBack(w, returnMsg, nil)
-		return
 	}
-	if !emailRegexp.MatchString(body.Email) {
+	writeBack(w, returnMsg, err)
+}
+
+// addMovieHandler adds a new movie in the existing set of movies in elasticsearch
+func (s *server) addMovieHandler(w http.ResponseWriter, r *http.Request) {
+	var returnMsg map[string]interface{}
+	var err error
+	email, reqCategory, ok, err := basicAuth(r)
+	if err != nil {
+		Log.Errorln(err)
 		returnMsg = map[string]interface{}{
-			"message": "invalid email present in the request body",
-			"status":  400,
+			"message": "Internal server error",
+			"status":  http.StatusInternalServerError,
 		}
-		writeBack(w, returnMsg, nil)
+		writeBack(w, returnMsg, err)
 		return
 	}
-	ok = (isAdmin(email) || isSuperAdmin(email) || isAuthorizedUser(body.Email, userID))
 	if ok {
-		returnMsg, err = deleteUser(body.Email)
+		if reqCategory != "users" {
+			returnMsg := map[string]interface{}{
+				"message": "Unauthorized",
+				"status":  http.StatusUnauthorized,
+			}
+			writeBack(w, returnMsg, nil)
+			return
+		}
+	}
+	ok = (s.isAdmin(email) || isSuperAdmin(email))
+	if ok {
+		body := models.Movie{}
+		err = validation.DecodeBody(w, r, &body)
+		if err != nil {
+			writeBack(w, nil, err)
+			return
+		}
+		err = validation.Check(body)
+		if err != nil {
+			writeBack(w, nil, err)
+			return
+		}
+		returnMsg, err = s.addMovie(body)
 	} else {
 		returnMsg = map[string]interface{}{
 			"message": "Not Authorized",
@@ -197,18 +188,55 @@ func removeUserHandler(w http.ResponseWriter, r *http.Request) {
 	writeBack(w, returnMsg, err)
 }
 
-// addMovieHandler adds a new movie in the existing set of movies in elasticsearch
-func addMovieHandler(w http.ResponseWriter, r *http.Request) {
+// removeMovieHandler deletes a movie from the existing set of movies
+func (s *server) removeMovieHandler(w http.ResponseWriter, r *http.Request) {
 	var returnMsg map[string]interface{}
 	var err error
-	if r.Method != "POST" {
+	email, reqCategory, ok, err := basicAuth(r)
+	if err != nil {
+		Log.Errorln(err)
 		returnMsg = map[string]interface{}{
-			"message": "Invalid HTTP method, allowed POST",
-			"status":  http.StatusBadRequest,
+			"message": "Internal server error",
+			"status":  http.StatusInternalServerError,
 		}
 		writeBack(w, returnMsg, err)
 		return
 	}
+	if ok {
+		if reqCategory != "users" {
+			returnMsg := map[string]interface{}{
+				"message": "Unauthorized",
+				"status":  http.StatusUnauthorized,
+			}
+			writeBack(w, returnMsg, nil)
+			return
+		}
+	}
+	ok = (s.isAdmin(email) || isSuperAdmin(email))
+	if ok {
+		// the /v2 route has the id in its path, the /v1 one in a URL param
+		movieID := pathParam(r, "id")
+		if movieID == "" {
+			movieID = r.URL.Query().Get("movie_id")
+		}
+		if movieID == "" {
+			writeBack(w, nil, apierror.Validation("movie_id required as URL param", apierror.Field("movie_id", "is required")))
+			return
+		}
+		returnMsg, err = s.deleteMovie(movieID)
+	} else {
+		returnMsg = map[string]interface{}{
+			"message": "Not Authorized",
+			"status":  401,
+		}
+	}
+	writeBack(w, returnMsg, err)
+}
+
+// updateMovieHandler updates a movie content
+func (s *server) updateMovieHandler(w http.ResponseWriter, r *http.Request) {
+	var returnMsg map[string]interface{}
+	var err error
 	email, reqCategory, ok, err := basicAuth(r)
 	if err != nil {
 		Log.Errorln(err)
@@ -229,29 +257,32 @@ func addMovieHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	ok = (isAdmin(email) || isSuperAdmin(email))
+	ok = (s.isAdmin(email) || isSuperAdmin(email))
 	if ok {
-		d := json.NewDecoder(r.Body)
 		body := models.Movie{}
-		err := d.Decode(&body)
+		err = validation.DecodeBody(w, r, &body)
 		if err != nil {
-			Log.Errorln("decoding err: ", err)
-			returnMsg = map[string]interface{}{
-				"message": "Unable to decode request body",
-				"status":  400,
-			}
-			writeBack(w, returnMsg, nil)
+			writeBack(w, nil, err)
 			return
 		}
-		if body.Name == "" || body.Director == "" || len(body.Genre) == 0 {
-			returnMsg = map[string]interface{}{
-				"message": "one or more fields missing in request body, required fields: name, director, genre",
-				"status":  400,
+		// the /v2 route has the id in its path, the /v1 one in the body
+		if id := pathParam(r, "id"); id != "" {
+			if body.ID != "" && body.ID != id {
+				writeBack(w, nil, apierror.Validation("movie_id in the request body does not match the URL", apierror.Field("movie_id", "must match the movie id of the URL")))
+				return
 			}
-			writeBack(w, returnMsg, nil)
+			body.ID = id
+		}
+		// movie_id is only required when updating
+		fields := validation.Struct(body)
+		if body.ID == "" {
+			fields = append([]apierror.FieldError{apierror.Field("movie_id", "is required")}, fields...)
+		}
+		if len(fields) > 0 {
+			writeBack(w, nil, apierror.Validation("one or more fields are invalid", fields...))
 			return
 		}
-		returnMsg, err = addMovie(body)
+		returnMsg, err = s.editMovie(body)
 	} else {
 		returnMsg = map[string]interface{}{
 			"message": "Not Authorized",
@@ -261,18 +292,81 @@ func addMovieHandler(w http.ResponseWriter, r *http.Request) {
 	writeBack(w, returnMsg, err)
 }
 
-// removeMovieHandler deletes a movie from the existing set of movies
-func removeMovieHandler(w http.ResponseWriter, r *http.Request) {
+// getMovieByIDHandler fetches a movie by the id in the route path
+func (s *server) getMovieByIDHandler(w http.ResponseWriter, r *http.Request) {
+	var returnMsg map[string]interface{}
+	var err error
+	_, reqCategory, ok, err := basicAuth(r)
+	if err != nil {
+		Log.Errorln(err)
+		returnMsg = map[string]interface{}{
+			"message": "Internal server error",
+			"status":  http.StatusInternalServerError,
+		}
+		writeBack(w, returnMsg, err)
+		return
+	}
+	if ok {
+		if reqCategory != "users" {
+			returnMsg := map[string]interface{}{
+				"message": "Unauthorized",
+				"status":  http.StatusUnauthorized,
+			}
+			writeBack(w, returnMsg, nil)
+			return
+		}
+	}
+	returnMsg, err = s.getMovie(pathParam(r, "id"))
+	writeBack(w, returnMsg, err)
+}
+
+// getMovieHandler fetches the list of movies matching the queries
+func (s *server) getMovieHandler(w http.ResponseWriter, r *http.Request) {
 	var returnMsg map[string]interface{}
 	var err error
-	if r.Method != "DELETE" {
+	user, reqCategory, ok, err := basicAuth(r)
+	if err != nil {
+		Log.Errorln(err)
 		returnMsg = map[string]interface{}{
-			"message": "Invalid HTTP method, allowed DELETE",
-			"status":  http.StatusBadRequest,
+			"message": "Internal server error",
+			"status":  http.StatusInternalServerError,
 		}
 		writeBack(w, returnMsg, err)
 		return
 	}
+	Log.Infoln("user: ", user, reqCategory)
+	if ok {
+		if reqCategory != "users" {
+			returnMsg := map[string]interface{}{
+				"message": "Unauthorized",
+				"status":  http.StatusUnauthorized,
+			}
+			writeBack(w, returnMsg, nil)
+			return
+		}
+	}
+	search, err := s.parseMovieSearch(r.URL.Query(), user)
+	if syntaxErr, ok := err.(*querySyntaxError); ok {
+		returnMsg := map[string]interface{}{
+			"message":  err.Error(),
+			"status":   http.StatusBadRequest,
+			"position": syntaxErr.position,
+		}
+		writeBack(w, returnMsg, nil)
+		return
+	}
+	if err != nil {
+		writeBack(w, nil, err)
+		return
+	}
+	returnMsg, err = s.listMovies(search)
+	writeBack(w, returnMsg, err)
+}
+
+// updateRankingHandler creates or replaces a ranking profile used to boost search results
+func (s *server) updateRankingHandler(w http.ResponseWriter, r *http.Request) {
+	var returnMsg map[string]interface{}
+	var err error
 	email, reqCategory, ok, err := basicAuth(r)
 	if err != nil {
 		Log.Errorln(err)
@@ -293,18 +387,25 @@ func removeMovieHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	ok = (isAdmin(email) || isSuperAdmin(email))
+	ok = (s.isAdmin(email) || isSuperAdmin(email))
 	if ok {
-		movieID := r.URL.Query().Get("movie_id")
-		if movieID == "" {
+		body := models.RankingProfile{}
+		err = validation.DecodeBody(w, r, &body)
+		if err != nil {
+			writeBack(w, nil, err)
+			return
+		}
+		err = validateRankingProfile(body)
+		if err != nil {
 			returnMsg = map[string]interface{}{
-				"message": "movie_id required as URL param",
+				"message": err.Error(),
 				"status":  400,
 			}
 			writeBack(w, returnMsg, nil)
 			return
 		}
-		returnMsg, err = deleteMovie(movieID)
+		body.UpdatedAt = time.Now().Unix()
+		returnMsg, err = saveRankingProfile(body)
 	} else {
 		returnMsg = map[string]interface{}{
 			"message": "Not Authorized",
@@ -314,18 +415,46 @@ func removeMovieHandler(w http.ResponseWriter, r *http.Request) {
 	writeBack(w, returnMsg, err)
 }
 
-// updateMovieHandler updates a movie content
-func updateMovieHandler(w http.ResponseWriter, r *http.Request) {
+// getRankingHandler lists the ranking profiles that can be picked with the ranking search param
+func (s *server) getRankingHandler(w http.ResponseWriter, r *http.Request) {
 	var returnMsg map[string]interface{}
 	var err error
-	if r.Method != "PUT" {
+	email, reqCategory, ok, err := basicAuth(r)
+	if err != nil {
+		Log.Errorln(err)
 		returnMsg = map[string]interface{}{
-			"message": "Invalid HTTP method, allowed PUT",
-			"status":  http.StatusBadRequest,
+			"message": "Internal server error",
+			"status":  http.StatusInternalServerError,
 		}
 		writeBack(w, returnMsg, err)
 		return
 	}
+	if ok {
+		if reqCategory != "users" {
+			returnMsg := map[string]interface{}{
+				"message": "Unauthorized",
+				"status":  http.StatusUnauthorized,
+			}
+			writeBack(w, returnMsg, nil)
+			return
+		}
+	}
+	ok = (s.isAdmin(email) || isSuperAdmin(email))
+	if ok {
+		returnMsg, err = listRankingProfiles()
+	} else {
+		returnMsg = map[string]interface{}{
+			"message": "Not Authorized",
+			"status":  401,
+		}
+	}
+	writeBack(w, returnMsg, err)
+}
+
+// removeRankingHandler deletes a stored ranking profile
+func (s *server) removeRankingHandler(w http.ResponseWriter, r *http.Request) {
+	var returnMsg map[string]interface{}
+	var err error
 	email, reqCategory, ok, err := basicAuth(r)
 	if err != nil {
 		Log.Errorln(err)
@@ -346,29 +475,65 @@ func updateMovieHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	ok = (isAdmin(email) || isSuperAdmin(email))
+	ok = (s.isAdmin(email) || isSuperAdmin(email))
 	if ok {
-		d := json.NewDecoder(r.Body)
-		body := models.Movie{}
-		err := d.Decode(&body)
-		if err != nil {
-			Log.Errorln("decoding err: ", err)
-			returnMsg = map[string]interface{}{
-				"message": "Unable to decode request body",
-				"status":  400,
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			writeBack(w, nil, apierror.Validation("name required as URL param", apierror.Field("name", "is required")))
+			return
+		}
+		returnMsg, err = deleteRankingProfile(name)
+	} else {
+		returnMsg = map[string]interface{}{
+			"message": "Not Authorized",
+			"status":  401,
+		}
+	}
+	writeBack(w, returnMsg, err)
+}
+
+// updateSynonymsHandler replaces the synonym rules used when searching movies
+func (s *server) updateSynonymsHandler(w http.ResponseWriter, r *http.Request) {
+	var returnMsg map[string]interface{}
+	var err error
+	email, reqCategory, ok, err := basicAuth(r)
+	if err != nil {
+		Log.Errorln(err)
+		returnMsg = map[string]interface{}{
+			"message": "Internal server error",
+			"status":  http.StatusInternalServerError,
+		}
+		writeBack(w, returnMsg, err)
+		return
+	}
+	if ok {
+		if reqCategory != "users" {
+			returnMsg := map[string]interface{}{
+				"message": "Unauthorized",
+				"status":  http.StatusUnauthorized,
 			}
 			writeBack(w, returnMsg, nil)
 			return
 		}
-		if body.Name == "" || body.Director == "" || len(body.Genre) == 0 || body.ID == "" {
+	}
+	ok = (s.isAdmin(email) || isSuperAdmin(email))
+	if ok {
+		body := synonymsBody{}
+		err = validation.DecodeBody(w, r, &body)
+		if err != nil {
+			writeBack(w, nil, err)
+			return
+		}
+		err = dbConnections.ValidateSynonyms(body.Synonyms)
+		if err != nil {
 			returnMsg = map[string]interface{}{
-				"message": "one or more fields missing in request body, required fields: name, director, genre",
+				"message": err.Error(),
 				"status":  400,
 			}
 			writeBack(w, returnMsg, nil)
 			return
 		}
-		returnMsg, err = editMovie(body)
+		returnMsg, err = s.updateSynonyms(body.Synonyms)
 	} else {
 		returnMsg = map[string]interface{}{
 			"message": "Not Authorized",
@@ -378,19 +543,47 @@ func updateMovieHandler(w http.ResponseWriter, r *http.Request) {
 	writeBack(w, returnMsg, err)
 }
 
-// getMovieHandler fetches the list of movies matching the queries
-func getMovieHandler(w http.ResponseWriter, r *http.Request) {
+// getSynonymsHandler lists the synonym rules used when searching movies
+func (s *server) getSynonymsHandler(w http.ResponseWriter, r *http.Request) {
 	var returnMsg map[string]interface{}
 	var err error
-	if r.Method != "GET" {
+	email, reqCategory, ok, err := basicAuth(r)
+	if err != nil {
+		Log.Errorln(err)
 		returnMsg = map[string]interface{}{
-			"message": "Invalid HTTP method, allowed GET",
-			"status":  http.StatusBadRequest,
+			"message": "Internal server error",
+			"status":  http.StatusInternalServerError,
 		}
 		writeBack(w, returnMsg, err)
 		return
 	}
-	user, reqCategory, ok, err := basicAuth(r)
+	if ok {
+		if reqCategory != "users" {
+			returnMsg := map[string]interface{}{
+				"message": "Unauthorized",
+				"status":  http.StatusUnauthorized,
+			}
+			writeBack(w, returnMsg, nil)
+			return
+		}
+	}
+	ok = (s.isAdmin(email) || isSuperAdmin(email))
+	if ok {
+		returnMsg, err = listSynonyms()
+	} else {
+		returnMsg = map[string]interface{}{
+			"message": "Not Authorized",
+			"status":  401,
+		}
+	}
+	writeBack(w, returnMsg, err)
+}
+
+// reindexMovieHandler queues a copy of the movie index into a new index with the current mapping
+func (s *server) reindexMovieHandler(w http.ResponseWriter, r *http.Request) {
+	var returnMsg map[string]interface{}
+	var err error
+	email, reqCategory, ok, err := basicAuth(r)
 	if err != nil {
 		Log.Errorln(err)
 		returnMsg = map[string]interface{}{
@@ -400,7 +593,6 @@ func getMovieHandler(w http.ResponseWriter, r *http.Request) {
 		writeBack(w, returnMsg, err)
 		return
 	}
-	Log.Infoln("user: ", user, reqCategory)
 	if ok {
 		if reqCategory != "users" {
 			returnMsg := map[string]interface{}{
@@ -411,113 +603,122 @@ func getMovieHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	searchQuery := elastic.NewBoolQuery()
-	foundFilters := 0
-	movieName := r.URL.Query().Get("name")
-	if movieName != "" {
-		searchQuery.Should(elastic.NewMatchQuery("name", fmt.Sprint(movieName)))
-		foundFilters++
-	}
-	directorName := r.URL.Query().Get("director")
-	if directorName != "" {
-		searchQuery.Should(elastic.NewMatchQuery("director", fmt.Sprint(directorName)))
-		foundFilters++
-	}
-	popularity := r.URL.Query().Get("99popularity")
-	if popularity != "" {
-		searchQuery.Should(elastic.NewMatchQuery("99popularity", popularity))
-		foundFilters++
-	}
-	IMDBScore := r.URL.Query().Get("imdb_score")
-	if IMDBScore != "" {
-		searchQuery.Should(elastic.NewMatchQuery("imdb_score", IMDBScore))
-		foundFilters++
-	}
-	genre := r.URL.Query().Get("genre")
-	if genre != "" {
-		searchQuery.Should(elastic.NewMatchPhraseQuery("genre", genre))
-		foundFilters++
-	}
-	fromString := r.URL.Query().Get("from")
-	var from, size int
-	if fromString != "" {
-		from, err = strconv.Atoi(fromString)
-		if err != nil {
-			Log.Errorln("Unable to parse from value: ", err)
+	ok = (s.isAdmin(email) || isSuperAdmin(email))
+	if ok {
+		returnMsg, err = s.startReindex()
+	} else {
+		returnMsg = map[string]interface{}{
+			"message": "Not Authorized",
+			"status":  401,
+		}
+	}
+	writeBack(w, returnMsg, err)
+}
+
+// getReindexHandler returns the state of a reindex job
+func (s *server) getReindexHandler(w http.ResponseWriter, r *http.Request) {
+	var returnMsg map[string]interface{}
+	var err error
+	email, reqCategory, ok, err := basicAuth(r)
+	if err != nil {
+		Log.Errorln(err)
+		returnMsg = map[string]interface{}{
+			"message": "Internal server error",
+			"status":  http.StatusInternalServerError,
+		}
+		writeBack(w, returnMsg, err)
+		return
+	}
+	if ok {
+		if reqCategory != "users" {
 			returnMsg := map[string]interface{}{
-				"message": "from value must be an integer",
-				"status":  http.StatusBadRequest,
+				"message": "Unauthorized",
+				"status":  http.StatusUnauthorized,
 			}
 			writeBack(w, returnMsg, nil)
 			return
 		}
-		if from < 0 {
-			Log.Errorln("Unable to parse size value: ", err)
-			returnMsg := map[string]interface{}{
-				"message": "from value must greater than -1",
-				"status":  http.StatusBadRequest,
-			}
-			writeBack(w, returnMsg, nil)
+	}
+	ok = (s.isAdmin(email) || isSuperAdmin(email))
+	if ok {
+		var jobID int64
+		jobID, err = strconv.ParseInt(r.URL.Query().Get("job_id"), 10, 64)
+		if err != nil {
+			writeBack(w, nil, apierror.Validation("job_id required as integer URL param", apierror.Field("job_id", "must be an integer")))
 			return
 		}
+		returnMsg, err = getReindexJob(jobID)
 	} else {
-		from = 0
+		returnMsg = map[string]interface{}{
+			"message": "Not Authorized",
+			"status":  401,
+		}
 	}
-	sizeString := r.URL.Query().Get("size")
-	if sizeString != "" {
-		size, err = strconv.Atoi(sizeString)
-		if err != nil {
-			Log.Errorln("Unable to parse size value: ", err)
+	writeBack(w, returnMsg, err)
+}
+
+// rollbackReindexHandler moves the movie alias back to the index a reindex job copied from
+func (s *server) rollbackReindexHandler(w http.ResponseWriter, r *http.Request) {
+	var returnMsg map[string]interface{}
+	var err error
+	email, reqCategory, ok, err := basicAuth(r)
+	if err != nil {
+		Log.Errorln(err)
+		returnMsg = map[string]interface{}{
+			"message": "Internal server error",
+			"status":  http.StatusInternalServerError,
+		}
+		writeBack(w, returnMsg, err)
+		return
+	}
+	if ok {
+		if reqCategory != "users" {
 			returnMsg := map[string]interface{}{
-				"message": "size value must be an integer",
-				"status":  http.StatusBadRequest,
+				"message": "Unauthorized",
+				"status":  http.StatusUnauthorized,
 			}
 			writeBack(w, returnMsg, nil)
 			return
 		}
-		if size > 100 || size < 1 {
-			Log.Errorln("Unable to parse size value: ", err)
-			returnMsg := map[string]interface{}{
-				"message": "size value must be greater than 0 and less than 100",
-				"status":  http.StatusBadRequest,
-			}
-			writeBack(w, returnMsg, nil)
+	}
+	ok = (s.isAdmin(email) || isSuperAdmin(email))
+	if ok {
+		var jobID int64
+		jobID, err = strconv.ParseInt(r.URL.Query().Get("job_id"), 10, 64)
+		if err != nil {
+			writeBack(w, nil, apierror.Validation("job_id required as integer URL param", apierror.Field("job_id", "must be an integer")))
 			return
 		}
+		returnMsg, err = rollbackReindex(jobID)
 	} else {
-		size = 20
-	}
-
-	src, err := searchQuery.Source()
-	if err != nil {
-		Log.Errorln(err)
-	}
-	data, err := json.MarshalIndent(src, "", "  ")
-	if err != nil {
-		Log.Errorln(err)
+		returnMsg = map[string]interface{}{
+			"message": "Not Authorized",
+			"status":  401,
+		}
 	}
-	Log.Infoln(string(data))
-	searchQuery.MinimumNumberShouldMatch(foundFilters)
-	returnMsg, err = listMovies(searchQuery, foundFilters, from, size)
 	writeBack(w, returnMsg, err)
 }
 
+// writeBack writes the response of a handler: err when it is set, returnMsg otherwise with the status code in its status key.
+// A returnMsg with an error status gets the code and request id of the error body.
 func writeBack(w http.ResponseWriter, returnMsg map[string]interface{}, err error) {
-	w.Header().Set("Content-Type", "application/json")
-	e := json.NewEncoder(w)
 	if err != nil {
-		w.WriteHeader(500)
-		e.Encode(err.Error())
-	} else {
-		statusCode, ok := returnMsg["status"]
-		if ok {
-			w.WriteHeader(statusCode.(int))
-			delete(returnMsg, "status")
-			e.Encode(returnMsg)
-		} else {
-			e.Encode(returnMsg)
-		}
+		writeError(w, apierror.From(err), nil)
+		return
+	}
+	statusCode, ok := returnMsg["status"].(int)
+	delete(returnMsg, "status")
+	if ok && statusCode >= 400 {
+		message, _ := returnMsg["message"].(string)
+		delete(returnMsg, "message")
+		writeError(w, &apierror.Error{Code: apierror.CodeForStatus(statusCode), Message: message}, returnMsg)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if ok {
+		w.WriteHeader(statusCode)
 	}
+	json.NewEncoder(w).Encode(returnMsg)
 }
 
 // basicAuth fetches email, user category from the request
@@ -0,0 +1,711 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/raazcrzy/imdb/apierror"
+	"github.com/raazcrzy/imdb/dbConnections"
+	"github.com/raazcrzy/imdb/jobs"
+	"github.com/raazcrzy/imdb/models"
+	"github.com/raazcrzy/imdb/store"
+	"github.com/raazcrzy/imdb/utils"
+	"github.com/raazcrzy/imdb/validation"
+)
+
+const (
+	// jobWorkers is the number of jobs a server runs at a time
+	jobWorkers = 2
+	// jobsLimit is the number of jobs listed, the latest ones
+	jobsLimit = 100
+	// importProgressEvery is the number of movies an import writes between two progress updates
+	importProgressEvery = 100
+	// maxImportBytes is the size limit of the movies uploaded to POST /v1/import/movies
+	maxImportBytes = 1 << 30
+)
+
+// jobBody is the body of POST /v1/jobs, params depend on the type
+type jobBody struct {
+	Type   string          `json:"type" validate:"required"`
+	Params json.RawMessage `json:"params"`
+}
+
+// importParams are the params of a movies.import job: the movies to add, and whether the ones with a movie_id
+// replace the movie of that id. The movies uploaded to POST /v1/import/movies are in the input of the job instead,
+// a JSON movie per line, after the ones of the params.
+type importParams struct {
+	Movies []models.Movie `json:"movies"`
+	Update bool           `json:"update"`
+}
+
+// importFailure is a movie a movies.import job could not write, by its index in the params, then in the input
+type importFailure struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+// exportParams are the params of a movies.export job, search holds the URL params of GET /v2/movies
+type exportParams struct {
+	Search string `json:"search"`
+}
+
+// jobQueue returns the job queue with the job types the storage backends in use support
+func (s *server) jobQueue() *jobs.Queue {
+	queue := jobs.NewQueue(utils.PgDB)
+	// an import is not retried, a failed attempt may have added some of the movies already
+	queue.Register("movies.import", jobs.Type{
+		Policy:   jobs.Policy{MaxAttempts: 1, Timeout: time.Hour},
+		Validate: validateImport,
+		Run:      s.runImport,
+	})
+	queue.Register("movies.export", jobs.Type{
+		Policy:   jobs.Policy{MaxAttempts: 3, Backoff: 30 * time.Second, Timeout: time.Hour},
+		Validate: s.validateExport,
+		Run:      s.runExport,
+	})
+	// reindexing and repairing cannot be interrupted, they run until they are done whether cancelled or not
+	if utils.UsesElasticsearch() {
+		queue.Register("movies.reindex", jobs.Type{
+			Policy: jobs.Policy{MaxAttempts: 1},
+			Run:    runReindexJob,
+		})
+		queue.Register("movies.repair", jobs.Type{
+			Policy: jobs.Policy{MaxAttempts: 3, Backoff: time.Minute},
+			Run:    runRepairJob,
+		})
+	}
+	return queue
+}
+
+// decodeJobParams decodes the params of a job strictly, like request bodies
+func decodeJobParams(params json.RawMessage, value interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(params))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(value)
+	if err != nil {
+		return apierror.Validation("invalid job params: "+err.Error(), apierror.Field("params", err.Error()))
+	}
+	return nil
+}
+
+// validateImport checks every movie of a movies.import job
+func validateImport(params json.RawMessage) error {
+	importing := importParams{}
+	err := decodeJobParams(params, &importing)
+	if err != nil {
+		return err
+	}
+	if len(importing.Movies) == 0 {
+		return apierror.Validation("there are no movies to import", apierror.Field("params.movies", "must not be empty"))
+	}
+	fields := []apierror.FieldError{}
+	for i, movie := range importing.Movies {
+		for _, field := range validation.Struct(movie) {
+			field.Field = fmt.Sprintf("params.movies[%d].%s", i, field.Field)
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) > 0 {
+		return apierror.Validation("one or more movies are invalid", fields...)
+	}
+	return nil
+}
+
+// runImport adds, or replaces, the movies of a movies.import job one at a time. A movie that cannot be written is
+// reported in the result and does not stop the import.
+func (s *server) runImport(ctx context.Context, run *jobs.Run) (interface{}, error) {
+	importing := importParams{}
+	err := run.Params(&importing)
+	if err != nil {
+		return nil, jobs.Permanent(err)
+	}
+	uploaded, err := countLines(run.Input())
+	if err != nil {
+		return nil, err
+	}
+	total := int64(len(importing.Movies)) + uploaded
+	input := json.NewDecoder(run.Input())
+	added, updated, failed := 0, 0, []importFailure{}
+	for i := 0; int64(i) < total; i++ {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		movie := models.Movie{}
+		if i < len(importing.Movies) {
+			movie = importing.Movies[i]
+		} else {
+			err = input.Decode(&movie)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if importing.Update && movie.ID != "" {
+			_, err = s.editMovie(movie)
+			if err == nil {
+				updated++
+			}
+		} else {
+			movie.ID = ""
+			_, err = s.addMovie(movie)
+			if err == nil {
+				added++
+			}
+		}
+		if err != nil {
+			failed = append(failed, importFailure{Index: i, Error: err.Error()})
+		}
+		// the attempt stops once it lost its claim on the job, rather than writing until its next heartbeat
+		if (i+1)%importProgressEvery == 0 {
+			err = run.Progress(int64(i+1), total)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	err = run.Progress(total, total)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"added": added, "updated": updated, "failed": failed}, nil
+}
+
+// countLines counts the lines of r, the movies of an import input
+func countLines(r io.Reader) (int64, error) {
+	count := int64(0)
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buffer)
+		count += int64(bytes.Count(buffer[:n], []byte("\n")))
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// importMovies queues a movies.import job of the movies of an upload, a JSON movie per line. The movies are checked
+// as the upload is stored, and the job is only queued when they all are valid.
+func (s *server) importMovies(body io.Reader, update bool) (map[string]interface{}, error) {
+	params, err := json.Marshal(importParams{Update: update})
+	if err != nil {
+		return nil, err
+	}
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(checkImportInput(body, writer))
+	}()
+	job, err := s.jobs.EnqueueInput("movies.import", params, reader)
+	// stops checkImportInput when the upload could not be stored
+	reader.Close()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"message": "job queued",
+		"job":     job,
+		"status":  202,
+	}, nil
+}
+
+// checkImportInput copies the movies of an upload to w, a JSON movie per line. It reads them all and fails when one
+// of them is invalid, the way POST /v1/add/movie checks a movie.
+func checkImportInput(body io.Reader, w io.Writer) error {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	encoder := json.NewEncoder(w)
+	fields := []apierror.FieldError{}
+	count := 0
+	for {
+		movie := models.Movie{}
+		err := decoder.Decode(&movie)
+		if err == io.EOF {
+			break
+		}
+		// http.MaxBytesReader has no error type of its own
+		if err != nil && err.Error() == "http: request body too large" {
+			return apierror.New(apierror.PayloadTooLarge, fmt.Sprintf("the uploaded movies must not be larger than %dMB", maxImportBytes>>20))
+		}
+		if err != nil {
+			field := fmt.Sprintf("movies[%d]", count)
+			return apierror.Validation(fmt.Sprintf("%s is not a valid movie: %s", field, err), apierror.Field(field, err.Error()))
+		}
+		for _, field := range validation.Struct(movie) {
+			field.Field = fmt.Sprintf("movies[%d].%s", count, field.Field)
+			fields = append(fields, field)
+		}
+		count++
+		if len(fields) == 0 {
+			err = encoder.Encode(movie)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if count == 0 {
+		return apierror.Validation("there are no movies to import", apierror.Field("movies", "must not be empty"))
+	}
+	if len(fields) > 0 {
+		return apierror.Validation("one or more movies are invalid", fields...)
+	}
+	return nil
+}
+
+// exportSearch returns the movie search of a movies.export job
+func (s *server) exportSearch(params json.RawMessage) (movieSearch, error) {
+	exporting := exportParams{}
+	err := decodeJobParams(params, &exporting)
+	if err != nil {
+		return movieSearch{}, err
+	}
+	values, err := url.ParseQuery(exporting.Search)
+	if err != nil {
+		return movieSearch{}, apierror.Validation("params.search must be URL params", apierror.Field("params.search", "must be URL params"))
+	}
+	for _, param := range []string{"from", "size", "cursor", "facets", "highlight", "explain"} {
+		values.Del(param)
+	}
+	values.Set("size", strconv.Itoa(exportPageSize))
+	return s.parseMovieSearch(values, "")
+}
+
+// validateExport checks the search of a movies.export job
+func (s *server) validateExport(params json.RawMessage) error {
+	_, err := s.exportSearch(params)
+	return err
+}
+
+// runExport writes the movies matching the search of a movies.export job to its output as JSON lines, a page at a
+// time, the result only tells their count and where to download them
+func (s *server) runExport(ctx context.Context, run *jobs.Run) (interface{}, error) {
+	search, err := s.exportSearch(run.Job.Params)
+	if err != nil {
+		return nil, jobs.Permanent(err)
+	}
+	count := int64(0)
+	err = s.exportMovies(search, func(results store.MovieResults) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if len(results.Hits) == 0 {
+			return nil
+		}
+		page := bytes.Buffer{}
+		encoder := json.NewEncoder(&page)
+		for _, hit := range results.Hits {
+			err := encoder.Encode(hit.Movie)
+			if err != nil {
+				return err
+			}
+		}
+		err := run.WriteOutput(page.Bytes())
+		if err != nil {
+			return err
+		}
+		count += int64(len(results.Hits))
+		return run.Progress(count, results.Total)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"count": count, "output": fmt.Sprintf("/v1/jobs/%d/output", run.Job.ID)}, nil
+}
+
+// runReindexJob copies the movie index into a new index, queued by POST /v1/reindex/movie and the synonyms updates
+// the index cannot reload
+func runReindexJob(ctx context.Context, run *jobs.Run) (interface{}, error) {
+	job, err := dbConnections.StartReindex()
+	if err == dbConnections.ErrReindexRunning {
+		return nil, jobs.Permanent(err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = dbConnections.RunReindex(job)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"reindex_job_id": job.ID, "index": job.TargetIndex}, nil
+}
+
+// runRepairJob reconciles the movie index with postgres, like `app repair`
+func runRepairJob(ctx context.Context, run *jobs.Run) (interface{}, error) {
+	report, err := store.RepairMovies(utils.PgDB, utils.MovieIndex)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// addJobHandler queues a job, admins only
+func (s *server) addJobHandler(w http.ResponseWriter, r *http.Request) {
+	var returnMsg map[string]interface{}
+	var err error
+	email, reqCategory, ok, err := basicAuth(r)
+	if err != nil {
+		Log.Errorln(err)
+		returnMsg = map[string]interface{}{
+			"message": "Internal server error",
+			"status":  http.StatusInternalServerError,
+		}
+		writeBack(w, returnMsg, err)
+		return
+	}
+	if ok {
+		if reqCategory != "users" {
+			returnMsg := map[string]interface{}{
+				"message": "Unauthorized",
+				"status":  http.StatusUnauthorized,
+			}
+			writeBack(w, returnMsg, nil)
+			return
+		}
+	}
+	ok = (s.isAdmin(email) || isSuperAdmin(email))
+	if ok {
+		body := jobBody{}
+		err = validation.DecodeBody(w, r, &body)
+		if err != nil {
+			writeBack(w, nil, err)
+			return
+		}
+		err = validation.Check(body)
+		if err != nil {
+			writeBack(w, nil, err)
+			return
+		}
+		returnMsg, err = s.addJob(body)
+	} else {
+		returnMsg = map[string]interface{}{
+			"message": "Not Authorized",
+			"status":  401,
+		}
+	}
+	writeBack(w, returnMsg, err)
+}
+
+// importMoviesHandler queues a movies.import job of the movies of the request body, a JSON movie per line, admins
+// only. Unlike the params of POST /v1/jobs, the body may be larger than validation.MaxBodyBytes.
+func (s *server) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var returnMsg map[string]interface{}
+	var err error
+	email, reqCategory, ok, err := basicAuth(r)
+	if err != nil {
+		Log.Errorln(err)
+		returnMsg = map[string]interface{}{
+			"message": "Internal server error",
+			"status":  http.StatusInternalServerError,
+		}
+		writeBack(w, returnMsg, err)
+		return
+	}
+	if ok {
+		if reqCategory != "users" {
+			returnMsg := map[string]interface{}{
+				"message": "Unauthorized",
+				"status":  http.StatusUnauthorized,
+			}
+			writeBack(w, returnMsg, nil)
+			return
+		}
+	}
+	ok = (s.isAdmin(email) || isSuperAdmin(email))
+	if ok {
+		var update bool
+		update, err = boolParam(r.URL.Query(), "update")
+		if err != nil {
+			writeBack(w, nil, apierror.Validation(err.Error(), apierror.Field("update", "must be a boolean")))
+			return
+		}
+		returnMsg, err = s.importMovies(http.MaxBytesReader(w, r.Body, maxImportBytes), update)
+	} else {
+		returnMsg = map[string]interface{}{
+			"message": "Not Authorized",
+			"status":  401,
+		}
+	}
+	writeBack(w, returnMsg, err)
+}
+
+// getJobsHandler lists the latest jobs, of the type and status params when they are set, admins only
+func (s *server) getJobsHandler(w http.ResponseWriter, r *http.Request) {
+	var returnMsg map[string]interface{}
+	var err error
+	email, reqCategory, ok, err := basicAuth(r)
+	if err != nil {
+		Log.Errorln(err)
+		returnMsg = map[string]interface{}{
+			"message": "Internal server error",
+			"status":  http.StatusInternalServerError,
+		}
+		writeBack(w, returnMsg, err)
+		return
+	}
+	if ok {
+		if reqCategory != "users" {
+			returnMsg := map[string]interface{}{
+				"message": "Unauthorized",
+				"status":  http.StatusUnauthorized,
+			}
+			writeBack(w, returnMsg, nil)
+			return
+		}
+	}
+	ok = (s.isAdmin(email) || isSuperAdmin(email))
+	if ok {
+		returnMsg, err = s.listJobs(r.URL.Query().Get("type"), r.URL.Query().Get("status"))
+	} else {
+		returnMsg = map[string]interface{}{
+			"message": "Not Authorized",
+			"status":  401,
+		}
+	}
+	writeBack(w, returnMsg, err)
+}
+
+// getJobHandler returns the status and progress of a job, admins only
+func (s *server) getJobHandler(w http.ResponseWriter, r *http.Request) {
+	var returnMsg map[string]interface{}
+	var err error
+	email, reqCategory, ok, err := basicAuth(r)
+	if err != nil {
+		Log.Errorln(err)
+		returnMsg = map[string]interface{}{
+			"message": "Internal server error",
+			"status":  http.StatusInternalServerError,
+		}
+		writeBack(w, returnMsg, err)
+		return
+	}
+	if ok {
+		if reqCategory != "users" {
+			returnMsg := map[string]interface{}{
+				"message": "Unauthorized",
+				"status":  http.StatusUnauthorized,
+			}
+			writeBack(w, returnMsg, nil)
+			return
+		}
+	}
+	ok = (s.isAdmin(email) || isSuperAdmin(email))
+	if ok {
+		var id int64
+		id, err = jobIDParam(r)
+		if err != nil {
+			writeBack(w, nil, err)
+			return
+		}
+		returnMsg, err = s.getJob(id)
+	} else {
+		returnMsg = map[string]interface{}{
+			"message": "Not Authorized",
+			"status":  401,
+		}
+	}
+	writeBack(w, returnMsg, err)
+}
+
+// getJobOutputHandler downloads the output of a succeeded job, such as the movies of an export as JSON lines, admins only
+func (s *server) getJobOutputHandler(w http.ResponseWriter, r *http.Request) {
+	var returnMsg map[string]interface{}
+	var err error
+	email, reqCategory, ok, err := basicAuth(r)
+	if err != nil {
+		Log.Errorln(err)
+		returnMsg = map[string]interface{}{
+			"message": "Internal server error",
+			"status":  http.StatusInternalServerError,
+		}
+		writeBack(w, returnMsg, err)
+		return
+	}
+	if ok {
+		if reqCategory != "users" {
+			returnMsg := map[string]interface{}{
+				"message": "Unauthorized",
+				"status":  http.StatusUnauthorized,
+			}
+			writeBack(w, returnMsg, nil)
+			return
+		}
+	}
+	ok = (s.isAdmin(email) || isSuperAdmin(email))
+	if !ok {
+		returnMsg = map[string]interface{}{
+			"message": "Not Authorized",
+			"status":  401,
+		}
+		writeBack(w, returnMsg, nil)
+		return
+	}
+	id, err := jobIDParam(r)
+	if err != nil {
+		writeBack(w, nil, err)
+		return
+	}
+	job, err := s.jobs.Get(id)
+	if err != nil {
+		Log.Errorln(err)
+		writeBack(w, nil, err)
+		return
+	}
+	if job == nil {
+		returnMsg = map[string]interface{}{
+			"message": "job not found",
+			"status":  404,
+		}
+		writeBack(w, returnMsg, nil)
+		return
+	}
+	if job.Status != jobs.Succeeded {
+		writeBack(w, nil, apierror.New(apierror.Conflict, fmt.Sprintf("job %d is %s, its output is only served once it succeeded", id, job.Status)))
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	err = s.jobs.Output(*job, func(chunk []byte) error {
+		_, err := w.Write(chunk)
+		return err
+	})
+	if err != nil {
+		// the status is sent already, the client sees a truncated body
+		Log.Errorln("cannot send the output of job", id, ":", err)
+	}
+}
+
+// cancelJobHandler cancels a queued or running job, admins only
+func (s *server) cancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	var returnMsg map[string]interface{}
+	var err error
+	email, reqCategory, ok, err := basicAuth(r)
+	if err != nil {
+		Log.Errorln(err)
+		returnMsg = map[string]interface{}{
+			"message": "Internal server error",
+			"status":  http.StatusInternalServerError,
+		}
+		writeBack(w, returnMsg, err)
+		return
+	}
+	if ok {
+		if reqCategory != "users" {
+			returnMsg := map[string]interface{}{
+				"message": "Unauthorized",
+				"status":  http.StatusUnauthorized,
+			}
+			writeBack(w, returnMsg, nil)
+			return
+		}
+	}
+	ok = (s.isAdmin(email) || isSuperAdmin(email))
+	if ok {
+		var id int64
+		id, err = jobIDParam(r)
+		if err != nil {
+			writeBack(w, nil, err)
+			return
+		}
+		returnMsg, err = s.cancelJob(id)
+	} else {
+		returnMsg = map[string]interface{}{
+			"message": "Not Authorized",
+			"status":  401,
+		}
+	}
+	writeBack(w, returnMsg, err)
+}
+
+// jobIDParam returns the job id of the route path
+func jobIDParam(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(pathParam(r, "id"), 10, 64)
+	if err != nil {
+		return 0, apierror.Validation("job id must be an integer", apierror.Field("id", "must be an integer"))
+	}
+	return id, nil
+}
+
+// addJob queues a job of a known type whose params are valid
+func (s *server) addJob(body jobBody) (map[string]interface{}, error) {
+	job, err := s.jobs.Enqueue(body.Type, body.Params)
+	if err == jobs.ErrUnknownType {
+		return nil, apierror.Validation(fmt.Sprintf("unknown job type %q", body.Type), apierror.Field("type", "must be one of: "+strings.Join(s.jobs.Types(), ", ")))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"message": "job queued",
+		"job":     job,
+		"status":  202,
+	}, nil
+}
+
+// listJobs returns the latest jobs, newest first
+func (s *server) listJobs(jobType, status string) (map[string]interface{}, error) {
+	list, err := s.jobs.List(jobType, status, jobsLimit)
+	if err != nil {
+		Log.Errorln(err)
+		return nil, err
+	}
+	return map[string]interface{}{
+		"message": "request successful",
+		"jobs":    list,
+		"status":  200,
+	}, nil
+}
+
+// getJob returns a job
+func (s *server) getJob(id int64) (map[string]interface{}, error) {
+	job, err := s.jobs.Get(id)
+	if err != nil {
+		Log.Errorln(err)
+		return nil, err
+	}
+	if job == nil {
+		return map[string]interface{}{
+			"message": "job not found",
+			"status":  404,
+		}, nil
+	}
+	return map[string]interface{}{
+		"message": "request successful",
+		"job":     job,
+		"status":  200,
+	}, nil
+}
+
+// cancelJob cancels a job, a running job is only cancelled once its worker notices, so it may still succeed
+func (s *server) cancelJob(id int64) (map[string]interface{}, error) {
+	job, err := s.jobs.Cancel(id)
+	if err == jobs.ErrFinished {
+		return nil, apierror.New(apierror.Conflict, fmt.Sprintf("job %d is already %s", id, job.Status))
+	}
+	if err != nil {
+		Log.Errorln(err)
+		return nil, err
+	}
+	if job == nil {
+		return map[string]interface{}{
+			"message": "job not found",
+			"status":  404,
+		}, nil
+	}
+	message := "job canceled"
+	if job.Status == jobs.Running {
+		message = "cancellation requested"
+	}
+	return map[string]interface{}{
+		"message": message,
+		"job":     job,
+		"status":  200,
+	}, nil
+}
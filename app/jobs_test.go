@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/raazcrzy/imdb/apierror"
+)
+
+// TestCheckImportInput checks the movies of uploads and what is stored of them, the movies stored before an invalid
+// one are discarded along with the job
+func TestCheckImportInput(t *testing.T) {
+	psycho := `{"name": "Psycho", "director": "Alfred Hitchcock", "genre": ["Horror"], "imdb_score": 8.5, "99popularity": 85}`
+	jaws := `{"movie_id": "a", "name": "Jaws", "director": "Steven Spielberg", "genre": ["Thriller"]}`
+	storedPsycho := `{"name":"Psycho","99popularity":85,"director":"Alfred Hitchcock","genre":["Horror"],"imdb_score":8.5}` + "\n"
+	storedJaws := `{"movie_id":"a","name":"Jaws","99popularity":0,"director":"Steven Spielberg","genre":["Thriller"],"imdb_score":0}` + "\n"
+	tests := []struct {
+		name   string
+		upload string
+		stored string
+		code   string
+		fields []string
+	}{
+		{"lines", psycho + "\n" + jaws + "\n", storedPsycho + storedJaws, "", nil},
+		{"without a last newline", jaws, storedJaws, "", nil},
+		{"invalid movies", psycho + "\n" + `{"name": "Jaws", "genre": ["Thriller"], "imdb_score": 11}` + "\n" + `{"director": "Nobody", "genre": ["Drama"]}`,
+			storedPsycho, apierror.ValidationFailed, []string{"movies[1].director", "movies[1].imdb_score", "movies[2].name"}},
+		{"unknown field", psycho + "\n" + `{"name": "Psycho", "year": 1960}`, storedPsycho, apierror.ValidationFailed, []string{"movies[1]"}},
+		{"not JSON", "Psycho,Alfred Hitchcock", "", apierror.ValidationFailed, []string{"movies[0]"}},
+		{"empty", "\n", "", apierror.ValidationFailed, []string{"movies"}},
+	}
+	for _, test := range tests {
+		stored := bytes.Buffer{}
+		err := checkImportInput(strings.NewReader(test.upload), &stored)
+		if stored.String() != test.stored {
+			t.Errorf("%s: stored %q, want %q", test.name, stored.String(), test.stored)
+		}
+		if test.code == "" {
+			if err != nil {
+				t.Errorf("%s: %s", test.name, err)
+			}
+			continue
+		}
+		var apiErr *apierror.Error
+		if !errors.As(err, &apiErr) || apiErr.Code != test.code {
+			t.Errorf("%s: got %v, want a %s error", test.name, err, test.code)
+			continue
+		}
+		fields := []string{}
+		for _, field := range apiErr.Fields {
+			fields = append(fields, field.Field)
+		}
+		if !reflect.DeepEqual(fields, test.fields) {
+			t.Errorf("%s: got fields %v, want %v", test.name, fields, test.fields)
+		}
+	}
+}
+
+func TestCheckImportInputTooLarge(t *testing.T) {
+	upload := strings.Repeat(`{"name": "Psycho", "director": "Alfred Hitchcock", "genre": ["Horror"]}`+"\n", 100)
+	body := http.MaxBytesReader(httptest.NewRecorder(), ioutil.NopCloser(strings.NewReader(upload)), int64(len(upload)/2))
+	var apiErr *apierror.Error
+	if err := checkImportInput(body, ioutil.Discard); !errors.As(err, &apiErr) || apiErr.Code != apierror.PayloadTooLarge {
+		t.Errorf("got %v, want a %s error", err, apierror.PayloadTooLarge)
+	}
+}
+
+func TestCountLines(t *testing.T) {
+	for upload, lines := range map[string]int64{"": 0, "{}\n": 1, "{}\n{}\n{}\n": 3, strings.Repeat("{}\n", 50000): 50000} {
+		if count, err := countLines(strings.NewReader(upload)); err != nil || count != lines {
+			t.Errorf("%d lines: got %d, %v", lines, count, err)
+		}
+	}
+}
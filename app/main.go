@@ -15,16 +15,77 @@ import (
 var Log = logrus.New()
 var emailKey, categoryKey interface{}
 
-// initializes env vars, Log with log levels, DB connections, and starts server on port 8000
+// initializes env vars, Log with log levels, DB connections, and starts server on port 8000,
+// and the gRPC server on GRPCAddress when it is set.
+// When the first argument is a command, the command is run instead of the server:
+//
+//	app reindex [env files]                      copies the movie index into a new index and moves the alias to it
+//	app migrate up|down|status [n] [env files]   applies the pending schema migrations, reverts the last n (1 by default) or lists them
+//	app repair [env files]                       reconciles the movie index with the movies stored in postgres
+//	app openapi                                  prints the OpenAPI document of every endpoint
+//	app graphql                                  prints the GraphQL schema of /graphql
 func main() {
 	emailKey = "email"
 	categoryKey = "category"
-	utils.ReadEnvironmentVariables()
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "openapi" {
+		runOpenAPICommand()
+		return
+	}
+	if len(args) > 0 && args[0] == "graphql" {
+		fmt.Print(newServer(nil, nil, nil).schema.SDL())
+		return
+	}
+	command, migrateAction, migrateCount := "", "", 0
+	if len(args) > 0 && (args[0] == "reindex" || args[0] == "migrate" || args[0] == "repair") {
+		command, args = args[0], args[1:]
+	}
+	if command == "migrate" {
+		migrateAction, migrateCount, args = parseMigrateArgs(args)
+	}
+	utils.ReadEnvironmentVariables(args)
 	Log.SetLevel(getLogLevel(utils.LogLevel))
 	Log.SetOutput(os.Stdout)
 	initLogger()
+	if command == "migrate" {
+		if !utils.UsesPostgres() {
+			log.Fatalln("migrate needs postgres, which is not used when UserStorage and MovieStorage are memory")
+		}
+		dbConnections.InitPostgres()
+		runMigrateCommand(migrateAction, migrateCount)
+		return
+	}
+	if (command == "reindex" || command == "repair") && !utils.UsesElasticsearch() {
+		log.Fatalln(command, "needs MovieStorage to be elasticsearch")
+	}
 	dbConnections.InitDbs()
-	getRoutes()
+	if command == "reindex" {
+		runReindexCommand()
+		return
+	}
+	if utils.UsesElasticsearch() {
+		dbConnections.EnsureMovieIndex()
+		if command == "repair" {
+			runRepairCommand()
+			return
+		}
+		importElasticMovies()
+		go dbConnections.RunOutboxWorker()
+	}
+	if utils.MoviesInPostgres() {
+		go dbConnections.RunWebhookWorker()
+	}
+	s := newServer(newStores())
+	if s.jobs != nil {
+		go s.jobs.Work(jobWorkers)
+	}
+	if utils.GRPCAddress != "" {
+		go func() {
+			log.Fatal(http.ListenAndServeTLS(utils.GRPCAddress, utils.GRPCCertFile, utils.GRPCKeyFile, withRequestID(s.grpcServer())))
+		}()
+		fmt.Println("gRPC server started on", utils.GRPCAddress)
+	}
+	routes := getRoutes(s)
 	fmt.Println("Server started...")
-	log.Fatal(http.ListenAndServe("localhost:8000", nil))
+	log.Fatal(http.ListenAndServe("localhost:8000", routes))
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/raazcrzy/imdb/migrations"
+	"github.com/raazcrzy/imdb/utils"
+)
+
+// parseMigrateArgs splits the arguments of `app migrate` into the action, the optional number of migrations
+// and the env files that follow them
+func parseMigrateArgs(args []string) (string, int, []string) {
+	if len(args) == 0 || (args[0] != "up" && args[0] != "down" && args[0] != "status") {
+		log.Fatalln("usage: app migrate up|down|status [n] [env files]")
+	}
+	action, args := args[0], args[1:]
+	n := 0
+	if action == "down" {
+		n = 1
+	}
+	if len(args) > 0 {
+		if value, err := strconv.Atoi(args[0]); err == nil {
+			if value < 1 {
+				log.Fatalln("the number of migrations must be at least 1")
+			}
+			n, args = value, args[1:]
+		}
+	}
+	return action, n, args
+}
+
+// runMigrateCommand applies, reverts or lists the schema migrations, used by `app migrate`
+func runMigrateCommand(action string, n int) {
+	switch action {
+	case "up":
+		applied, err := migrations.Up(utils.PgDB, n)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		for _, migration := range applied {
+			fmt.Println("applied", migration.Version, migration.Name)
+		}
+		if len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+	case "down":
+		reverted, err := migrations.Down(utils.PgDB, n)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		for _, migration := range reverted {
+			fmt.Println("reverted", migration.Version, migration.Name)
+		}
+		if len(reverted) == 0 {
+			fmt.Println("no applied migrations")
+		}
+	case "status":
+		states, err := migrations.Status(utils.PgDB)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		for _, state := range states {
+			applied := "pending"
+			if state.AppliedAt != 0 {
+				applied = "applied at " + time.Unix(state.AppliedAt, 0).UTC().Format(time.RFC3339)
+			}
+			fmt.Printf("%04d %-30s %s\n", state.Version, state.Name, applied)
+		}
+	}
+}
@@ -1,39 +1,59 @@
 package main
 
 import (
-	ctx "context"
 	"encoding/json"
+	"errors"
 
-	elastic "gopkg.in/olivere/elastic.v5"
-
+	"github.com/raazcrzy/imdb/apierror"
+	"github.com/raazcrzy/imdb/dbConnections"
 	"github.com/raazcrzy/imdb/models"
-	"github.com/raazcrzy/imdb/utils"
+	"github.com/raazcrzy/imdb/store"
 )
 
-// addMovie function adds a new movie to the elasticsearch index
-func addMovie(movie models.Movie) (map[string]interface{}, error) {
-	_, err := utils.Elasticconn.Index().Index(utils.MovieIndex).Type("imdb").BodyJson(movie).Do(ctx.Background())
+// exportPageSize is the number of movies an export fetches at a time
+const exportPageSize = 100
+
+// addMovie function adds a new movie to the movie store.
+// addMovie, editMovie and deleteMovie record a movie event once the movie is written.
+func (s *server) addMovie(movie models.Movie) (map[string]interface{}, error) {
+	id, err := s.movies.AddMovie(movie)
 	if err != nil {
-		return map[string]interface{}{
-			"message": err.Error(),
-			"status":  400,
-		}, nil
+		return nil, err
 	}
+	s.movieEventRecorded()
 	return map[string]interface{}{
-		"message": "movie added successfully",
-		"status":  201,
+		"message":  "movie added successfully",
+		"movie_id": id,
+		"status":   201,
 	}, nil
 }
 
-// deleteMovie function deletes a movie from the elasticsearch index
-func deleteMovie(movieID string) (map[string]interface{}, error) {
-	_, err := utils.Elasticconn.Delete().Index(utils.MovieIndex).Type("imdb").Id(movieID).Do(ctx.Background())
+// getMovie function fetches a movie of the movie store by id
+func (s *server) getMovie(movieID string) (map[string]interface{}, error) {
+	movie, err := s.movies.GetMovie(movieID)
+	if errors.Is(err, store.ErrMovieNotFound) {
+		return nil, apierror.New(apierror.NotFound, err.Error())
+	}
 	if err != nil {
-		return map[string]interface{}{
-			"message": err.Error(),
-			"status":  400,
-		}, nil
+		return nil, err
+	}
+	return map[string]interface{}{
+		"message": "request successful",
+		"movie":   movie,
+		"status":  200,
+	}, nil
+}
+
+// deleteMovie function deletes a movie from the movie store
+func (s *server) deleteMovie(movieID string) (map[string]interface{}, error) {
+	err := s.movies.DeleteMovie(movieID)
+	if errors.Is(err, store.ErrMovieNotFound) {
+		return nil, apierror.New(apierror.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, err
 	}
+	s.movieEventRecorded()
 	return map[string]interface{}{
 		"message": "movie deleted successfully",
 		"status":  200,
@@ -41,54 +61,120 @@ func deleteMovie(movieID string) (map[string]interface{}, error) {
 }
 
 // editMovie function edits an existing movie
-func editMovie(movie models.Movie) (map[string]interface{}, error) {
-	_, err := utils.Elasticconn.Update().Index(utils.MovieIndex).Type("imdb").Id(movie.ID).Doc(movie).Do(ctx.Background())
+func (s *server) editMovie(movie models.Movie) (map[string]interface{}, error) {
+	err := s.movies.EditMovie(movie)
+	if errors.Is(err, store.ErrMovieNotFound) {
+		return nil, apierror.New(apierror.NotFound, err.Error())
+	}
 	if err != nil {
-		return map[string]interface{}{
-			"message": err.Error(),
-			"status":  400,
-		}, nil
+		return nil, err
 	}
+	s.movieEventRecorded()
 	return map[string]interface{}{
 		"message": "movie updated successfully",
 		"status":  200,
 	}, nil
 }
 
-// listMovies function queries the elasticsearch with appropriate query and fetches the list of movies matching the query
-func listMovies(query elastic.Query, filters int, from, size int) (map[string]interface{}, error) {
-	if filters == 0 {
-		query = elastic.NewMatchAllQuery()
-	}
-	src, err := query.Source()
+// listMovies function searches the movie store and fetches the list of movies matching the query
+func (s *server) listMovies(search movieSearch) (map[string]interface{}, error) {
+	data, err := json.Marshal(search.MovieQuery)
 	if err != nil {
 		Log.Errorln(err)
 	}
-	data, err := json.MarshalIndent(src, "", "  ")
+	Log.Infoln("search:", string(data))
+	results, nextCursor, err := s.searchMovies(search)
+	if err != nil {
+		return nil, err
+	}
+	returnMsg := map[string]interface{}{
+		"message": "request successful",
+		"movies":  results.Hits,
+		"total":   results.Total,
+		"status":  200,
+	}
+	if nextCursor != "" {
+		returnMsg["next_cursor"] = nextCursor
+	}
+	if search.Facets {
+		returnMsg["facets"] = results.Facets
+	}
+	return returnMsg, nil
+}
+
+// searchMovies runs a search on the movie store, along with the cursor of the next page when there may be one
+func (s *server) searchMovies(search movieSearch) (store.MovieResults, string, error) {
+	results, err := s.movies.SearchMovies(search.MovieQuery)
+	if err != nil {
+		return store.MovieResults{}, "", err
+	}
+	Log.Infoln("total hits: ", results.Total)
+	// a full page may be followed by more results
+	if len(results.Hits) == 0 || len(results.Hits) < search.Size {
+		return results, "", nil
+	}
+	nextCursor, err := encodeCursor(results.Hits[len(results.Hits)-1].Sort, search.fingerprint)
 	if err != nil {
 		Log.Errorln(err)
+		return store.MovieResults{}, "", err
+	}
+	return results, nextCursor, nil
+}
+
+// exportMovies runs a search a page of exportPageSize movies at a time, calling page with each page until the last
+func (s *server) exportMovies(search movieSearch, page func(results store.MovieResults) error) error {
+	for {
+		results, nextCursor, err := s.searchMovies(search)
+		if err != nil {
+			return err
+		}
+		err = page(results)
+		if err != nil {
+			return err
+		}
+		if nextCursor == "" {
+			return nil
+		}
+		search.After = results.Hits[len(results.Hits)-1].Sort
 	}
-	Log.Infoln("here:", string(data))
-	movies := []models.Movie{}
-	response, err := utils.Elasticconn.Search().Index(utils.MovieIndex).Type("imdb").Query(query).From(from).Size(size).Do(ctx.Background())
+}
+
+// updateSynonyms saves new synonym rules and applies them to the movie index, through a queued movies.reindex job
+// when the index cannot reload them
+func (s *server) updateSynonyms(synonyms []string) (map[string]interface{}, error) {
+	reindex, err := dbConnections.UpdateSynonyms(synonyms)
 	if err != nil {
+		Log.Errorln(err)
+		return nil, err
+	}
+	if reindex {
+		job, err := s.jobs.Enqueue("movies.reindex", nil)
+		if err != nil {
+			Log.Errorln(err)
+			return nil, err
+		}
 		return map[string]interface{}{
-			"message": err.Error(),
-			"status":  400,
+			"message": "synonyms saved, they apply once the reindex job is done",
+			"job":     job,
+			"status":  202,
 		}, nil
 	}
-	Log.Infof("%#v", response, response.Hits.TotalHits)
-	for i := range response.Hits.Hits {
-		movie := models.Movie{}
-		Log.Infoln(string(*response.Hits.Hits[i].Source))
-		json.Unmarshal(*response.Hits.Hits[i].Source, &movie)
-		movie.ID = response.Hits.Hits[i].Id
-		movies = append(movies, movie)
+	return map[string]interface{}{
+		"message": "synonyms updated successfully",
+		"status":  200,
+	}, nil
+}
 
+// listSynonyms returns the managed synonym rules
+func listSynonyms() (map[string]interface{}, error) {
+	synonyms, err := dbConnections.ReadSynonyms()
+	if err != nil {
+		Log.Errorln(err)
+		return nil, err
 	}
 	return map[string]interface{}{
-		"message": "request successful",
-		"movies":  movies,
-		"status":  200,
+		"message":  "request successful",
+		"synonyms": synonyms,
+		"status":   200,
 	}, nil
 }
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// request sends a request signed in as the admin and returns the status and the decoded JSON body
+func request(t *testing.T, routes http.Handler, method, path, body string) (int, map[string]interface{}) {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.SetBasicAuth("foox", "barx")
+	w := httptest.NewRecorder()
+	routes.ServeHTTP(w, r)
+	result := map[string]interface{}{}
+	err := json.Unmarshal(w.Body.Bytes(), &result)
+	if err != nil {
+		t.Fatalf("%s %s: body is not JSON: %s", method, path, w.Body.String())
+	}
+	return w.Code, result
+}
+
+// movieNames returns the names of the movies of a search response, in order
+func movieNames(body map[string]interface{}) []string {
+	names := []string{}
+	movies, _ := body["movies"].([]interface{})
+	for _, movie := range movies {
+		names = append(names, movie.(map[string]interface{})["name"].(string))
+	}
+	return names
+}
+
+// TestMovieHandlers adds, edits and deletes a movie through the handlers of a server keeping movies in memory.
+// Each step runs on the state left by the previous ones, {id} is the id of the movie added by the first.
+func TestMovieHandlers(t *testing.T) {
+	routes, _ := newTestRoutes(t)
+	id := ""
+	steps := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		want   map[string]interface{}
+	}{
+		{"add", "POST", "/v2/movies",
+			`{"name": "Psycho", "director": "Alfred Hitchcock", "genre": ["Horror"], "imdb_score": 8.5, "99popularity": 85}`,
+			201, nil},
+		{"add without a name", "POST", "/v2/movies", `{"director": "Alfred Hitchcock", "genre": ["Horror"]}`, 400, nil},
+		{"add an unknown genre", "POST", "/v1/add/movie", `{"name": "Psycho", "director": "Alfred Hitchcock", "genre": ["Slasher"]}`, 400, nil},
+		{"get", "GET", "/v2/movies/{id}", "", 200,
+			map[string]interface{}{"name": "Psycho", "director": "Alfred Hitchcock", "imdb_score": 8.5}},
+		{"edit", "PUT", "/v2/movies/{id}",
+			`{"name": "Psycho", "director": "Alfred Hitchcock", "genre": ["Horror", "Thriller"], "imdb_score": 8.6, "99popularity": 86}`,
+			200, nil},
+		{"get the edited movie", "GET", "/v2/movies/{id}", "", 200,
+			map[string]interface{}{"genre": []interface{}{"Horror", "Thriller"}, "imdb_score": 8.6}},
+		{"edit with the v1 route", "PUT", "/v1/update/movie",
+			`{"movie_id": "{id}", "name": "Psycho", "director": "Alfred Hitchcock", "genre": ["Horror"], "imdb_score": 8.5, "99popularity": 85}`,
+			200, nil},
+		{"edit a missing movie", "PUT", "/v2/movies/missing", `{"name": "Missing", "director": "Nobody", "genre": ["Drama"]}`, 404, nil},
+		{"delete", "DELETE", "/v2/movies/{id}", "", 200, nil},
+		{"get the deleted movie", "GET", "/v2/movies/{id}", "", 404, nil},
+		{"delete again", "DELETE", "/v1/remove/movie?movie_id={id}", "", 404, nil},
+	}
+	for _, step := range steps {
+		status, body := request(t, routes, step.method, strings.Replace(step.path, "{id}", id, -1), strings.Replace(step.body, "{id}", id, -1))
+		if status != step.status {
+			t.Fatalf("%s: got status %d, want %d: %v", step.name, status, step.status, body)
+		}
+		if id == "" {
+			id, _ = body["movie_id"].(string)
+		}
+		movie, _ := body["movie"].(map[string]interface{})
+		for key, want := range step.want {
+			if !reflect.DeepEqual(movie[key], want) {
+				t.Errorf("%s: got %s %v, want %v", step.name, key, movie[key], want)
+			}
+		}
+	}
+}
+
+// TestSearchHandler searches a small catalogue through the search handler of a server keeping movies in memory
+func TestSearchHandler(t *testing.T) {
+	routes, _ := newTestRoutes(t)
+	for _, movie := range []string{
+		`{"name": "Star Wars", "director": "George Lucas", "genre": ["Sci-Fi", "Action"], "imdb_score": 8.6, "99popularity": 88}`,
+		`{"name": "Psycho", "director": "Alfred Hitchcock", "genre": ["Horror", "Thriller"], "imdb_score": 8.5, "99popularity": 85}`,
+		`{"name": "Vertigo", "director": "Alfred Hitchcock", "genre": ["Mystery", "Thriller"], "imdb_score": 8.3, "99popularity": 83}`,
+		`{"name": "The Birds", "director": "Alfred Hitchcock", "genre": ["Horror"], "imdb_score": 7.7, "99popularity": 77}`,
+	} {
+		status, body := request(t, routes, "POST", "/v2/movies", movie)
+		if status != 201 {
+			t.Fatalf("cannot add %s: %v", movie, body)
+		}
+	}
+
+	tests := []struct {
+		query string
+		names []string
+		total float64
+	}{
+		{"name=star", []string{"Star Wars"}, 1},
+		{"director=hitchcock&sort=imdb_score:desc", []string{"Psycho", "Vertigo", "The Birds"}, 3},
+		{"genre=Horror&genre=Thriller&genre_match=all", []string{"Psycho"}, 1},
+		{"genre=Thriller&sort=name:asc", []string{"Psycho", "Vertigo"}, 2},
+		{"-genre=Horror&sort=99popularity:asc", []string{"Vertigo", "Star Wars"}, 2},
+		{"imdb_score_gte=8.3&imdb_score_lte=8.5&sort=imdb_score:asc", []string{"Vertigo", "Psycho"}, 2},
+		{"director_facet=Alfred Hitchcock&sort=name:desc&size=2", []string{"Vertigo", "The Birds"}, 3},
+		{"q=" + `director:hitchcock -genre:mystery score>=8`, []string{"Psycho"}, 1},
+		{"name=casablanca", []string{}, 0},
+	}
+	for _, test := range tests {
+		status, body := request(t, routes, "GET", "/v2/movies?"+strings.Replace(test.query, " ", "%20", -1), "")
+		if status != 200 {
+			t.Errorf("%s: got status %d: %v", test.query, status, body)
+			continue
+		}
+		if names := movieNames(body); !reflect.DeepEqual(names, test.names) || body["total"] != test.total {
+			t.Errorf("%s: got %v of %v, want %v of %v", test.query, names, body["total"], test.names, test.total)
+		}
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/raazcrzy/imdb/apierror"
+	"github.com/raazcrzy/imdb/jobs"
+	"github.com/raazcrzy/imdb/models"
+	"github.com/raazcrzy/imdb/openapi"
+	"github.com/raazcrzy/imdb/store"
+	"github.com/raazcrzy/imdb/utils"
+)
+
+// apiOperation documents a route in the OpenAPI document served at /v1/openapi.json. request is a value of the type
+// the handler decodes the request body into, nil when there is none, and requestContentType its media type,
+// application/json when empty. contentType is the media type of a success response, application/json when empty.
+// available tells whether the route is registered with the storage backends in use, nil when it always is.
+type apiOperation struct {
+	id                 string
+	summary            string
+	tag                string
+	params             []openapi.Parameter
+	request            interface{}
+	requestContentType string
+	status             int
+	response           *openapi.Schema
+	contentType        string
+	errors             []int
+	public             bool
+	available          func() bool
+}
+
+// apiOperations returns the documented operations by "METHOD /path", with the path in the form routes are registered in
+func apiOperations(g *openapi.Generator) map[string]apiOperation {
+	messageOnly := messageBody(nil)
+	movieSearch := messageBody(map[string]*openapi.Schema{
+		"movies":      {Type: "array", Items: g.Schema(store.MovieHit{})},
+		"total":       {Type: "integer", Format: "int64"},
+		"next_cursor": {Type: "string", Description: "Sent when a full page was returned, pass it as cursor to get the next page"},
+		"facets":      {Type: "object", AdditionalProperties: &openapi.Schema{Type: "array", Items: g.Schema(store.FacetBucket{})}},
+	}, "movies", "total")
+	reindexJob := messageBody(map[string]*openapi.Schema{"job": g.Schema(models.ReindexJob{})}, "job")
+	eventTypes := []interface{}{}
+	for _, eventType := range models.MovieEventTypes {
+		eventTypes = append(eventTypes, eventType)
+	}
+	job := messageBody(map[string]*openapi.Schema{"job": g.Schema(models.Job{})}, "job")
+	jobStatuses := []interface{}{jobs.Queued, jobs.Running, jobs.Succeeded, jobs.Failed, jobs.Canceled}
+	jobID := []openapi.Parameter{queryParam("job_id", &openapi.Schema{Type: "integer", Format: "int64"}, "Id of the reindex job", true)}
+
+	addUser := apiOperation{summary: "Create a user", tag: "users", request: models.User{}, status: http.StatusCreated,
+		response: messageOnly, errors: []int{400, 401, 409, 413}}
+	removeUser := apiOperation{summary: "Delete a user, users can only delete themselves", tag: "users", status: http.StatusOK,
+		response: messageOnly, errors: []int{400, 401, 404}}
+	searchMovies := apiOperation{summary: "Search movies", tag: "movies", params: movieSearchParams(), status: http.StatusOK,
+		response: movieSearch, errors: []int{400, 401}}
+	addMovie := apiOperation{summary: "Add a movie", tag: "movies", request: models.Movie{}, status: http.StatusCreated,
+		response: messageBody(map[string]*openapi.Schema{"movie_id": {Type: "string"}}, "movie_id"), errors: []int{400, 401, 413}}
+	getMovie := apiOperation{summary: "Get a movie", tag: "movies", status: http.StatusOK,
+		response: messageBody(map[string]*openapi.Schema{"movie": g.Schema(models.Movie{})}, "movie"), errors: []int{401, 404}}
+	updateMovie := apiOperation{summary: "Replace a movie", tag: "movies", request: models.Movie{}, status: http.StatusOK,
+		response: messageOnly, errors: []int{400, 401, 404, 413}}
+	removeMovie := apiOperation{summary: "Delete a movie", tag: "movies", status: http.StatusOK,
+		response: messageOnly, errors: []int{400, 401, 404}}
+	graphqlResponse := openapi.Object(map[string]*openapi.Schema{
+		"data": {Type: "object", Nullable: true, Description: "The selected fields, absent when the query is invalid"},
+		"errors": {Type: "array", Items: openapi.Object(map[string]*openapi.Schema{
+			"message":    {Type: "string"},
+			"locations":  {Type: "array", Items: openapi.Object(map[string]*openapi.Schema{"line": {Type: "integer"}, "column": {Type: "integer"}})},
+			"path":       {Type: "array", Items: &openapi.Schema{Description: "Field name or list index"}},
+			"extensions": {Type: "object", Description: "code holds the error code, fields the fields of a validation error"},
+		}, "message")},
+	})
+	graphqlQuery := apiOperation{summary: "Run a GraphQL query or mutation, `app graphql` prints the schema", tag: "graphql",
+		request: graphqlBody{}, status: http.StatusOK, response: graphqlResponse, errors: []int{400, 401, 413}}
+	graphqlGet := apiOperation{summary: "Run a GraphQL query, mutations need POST", tag: "graphql", status: http.StatusOK,
+		params: []openapi.Parameter{
+			queryParam("query", &openapi.Schema{Type: "string"}, "GraphQL query", true),
+			queryParam("operationName", &openapi.Schema{Type: "string"}, "Operation to run when the query has several", false),
+			queryParam("variables", &openapi.Schema{Type: "string"}, "Variables as a JSON object", false),
+		},
+		response: graphqlResponse, errors: []int{400, 401}}
+
+	operations := map[string]apiOperation{
+		"GET /v1/openapi.json": {id: "getOpenAPI", summary: "This document", tag: "meta", status: http.StatusOK,
+			response: &openapi.Schema{Type: "object"}, public: true},
+	}
+	for key, operation := range map[string]apiOperation{
+		"POST /v2/users":          addUser,
+		"DELETE /v2/users/:email": removeUser,
+		"GET /v2/movies":          searchMovies,
+		"POST /v2/movies":         addMovie,
+		"GET /v2/movies/:id":      getMovie,
+		"PUT /v2/movies/:id":      updateMovie,
+		"DELETE /v2/movies/:id":   removeMovie,
+		"POST /graphql":           graphqlQuery,
+		"GET /graphql":            graphqlGet,
+	} {
+		operation.id = operationID(key)
+		operations[key] = operation
+	}
+
+	removeUser.request = removeUserBody{}
+	removeMovie.params = []openapi.Parameter{queryParam("movie_id", &openapi.Schema{Type: "string"}, "Id of the movie", true)}
+	for key, operation := range map[string]apiOperation{
+		"POST /v1/add/user":       addUser,
+		"DELETE /v1/remove/user":  removeUser,
+		"POST /v1/add/movie":      addMovie,
+		"DELETE /v1/remove/movie": removeMovie,
+		"PUT /v1/update/movie":    updateMovie,
+		"GET /v1/get/movie":       searchMovies,
+		"GET /v1/events": {summary: "Stream the movie events as Server-Sent Events", tag: "events",
+			params: []openapi.Parameter{
+				queryParam("type", &openapi.Schema{Type: "array", Items: &openapi.Schema{Type: "string", Enum: eventTypes}}, "Types of the streamed events, every type by default", false),
+				queryParam("last_event_id", &openapi.Schema{Type: "integer", Format: "int64"}, "Id of the event to resume after, the Last-Event-ID header takes precedence", false),
+			},
+			status: http.StatusOK, contentType: "text/event-stream",
+			response: &openapi.Schema{Type: "string", Description: "Events with the id and type of a MovieEvent and its JSON as data"},
+			errors:   []int{400, 401}},
+	} {
+		operation.id = operationID(key)
+		operations[key] = operation
+	}
+
+	for key, operation := range map[string]apiOperation{
+		"PUT /v1/update/ranking": {summary: "Create or replace a ranking profile", tag: "ranking", request: models.RankingProfile{},
+			status: http.StatusOK, response: messageOnly, errors: []int{400, 401, 413}},
+		"GET /v1/get/ranking": {summary: "List the ranking profiles", tag: "ranking", status: http.StatusOK,
+			response: messageBody(map[string]*openapi.Schema{"profiles": {Type: "array", Items: g.Schema(models.RankingProfile{})}}, "profiles"),
+			errors:   []int{401}},
+		"DELETE /v1/remove/ranking": {summary: "Delete a stored ranking profile", tag: "ranking",
+			params: []openapi.Parameter{queryParam("name", &openapi.Schema{Type: "string"}, "Name of the profile", true)},
+			status: http.StatusOK, response: messageOnly, errors: []int{400, 401, 404}},
+		"POST /v1/jobs": {summary: "Queue a job", tag: "jobs", request: jobBody{}, status: http.StatusAccepted,
+			response: job, errors: []int{400, 401, 413}},
+		"POST /v1/import/movies": {summary: "Queue a movies.import job of the uploaded movies, one JSON movie per line", tag: "jobs",
+			params: []openapi.Parameter{
+				queryParam("update", &openapi.Schema{Type: "boolean"}, "Whether the movies with a movie_id replace the movie of that id", false),
+			},
+			request: models.Movie{}, requestContentType: "application/x-ndjson", status: http.StatusAccepted, response: job,
+			errors: []int{400, 401, 413}},
+		"GET /v1/jobs": {summary: "List the latest jobs", tag: "jobs",
+			params: []openapi.Parameter{
+				queryParam("type", &openapi.Schema{Type: "string"}, "Type of the listed jobs", false),
+				queryParam("status", &openapi.Schema{Type: "string", Enum: jobStatuses}, "Status of the listed jobs", false),
+			},
+			status: http.StatusOK, response: messageBody(map[string]*openapi.Schema{"jobs": {Type: "array", Items: g.Schema(models.Job{})}}, "jobs"),
+			errors: []int{401}},
+		"GET /v1/jobs/:id": {summary: "Get the status and progress of a job", tag: "jobs", status: http.StatusOK, response: job,
+			errors: []int{400, 401, 404}},
+		"GET /v1/jobs/:id/output": {summary: "Download the output of a succeeded job, the movies of an export as JSON lines", tag: "jobs",
+			status: http.StatusOK, contentType: "application/x-ndjson",
+			response: &openapi.Schema{Type: "string", Description: "One JSON movie per line"},
+			errors:   []int{400, 401, 404, 409}},
+		"POST /v1/jobs/:id/cancel": {summary: "Cancel a queued job or request the cancellation of a running one", tag: "jobs",
+			status: http.StatusOK, response: job, errors: []int{400, 401, 404, 409}},
+	} {
+		operation.id = operationID(key)
+		operation.available = utils.UsesPostgres
+		operations[key] = operation
+	}
+
+	for key, operation := range map[string]apiOperation{
+		"POST /v1/webhooks": {summary: "Register a webhook the movie events are posted to", tag: "webhooks", request: models.Webhook{},
+			status: http.StatusCreated, response: messageBody(map[string]*openapi.Schema{"webhook": g.Schema(models.Webhook{})}, "webhook"),
+			errors: []int{400, 401, 413}},
+		"GET /v1/webhooks": {summary: "List the webhooks, without their secrets", tag: "webhooks", status: http.StatusOK,
+			response: messageBody(map[string]*openapi.Schema{"webhooks": {Type: "array", Items: g.Schema(models.Webhook{})}}, "webhooks"),
+			errors:   []int{401}},
+		"DELETE /v1/webhooks/:id": {summary: "Delete a webhook", tag: "webhooks", status: http.StatusOK, response: messageOnly,
+			errors: []int{400, 401, 404}},
+		"GET /v1/webhooks/:id/dead_letters": {summary: "List the latest events a webhook failed to receive", tag: "webhooks", status: http.StatusOK,
+			response: messageBody(map[string]*openapi.Schema{"dead_letters": {Type: "array", Items: g.Schema(models.WebhookDeadLetter{})}}, "dead_letters"),
+			errors:   []int{400, 401, 404}},
+		"POST /v1/webhooks/:id/redeliver": {summary: "Queue the dead letters of a webhook for delivery again", tag: "webhooks", status: http.StatusOK,
+			response: messageBody(map[string]*openapi.Schema{"redelivered": {Type: "integer", Format: "int64"}}, "redelivered"),
+			errors:   []int{400, 401, 404}},
+	} {
+		operation.id = operationID(key)
+		operation.available = utils.MoviesInPostgres
+		operations[key] = operation
+	}
+
+	for key, operation := range map[string]apiOperation{
+		"PUT /v1/update/synonyms": {summary: "Replace the synonym rules, a 202 with the queued reindex job applying them when the index cannot reload them",
+			tag: "synonyms", request: synonymsBody{}, status: http.StatusOK, response: messageOnly, errors: []int{400, 401, 413}},
+		"GET /v1/get/synonyms": {summary: "List the synonym rules", tag: "synonyms", status: http.StatusOK,
+			response: messageBody(map[string]*openapi.Schema{"synonyms": {Type: "array", Items: &openapi.Schema{Type: "string"}}}, "synonyms"),
+			errors:   []int{401}},
+		"POST /v1/reindex/movie": {summary: "Queue a job copying the movie index into a new index", tag: "reindex",
+			status: http.StatusAccepted, response: job, errors: []int{401}},
+		"GET /v1/get/reindex": {summary: "Get a reindex job", tag: "reindex", params: jobID, status: http.StatusOK,
+			response: reindexJob, errors: []int{400, 401, 404}},
+		"POST /v1/rollback/reindex": {summary: "Move the movie alias back to the index a reindex job copied from", tag: "reindex",
+			params: jobID, status: http.StatusOK, response: messageOnly, errors: []int{400, 401, 404, 409}},
+	} {
+		operation.id = operationID(key)
+		operation.available = utils.UsesElasticsearch
+		operations[key] = operation
+	}
+	return operations
+}
+
+// movieSearchParams are the URL params of the movie search
+func movieSearchParams() []openapi.Parameter {
+	text := &openapi.Schema{Type: "string"}
+	number := &openapi.Schema{Type: "number"}
+	boolean := &openapi.Schema{Type: "boolean"}
+	genres := &openapi.Schema{Type: "array", Items: text}
+	return []openapi.Parameter{
+		queryParam("name", text, "Text searched in the movie names", false),
+		queryParam("director", text, "Text searched in the director names", false),
+		queryParam("genre", genres, "Genres of the movie, repeat the param for several", false),
+		queryParam("genre_match", &openapi.Schema{Type: "string", Enum: []interface{}{"any", "all"}}, "Whether a movie needs any or all of the genres", false),
+		queryParam("-genre", genres, "Genres the movie must not have", false),
+		queryParam("99popularity", number, "Exact popularity", false),
+		queryParam("99popularity_gte", number, "Lowest popularity", false),
+		queryParam("99popularity_lte", number, "Highest popularity", false),
+		queryParam("imdb_score", number, "Exact score", false),
+		queryParam("imdb_score_gte", number, "Lowest score", false),
+		queryParam("imdb_score_lte", number, "Highest score", false),
+		queryParam("director_facet", text, "Exact director name, as found in the director facet", false),
+		queryParam("q", text, `Query language, for example director:"george lucas" score>=8 star`, false),
+		queryParam("sort", text, "Comma separated field:order pairs, fields: relevance, imdb_score, 99popularity, name", false),
+		queryParam("ranking", text, "Ranking profile boosting the relevance", false),
+		queryParam("facets", boolean, "Return the facet counts", false),
+		queryParam("highlight", boolean, "Return the matching fragments", false),
+		queryParam("explain", boolean, "Return the score explanation, admins only", false),
+		queryParam("from", &openapi.Schema{Type: "integer", Minimum: float(0)}, "Offset of the first movie", false),
+		queryParam("size", &openapi.Schema{Type: "integer", Minimum: float(1), Maximum: float(100)}, "Number of movies, 20 by default", false),
+		queryParam("cursor", text, "next_cursor of the previous page", false),
+	}
+}
+
+// openAPIDocument builds the OpenAPI document of the routes of a router. It fails when a route is not documented,
+// or when a documented route available with the storage backends in use is not registered, so that the document
+// cannot drift from the routes.
+func openAPIDocument(rt *router) (*openapi.Document, error) {
+	g := openapi.NewGenerator()
+	operations := apiOperations(g)
+	document := &openapi.Document{
+		OpenAPI: openapi.Version,
+		Info: openapi.Info{
+			Title:       "IMDB search catalogue",
+			Version:     "2",
+			Description: "Every error has the Error body. Request bodies are limited to 1MB and must not have unknown fields.",
+		},
+		Paths:    map[string]map[string]*openapi.Operation{},
+		Security: []openapi.Requirement{{"basicAuth": {}}},
+	}
+
+	problems := []string{}
+	registered := map[string]bool{}
+	for _, endpoint := range rt.endpoints() {
+		registered[endpoint] = true
+		operation, ok := operations[endpoint]
+		if !ok {
+			problems = append(problems, endpoint+" is not documented")
+			continue
+		}
+		parts := strings.SplitN(endpoint, " ", 2)
+		path := openAPIPath(parts[1])
+		if document.Paths[path] == nil {
+			document.Paths[path] = map[string]*openapi.Operation{}
+		}
+		document.Paths[path][strings.ToLower(parts[0])] = operation.document(g, parts[1])
+	}
+	for endpoint, operation := range operations {
+		if !registered[endpoint] && (operation.available == nil || operation.available()) {
+			problems = append(problems, endpoint+" is documented but not registered")
+		}
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("openapi document does not match the routes: %s", strings.Join(problems, ", "))
+	}
+
+	g.Schemas["Error"] = errorSchema(g)
+	document.Components = openapi.Components{
+		Schemas:         g.Schemas,
+		SecuritySchemes: map[string]openapi.SecurityScheme{"basicAuth": {Type: "http", Scheme: "basic"}},
+	}
+	return document, nil
+}
+
+// document returns the OpenAPI operation of a route
+func (operation apiOperation) document(g *openapi.Generator, path string) *openapi.Operation {
+	content := openapi.JSON(operation.response)
+	if operation.contentType != "" {
+		content = map[string]openapi.MediaType{operation.contentType: {Schema: operation.response}}
+	}
+	documented := &openapi.Operation{
+		OperationID: operation.id,
+		Summary:     operation.summary,
+		Tags:        []string{operation.tag},
+		Responses: map[string]openapi.Response{
+			strconv.Itoa(operation.status): {Description: http.StatusText(operation.status), Content: content},
+		},
+	}
+	for _, segment := range splitPath(path) {
+		if strings.HasPrefix(segment, ":") {
+			documented.Parameters = append(documented.Parameters,
+				openapi.Parameter{Name: segment[1:], In: "path", Required: true, Schema: &openapi.Schema{Type: "string"}})
+		}
+	}
+	documented.Parameters = append(documented.Parameters, operation.params...)
+	if operation.request != nil {
+		documented.RequestBody = &openapi.RequestBody{Required: true, Content: openapi.JSON(g.Schema(operation.request))}
+		if operation.requestContentType != "" {
+			documented.RequestBody.Content = map[string]openapi.MediaType{operation.requestContentType: {Schema: g.Schema(operation.request)}}
+		}
+	}
+	errors := operation.errors
+	if !operation.public {
+		errors = append(errors, http.StatusServiceUnavailable, http.StatusInternalServerError)
+	}
+	for _, status := range errors {
+		documented.Responses[strconv.Itoa(status)] = openapi.Response{Description: http.StatusText(status), Content: openapi.JSON(openapi.Ref("Error"))}
+	}
+	if operation.public {
+		documented.Security = &[]openapi.Requirement{}
+	}
+	return documented
+}
+
+// errorSchema is the schema of the error bodies written by writeError
+func errorSchema(g *openapi.Generator) *openapi.Schema {
+	codes := []interface{}{}
+	for _, code := range apierror.Codes {
+		codes = append(codes, code)
+	}
+	return openapi.Object(map[string]*openapi.Schema{
+		"code":       {Type: "string", Enum: codes},
+		"message":    {Type: "string"},
+		"request_id": {Type: "string"},
+		"fields":     {Type: "array", Items: g.Schema(apierror.FieldError{})},
+		"position":   {Type: "integer", Description: "Position of a syntax error in the q search param"},
+	}, "code", "message", "request_id")
+}
+
+// messageBody is the schema of a success body, which has a message along with the given properties
+func messageBody(properties map[string]*openapi.Schema, required ...string) *openapi.Schema {
+	body := map[string]*openapi.Schema{"message": {Type: "string"}}
+	for name, schema := range properties {
+		body[name] = schema
+	}
+	return openapi.Object(body, append([]string{"message"}, required...)...)
+}
+
+func queryParam(name string, schema *openapi.Schema, description string, required bool) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: "query", Description: description, Required: required, Schema: schema}
+}
+
+// openAPIPath turns the :param segments of a route path into {param}
+func openAPIPath(path string) string {
+	segments := splitPath(path)
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// operationID names an operation after its method and path, "DELETE /v2/users/:email" is deleteV2UsersEmail
+func operationID(endpoint string) string {
+	parts := strings.SplitN(endpoint, " ", 2)
+	id := strings.ToLower(parts[0])
+	for _, segment := range splitPath(parts[1]) {
+		for _, word := range strings.FieldsFunc(segment, func(r rune) bool { return r == ':' || r == '.' || r == '_' }) {
+			id += strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return id
+}
+
+func float(n float64) *float64 {
+	return &n
+}
+
+// runOpenAPICommand prints the OpenAPI document of every route, used by `app openapi`
+func runOpenAPICommand() {
+	utils.UserStorage, utils.MovieStorage = utils.PostgresStorage, utils.ElasticsearchStorage
+	_, spec := newRoutes(newServer(nil, nil, nil))
+	data, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	fmt.Println(string(data))
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/raazcrzy/imdb/store"
+)
+
+/*
+The q search param accepts a small query language for power users, for example:
+
+	director:"george lucas" genre:sci-fi score>=8 -genre:horror popularity:80..90 star
+
+A query is a list of terms separated by spaces, all of which must match. A term is one of:
+
+	field:value           name, director and genre match the value, score and popularity the exact number
+	field:"some value"    the value matched as a phrase
+	field>=n, field<=n    a numeric comparison, > and < are accepted as well
+	field:n..m            a numeric range with both bounds included, either bound may be left out
+	word, "some words"    free text searched in name and director
+
+A term prefixed with - excludes the movies matching it.
+*/
+
+// queryFields maps the field names accepted in q to the movie fields they search
+var queryFields = map[string]string{
+	"name":         "name",
+	"director":     "director",
+	"genre":        "genre",
+	"score":        "imdb_score",
+	"imdb_score":   "imdb_score",
+	"popularity":   "99popularity",
+	"99popularity": "99popularity",
+}
+
+// querySyntaxError reports the position in q, counted in characters from 1, where parsing failed
+type querySyntaxError struct {
+	position int
+	message  string
+}
+
+func (e *querySyntaxError) Error() string {
+	return fmt.Sprintf("syntax error in q at position %d: %s", e.position, e.message)
+}
+
+// queryTerm is a single parsed term of q
+type queryTerm struct {
+	negated  bool
+	field    string // empty for free text
+	operator string // one of :, >=, <=, >, <
+	value    string
+	phrase   bool
+	position int
+}
+
+// applyQueryString parses q and adds its terms to the search conditions
+func applyQueryString(search *movieSearch, q string, boosts map[string]float64) error {
+	terms, err := parseQueryString(q)
+	if err != nil {
+		return err
+	}
+	for _, term := range terms {
+		condition, scored, err := term.condition(boosts)
+		if err != nil {
+			return err
+		}
+		switch {
+		case term.negated:
+			search.MustNot = append(search.MustNot, condition)
+		case scored:
+			search.Must = append(search.Must, condition)
+		default:
+			search.Filter = append(search.Filter, condition)
+		}
+	}
+	return nil
+}
+
+// condition returns the search condition for the term and whether it should take part in scoring.
+// boosts are the per-field boosts of the ranking profile in use, if any.
+func (t queryTerm) condition(boosts map[string]float64) (store.Condition, bool, error) {
+	switch t.field {
+	case "", "name", "director":
+		condition := store.Condition{
+			Kind:   store.ConditionMatch,
+			Fields: []string{t.field},
+			Value:  t.value,
+			Boosts: boosts,
+		}
+		if t.field == "" {
+			condition.Fields = []string{"name", "director"}
+		} else {
+			condition.AllWords = true
+		}
+		if t.phrase {
+			condition.Kind = store.ConditionPhrase
+		}
+		return condition, true, nil
+	case "genre":
+		return genreCondition(t.value), false, nil
+	}
+
+	condition := store.Condition{Kind: store.ConditionRange, Fields: []string{t.field}}
+	if t.operator == ":" && strings.Contains(t.value, "..") {
+		bounds := strings.SplitN(t.value, "..", 2)
+		if bounds[0] == "" && bounds[1] == "" {
+			return store.Condition{}, false, &querySyntaxError{t.position, "expected a number before or after '..'"}
+		}
+		if bounds[0] != "" {
+			lower, err := t.number(bounds[0])
+			if err != nil {
+				return store.Condition{}, false, err
+			}
+			condition.Gte = &lower
+		}
+		if bounds[1] != "" {
+			upper, err := t.number(bounds[1])
+			if err != nil {
+				return store.Condition{}, false, err
+			}
+			condition.Lte = &upper
+		}
+		return condition, false, nil
+	}
+	value, err := t.number(t.value)
+	if err != nil {
+		return store.Condition{}, false, err
+	}
+	switch t.operator {
+	case ">=":
+		condition.Gte = &value
+	case "<=":
+		condition.Lte = &value
+	case ">":
+		condition.Gt = &value
+	case "<":
+		condition.Lt = &value
+	default:
+		return store.Condition{Kind: store.ConditionEquals, Fields: []string{t.field}, Value: value}, false, nil
+	}
+	return condition, false, nil
+}
+
+func (t queryTerm) number(value string) (float64, error) {
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, &querySyntaxError{t.position, fmt.Sprintf("expected a number, found %q", value)}
+	}
+	return number, nil
+}
+
+// parseQueryString splits q into its terms
+func parseQueryString(q string) ([]queryTerm, error) {
+	input := []rune(q)
+	terms := []queryTerm{}
+	i := 0
+	for {
+		for i < len(input) && unicode.IsSpace(input[i]) {
+			i++
+		}
+		if i == len(input) {
+			return terms, nil
+		}
+		term := queryTerm{}
+		if input[i] == '-' {
+			term.negated = true
+			i++
+			if i == len(input) || unicode.IsSpace(input[i]) {
+				return nil, &querySyntaxError{i + 1, "expected a term after '-'"}
+			}
+		}
+
+		if input[i] == '"' {
+			value, next, err := readQuoted(input, i)
+			if err != nil {
+				return nil, err
+			}
+			term.value, term.phrase, term.position = value, true, i+1
+			i = next
+			terms = append(terms, term)
+			continue
+		}
+
+		start := i
+		for i < len(input) && !unicode.IsSpace(input[i]) && !strings.ContainsRune(`:<>="`, input[i]) {
+			i++
+		}
+		word := string(input[start:i])
+		if i == len(input) || unicode.IsSpace(input[i]) {
+			term.value, term.position = word, start+1
+			terms = append(terms, term)
+			continue
+		}
+		if input[i] == '"' || input[i] == '=' {
+			return nil, &querySyntaxError{i + 1, fmt.Sprintf("unexpected '%c'", input[i])}
+		}
+
+		// word is followed by an operator, so it is a field name
+		if word == "" {
+			return nil, &querySyntaxError{i + 1, fmt.Sprintf("expected a field name before '%c'", input[i])}
+		}
+		field, ok := queryFields[strings.ToLower(word)]
+		if !ok {
+			return nil, &querySyntaxError{start + 1, fmt.Sprintf("unknown field %q, allowed: director, genre, name, popularity, score", word)}
+		}
+		term.field = field
+		operatorStart := i
+		term.operator = string(input[i])
+		i++
+		if term.operator != ":" && i < len(input) && input[i] == '=' {
+			term.operator += "="
+			i++
+		}
+		if term.operator != ":" && (field == "name" || field == "director" || field == "genre") {
+			return nil, &querySyntaxError{operatorStart + 1, fmt.Sprintf("operator %s is only allowed on score and popularity", term.operator)}
+		}
+
+		term.position = i + 1
+		if i < len(input) && input[i] == '"' {
+			value, next, err := readQuoted(input, i)
+			if err != nil {
+				return nil, err
+			}
+			term.value, term.phrase = value, true
+			i = next
+		} else {
+			valueStart := i
+			for i < len(input) && !unicode.IsSpace(input[i]) {
+				if input[i] == '"' {
+					return nil, &querySyntaxError{i + 1, "unexpected '\"'"}
+				}
+				i++
+			}
+			term.value = string(input[valueStart:i])
+		}
+		if strings.TrimSpace(term.value) == "" {
+			return nil, &querySyntaxError{term.position, fmt.Sprintf("expected a value after '%s'", term.operator)}
+		}
+		terms = append(terms, term)
+	}
+}
+
+// readQuoted reads the quoted string starting at input[start] and returns its content and the index after it
+func readQuoted(input []rune, start int) (string, int, error) {
+	end := start + 1
+	for end < len(input) && input[end] != '"' {
+		end++
+	}
+	if end == len(input) {
+		return "", 0, &querySyntaxError{start + 1, "unterminated quoted string"}
+	}
+	if end+1 < len(input) && !unicode.IsSpace(input[end+1]) {
+		return "", 0, &querySyntaxError{end + 2, "expected a space after the closing quote"}
+	}
+	return string(input[start+1 : end]), end + 1, nil
+}
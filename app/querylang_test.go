@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseQueryString(t *testing.T) {
+	tests := []struct {
+		q     string
+		terms []queryTerm
+	}{
+		{"", []queryTerm{}},
+		{"star wars", []queryTerm{
+			{value: "star", position: 1},
+			{value: "wars", position: 6},
+		}},
+		{`"star wars" -"return of"`, []queryTerm{
+			{value: "star wars", phrase: true, position: 1},
+			{negated: true, value: "return of", phrase: true, position: 14},
+		}},
+		{`director:"george lucas"`, []queryTerm{
+			{field: "director", operator: ":", value: "george lucas", phrase: true, position: 10},
+		}},
+		{"-genre:horror Genre:Sci-Fi", []queryTerm{
+			{negated: true, field: "genre", operator: ":", value: "horror", position: 8},
+			{field: "genre", operator: ":", value: "Sci-Fi", position: 21},
+		}},
+		{"score>=8 score<9.5 popularity>80", []queryTerm{
+			{field: "imdb_score", operator: ">=", value: "8", position: 8},
+			{field: "imdb_score", operator: "<", value: "9.5", position: 16},
+			{field: "99popularity", operator: ">", value: "80", position: 31},
+		}},
+		{"popularity:80..90 score:..7", []queryTerm{
+			{field: "99popularity", operator: ":", value: "80..90", position: 12},
+			{field: "imdb_score", operator: ":", value: "..7", position: 25},
+		}},
+		{"  alien   name:été ", []queryTerm{
+			{value: "alien", position: 3},
+			{field: "name", operator: ":", value: "été", position: 16},
+		}},
+	}
+	for _, test := range tests {
+		terms, err := parseQueryString(test.q)
+		if err != nil {
+			t.Errorf("%q: %s", test.q, err)
+			continue
+		}
+		if !reflect.DeepEqual(terms, test.terms) {
+			t.Errorf("%q: got %+v, want %+v", test.q, terms, test.terms)
+		}
+	}
+}
+
+// TestQueryStringConditions checks the conditions q adds to a search, as JSON
+func TestQueryStringConditions(t *testing.T) {
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{"star", `{"must":[{"kind":"match","fields":["name","director"],"value":"star"}],"sort":null,"from":0,"size":0}`},
+		{`"star wars"`, `{"must":[{"kind":"phrase","fields":["name","director"],"value":"star wars"}],"sort":null,"from":0,"size":0}`},
+		{`director:"george lucas"`, `{"must":[{"kind":"phrase","fields":["director"],"value":"george lucas","all_words":true}],"sort":null,"from":0,"size":0}`},
+		{"name:star", `{"must":[{"kind":"match","fields":["name"],"value":"star","all_words":true}],"sort":null,"from":0,"size":0}`},
+		{"-genre:horror", `{"must_not":[{"kind":"phrase","fields":["genre"],"value":"horror"}],"sort":null,"from":0,"size":0}`},
+		{"score>=8", `{"filter":[{"kind":"range","fields":["imdb_score"],"gte":8}],"sort":null,"from":0,"size":0}`},
+		{"score:8.5", `{"filter":[{"kind":"equals","fields":["imdb_score"],"value":8.5}],"sort":null,"from":0,"size":0}`},
+		{"popularity:80..90", `{"filter":[{"kind":"range","fields":["99popularity"],"gte":80,"lte":90}],"sort":null,"from":0,"size":0}`},
+		{"popularity:80..", `{"filter":[{"kind":"range","fields":["99popularity"],"gte":80}],"sort":null,"from":0,"size":0}`},
+		{"-score<5", `{"must_not":[{"kind":"range","fields":["imdb_score"],"lt":5}],"sort":null,"from":0,"size":0}`},
+	}
+	for _, test := range tests {
+		search := movieSearch{}
+		err := applyQueryString(&search, test.q, nil)
+		if err != nil {
+			t.Errorf("%q: %s", test.q, err)
+			continue
+		}
+		got, err := json.Marshal(search.MovieQuery)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != test.want {
+			t.Errorf("%q:\ngot  %s\nwant %s", test.q, got, test.want)
+		}
+	}
+}
+
+func TestQueryStringErrors(t *testing.T) {
+	tests := []struct {
+		q        string
+		position int
+		message  string
+	}{
+		{"score>=x", 8, `expected a number, found "x"`},
+		{"star score>=x", 13, `expected a number, found "x"`},
+		{`"star wars`, 1, "unterminated quoted string"},
+		{`alien director:"ridley scott`, 16, "unterminated quoted string"},
+		{`"star"wars`, 7, "expected a space after the closing quote"},
+		{"star -", 7, "expected a term after '-'"},
+		{"year:1977", 1, `unknown field "year", allowed: director, genre, name, popularity, score`},
+		{"genre>=drama", 6, "operator >= is only allowed on score and popularity"},
+		{"score:", 7, "expected a value after ':'"},
+		{":star", 1, "expected a field name before ':'"},
+		{"star=wars", 5, "unexpected '='"},
+		{"popularity:..", 12, "expected a number before or after '..'"},
+		{"popularity:80..x", 12, `expected a number, found "x"`},
+		{`name:st"ar`, 8, `unexpected '"'`},
+	}
+	for _, test := range tests {
+		err := applyQueryString(&movieSearch{}, test.q, nil)
+		syntaxErr, ok := err.(*querySyntaxError)
+		if !ok {
+			t.Errorf("%q: got %v, want a syntax error", test.q, err)
+			continue
+		}
+		if syntaxErr.position != test.position || syntaxErr.message != test.message {
+			t.Errorf("%q: got %q at %d, want %q at %d", test.q, syntaxErr.message, syntaxErr.position, test.message, test.position)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/raazcrzy/imdb/models"
+	"github.com/raazcrzy/imdb/utils"
+)
+
+/*
+Ranking profiles boost the score of the search results, like a function_score query, so that their order
+can take imdb_score and 99popularity into account. The built in profiles below are always available,
+admins can add their own or override these through the ranking endpoints without redeploying.
+*/
+
+var rankingNameRegexp = regexp.MustCompile("^[a-z0-9_-]{1,64}$")
+
+// defaultRankingProfiles are the profiles shipped with the service
+var defaultRankingProfiles = map[string]models.RankingProfile{
+	"popular": {
+		Name: "popular",
+		FieldValueFactors: []models.FieldValueFactor{
+			{Field: "99popularity", Factor: 0.1, Modifier: "log1p", Missing: 1},
+		},
+		BoostMode: "multiply",
+	},
+	"top_rated": {
+		Name:        "top_rated",
+		FieldBoosts: map[string]float64{"name": 2},
+		FieldValueFactors: []models.FieldValueFactor{
+			{Field: "imdb_score", Modifier: "log1p", Missing: 1},
+		},
+		Decays: []models.DecayFunction{
+			{Function: "gauss", Field: "imdb_score", Origin: 10, Scale: 3, Decay: 0.5},
+		},
+		ScoreMode: "multiply",
+		BoostMode: "multiply",
+	},
+}
+
+// rankingNumericFields are the fields that can be used by field value factors and decay functions
+var rankingNumericFields = map[string]bool{"imdb_score": true, "99popularity": true}
+
+var rankingModifiers = map[string]bool{"none": true, "log": true, "log1p": true, "log2p": true, "ln": true, "ln1p": true, "ln2p": true, "square": true, "sqrt": true, "reciprocal": true}
+
+var rankingDecayFunctions = map[string]bool{"gauss": true, "exp": true, "linear": true}
+
+var rankingScoreModes = map[string]bool{"multiply": true, "sum": true, "avg": true, "first": true, "max": true, "min": true}
+
+var rankingBoostModes = map[string]bool{"multiply": true, "replace": true, "sum": true, "avg": true, "max": true, "min": true}
+
+// validateRankingProfile checks that a profile only refers to known fields and modes
+func validateRankingProfile(profile models.RankingProfile) error {
+	if !rankingNameRegexp.MatchString(profile.Name) {
+		return fmt.Errorf("name is required and may only contain lowercase letters, digits, _ and -, up to 64 characters")
+	}
+	for field, boost := range profile.FieldBoosts {
+		if field != "name" && field != "director" {
+			return fmt.Errorf("invalid field_boosts field %q, allowed: director, name", field)
+		}
+		if boost <= 0 {
+			return fmt.Errorf("field_boosts value for %s must be greater than 0", field)
+		}
+	}
+	for _, factor := range profile.FieldValueFactors {
+		if !rankingNumericFields[factor.Field] {
+			return fmt.Errorf("invalid field_value_factors field %q, allowed: 99popularity, imdb_score", factor.Field)
+		}
+		if factor.Modifier != "" && !rankingModifiers[factor.Modifier] {
+			return fmt.Errorf("invalid field_value_factors modifier %q, allowed: %s", factor.Modifier, joinKeys(rankingModifiers))
+		}
+	}
+	for _, decay := range profile.Decays {
+		if !rankingDecayFunctions[decay.Function] {
+			return fmt.Errorf("invalid decays function %q, allowed: %s", decay.Function, joinKeys(rankingDecayFunctions))
+		}
+		if !rankingNumericFields[decay.Field] {
+			return fmt.Errorf("invalid decays field %q, allowed: 99popularity, imdb_score", decay.Field)
+		}
+		if decay.Scale <= 0 {
+			return fmt.Errorf("decays scale for %s must be greater than 0", decay.Field)
+		}
+		if decay.Decay < 0 || decay.Decay >= 1 {
+			return fmt.Errorf("decays decay for %s must be between 0 and 1", decay.Field)
+		}
+	}
+	if profile.ScoreMode != "" && !rankingScoreModes[profile.ScoreMode] {
+		return fmt.Errorf("invalid score_mode %q, allowed: %s", profile.ScoreMode, joinKeys(rankingScoreModes))
+	}
+	if profile.BoostMode != "" && !rankingBoostModes[profile.BoostMode] {
+		return fmt.Errorf("invalid boost_mode %q, allowed: %s", profile.BoostMode, joinKeys(rankingBoostModes))
+	}
+	return nil
+}
+
+// fetchRankingProfile returns the stored profile with the given name, falling back to the built in ones.
+// It returns nil when there is no such profile.
+func fetchRankingProfile(name string) (*models.RankingProfile, error) {
+	// without postgres there are no stored profiles
+	if !utils.UsesPostgres() {
+		profile, ok := defaultRankingProfiles[name]
+		if !ok {
+			return nil, nil
+		}
+		return &profile, nil
+	}
+	row := utils.PgDB.QueryRow(`SELECT profile FROM imdb.ranking_profiles WHERE name=$1`, name)
+
+	var data []byte
+	err := row.Scan(&data)
+	if err == sql.ErrNoRows {
+		profile, ok := defaultRankingProfiles[name]
+		if !ok {
+			return nil, nil
+		}
+		return &profile, nil
+	}
+	if err != nil {
+		Log.Errorln(err)
+		return nil, err
+	}
+
+	profile := models.RankingProfile{}
+	err = json.Unmarshal(data, &profile)
+	if err != nil {
+		Log.Errorln(err)
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// listRankingProfiles returns the built in profiles along with the stored ones, which take precedence
+func listRankingProfiles() (map[string]interface{}, error) {
+	profiles := map[string]models.RankingProfile{}
+	for name, profile := range defaultRankingProfiles {
+		profiles[name] = profile
+	}
+	rows, err := utils.PgDB.Query(`SELECT profile FROM imdb.ranking_profiles`)
+	if err != nil {
+		Log.Errorln(err)
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data []byte
+		err = rows.Scan(&data)
+		if err != nil {
+			Log.Errorln(err)
+			return nil, err
+		}
+		profile := models.RankingProfile{}
+		err = json.Unmarshal(data, &profile)
+		if err != nil {
+			Log.Errorln(err)
+			return nil, err
+		}
+		profiles[profile.Name] = profile
+	}
+	if err = rows.Err(); err != nil {
+		Log.Errorln(err)
+		return nil, err
+	}
+
+	list := []models.RankingProfile{}
+	for _, name := range sortedProfileNames(profiles) {
+		list = append(list, profiles[name])
+	}
+	return map[string]interface{}{
+		"message":  "request successful",
+		"profiles": list,
+		"status":   200,
+	}, nil
+}
+
+// saveRankingProfile creates or replaces a stored profile
+func saveRankingProfile(profile models.RankingProfile) (map[string]interface{}, error) {
+	data, err := json.Marshal(profile)
+	if err != nil {
+		return nil, err
+	}
+	_, err = utils.PgDB.Exec(`INSERT INTO imdb.ranking_profiles(name, profile, updated_at) VALUES($1, $2, $3)
+	ON CONFLICT (name) DO UPDATE SET profile=EXCLUDED.profile, updated_at=EXCLUDED.updated_at;`, profile.Name, string(data), profile.UpdatedAt)
+	if err != nil {
+		Log.Errorln(err)
+		return nil, err
+	}
+	return map[string]interface{}{
+		"message": "ranking profile saved successfully",
+		"status":  200,
+	}, nil
+}
+
+// deleteRankingProfile deletes a stored profile, built in profiles it overrode become visible again
+func deleteRankingProfile(name string) (map[string]interface{}, error) {
+	result, err := utils.PgDB.Exec(`DELETE FROM imdb.ranking_profiles WHERE name=$1;`, name)
+	if err != nil {
+		Log.Errorln(err)
+		return nil, err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		Log.Errorln(err)
+		return nil, err
+	}
+	if deleted == 0 {
+		return map[string]interface{}{
+			"message": "ranking profile not found",
+			"status":  404,
+		}, nil
+	}
+	return map[string]interface{}{
+		"message": "ranking profile deleted successfully",
+		"status":  200,
+	}, nil
+}
+
+func sortedProfileNames(profiles map[string]models.RankingProfile) []string {
+	names := []string{}
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// joinKeys lists the keys of a set in alphabetical order
+func joinKeys(set map[string]bool) string {
+	keys := []string{}
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ", ")
+}
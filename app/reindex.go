@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"log"
+
+	"github.com/raazcrzy/imdb/apierror"
+	"github.com/raazcrzy/imdb/dbConnections"
+)
+
+// runReindexCommand runs a reindex in the foreground, used by `app reindex` to migrate an index the server refuses to start with
+func runReindexCommand() {
+	job, err := dbConnections.StartReindex()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Println("reindex", job.ID, "copying", job.SourceIndex, "into", job.TargetIndex)
+	err = dbConnections.RunReindex(job)
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// startReindex queues a movies.reindex job, which copies the movie index into a new index once the reindex jobs
+// queued before it are done
+func (s *server) startReindex() (map[string]interface{}, error) {
+	job, err := s.jobs.Enqueue("movies.reindex", nil)
+	if err != nil {
+		Log.Errorln(err)
+		return nil, err
+	}
+	return map[string]interface{}{
+		"message": "reindex queued",
+		"job":     job,
+		"status":  202,
+	}, nil
+}
+
+// getReindexJob returns the state of a reindex job
+func getReindexJob(id int64) (map[string]interface{}, error) {
+	job, err := dbConnections.GetReindexJob(id)
+	if err != nil {
+		Log.Errorln(err)
+		return nil, err
+	}
+	if job == nil {
+		return map[string]interface{}{
+			"message": "reindex job not found",
+			"status":  404,
+		}, nil
+	}
+	return map[string]interface{}{
+		"message": "request successful",
+		"job":     job,
+		"status":  200,
+	}, nil
+}
+
+// rollbackReindex moves the movie alias back to the index a finished reindex job copied from
+func rollbackReindex(id int64) (map[string]interface{}, error) {
+	err := dbConnections.RollbackReindex(id)
+	if errors.Is(err, dbConnections.ErrReindexJobNotFound) {
+		return nil, apierror.New(apierror.NotFound, err.Error())
+	}
+	if errors.Is(err, dbConnections.ErrRollbackRefused) {
+		return nil, apierror.New(apierror.Conflict, err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"message": "reindex rolled back successfully",
+		"status":  200,
+	}, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/raazcrzy/imdb/dbConnections"
+	"github.com/raazcrzy/imdb/store"
+	"github.com/raazcrzy/imdb/utils"
+)
+
+// runRepairCommand reconciles the movie index with postgres and delivers the outbox, used by `app repair`
+func runRepairCommand() {
+	report, err := store.RepairMovies(utils.PgDB, utils.MovieIndex)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	fmt.Printf("checked %d movies and %d documents: %d missing, %d changed, %d deleted, %d imported\n",
+		report.Movies, report.Documents, report.Missing, report.Changed, report.Deleted, report.Imported)
+	for {
+		claimed, err := dbConnections.DeliverOutbox()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if claimed == 0 {
+			break
+		}
+	}
+	pending, err := dbConnections.PendingOutboxEvents()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if pending > 0 {
+		fmt.Println(pending, "outbox events could not be delivered yet, the server retries them")
+	}
+}
+
+// importElasticMovies imports the movies of the movie index into postgres on the first start after the outbox was
+// introduced
+func importElasticMovies() {
+	imported, err := store.ImportElasticMovies(utils.PgDB, utils.MovieIndex)
+	if err != nil {
+		log.Fatalln("cannot import the movies of", utils.MovieIndex, err)
+	}
+	if imported > 0 {
+		log.Println("imported", imported, "movies of", utils.MovieIndex, "into postgres")
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// router dispatches requests on their method and path. Path segments starting with a colon are parameters,
+// which handlers read with pathParam. A path matching a route registered for other methods only is answered
+// with 405 and an Allow header listing them, unknown paths with 404.
+type router struct {
+	routes []*route
+}
+
+type route struct {
+	segments []string
+	handlers map[string]http.Handler
+}
+
+type pathParamsKey struct{}
+
+func newRouter() *router {
+	return &router{}
+}
+
+// handle registers the handler of a method and path, routes are matched in the order they were first registered
+func (rt *router) handle(method, path string, handler http.Handler) {
+	segments := splitPath(path)
+	for _, existing := range rt.routes {
+		if strings.Join(existing.segments, "/") == strings.Join(segments, "/") {
+			existing.handlers[method] = handler
+			return
+		}
+	}
+	rt.routes = append(rt.routes, &route{segments: segments, handlers: map[string]http.Handler{method: handler}})
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := splitPath(r.URL.EscapedPath())
+	for _, route := range rt.routes {
+		params, ok := route.match(segments)
+		if !ok {
+			continue
+		}
+		handler, ok := route.handlers[r.Method]
+		if !ok && r.Method == http.MethodHead {
+			handler, ok = route.handlers[http.MethodGet]
+		}
+		if !ok {
+			allow := route.allowed()
+			w.Header().Set("Allow", allow)
+			if r.Method == http.MethodOptions {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			returnMsg := map[string]interface{}{
+				"message": "Invalid HTTP method, allowed " + allow,
+				"status":  http.StatusMethodNotAllowed,
+			}
+			writeBack(w, returnMsg, nil)
+			return
+		}
+		if len(params) > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), pathParamsKey{}, params))
+		}
+		handler.ServeHTTP(w, r)
+		return
+	}
+	returnMsg := map[string]interface{}{
+		"message": "Not found",
+		"status":  http.StatusNotFound,
+	}
+	writeBack(w, returnMsg, nil)
+}
+
+// match returns the path parameters when the segments of a request path match the route
+func (route *route) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(route.segments) {
+		return nil, false
+	}
+	params := map[string]string{}
+	for i, segment := range route.segments {
+		value, err := url.PathUnescape(segments[i])
+		if err != nil {
+			return nil, false
+		}
+		if strings.HasPrefix(segment, ":") {
+			params[segment[1:]] = value
+		} else if segment != value {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// allowed lists the methods of the route for the Allow header
+func (route *route) allowed() string {
+	methods := []string{http.MethodOptions}
+	for method := range route.handlers {
+		methods = append(methods, method)
+	}
+	if _, ok := route.handlers[http.MethodGet]; ok {
+		if _, ok := route.handlers[http.MethodHead]; !ok {
+			methods = append(methods, http.MethodHead)
+		}
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
+
+// splitPath splits a path into its segments, ignoring leading and trailing slashes
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
+
+// endpoints lists the methods and paths of the routes, as "METHOD /path", in the order they were registered
+func (rt *router) endpoints() []string {
+	endpoints := []string{}
+	for _, route := range rt.routes {
+		methods := []string{}
+		for method := range route.handlers {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+		for _, method := range methods {
+			endpoints = append(endpoints, method+" /"+strings.Join(route.segments, "/"))
+		}
+	}
+	return endpoints
+}
+
+// pathParam returns a parameter of the route path, "" when the route has none with that name
+func pathParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(pathParamsKey{}).(map[string]string)
+	return params[name]
+}
@@ -1,16 +1,88 @@
 package main
 
 import (
+	"encoding/json"
+	"log"
 	"net/http"
+
+	"github.com/raazcrzy/imdb/openapi"
+	"github.com/raazcrzy/imdb/utils"
 )
 
-// getRoutes registers the routes and uses populateSession middleware for authentication
-func getRoutes() {
-	http.Handle("/v1/add/user", populateSession(http.HandlerFunc(addUserHandler)))
-	http.Handle("/v1/remove/user", populateSession(http.HandlerFunc(removeUserHandler)))
-	http.Handle("/v1/add/movie", populateSession(http.HandlerFunc(addMovieHandler)))
-	http.Handle("/v1/remove/movie", populateSession(http.HandlerFunc(removeMovieHandler)))
-	http.Handle("/v1/update/movie", populateSession(http.HandlerFunc(updateMovieHandler)))
-	http.Handle("/v1/get/movie", populateSession(http.HandlerFunc(getMovieHandler)))
-	return
+// getRoutes returns the router of the service, every route uses populateSession middleware for authentication.
+// The /v2 routes address users and movies as resources, the /v1 routes are kept for existing clients.
+// /graphql serves the same users and movies to clients picking the fields they need.
+// /v1/events streams the changes of the catalogue, which webhooks receive too.
+// Ranking profiles and webhooks are stored in postgres and synonyms and reindexing are elasticsearch features,
+// so their routes are only registered when the storage backends in use provide them.
+// The OpenAPI document of the routes is public, the service does not start when it does not match them.
+func getRoutes(s *server) http.Handler {
+	router, _ := newRoutes(s)
+	return withRequestID(router)
+}
+
+// newRoutes registers the routes of the service and returns them along with their OpenAPI document
+func newRoutes(s *server) (*router, *openapi.Document) {
+	router := newRouter()
+	handle := func(method, path string, handler http.HandlerFunc) {
+		router.handle(method, path, s.populateSession(handler))
+	}
+	var document []byte
+	router.handle("GET", "/v1/openapi.json", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(document)
+	}))
+
+	handle("POST", "/v2/users", s.addUserHandler)
+	handle("DELETE", "/v2/users/:email", s.removeUserHandler)
+	handle("GET", "/v2/movies", s.getMovieHandler)
+	handle("POST", "/v2/movies", s.addMovieHandler)
+	handle("GET", "/v2/movies/:id", s.getMovieByIDHandler)
+	handle("PUT", "/v2/movies/:id", s.updateMovieHandler)
+	handle("DELETE", "/v2/movies/:id", s.removeMovieHandler)
+	handle("POST", "/graphql", s.graphqlHandler)
+	handle("GET", "/graphql", s.graphqlHandler)
+
+	handle("POST", "/v1/add/user", s.addUserHandler)
+	handle("DELETE", "/v1/remove/user", s.removeUserHandler)
+	handle("POST", "/v1/add/movie", s.addMovieHandler)
+	handle("DELETE", "/v1/remove/movie", s.removeMovieHandler)
+	handle("PUT", "/v1/update/movie", s.updateMovieHandler)
+	handle("GET", "/v1/get/movie", s.getMovieHandler)
+	handle("GET", "/v1/events", s.eventsHandler)
+	if utils.UsesPostgres() {
+		handle("PUT", "/v1/update/ranking", s.updateRankingHandler)
+		handle("GET", "/v1/get/ranking", s.getRankingHandler)
+		handle("DELETE", "/v1/remove/ranking", s.removeRankingHandler)
+		handle("POST", "/v1/jobs", s.addJobHandler)
+		handle("POST", "/v1/import/movies", s.importMoviesHandler)
+		handle("GET", "/v1/jobs", s.getJobsHandler)
+		handle("GET", "/v1/jobs/:id", s.getJobHandler)
+		handle("GET", "/v1/jobs/:id/output", s.getJobOutputHandler)
+		handle("POST", "/v1/jobs/:id/cancel", s.cancelJobHandler)
+	}
+	if utils.MoviesInPostgres() {
+		handle("POST", "/v1/webhooks", s.addWebhookHandler)
+		handle("GET", "/v1/webhooks", s.getWebhooksHandler)
+		handle("DELETE", "/v1/webhooks/:id", s.removeWebhookHandler)
+		handle("GET", "/v1/webhooks/:id/dead_letters", s.getDeadLettersHandler)
+		handle("POST", "/v1/webhooks/:id/redeliver", s.redeliverWebhookHandler)
+	}
+	if utils.UsesElasticsearch() {
+		handle("PUT", "/v1/update/synonyms", s.updateSynonymsHandler)
+		handle("GET", "/v1/get/synonyms", s.getSynonymsHandler)
+		handle("POST", "/v1/reindex/movie", s.reindexMovieHandler)
+		handle("GET", "/v1/get/reindex", s.getReindexHandler)
+		handle("POST", "/v1/rollback/reindex", s.rollbackReindexHandler)
+	}
+
+	spec, err := openAPIDocument(router)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	document, err = json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return router, spec
 }
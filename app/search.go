@@ -0,0 +1,314 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/raazcrzy/imdb/apierror"
+	"github.com/raazcrzy/imdb/models"
+	"github.com/raazcrzy/imdb/store"
+)
+
+// maxResultWindow is the max_result_window of the movie index, from + size cannot go past it
+const maxResultWindow = 10000
+
+// movieSearch holds the parsed options of a movie search request
+type movieSearch struct {
+	store.MovieQuery
+	fingerprint string
+}
+
+// scored reports whether the search has text matches, so that the relevance of its hits differs
+func (search movieSearch) scored() bool {
+	return len(search.Must) > 0
+}
+
+// parseMovieSearch builds the movie search of the search URL params sent by user, for GET /v2/movies and the movies GraphQL query.
+// Invalid params fail with a validation error, or a *querySyntaxError for q.
+func (s *server) parseMovieSearch(params url.Values, user string) (movieSearch, error) {
+	var ranking *models.RankingProfile
+	var err error
+	rankingName := params.Get("ranking")
+	if rankingName != "" {
+		ranking, err = fetchRankingProfile(rankingName)
+		if err != nil {
+			return movieSearch{}, err
+		}
+		if ranking == nil {
+			return movieSearch{}, apierror.Validation(fmt.Sprintf("unknown ranking profile %q", rankingName), apierror.Field("ranking", "is not a ranking profile"))
+		}
+	}
+	var boosts map[string]float64
+	if ranking != nil {
+		boosts = ranking.FieldBoosts
+	}
+	search, err := movieSearchQuery(params, boosts)
+	if _, ok := err.(*querySyntaxError); ok {
+		return movieSearch{}, err
+	}
+	if err != nil {
+		return movieSearch{}, apierror.Validation(err.Error())
+	}
+	search.Ranking = ranking
+	search.Sort, err = movieSearchSort(params, search.scored() || ranking != nil)
+	if err != nil {
+		return movieSearch{}, apierror.Validation(err.Error(), apierror.Field("sort", err.Error()))
+	}
+	search.Facets, err = boolParam(params, "facets")
+	if err == nil {
+		search.Highlight, err = boolParam(params, "highlight")
+	}
+	if err == nil {
+		search.Explain, err = boolParam(params, "explain")
+	}
+	if err != nil {
+		return movieSearch{}, apierror.Validation(err.Error())
+	}
+	// scoring details expose how the index is tuned, so only admins get them
+	if search.Explain && !(s.isAdmin(user) || isSuperAdmin(user)) {
+		return movieSearch{}, apierror.New(apierror.Unauthorized, "Not Authorized, explain is only available to admins")
+	}
+	fromString := params.Get("from")
+	var from, size int
+	if fromString != "" {
+		from, err = strconv.Atoi(fromString)
+		if err != nil {
+			Log.Errorln("Unable to parse from value: ", err)
+			return movieSearch{}, apierror.Validation("from value must be an integer", apierror.Field("from", "must be an integer"))
+		}
+		if from < 0 {
+			Log.Errorln("Unable to parse size value: ", err)
+			return movieSearch{}, apierror.Validation("from value must greater than -1", apierror.Field("from", "must not be negative"))
+		}
+	} else {
+		from = 0
+	}
+	sizeString := params.Get("size")
+	if sizeString != "" {
+		size, err = strconv.Atoi(sizeString)
+		if err != nil {
+			Log.Errorln("Unable to parse size value: ", err)
+			return movieSearch{}, apierror.Validation("size value must be an integer", apierror.Field("size", "must be an integer"))
+		}
+		if size > 100 || size < 1 {
+			Log.Errorln("Unable to parse size value: ", err)
+			return movieSearch{}, apierror.Validation("size value must be greater than 0 and less than 100", apierror.Field("size", "must be between 1 and 100"))
+		}
+	} else {
+		size = 20
+	}
+
+	if from+size > maxResultWindow {
+		return movieSearch{}, apierror.Validation(fmt.Sprintf("from + size must not exceed %d, use cursor to page deeper", maxResultWindow), apierror.Field("from", fmt.Sprintf("added to size must not exceed %d", maxResultWindow)))
+	}
+	search.From = from
+	search.Size = size
+	search.fingerprint = searchFingerprint(params)
+	cursor := params.Get("cursor")
+	if cursor != "" {
+		if fromString != "" {
+			return movieSearch{}, apierror.Validation("from cannot be used along with cursor", apierror.Field("from", "cannot be used along with cursor"))
+		}
+		search.After, err = decodeCursor(cursor, search.fingerprint)
+		if err != nil {
+			return movieSearch{}, apierror.Validation(err.Error(), apierror.Field("cursor", err.Error()))
+		}
+	}
+	return search, nil
+}
+
+// sortFields are the accepted sort param values
+var sortFields = map[string]bool{
+	"relevance":    true,
+	"imdb_score":   true,
+	"99popularity": true,
+	"name":         true,
+}
+
+// movieSearchQuery builds the search conditions from the search URL params.
+// name, director and the free text of q are scored text matches, every other param only filters the results.
+// boosts holds the per-field boosts of the ranking profile in use, if any.
+func movieSearchQuery(params url.Values, boosts map[string]float64) (movieSearch, error) {
+	search, err := movieSearchFilters(params)
+	if err != nil {
+		return movieSearch{}, err
+	}
+	for _, field := range []string{"name", "director"} {
+		value := params.Get(field)
+		if value != "" {
+			search.Must = append(search.Must, store.Condition{
+				Kind:   store.ConditionMatch,
+				Fields: []string{field},
+				Value:  value,
+				Boosts: boosts,
+			})
+		}
+	}
+	q := params.Get("q")
+	if q != "" {
+		err = applyQueryString(&search, q, boosts)
+		if err != nil {
+			return movieSearch{}, err
+		}
+	}
+	return search, nil
+}
+
+// movieSearchFilters builds the filter conditions from the search URL params
+func movieSearchFilters(params url.Values) (movieSearch, error) {
+	search := movieSearch{}
+	for _, field := range []string{"99popularity", "imdb_score"} {
+		value, ok, err := floatParam(params, field)
+		if err != nil {
+			return movieSearch{}, err
+		}
+		if ok {
+			search.Filter = append(search.Filter, store.Condition{Kind: store.ConditionEquals, Fields: []string{field}, Value: value})
+		}
+		gte, hasGte, err := floatParam(params, field+"_gte")
+		if err != nil {
+			return movieSearch{}, err
+		}
+		lte, hasLte, err := floatParam(params, field+"_lte")
+		if err != nil {
+			return movieSearch{}, err
+		}
+		if hasGte && hasLte && gte > lte {
+			return movieSearch{}, fmt.Errorf("%s_gte value must not be greater than %s_lte value", field, field)
+		}
+		if hasGte || hasLte {
+			condition := store.Condition{Kind: store.ConditionRange, Fields: []string{field}}
+			if hasGte {
+				condition.Gte = &gte
+			}
+			if hasLte {
+				condition.Lte = &lte
+			}
+			search.Filter = append(search.Filter, condition)
+		}
+	}
+
+	genres := nonEmpty(params["genre"])
+	if len(genres) > 0 {
+		switch params.Get("genre_match") {
+		case "", "any":
+			anyGenre := store.Condition{Kind: store.ConditionAny}
+			for _, genre := range genres {
+				anyGenre.Any = append(anyGenre.Any, genreCondition(genre))
+			}
+			search.Filter = append(search.Filter, anyGenre)
+		case "all":
+			for _, genre := range genres {
+				search.Filter = append(search.Filter, genreCondition(genre))
+			}
+		default:
+			return movieSearch{}, fmt.Errorf("genre_match value must be one of: any, all")
+		}
+	}
+	for _, genre := range nonEmpty(params["-genre"]) {
+		search.MustNot = append(search.MustNot, genreCondition(genre))
+	}
+
+	directorFacet := params.Get("director_facet")
+	if directorFacet != "" {
+		search.Filter = append(search.Filter, store.Condition{Kind: store.ConditionEquals, Fields: []string{"director"}, Value: directorFacet})
+	}
+	return search, nil
+}
+
+// genreCondition matches the movies having the given genre
+func genreCondition(genre string) store.Condition {
+	return store.Condition{Kind: store.ConditionPhrase, Fields: []string{"genre"}, Value: genre}
+}
+
+// movieSearchSort parses the sort URL param, a comma separated list of field:order pairs like imdb_score:desc.
+// The order defaults to asc for name and desc otherwise. Relevance is always appended as a tie-breaker,
+// and the stores order by movie id last, so that results come back in a stable order.
+// Searches without any text match are sorted by imdb_score when no sort is asked for, as every hit has the same score.
+func movieSearchSort(params url.Values, scored bool) ([]store.SortField, error) {
+	sortString := params.Get("sort")
+	if sortString == "" {
+		if scored {
+			sortString = "relevance"
+		} else {
+			sortString = "imdb_score"
+		}
+	}
+	fields := []store.SortField{}
+	sortsOnScore := false
+	for _, item := range strings.Split(sortString, ",") {
+		parts := strings.SplitN(strings.TrimSpace(item), ":", 2)
+		if !sortFields[parts[0]] {
+			return nil, fmt.Errorf("invalid sort field %q, allowed: %s", parts[0], strings.Join(allowedSortFields(), ", "))
+		}
+		ascending := parts[0] == "name"
+		if len(parts) == 2 {
+			switch parts[1] {
+			case "asc":
+				ascending = true
+			case "desc":
+				ascending = false
+			default:
+				return nil, fmt.Errorf("invalid sort order %q for %s, allowed: asc, desc", parts[1], parts[0])
+			}
+		}
+		if parts[0] == "relevance" {
+			sortsOnScore = true
+		}
+		fields = append(fields, store.SortField{Field: parts[0], Ascending: ascending})
+	}
+	if !sortsOnScore {
+		fields = append(fields, store.SortField{Field: "relevance"})
+	}
+	return fields, nil
+}
+
+// allowedSortFields returns the accepted sort param values in alphabetical order
+func allowedSortFields() []string {
+	fields := []string{}
+	for field := range sortFields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// floatParam parses a numeric URL param, ok is false when the param is absent
+func floatParam(params url.Values, key string) (float64, bool, error) {
+	value := params.Get(key)
+	if value == "" {
+		return 0, false, nil
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s value must be a number", key)
+	}
+	return number, true, nil
+}
+
+// boolParam parses a boolean URL param, an absent param is false
+func boolParam(params url.Values, key string) (bool, error) {
+	value := params.Get(key)
+	if value == "" {
+		return false, nil
+	}
+	flag, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s value must be a boolean", key)
+	}
+	return flag, nil
+}
+
+// nonEmpty drops the empty values of a multi-valued URL param
+func nonEmpty(values []string) []string {
+	result := []string{}
+	for _, value := range values {
+		if value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/raazcrzy/imdb/graphql"
+	"github.com/raazcrzy/imdb/jobs"
+	"github.com/raazcrzy/imdb/models"
+	"github.com/raazcrzy/imdb/store"
+	"github.com/raazcrzy/imdb/utils"
+)
+
+// server holds the stores the handlers read and write through, and the GraphQL schema resolved against them.
+// notifier wakes the /v1/events streams when the server records a movie event. jobs is the job queue, nil when
+// postgres is not used.
+type server struct {
+	users    store.UserStore
+	movies   store.MovieStore
+	events   store.EventStore
+	notifier *eventNotifier
+	schema   *graphql.Schema
+	jobs     *jobs.Queue
+}
+
+// newServer returns a server using the given stores
+func newServer(users store.UserStore, movies store.MovieStore, events store.EventStore) *server {
+	s := &server{users: users, movies: movies, events: events, notifier: newEventNotifier()}
+	s.schema = s.graphqlSchema()
+	if utils.UsesPostgres() {
+		s.jobs = s.jobQueue()
+	}
+	return s
+}
+
+// newStores returns the stores picked by the UserStorage and MovieStorage env vars. Events are kept along with the
+// movies, since a movie store records the event of a write in its transaction. The databases they use must have
+// been initialized.
+func newStores() (store.UserStore, store.MovieStore, store.EventStore) {
+	var users store.UserStore
+	switch utils.UserStorage {
+	case utils.MemoryStorage:
+		memoryUsers := store.NewMemoryUserStore()
+		// same as the admin seeded in postgres
+		err := memoryUsers.CreateUser(models.User{
+			Email:        utils.Admins[0],
+			Name:         "auto created",
+			Role:         "admin",
+			CreatedAt:    time.Now().Unix(),
+			UserName:     "foox",
+			UserPassword: "barx",
+		})
+		if err != nil {
+			log.Fatalln(err)
+		}
+		users = memoryUsers
+	default:
+		users = store.NewPostgresUserStore(utils.PgDB)
+	}
+
+	var movies store.MovieStore
+	var events store.EventStore = store.NewPostgresEventStore(utils.PgDB)
+	switch utils.MovieStorage {
+	case utils.MemoryStorage:
+		memoryEvents := store.NewMemoryEventStore()
+		movies = store.NewMemoryMovieStore(memoryEvents)
+		events = memoryEvents
+	case utils.PostgresStorage:
+		movies = store.NewPostgresMovieStore(utils.PgDB)
+	default:
+		movies = store.NewElasticMovieStore(utils.PgDB, utils.MovieIndex)
+	}
+	return users, movies, events
+}
@@ -7,12 +7,13 @@ import (
 	"golang.org/x/net/context"
 )
 
-func populateSession(next http.Handler) http.Handler {
+// populateSession authenticates the request with basic auth and stores the email of the user in its context
+func (s *server) populateSession(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if username, password, ok := r.BasicAuth(); ok {
 			fmt.Println("coming in this block")
 			// check if username, password are user's credentials
-			email, err := fetchEmailForUser(username, password)
+			email, err := s.fetchEmailForUser(username, password)
 			if err == nil && email != "" {
 				ctx := r.Context()
 				ctx = context.WithValue(ctx, emailKey, email)
@@ -30,7 +31,10 @@ func populateSession(next http.Handler) http.Handler {
 			writeBack(w, msg, nil)
 			return
 		}
-		w.WriteHeader(401)
-		fmt.Fprintln(w, `{"message":"Not Logged In, No Token"}`)
+		msg := map[string]interface{}{
+			"message": "Not Logged In, No Token",
+			"status":  http.StatusUnauthorized,
+		}
+		writeBack(w, msg, nil)
 	})
 }
@@ -1,18 +1,15 @@
 package main
 
 import (
-	"database/sql"
-
+	"github.com/raazcrzy/imdb/apierror"
 	"github.com/raazcrzy/imdb/models"
+	"github.com/raazcrzy/imdb/store"
 	"github.com/raazcrzy/imdb/utils"
 )
 
 // fetchEmailForUser function fetches the email for a particular username
-func fetchEmailForUser(userID, password string) (string, error) {
-	row := utils.PgDB.QueryRow(`SELECT email FROM imdb.users WHERE user_id=$1 AND user_password=$2`, userID, password)
-
-	var email string
-	err := row.Scan(&email)
+func (s *server) fetchEmailForUser(userID, password string) (string, error) {
+	email, err := s.users.EmailForCredentials(userID, password)
 	if err != nil {
 		Log.Errorln(err)
 		return "", err
@@ -32,55 +29,36 @@ func isSuperAdmin(email string) bool {
 }
 
 // isAdmin function checks if the user has admin role
-func isAdmin(email string) bool {
-	row := utils.PgDB.QueryRow(`SELECT role FROM imdb.users WHERE email=$1`, email)
-
-	var role sql.NullString
-	err := row.Scan(&role)
+func (s *server) isAdmin(email string) bool {
+	role, err := s.users.Role(email)
 	if err != nil {
 		Log.Errorln(err)
 		return false
 	}
 
-	if role.String == "admin" {
+	if role == "admin" {
 		return true
 	}
 	return false
 }
 
 // isAuthorizedUser function checks if the email and username matches
-func isAuthorizedUser(email, userID string) bool {
-	row := utils.PgDB.QueryRow(`SELECT COUNT(*) FROM imdb.users WHERE email=$1 AND user_id=$2`, email, userID)
-
-	var num int
-	err := row.Scan(&num)
+func (s *server) isAuthorizedUser(email, userID string) bool {
+	ok, err := s.users.HasUserName(email, userID)
 	if err != nil {
 		Log.Errorln(err)
 		return false
 	}
-
-	if num > 0 {
-		return true
-	}
-	return false
+	return ok
 }
 
-// createUser function creates a new user in the postgres database
-func createUser(user models.User) (map[string]interface{}, error) {
-	_, err := utils.PgDB.Exec(`INSERT INTO imdb.users(email, name, created_at, user_id, user_password, role) VALUES($1, $2, $3, $4, $5, $6);`, user.Email, user.Name, user.CreatedAt, user.UserName, user.UserPassword, user.Role)
+// createUser function creates a new user in the user store
+func (s *server) createUser(user models.User) (map[string]interface{}, error) {
+	err := s.users.CreateUser(user)
+	if err == store.ErrUserExists || err == store.ErrUserNameTaken {
+		return nil, apierror.New(apierror.Conflict, err.Error())
+	}
 	if err != nil {
-		if err.Error() == `pq: duplicate key value violates unique constraint "users_pkey"` {
-			return map[string]interface{}{
-				"message": "User already exists",
-				"status":  400,
-			}, nil
-		}
-		if err.Error() == `pq: duplicate key value violates unique constraint "users_user_password_key"` {
-			return map[string]interface{}{
-				"message": "user_name not unique",
-				"status":  400,
-			}, nil
-		}
 		Log.Errorln(err)
 		return nil, err
 	}
@@ -90,9 +68,9 @@ func createUser(user models.User) (map[string]interface{}, error) {
 	}, nil
 }
 
-// deleteUser function deletes a user from the postgres database
-func deleteUser(user string) (map[string]interface{}, error) {
-	_, err := utils.PgDB.Exec(`DELETE FROM imdb.users WHERE email=$1;`, user)
+// deleteUser function deletes a user from the user store
+func (s *server) deleteUser(user string) (map[string]interface{}, error) {
+	err := s.users.DeleteUser(user)
 	if err != nil {
 		Log.Errorln(err)
 		return nil, err
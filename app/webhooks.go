@@ -0,0 +1,418 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/raazcrzy/imdb/apierror"
+	"github.com/raazcrzy/imdb/dbConnections"
+	"github.com/raazcrzy/imdb/models"
+	"github.com/raazcrzy/imdb/utils"
+	"github.com/raazcrzy/imdb/validation"
+)
+
+// deadLettersLimit is the number of dead letters listed, the latest ones
+const deadLettersLimit = 100
+
+// addWebhookHandler registers a webhook the movie events are posted to, admins only
+func (s *server) addWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var returnMsg map[string]interface{}
+	var err error
+	email, reqCategory, ok, err := basicAuth(r)
+	if err != nil {
+		Log.Errorln(err)
+		returnMsg = map[string]interface{}{
+			"message": "Internal server error",
+			"status":  http.StatusInternalServerError,
+		}
+		writeBack(w, returnMsg, err)
+		return
+	}
+	if ok {
+		if reqCategory != "users" {
+			returnMsg := map[string]interface{}{
+				"message": "Unauthorized",
+				"status":  http.StatusUnauthorized,
+			}
+			writeBack(w, returnMsg, nil)
+			return
+		}
+	}
+	ok = (s.isAdmin(email) || isSuperAdmin(email))
+	if ok {
+		body := models.Webhook{}
+		err = validation.DecodeBody(w, r, &body)
+		if err != nil {
+			writeBack(w, nil, err)
+			return
+		}
+		err = validation.Check(body)
+		if err != nil {
+			writeBack(w, nil, err)
+			return
+		}
+		returnMsg, err = addWebhook(body)
+	} else {
+		returnMsg = map[string]interface{}{
+			"message": "Not Authorized",
+			"status":  401,
+		}
+	}
+	writeBack(w, returnMsg, err)
+}
+
+// getWebhooksHandler lists the webhooks, without their secrets, admins only
+func (s *server) getWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	var returnMsg map[string]interface{}
+	var err error
+	email, reqCategory, ok, err := basicAuth(r)
+	if err != nil {
+		Log.Errorln(err)
+		returnMsg = map[string]interface{}{
+			"message": "Internal server error",
+			"status":  http.StatusInternalServerError,
+		}
+		writeBack(w, returnMsg, err)
+		return
+	}
+	if ok {
+		if reqCategory != "users" {
+			returnMsg := map[string]interface{}{
+				"message": "Unauthorized",
+				"status":  http.StatusUnauthorized,
+			}
+			writeBack(w, returnMsg, nil)
+			return
+		}
+	}
+	ok = (s.isAdmin(email) || isSuperAdmin(email))
+	if ok {
+		returnMsg, err = listWebhooks()
+	} else {
+		returnMsg = map[string]interface{}{
+			"message": "Not Authorized",
+			"status":  401,
+		}
+	}
+	writeBack(w, returnMsg, err)
+}
+
+// removeWebhookHandler deletes a webhook along with its pending deliveries and dead letters, admins only
+func (s *server) removeWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var returnMsg map[string]interface{}
+	var err error
+	email, reqCategory, ok, err := basicAuth(r)
+	if err != nil {
+		Log.Errorln(err)
+		returnMsg = map[string]interface{}{
+			"message": "Internal server error",
+			"status":  http.StatusInternalServerError,
+		}
+		writeBack(w, returnMsg, err)
+		return
+	}
+	if ok {
+		if reqCategory != "users" {
+			returnMsg := map[string]interface{}{
+				"message": "Unauthorized",
+				"status":  http.StatusUnauthorized,
+			}
+			writeBack(w, returnMsg, nil)
+			return
+		}
+	}
+	ok = (s.isAdmin(email) || isSuperAdmin(email))
+	if ok {
+		var webhookID int64
+		webhookID, err = webhookIDParam(r)
+		if err != nil {
+			writeBack(w, nil, err)
+			return
+		}
+		returnMsg, err = deleteWebhook(webhookID)
+	} else {
+		returnMsg = map[string]interface{}{
+			"message": "Not Authorized",
+			"status":  401,
+		}
+	}
+	writeBack(w, returnMsg, err)
+}
+
+// getDeadLettersHandler lists the latest events a webhook failed to receive, admins only
+func (s *server) getDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	var returnMsg map[string]interface{}
+	var err error
+	email, reqCategory, ok, err := basicAuth(r)
+	if err != nil {
+		Log.Errorln(err)
+		returnMsg = map[string]interface{}{
+			"message": "Internal server error",
+			"status":  http.StatusInternalServerError,
+		}
+		writeBack(w, returnMsg, err)
+		return
+	}
+	if ok {
+		if reqCategory != "users" {
+			returnMsg := map[string]interface{}{
+				"message": "Unauthorized",
+				"status":  http.StatusUnauthorized,
+			}
+			writeBack(w, returnMsg, nil)
+			return
+		}
+	}
+	ok = (s.isAdmin(email) || isSuperAdmin(email))
+	if ok {
+		var webhookID int64
+		webhookID, err = webhookIDParam(r)
+		if err != nil {
+			writeBack(w, nil, err)
+			return
+		}
+		returnMsg, err = listDeadLetters(webhookID)
+	} else {
+		returnMsg = map[string]interface{}{
+			"message": "Not Authorized",
+			"status":  401,
+		}
+	}
+	writeBack(w, returnMsg, err)
+}
+
+// redeliverWebhookHandler queues the dead letters of a webhook for delivery again, admins only
+func (s *server) redeliverWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var returnMsg map[string]interface{}
+	var err error
+	email, reqCategory, ok, err := basicAuth(r)
+	if err != nil {
+		Log.Errorln(err)
+		returnMsg = map[string]interface{}{
+			"message": "Internal server error",
+			"status":  http.StatusInternalServerError,
+		}
+		writeBack(w, returnMsg, err)
+		return
+	}
+	if ok {
+		if reqCategory != "users" {
+			returnMsg := map[string]interface{}{
+				"message": "Unauthorized",
+				"status":  http.StatusUnauthorized,
+			}
+			writeBack(w, returnMsg, nil)
+			return
+		}
+	}
+	ok = (s.isAdmin(email) || isSuperAdmin(email))
+	if ok {
+		var webhookID int64
+		webhookID, err = webhookIDParam(r)
+		if err != nil {
+			writeBack(w, nil, err)
+			return
+		}
+		returnMsg, err = redeliverDeadLetters(webhookID)
+	} else {
+		returnMsg = map[string]interface{}{
+			"message": "Not Authorized",
+			"status":  401,
+		}
+	}
+	writeBack(w, returnMsg, err)
+}
+
+// webhookIDParam returns the webhook id of the route path
+func webhookIDParam(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(pathParam(r, "id"), 10, 64)
+	if err != nil {
+		return 0, apierror.Validation("webhook id must be an integer", apierror.Field("id", "must be an integer"))
+	}
+	return id, nil
+}
+
+// addWebhook stores a webhook. It gets every event type when it has none, and a random secret when it has none.
+func addWebhook(webhook models.Webhook) (map[string]interface{}, error) {
+	// the types are validated ignoring case
+	events := []string{}
+	for _, eventType := range models.MovieEventTypes {
+		for _, wanted := range webhook.Events {
+			if strings.EqualFold(strings.TrimSpace(wanted), eventType) {
+				events = append(events, eventType)
+				break
+			}
+		}
+	}
+	if len(events) == 0 {
+		events = models.MovieEventTypes
+	}
+	webhook.Events = events
+	if webhook.Secret == "" {
+		random := make([]byte, 32)
+		_, err := rand.Read(random)
+		if err != nil {
+			return nil, err
+		}
+		webhook.Secret = hex.EncodeToString(random)
+	}
+	webhook.CreatedAt = time.Now().Unix()
+	err := utils.PgDB.QueryRow(`INSERT INTO imdb.webhooks(url, secret, events, created_at) VALUES($1, $2, $3, $4) RETURNING id`,
+		webhook.URL, webhook.Secret, pq.Array(webhook.Events), webhook.CreatedAt).Scan(&webhook.ID)
+	if err != nil {
+		Log.Errorln(err)
+		return nil, err
+	}
+	return map[string]interface{}{
+		"message": "webhook added successfully",
+		"webhook": webhook,
+		"status":  201,
+	}, nil
+}
+
+// listWebhooks returns the webhooks without their secrets
+func listWebhooks() (map[string]interface{}, error) {
+	rows, err := utils.PgDB.Query(`SELECT id, url, events, created_at FROM imdb.webhooks ORDER BY id`)
+	if err != nil {
+		Log.Errorln(err)
+		return nil, err
+	}
+	defer rows.Close()
+	webhooks := []models.Webhook{}
+	for rows.Next() {
+		webhook := models.Webhook{}
+		err = rows.Scan(&webhook.ID, &webhook.URL, pq.Array(&webhook.Events), &webhook.CreatedAt)
+		if err != nil {
+			Log.Errorln(err)
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	if err = rows.Err(); err != nil {
+		Log.Errorln(err)
+		return nil, err
+	}
+	return map[string]interface{}{
+		"message":  "request successful",
+		"webhooks": webhooks,
+		"status":   200,
+	}, nil
+}
+
+// deleteWebhook deletes a webhook, its deliveries and dead letters are deleted along with it
+func deleteWebhook(id int64) (map[string]interface{}, error) {
+	result, err := utils.PgDB.Exec(`DELETE FROM imdb.webhooks WHERE id=$1`, id)
+	if err != nil {
+		Log.Errorln(err)
+		return nil, err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		Log.Errorln(err)
+		return nil, err
+	}
+	if deleted == 0 {
+		return map[string]interface{}{
+			"message": "webhook not found",
+			"status":  404,
+		}, nil
+	}
+	return map[string]interface{}{
+		"message": "webhook deleted successfully",
+		"status":  200,
+	}, nil
+}
+
+// webhookExists reports whether there is a webhook with the given id
+func webhookExists(id int64) (bool, error) {
+	var exists bool
+	err := utils.PgDB.QueryRow(`SELECT EXISTS(SELECT 1 FROM imdb.webhooks WHERE id=$1)`, id).Scan(&exists)
+	return exists, err
+}
+
+// listDeadLetters returns the latest dead letters of a webhook, newest first
+func listDeadLetters(webhookID int64) (map[string]interface{}, error) {
+	exists, err := webhookExists(webhookID)
+	if err != nil {
+		Log.Errorln(err)
+		return nil, err
+	}
+	if !exists {
+		return map[string]interface{}{
+			"message": "webhook not found",
+			"status":  404,
+		}, nil
+	}
+	rows, err := utils.PgDB.Query(`SELECT id, webhook_id, payload, attempts, last_error, failed_at FROM imdb.webhook_dead_letters
+	WHERE webhook_id=$1 ORDER BY id DESC LIMIT $2`, webhookID, deadLettersLimit)
+	if err != nil {
+		Log.Errorln(err)
+		return nil, err
+	}
+	defer rows.Close()
+	deadLetters := []models.WebhookDeadLetter{}
+	for rows.Next() {
+		deadLetter := models.WebhookDeadLetter{}
+		var payload []byte
+		err = rows.Scan(&deadLetter.ID, &deadLetter.WebhookID, &payload, &deadLetter.Attempts, &deadLetter.LastError, &deadLetter.FailedAt)
+		if err != nil {
+			Log.Errorln(err)
+			return nil, err
+		}
+		err = json.Unmarshal(payload, &deadLetter.Event)
+		if err != nil {
+			Log.Errorln(err)
+			return nil, err
+		}
+		deadLetters = append(deadLetters, deadLetter)
+	}
+	if err = rows.Err(); err != nil {
+		Log.Errorln(err)
+		return nil, err
+	}
+	return map[string]interface{}{
+		"message":      "request successful",
+		"dead_letters": deadLetters,
+		"status":       200,
+	}, nil
+}
+
+// redeliverDeadLetters moves the dead letters of a webhook back to its deliveries, to be attempted again from scratch
+func redeliverDeadLetters(webhookID int64) (map[string]interface{}, error) {
+	exists, err := webhookExists(webhookID)
+	if err != nil {
+		Log.Errorln(err)
+		return nil, err
+	}
+	if !exists {
+		return map[string]interface{}{
+			"message": "webhook not found",
+			"status":  404,
+		}, nil
+	}
+	now := time.Now().Unix()
+	result, err := utils.PgDB.Exec(`WITH moved AS (DELETE FROM imdb.webhook_dead_letters WHERE webhook_id=$1 RETURNING webhook_id, event_id, payload)
+	INSERT INTO imdb.webhook_deliveries(webhook_id, event_id, payload, next_attempt_at, created_at) SELECT webhook_id, event_id, payload, $2, $2 FROM moved ORDER BY event_id`,
+		webhookID, now)
+	if err != nil {
+		Log.Errorln(err)
+		return nil, err
+	}
+	redelivered, err := result.RowsAffected()
+	if err != nil {
+		Log.Errorln(err)
+		return nil, err
+	}
+	dbConnections.WakeWebhooks()
+	return map[string]interface{}{
+		"message":     "dead letters queued for delivery",
+		"redelivered": redelivered,
+		"status":      200,
+	}, nil
+}
@@ -0,0 +1,246 @@
+// Package client calls the catalogue API from Go services. It uses the /v2 endpoints, sends the models types
+// as they are and answers errors with *Error, so callers do not have to hand-roll requests:
+//
+//	c, err := client.New("http://localhost:8000", client.WithBasicAuth("user_name", "user_password"))
+//	page, err := c.Movies.Search(ctx, client.SearchParams{Name: "star", Genres: []string{"Sci-Fi"}})
+//
+// Requests that fail because the API or one of its databases is unavailable are retried with exponential backoff.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client calls the catalogue API, it is safe for concurrent use
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	auth       func(r *http.Request)
+	headers    http.Header
+	retries    int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	userAgent  string
+
+	Movies *MoviesService
+	Users  *UsersService
+}
+
+// Option configures a Client
+type Option func(c *Client)
+
+// WithBasicAuth authenticates requests with the user_name and user_password of a user, which is what the API checks
+func WithBasicAuth(userName, password string) Option {
+	return func(c *Client) {
+		c.auth = func(r *http.Request) { r.SetBasicAuth(userName, password) }
+	}
+}
+
+// WithHeader sets a header on every request, after the credentials, for gateways in front of the API checking
+// their own credentials. The API itself only checks basic auth.
+func WithHeader(name, value string) Option {
+	return func(c *Client) {
+		c.headers.Set(name, value)
+	}
+}
+
+// WithToken sends a bearer token in the Authorization header instead of basic auth, for a gateway checking tokens
+// that signs in to the API itself
+func WithToken(token string) Option {
+	return WithHeader("Authorization", "Bearer "+token)
+}
+
+// WithAPIKey sends an API key in the X-API-Key header, along with basic auth, for a gateway checking keys
+func WithAPIKey(key string) Option {
+	return WithHeader("X-API-Key", key)
+}
+
+// WithHTTPClient sends the requests through the given client instead of one with a 30 seconds timeout
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithRetries sets how many times a failed request is retried, 3 by default, 0 disables retries.
+// The wait before a retry doubles from min up to max, 200ms and 5s by default, with random jitter.
+func WithRetries(retries int, min, max time.Duration) Option {
+	return func(c *Client) {
+		c.retries, c.minBackoff, c.maxBackoff = retries, min, max
+	}
+}
+
+// WithUserAgent sets the User-Agent header of the requests
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+// New returns a client of the API at baseURL, such as http://localhost:8000
+func New(baseURL string, options ...Option) (*Client, error) {
+	baseURL = strings.TrimRight(baseURL, "/")
+	parsed, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || parsed.RawQuery != "" {
+		return nil, fmt.Errorf("client: base URL %q must be an http or https URL without query", baseURL)
+	}
+	c := &Client{
+		baseURL:    baseURL,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		auth:       func(r *http.Request) {},
+		headers:    http.Header{},
+		retries:    3,
+		minBackoff: 200 * time.Millisecond,
+		maxBackoff: 5 * time.Second,
+		userAgent:  "imdb-client",
+	}
+	for _, option := range options {
+		option(c)
+	}
+	c.Movies = &MoviesService{client: c}
+	c.Users = &UsersService{client: c}
+	return c, nil
+}
+
+// do sends a request with in as its JSON body, when not nil, and decodes the JSON body of a success into out,
+// when not nil. Errors of the API are returned as *Error.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		body, err = json.Marshal(in)
+		if err != nil {
+			return err
+		}
+	}
+	// path is escaped already
+	target := c.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	for attempt := 0; ; attempt++ {
+		response, err := c.send(ctx, method, target, body)
+		retry, wait := c.shouldRetry(method, response, err, attempt)
+		if !retry {
+			if err != nil {
+				return err
+			}
+			return decodeResponse(response, out)
+		}
+		if response != nil {
+			io.Copy(ioutil.Discard, response.Body)
+			response.Body.Close()
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, target string, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	r, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	r.Header.Set("Accept", "application/json")
+	r.Header.Set("User-Agent", c.userAgent)
+	c.auth(r)
+	for name, values := range c.headers {
+		r.Header[name] = values
+	}
+	return c.httpClient.Do(r)
+}
+
+// shouldRetry tells whether a request is sent again and after how long. Requests are retried when the API could not
+// be reached or answered that it was unavailable. A POST is not idempotent, so it is only retried when it provably was
+// not handled: the connection could not be opened, or the API answered 429 Too Many Requests. A 502, 503 or 504 may
+// come from a proxy after the API applied the request.
+func (c *Client) shouldRetry(method string, response *http.Response, err error, attempt int) (bool, time.Duration) {
+	if attempt >= c.retries {
+		return false, 0
+	}
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return false, 0
+		}
+		return method != http.MethodPost || isDialError(err), c.backoff(attempt)
+	}
+	switch response.StatusCode {
+	case http.StatusTooManyRequests:
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		if method == http.MethodPost {
+			return false, 0
+		}
+	default:
+		return false, 0
+	}
+	if seconds, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+		return true, time.Duration(seconds) * time.Second
+	}
+	return true, c.backoff(attempt)
+}
+
+// isDialError reports whether err is the failure to open a connection, when nothing of the request was sent
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// backoff returns a random wait of up to minBackoff doubled attempt times, capped to maxBackoff
+func (c *Client) backoff(attempt int) time.Duration {
+	wait := c.minBackoff << uint(attempt)
+	if wait > c.maxBackoff || wait <= 0 {
+		wait = c.maxBackoff
+	}
+	if wait <= 0 {
+		return 0
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+func decodeResponse(response *http.Response, out interface{}) error {
+	defer response.Body.Close()
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode >= 400 {
+		return newError(response, data)
+	}
+	if out == nil {
+		return nil
+	}
+	err = json.Unmarshal(data, out)
+	if err != nil {
+		return fmt.Errorf("client: cannot decode the %d response: %w", response.StatusCode, err)
+	}
+	return nil
+}
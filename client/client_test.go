@@ -0,0 +1,309 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/raazcrzy/imdb/models"
+)
+
+// replies is a server answering its requests with the statuses of a script, in order, and the last one once the
+// script is over
+type replies struct {
+	mu       sync.Mutex
+	statuses []int
+	methods  []string
+}
+
+func (s *replies) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	status := s.statuses[0]
+	if len(s.statuses) > 1 {
+		s.statuses = s.statuses[1:]
+	}
+	s.methods = append(s.methods, r.Method)
+	s.mu.Unlock()
+	w.Header().Set("Retry-After", "0")
+	w.WriteHeader(status)
+	fmt.Fprint(w, `{"movie_id": "a", "movie": {"movie_id": "a"}}`)
+}
+
+func newTestClient(t *testing.T, url string, options ...Option) *Client {
+	t.Helper()
+	options = append([]Option{WithRetries(3, time.Millisecond, 4*time.Millisecond)}, options...)
+	c, err := New(url, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestRetries(t *testing.T) {
+	get := func(c *Client) error {
+		_, err := c.Movies.Get(context.Background(), "a")
+		return err
+	}
+	create := func(c *Client) error {
+		_, err := c.Movies.Create(context.Background(), models.Movie{Name: "Psycho"})
+		return err
+	}
+	tests := []struct {
+		name     string
+		call     func(c *Client) error
+		statuses []int
+		attempts int
+		status   int
+	}{
+		{"GET succeeding", get, []int{200}, 1, 200},
+		{"GET unavailable", get, []int{503, 502, 504, 200}, 4, 200},
+		{"GET unavailable too long", get, []int{503}, 4, 503},
+		{"GET rate limited", get, []int{429, 200}, 2, 200},
+		{"GET failing", get, []int{500, 200}, 1, 500},
+		{"GET not found", get, []int{404, 200}, 1, 404},
+		{"POST rate limited", create, []int{429, 429, 201}, 3, 201},
+		{"POST unavailable", create, []int{503, 201}, 1, 503},
+		{"POST through a failing proxy", create, []int{502, 201}, 1, 502},
+		{"POST timing out", create, []int{504, 201}, 1, 504},
+	}
+	for _, test := range tests {
+		server := &replies{statuses: test.statuses}
+		srv := httptest.NewServer(server)
+		err := test.call(newTestClient(t, srv.URL))
+		srv.Close()
+
+		if len(server.methods) != test.attempts {
+			t.Errorf("%s: got %d attempts, want %d", test.name, len(server.methods), test.attempts)
+		}
+		var apiErr *Error
+		switch {
+		case test.status < 400 && err != nil:
+			t.Errorf("%s: got %v", test.name, err)
+		case test.status >= 400 && (!errors.As(err, &apiErr) || apiErr.StatusCode != test.status):
+			t.Errorf("%s: got %v, want a %d error", test.name, err, test.status)
+		}
+	}
+}
+
+// failingTransport fails every request with err, counting them
+type failingTransport struct {
+	err      error
+	attempts int
+}
+
+func (t *failingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.attempts++
+	return nil, t.err
+}
+
+// TestRetriesWithoutResponse checks that requests without a response are retried, except a POST that may have
+// reached the API
+func TestRetriesWithoutResponse(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+	tests := []struct {
+		name     string
+		method   string
+		err      error
+		attempts int
+	}{
+		{"GET refused", http.MethodGet, refused, 4},
+		{"GET reset", http.MethodGet, reset, 4},
+		{"GET response lost", http.MethodGet, io.ErrUnexpectedEOF, 4},
+		{"POST refused", http.MethodPost, refused, 4},
+		{"POST reset", http.MethodPost, reset, 1},
+		{"POST response lost", http.MethodPost, io.ErrUnexpectedEOF, 1},
+		{"DELETE reset", http.MethodDelete, reset, 4},
+		{"GET canceled", http.MethodGet, context.Canceled, 1},
+	}
+	for _, test := range tests {
+		transport := &failingTransport{err: test.err}
+		c := newTestClient(t, "http://imdb.invalid", WithHTTPClient(&http.Client{Transport: transport}))
+		err := c.do(context.Background(), test.method, "/v2/movies", nil, nil, nil)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.err)
+		}
+		if transport.attempts != test.attempts {
+			t.Errorf("%s: got %d attempts, want %d", test.name, transport.attempts, test.attempts)
+		}
+	}
+
+	// a refused connection is told apart once wrapped by the http client
+	var urlErr error = &url.Error{Op: "Post", URL: "http://imdb.invalid", Err: refused}
+	if !isDialError(urlErr) || isDialError(&url.Error{Op: "Post", URL: "http://imdb.invalid", Err: reset}) {
+		t.Error("isDialError does not tell a refused connection from a reset one")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	c := newTestClient(t, "http://imdb.invalid", WithRetries(10, 100*time.Millisecond, time.Second))
+	for attempt, max := range []time.Duration{100, 200, 400, 800, 1000, 1000, 1000} {
+		max *= time.Millisecond
+		for i := 0; i < 20; i++ {
+			if wait := c.backoff(attempt); wait < max/2 || wait > max {
+				t.Fatalf("attempt %d: waited %s, want between %s and %s", attempt, wait, max/2, max)
+			}
+		}
+	}
+	if wait := c.backoff(80); wait < 500*time.Millisecond || wait > time.Second {
+		t.Errorf("attempt 80: waited %s, want the max", wait)
+	}
+
+	response := &http.Response{StatusCode: 429, Header: http.Header{"Retry-After": {"7"}}}
+	if retry, wait := c.shouldRetry(http.MethodGet, response, nil, 0); !retry || wait != 7*time.Second {
+		t.Errorf("got %v after %s, want a retry after the 7s of Retry-After", retry, wait)
+	}
+	if retry, _ := c.shouldRetry(http.MethodGet, response, nil, 10); retry {
+		t.Error("retried after the last attempt")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		header string
+		body   string
+		want   Error
+		text   string
+	}{
+		{"API error", 400, "", `{"code": "validation_failed", "message": "one or more fields are invalid", "request_id": "r1",
+			"fields": [{"field": "name", "message": "is required"}, {"field": "genre", "message": "is unknown"}]}`,
+			Error{StatusCode: 400, Code: CodeValidationFailed, Message: "one or more fields are invalid", RequestID: "r1",
+				Fields: []FieldError{{"name", "is required"}, {"genre", "is unknown"}}},
+			"imdb api: 400 validation_failed: one or more fields are invalid (name is required, genre is unknown) [request r1]"},
+		{"request id in a header", 404, "r2", `{"code": "not_found", "message": "movie a not found"}`,
+			Error{StatusCode: 404, Code: CodeNotFound, Message: "movie a not found", RequestID: "r2"},
+			"imdb api: 404 not_found: movie a not found [request r2]"},
+		{"proxy error", 502, "", "<html>Bad Gateway</html>\n",
+			Error{StatusCode: 502, Code: CodeUpstreamUnavailable, Message: "<html>Bad Gateway</html>"},
+			"imdb api: 502 upstream_unavailable: <html>Bad Gateway</html>"},
+		{"empty body", 403, "", "",
+			Error{StatusCode: 403, Code: CodeUnauthorized, Message: "Forbidden"},
+			"imdb api: 403 unauthorized: Forbidden"},
+		{"JSON without a code", 409, "", `{"message": "taken"}`,
+			Error{StatusCode: 409, Code: CodeConflict, Message: `{"message": "taken"}`},
+			`imdb api: 409 conflict: {"message": "taken"}`},
+	}
+	for _, test := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if test.header != "" {
+				w.Header().Set("X-Request-ID", test.header)
+			}
+			w.WriteHeader(test.status)
+			fmt.Fprint(w, test.body)
+		}))
+		_, err := newTestClient(t, srv.URL, WithRetries(0, 0, 0)).Movies.Get(context.Background(), "a")
+		srv.Close()
+
+		var apiErr *Error
+		if !errors.As(err, &apiErr) {
+			t.Errorf("%s: got %v, want an *Error", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(*apiErr, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, *apiErr, test.want)
+		}
+		if apiErr.Error() != test.text {
+			t.Errorf("%s: got %q, want %q", test.name, apiErr.Error(), test.text)
+		}
+	}
+
+	err := error(&Error{StatusCode: 404, Code: CodeNotFound})
+	if !IsNotFound(fmt.Errorf("get: %w", err)) || IsConflict(err) || IsValidation(err) || IsUnauthorized(err) {
+		t.Error("the Is functions do not tell the code of a wrapped error")
+	}
+}
+
+func TestIterate(t *testing.T) {
+	// pages of the search, by cursor
+	pages := map[string]string{
+		"":   `{"movies": [{"movie_id": "a", "name": "Alien"}, {"movie_id": "b", "name": "Psycho"}], "total": 5, "next_cursor": "c1"}`,
+		"c1": `{"movies": [], "total": 5, "next_cursor": "c2"}`,
+		"c2": `{"movies": [{"movie_id": "c", "name": "Star Wars"}, {"movie_id": "d", "name": "The Birds"}], "total": 5, "next_cursor": "c3"}`,
+		"c3": `{"movies": [{"movie_id": "e", "name": "Vertigo"}], "total": 5}`,
+	}
+	queries := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		page, ok := pages[r.URL.Query().Get("cursor")]
+		if !ok {
+			w.WriteHeader(400)
+			fmt.Fprint(w, `{"code": "validation_failed", "message": "invalid cursor"}`)
+			return
+		}
+		fmt.Fprint(w, page)
+	}))
+	defer srv.Close()
+	c := newTestClient(t, srv.URL)
+
+	it := c.Movies.Iterate(context.Background(), SearchParams{Genres: []string{"Horror"}, From: 10, Cursor: "ignored", Size: 2})
+	names := []string{}
+	for it.Next() {
+		names = append(names, it.Movie().Name)
+	}
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+	if want := []string{"Alien", "Psycho", "Star Wars", "The Birds", "Vertigo"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+	want := []string{"genre=Horror&size=2", "cursor=c1&genre=Horror&size=2", "cursor=c2&genre=Horror&size=2", "cursor=c3&genre=Horror&size=2"}
+	if !reflect.DeepEqual(queries, want) {
+		t.Errorf("got queries %v, want %v", queries, want)
+	}
+	if it.Next() {
+		t.Error("Next moved past the last page")
+	}
+
+	// a failing page ends the iteration with its error
+	pages["c2"] = strings.Replace(pages["c2"], `"c3"`, `"expired"`, 1)
+	it = c.Movies.Iterate(context.Background(), SearchParams{})
+	count := 0
+	for it.Next() {
+		count++
+	}
+	if count != 4 || !IsValidation(it.Err()) {
+		t.Errorf("got %d movies and %v, want 4 movies and the error of the expired cursor", count, it.Err())
+	}
+}
+
+func TestAuth(t *testing.T) {
+	tests := []struct {
+		name          string
+		options       []Option
+		authorization string
+		apiKey        string
+	}{
+		{"none", nil, "", ""},
+		{"basic auth", []Option{WithBasicAuth("foox", "barx")}, "Basic Zm9veDpiYXJ4", ""},
+		{"token", []Option{WithToken("t0k")}, "Bearer t0k", ""},
+		{"token over basic auth", []Option{WithBasicAuth("foox", "barx"), WithToken("t0k")}, "Bearer t0k", ""},
+		{"API key along with basic auth", []Option{WithBasicAuth("foox", "barx"), WithAPIKey("k3y")}, "Basic Zm9veDpiYXJ4", "k3y"},
+	}
+	for _, test := range tests {
+		var got http.Header
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r.Header
+			fmt.Fprint(w, `{"movie": {}}`)
+		}))
+		_, err := newTestClient(t, srv.URL, test.options...).Movies.Get(context.Background(), "a")
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Get("Authorization") != test.authorization || got.Get("X-API-Key") != test.apiKey {
+			t.Errorf("%s: got Authorization %q and X-API-Key %q", test.name, got.Get("Authorization"), got.Get("X-API-Key"))
+		}
+	}
+}
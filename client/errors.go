@@ -0,0 +1,114 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Error codes of the API
+const (
+	CodeNotFound            = "not_found"
+	CodeValidationFailed    = "validation_failed"
+	CodeConflict            = "conflict"
+	CodeUnauthorized        = "unauthorized"
+	CodeMethodNotAllowed    = "method_not_allowed"
+	CodePayloadTooLarge     = "payload_too_large"
+	CodeUpstreamUnavailable = "upstream_unavailable"
+	CodeInternal            = "internal"
+)
+
+// FieldError tells why the value of a request field or param was refused
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is an error answered by the API
+type Error struct {
+	StatusCode int          `json:"-"`
+	Code       string       `json:"code"`
+	Message    string       `json:"message"`
+	RequestID  string       `json:"request_id"`
+	Fields     []FieldError `json:"fields,omitempty"`
+}
+
+func (e *Error) Error() string {
+	message := fmt.Sprintf("imdb api: %d %s: %s", e.StatusCode, e.Code, e.Message)
+	if len(e.Fields) > 0 {
+		fields := []string{}
+		for _, field := range e.Fields {
+			fields = append(fields, field.Field+" "+field.Message)
+		}
+		message += " (" + strings.Join(fields, ", ") + ")"
+	}
+	if e.RequestID != "" {
+		message += " [request " + e.RequestID + "]"
+	}
+	return message
+}
+
+// newError reads the error body of a response, bodies not written by the API, for example by a proxy,
+// get the code of their status
+func newError(response *http.Response, data []byte) *Error {
+	apiErr := &Error{}
+	if json.Unmarshal(data, apiErr) != nil || apiErr.Code == "" {
+		apiErr = &Error{Code: codeForStatus(response.StatusCode), Message: strings.TrimSpace(string(data))}
+		if apiErr.Message == "" {
+			apiErr.Message = http.StatusText(response.StatusCode)
+		}
+	}
+	apiErr.StatusCode = response.StatusCode
+	if apiErr.RequestID == "" {
+		apiErr.RequestID = response.Header.Get("X-Request-ID")
+	}
+	return apiErr
+}
+
+func codeForStatus(status int) string {
+	switch status {
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusBadRequest:
+		return CodeValidationFailed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return CodeUnauthorized
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusRequestEntityTooLarge:
+		return CodePayloadTooLarge
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return CodeUpstreamUnavailable
+	}
+	return CodeInternal
+}
+
+// HasCode reports whether err is an *Error with the given code
+func HasCode(err error, code string) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
+// IsNotFound reports whether err is the API answering that a movie or user does not exist
+func IsNotFound(err error) bool {
+	return HasCode(err, CodeNotFound)
+}
+
+// IsConflict reports whether err is the API refusing a change conflicting with the stored data, such as a taken email
+func IsConflict(err error) bool {
+	return HasCode(err, CodeConflict)
+}
+
+// IsValidation reports whether err is the API refusing invalid fields or params, listed in the Fields of the *Error
+func IsValidation(err error) bool {
+	return HasCode(err, CodeValidationFailed)
+}
+
+// IsUnauthorized reports whether err is the API refusing the credentials or the operation to the user
+func IsUnauthorized(err error) bool {
+	return HasCode(err, CodeUnauthorized)
+}
@@ -0,0 +1,206 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/raazcrzy/imdb/models"
+)
+
+// MoviesService calls the movie endpoints. Writes need an admin.
+type MoviesService struct {
+	client *Client
+}
+
+// SearchParams are the params of a movie search, zero values are left out. Pointers are used for the numbers
+// whose zero value is a valid bound.
+type SearchParams struct {
+	Name          string
+	Director      string
+	Genres        []string
+	MatchAll      bool // a movie needs all of Genres instead of any
+	ExcludeGenres []string
+	DirectorFacet string
+	MinScore      *float64
+	MaxScore      *float64
+	MinPopularity *float64
+	MaxPopularity *float64
+	Query         string // the q query language, for example director:"george lucas" score>=8
+	Sort          string // comma separated field:order pairs
+	Ranking       string
+	Facets        bool
+	Highlight     bool
+	Explain       bool
+	From          int
+	Size          int
+	Cursor        string
+}
+
+// Values returns the URL params of the search
+func (p SearchParams) Values() url.Values {
+	values := url.Values{}
+	set := func(name, value string) {
+		if value != "" {
+			values.Set(name, value)
+		}
+	}
+	setNumber := func(name string, value *float64) {
+		if value != nil {
+			values.Set(name, strconv.FormatFloat(*value, 'f', -1, 64))
+		}
+	}
+	set("name", p.Name)
+	set("director", p.Director)
+	for _, genre := range p.Genres {
+		values.Add("genre", genre)
+	}
+	if p.MatchAll {
+		values.Set("genre_match", "all")
+	}
+	for _, genre := range p.ExcludeGenres {
+		values.Add("-genre", genre)
+	}
+	set("director_facet", p.DirectorFacet)
+	setNumber("imdb_score_gte", p.MinScore)
+	setNumber("imdb_score_lte", p.MaxScore)
+	setNumber("99popularity_gte", p.MinPopularity)
+	setNumber("99popularity_lte", p.MaxPopularity)
+	set("q", p.Query)
+	set("sort", p.Sort)
+	set("ranking", p.Ranking)
+	if p.Facets {
+		values.Set("facets", "true")
+	}
+	if p.Highlight {
+		values.Set("highlight", "true")
+	}
+	if p.Explain {
+		values.Set("explain", "true")
+	}
+	if p.From > 0 {
+		values.Set("from", strconv.Itoa(p.From))
+	}
+	if p.Size > 0 {
+		values.Set("size", strconv.Itoa(p.Size))
+	}
+	set("cursor", p.Cursor)
+	return values
+}
+
+// MovieHit is a movie found by a search, with the highlight and score asked for
+type MovieHit struct {
+	models.Movie
+	Highlight   map[string][]string `json:"highlight,omitempty"`
+	Score       *float64            `json:"score,omitempty"`
+	Explanation json.RawMessage     `json:"explanation,omitempty"`
+}
+
+// FacetBucket is the count of the movies having a facet value
+type FacetBucket struct {
+	Key   interface{} `json:"key"`
+	Count int64       `json:"count"`
+}
+
+// SearchPage is a page of search results. NextCursor is set when more results may follow.
+type SearchPage struct {
+	Movies     []MovieHit               `json:"movies"`
+	Total      int64                    `json:"total"`
+	NextCursor string                   `json:"next_cursor,omitempty"`
+	Facets     map[string][]FacetBucket `json:"facets,omitempty"`
+}
+
+// Search returns a page of the movies matching the params
+func (s *MoviesService) Search(ctx context.Context, params SearchParams) (*SearchPage, error) {
+	page := &SearchPage{}
+	err := s.client.do(ctx, http.MethodGet, "/v2/movies", params.Values(), nil, page)
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// Iterate returns an iterator over every movie matching the params, fetched a page at a time with cursors.
+// From and Cursor are ignored.
+//
+//	it := c.Movies.Iterate(ctx, client.SearchParams{Genres: []string{"Drama"}})
+//	for it.Next() {
+//		movie := it.Movie()
+//	}
+//	if err := it.Err(); err != nil {
+func (s *MoviesService) Iterate(ctx context.Context, params SearchParams) *MovieIterator {
+	params.From, params.Cursor = 0, ""
+	return &MovieIterator{service: s, ctx: ctx, params: params}
+}
+
+// MovieIterator walks the results of a search, it is not safe for concurrent use
+type MovieIterator struct {
+	service *MoviesService
+	ctx     context.Context
+	params  SearchParams
+	page    []MovieHit
+	current MovieHit
+	last    bool
+	err     error
+}
+
+// Next moves to the next movie, fetching the next page when needed. It returns false once every movie was walked
+// or a request failed, which Err tells.
+func (it *MovieIterator) Next() bool {
+	for len(it.page) == 0 {
+		if it.last || it.err != nil {
+			return false
+		}
+		page, err := it.service.Search(it.ctx, it.params)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.page = page.Movies
+		it.params.Cursor = page.NextCursor
+		it.last = page.NextCursor == ""
+	}
+	it.current, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+// Movie returns the current movie
+func (it *MovieIterator) Movie() MovieHit {
+	return it.current
+}
+
+// Err returns the error that ended the iteration, nil when every movie was walked
+func (it *MovieIterator) Err() error {
+	return it.err
+}
+
+// Get returns the movie with the given id
+func (s *MoviesService) Get(ctx context.Context, id string) (models.Movie, error) {
+	var response struct {
+		Movie models.Movie `json:"movie"`
+	}
+	err := s.client.do(ctx, http.MethodGet, "/v2/movies/"+url.PathEscape(id), nil, nil, &response)
+	return response.Movie, err
+}
+
+// Create adds a movie and returns the id it was given, the ID of the movie is ignored
+func (s *MoviesService) Create(ctx context.Context, movie models.Movie) (string, error) {
+	movie.ID = ""
+	var response struct {
+		ID string `json:"movie_id"`
+	}
+	err := s.client.do(ctx, http.MethodPost, "/v2/movies", nil, movie, &response)
+	return response.ID, err
+}
+
+// Update replaces every field of the movie with the ID of the given one
+func (s *MoviesService) Update(ctx context.Context, movie models.Movie) error {
+	return s.client.do(ctx, http.MethodPut, "/v2/movies/"+url.PathEscape(movie.ID), nil, movie, nil)
+}
+
+// Delete removes the movie with the given id
+func (s *MoviesService) Delete(ctx context.Context, id string) error {
+	return s.client.do(ctx, http.MethodDelete, "/v2/movies/"+url.PathEscape(id), nil, nil, nil)
+}
@@ -0,0 +1,24 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/raazcrzy/imdb/models"
+)
+
+// UsersService calls the user endpoints
+type UsersService struct {
+	client *Client
+}
+
+// Create adds a user. Creating an admin needs an admin, the role defaults to user.
+func (s *UsersService) Create(ctx context.Context, user models.User) error {
+	return s.client.do(ctx, http.MethodPost, "/v2/users", nil, user, nil)
+}
+
+// Delete removes the user with the given email. Users can delete themselves, admins anyone.
+func (s *UsersService) Delete(ctx context.Context, email string) error {
+	return s.client.do(ctx, http.MethodDelete, "/v2/users/"+url.PathEscape(email), nil, nil, nil)
+}
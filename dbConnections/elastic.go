@@ -0,0 +1,194 @@
+package dbConnections
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/raazcrzy/imdb/utils"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+/*
+The elastic.v5 client puts the imdb mapping type in the path of every document, mapping and search call,
+while mapping types were removed in Elasticsearch 7. The calls below go through PerformRequest instead,
+using the typed paths on Elasticsearch 5 and 6 and the typeless ones on Elasticsearch 7, 8 and OpenSearch,
+depending on the cluster version detected at startup. Calls that never had a type, such as index and alias
+management, still go through the client services.
+*/
+
+// InitElasticsearch connects to the cluster and detects its version
+func InitElasticsearch() {
+	var err error
+	utils.Elasticconn, err = elastic.NewClient(elastic.SetURL(utils.ElasticURL), elastic.SetSniff(false))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	response, err := utils.Elasticconn.PerformRequest(context.Background(), "GET", "/", nil, nil)
+	if err != nil {
+		log.Fatalln("cannot read the elasticsearch version:", err)
+	}
+	var info struct {
+		Version struct {
+			Number       string `json:"number"`
+			Distribution string `json:"distribution"`
+		} `json:"version"`
+	}
+	err = json.Unmarshal(response.Body, &info)
+	if err != nil {
+		log.Fatalln("cannot read the elasticsearch version:", err)
+	}
+	utils.ElasticDistribution = info.Version.Distribution
+	if utils.ElasticDistribution == "" {
+		utils.ElasticDistribution = "elasticsearch"
+	}
+	utils.ElasticVersion = info.Version.Number
+	major, _ := strconv.Atoi(strings.SplitN(utils.ElasticVersion, ".", 2)[0])
+	switch {
+	// opensearch reports 7.10.2 when compatibility.override_main_response_version is set
+	case utils.ElasticDistribution == "opensearch" && (major == 1 || major == 2 || utils.ElasticVersion == "7.10.2"):
+		utils.ElasticTypeless = true
+	case utils.ElasticDistribution == "elasticsearch" && major >= 5 && major <= 8:
+		utils.ElasticTypeless = major >= 7
+	default:
+		log.Fatalf("%s %s is not supported, use elasticsearch 5 to 8 or opensearch 1 to 2", utils.ElasticDistribution, utils.ElasticVersion)
+	}
+	log.Println("connected to", utils.ElasticDistribution, utils.ElasticVersion)
+}
+
+// documentPath is the path of a movie document, with the mapping type on clusters that still have them
+func documentPath(index, id string) string {
+	if utils.ElasticTypeless {
+		return "/" + url.PathEscape(index) + "/_doc/" + url.PathEscape(id)
+	}
+	return "/" + url.PathEscape(index) + "/imdb/" + url.PathEscape(id)
+}
+
+// IndexDocument creates or replaces the movie document with the given id.
+// A version above 0 is an external version: a document holding the same or a later version is left as is,
+// and elastic.IsConflict reports it.
+func IndexDocument(ctx context.Context, index, id string, version int64, body interface{}) error {
+	_, err := utils.Elasticconn.PerformRequest(ctx, "PUT", documentPath(index, id), externalVersion(version), body)
+	return err
+}
+
+// DeleteDocument deletes the movie document with the given id, elastic.IsNotFound reports a missing one.
+// A version above 0 is an external version, as in IndexDocument.
+func DeleteDocument(ctx context.Context, index, id string, version int64) error {
+	_, err := utils.Elasticconn.PerformRequest(ctx, "DELETE", documentPath(index, id), externalVersion(version), nil)
+	return err
+}
+
+func externalVersion(version int64) url.Values {
+	if version <= 0 {
+		return nil
+	}
+	params := url.Values{}
+	params.Set("version", strconv.FormatInt(version, 10))
+	params.Set("version_type", "external")
+	return params
+}
+
+// SearchIndex runs a search on the movie documents of an index or alias
+func SearchIndex(ctx context.Context, index string, source *elastic.SearchSource) (*elastic.SearchResult, error) {
+	body, err := source.Source()
+	if err != nil {
+		return nil, err
+	}
+	path := "/" + url.PathEscape(index) + "/imdb/_search"
+	params := url.Values{}
+	if utils.ElasticTypeless {
+		path = "/" + url.PathEscape(index) + "/_search"
+		// hits.total is an object since elasticsearch 7
+		params.Set("rest_total_hits_as_int", "true")
+	}
+	response, err := utils.Elasticconn.PerformRequest(ctx, "POST", path, params, body)
+	if err != nil {
+		return nil, err
+	}
+	result := &elastic.SearchResult{}
+	err = json.Unmarshal(response.Body, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// getMovieMapping returns the mapping of the movie documents of an index, along with the name of the concrete index
+func getMovieMapping(ctx context.Context, index string) (string, map[string]interface{}, error) {
+	response, err := utils.Elasticconn.PerformRequest(ctx, "GET", "/"+url.PathEscape(index)+"/_mapping", nil, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	indices := map[string]struct {
+		Mappings map[string]interface{} `json:"mappings"`
+	}{}
+	err = json.Unmarshal(response.Body, &indices)
+	if err != nil {
+		return "", nil, err
+	}
+	for name, concrete := range indices {
+		if utils.ElasticTypeless {
+			return name, concrete.Mappings, nil
+		}
+		mapping, _ := concrete.Mappings["imdb"].(map[string]interface{})
+		return name, mapping, nil
+	}
+	return "", map[string]interface{}{}, nil
+}
+
+// putMovieMapping adds the fields of mapping to the movie documents of an index
+func putMovieMapping(ctx context.Context, index string, mapping map[string]interface{}) error {
+	path := "/" + url.PathEscape(index) + "/_mapping/imdb"
+	if utils.ElasticTypeless {
+		path = "/" + url.PathEscape(index) + "/_mapping"
+	}
+	_, err := utils.Elasticconn.PerformRequest(ctx, "PUT", path, nil, mapping)
+	return err
+}
+
+// movieMappings is the mappings section used to create a movie index
+func movieMappings() map[string]interface{} {
+	if utils.ElasticTypeless {
+		return movieMapping()
+	}
+	return map[string]interface{}{
+		"imdb": movieMapping(),
+	}
+}
+
+// indexAliases returns the aliases of the indices matching name, keyed by concrete index
+func indexAliases(ctx context.Context, name string) (map[string][]string, error) {
+	response, err := utils.Elasticconn.PerformRequest(ctx, "GET", "/"+url.PathEscape(name)+"/_alias", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	indices := map[string]struct {
+		Aliases map[string]interface{} `json:"aliases"`
+	}{}
+	err = json.Unmarshal(response.Body, &indices)
+	if err != nil {
+		return nil, err
+	}
+	aliases := map[string][]string{}
+	for index, concrete := range indices {
+		aliases[index] = []string{}
+		for alias := range concrete.Aliases {
+			aliases[index] = append(aliases[index], alias)
+		}
+	}
+	return aliases, nil
+}
+
+// PainlessScript returns an inline painless script, written with the source key on clusters that no longer
+// accept the inline key used by the client
+func PainlessScript(code string) *elastic.Script {
+	script := elastic.NewScript(code).Lang("painless")
+	if utils.ElasticTypeless {
+		script.Type("source")
+	}
+	return script
+}
@@ -0,0 +1,480 @@
+package dbConnections
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/raazcrzy/imdb/utils"
+)
+
+/*
+The service owns the settings of the movie index. Text fields are indexed with the movie_text analyzer,
+which normalizes punctuation and splits hyphenated words while keeping the joined form, so "Sci-Fi"
+is found by "sci-fi", "sci fi" and "scifi". They are searched with movie_text_search, which also
+expands the managed synonyms, so that "Science Fiction" finds "Sci-Fi" as well.
+
+The synonym rules are kept in the imdb.synonyms table. On clusters with the _reload_search_analyzers API,
+Elasticsearch 7.3 and later, the synonym filter is updateable and reads them from the file at SynonymsPath in the
+config directory of the nodes, which the service writes at SynonymsFile: changing them rewrites the file and reloads
+the search analyzer, the index stays open. Other clusters, and indices created before, have the rules inline in the
+index settings, which cannot change on an open index, so changing them reindexes into a new index and moves the alias.
+*/
+
+// maxSynonymRules caps the number of synonym rules
+const maxSynonymRules = 5000
+
+// synonymsLockID is the advisory lock serializing the synonym updates, so that the file matches the table
+const synonymsLockID = 727170218
+
+// movieAnalysis returns the analysis settings of the movie index for the given synonym rules, read from the synonyms
+// file instead when updateable
+func movieAnalysis(synonyms []string, updateable bool) map[string]interface{} {
+	// synonym_graph refuses an empty rule list, a rule mapping a word to itself is a no-op
+	if len(synonyms) == 0 {
+		synonyms = []string{"movie => movie"}
+	}
+	filter := map[string]interface{}{
+		"type":     "synonym_graph",
+		"synonyms": synonyms,
+	}
+	if updateable {
+		filter = map[string]interface{}{
+			"type":          "synonym_graph",
+			"synonyms_path": utils.SynonymsPath,
+			"updateable":    true,
+		}
+	}
+	return map[string]interface{}{
+		"char_filter": map[string]interface{}{
+			"movie_punctuation": map[string]interface{}{
+				"type":        "pattern_replace",
+				"pattern":     `[\p{Punct}&&[^\-'&]]+`,
+				"replacement": " ",
+			},
+		},
+		"filter": map[string]interface{}{
+			"movie_word_delimiter": map[string]interface{}{
+				"type":              "word_delimiter",
+				"catenate_words":    true,
+				"preserve_original": true,
+			},
+			"movie_synonyms": filter,
+		},
+		"analyzer": map[string]interface{}{
+			"movie_text": map[string]interface{}{
+				"type":        "custom",
+				"char_filter": []string{"movie_punctuation"},
+				"tokenizer":   "whitespace",
+				"filter":      []string{"movie_word_delimiter", "lowercase", "asciifolding"},
+			},
+			"movie_text_search": map[string]interface{}{
+				"type":        "custom",
+				"char_filter": []string{"movie_punctuation"},
+				"tokenizer":   "whitespace",
+				"filter":      []string{"lowercase", "asciifolding", "movie_synonyms"},
+			},
+		},
+	}
+}
+
+// movieTextField is the mapping of a text field searched with the movie analyzers
+func movieTextField() map[string]interface{} {
+	return map[string]interface{}{
+		"type":            "text",
+		"analyzer":        "movie_text",
+		"search_analyzer": "movie_text_search",
+		"fields": map[string]interface{}{
+			"keyword": map[string]interface{}{
+				"type":         "keyword",
+				"ignore_above": 256,
+			},
+		},
+	}
+}
+
+// MovieMappingVersion is the version of movieMapping, bump it on every change to the mapping.
+// Version 2 added movie_id, a copy of the document id that searches are sorted on last, as _uid is gone since elasticsearch 7.
+const MovieMappingVersion = 2
+
+// movieMapping is the mapping of models.Movie in the movie index.
+// It is strict so that a typo in a field name is rejected instead of silently adding a field.
+func movieMapping() map[string]interface{} {
+	return map[string]interface{}{
+		"dynamic": "strict",
+		"_meta": map[string]interface{}{
+			"mapping_version": MovieMappingVersion,
+		},
+		"properties": map[string]interface{}{
+			"movie_id": map[string]interface{}{
+				"type": "keyword",
+			},
+			"name":     movieTextField(),
+			"director": movieTextField(),
+			"genre":    movieTextField(),
+			"99popularity": map[string]interface{}{
+				"type": "float",
+			},
+			"imdb_score": map[string]interface{}{
+				"type": "float",
+			},
+		},
+	}
+}
+
+// EnsureMovieIndex creates the movie index with the service's settings and mapping, along with the MovieIndex alias
+// pointing to it, when it does not exist yet. When it exists, the live mapping is checked against movieMapping:
+// missing fields are added, while fields mapped differently make the service refuse to start, as they can only
+// be fixed by reindexing.
+func EnsureMovieIndex() {
+	ctx := context.Background()
+	exists, err := utils.Elasticconn.IndexExists(utils.MovieIndex).Do(ctx)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if !exists {
+		body, err := movieIndexBody()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		body["aliases"] = map[string]interface{}{
+			utils.MovieIndex: map[string]interface{}{},
+		}
+		index := newMovieIndexName()
+		_, err = utils.Elasticconn.CreateIndex(index).BodyJson(body).Do(ctx)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		log.Println("created elasticsearch index", index, "with mapping version", MovieMappingVersion, "behind alias", utils.MovieIndex)
+		return
+	}
+
+	_, live, err := getMovieMapping(ctx, utils.MovieIndex)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	version, properties := liveMovieMapping(live)
+	if version > MovieMappingVersion {
+		log.Fatalf("index %s has mapping version %d, newer than the version %d of this service", utils.MovieIndex, version, MovieMappingVersion)
+	}
+	conflicts := mappingConflicts(movieMapping()["properties"].(map[string]interface{}), properties, "")
+	if len(conflicts) > 0 {
+		log.Fatalf("index %s has a mapping incompatible with version %d, run `app reindex` to copy it into a new index:\n%s", utils.MovieIndex, MovieMappingVersion, strings.Join(conflicts, "\n"))
+	}
+	err = putMovieMapping(ctx, utils.MovieIndex, movieMapping())
+	if err != nil {
+		log.Fatalln("cannot update the mapping of index", utils.MovieIndex, err)
+	}
+	if version < 2 {
+		err = backfillMovieIDs(ctx)
+		if err != nil {
+			log.Fatalln("cannot fill movie_id in index", utils.MovieIndex, err)
+		}
+	}
+	if version < MovieMappingVersion {
+		log.Println("updated the mapping of index", utils.MovieIndex, "from version", version, "to", MovieMappingVersion)
+	}
+}
+
+// movieIndexBody returns the settings and mappings used to create a movie index. The synonyms file is written first
+// when the index reads it.
+func movieIndexBody() (map[string]interface{}, error) {
+	synonyms, err := ReadSynonyms()
+	if err != nil {
+		return nil, err
+	}
+	updateable := synonymsReloadable()
+	if updateable {
+		err = writeSynonyms(synonyms)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return map[string]interface{}{
+		"settings": map[string]interface{}{
+			"analysis": movieAnalysis(synonyms, updateable),
+		},
+		"mappings": movieMappings(),
+	}, nil
+}
+
+// liveMovieMapping extracts the mapping version and the field mappings from the mapping of the movie documents.
+// Indices created before the mapping was versioned have version 0.
+func liveMovieMapping(mapping map[string]interface{}) (int, map[string]interface{}) {
+	properties, _ := mapping["properties"].(map[string]interface{})
+	version := 0
+	if meta, ok := mapping["_meta"].(map[string]interface{}); ok {
+		if v, ok := meta["mapping_version"].(float64); ok {
+			version = int(v)
+		}
+	}
+	return version, properties
+}
+
+// backfillMovieIDs copies the document id into movie_id for the movies written before movie_id existed
+func backfillMovieIDs(ctx context.Context) error {
+	script, err := PainlessScript("ctx._source.movie_id = ctx._id").Source()
+	if err != nil {
+		return err
+	}
+	body := map[string]interface{}{
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must_not": map[string]interface{}{
+					"exists": map[string]interface{}{"field": "movie_id"},
+				},
+			},
+		},
+		"script": script,
+	}
+	params := url.Values{}
+	params.Set("conflicts", "proceed")
+	params.Set("refresh", "true")
+	_, err = utils.Elasticconn.PerformRequest(ctx, "POST", "/"+url.PathEscape(utils.MovieIndex)+"/_update_by_query", params, body)
+	return err
+}
+
+// mappingConflicts lists the fields whose live mapping cannot be turned into the wanted one without reindexing.
+// Fields missing from the live mapping are not conflicts, they are added by a put mapping.
+func mappingConflicts(want, live map[string]interface{}, prefix string) []string {
+	conflicts := []string{}
+	for field, wantField := range want {
+		liveField, ok := live[field].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		wantField := wantField.(map[string]interface{})
+		wantType, liveType := wantField["type"], liveField["type"]
+		if wantType != liveType {
+			conflicts = append(conflicts, fmt.Sprintf("%s%s is mapped as %v instead of %v", prefix, field, liveType, wantType))
+			continue
+		}
+		if wantType == "text" {
+			liveAnalyzer, ok := liveField["analyzer"]
+			if !ok {
+				liveAnalyzer = "standard"
+			}
+			if wantField["analyzer"] != liveAnalyzer {
+				conflicts = append(conflicts, fmt.Sprintf("%s%s is analyzed with %v instead of %v", prefix, field, liveAnalyzer, wantField["analyzer"]))
+			}
+		}
+		if wantFields, ok := wantField["fields"].(map[string]interface{}); ok {
+			liveFields, _ := liveField["fields"].(map[string]interface{})
+			conflicts = append(conflicts, mappingConflicts(wantFields, liveFields, prefix+field+".")...)
+		}
+	}
+	return conflicts
+}
+
+// ReadSynonyms returns the managed synonym rules. Until they are first updated, they are read from the synonyms file,
+// in the Solr synonyms format, a missing file meaning there are none.
+func ReadSynonyms() ([]string, error) {
+	synonyms := []string{}
+	err := utils.PgDB.QueryRow(`SELECT rules FROM imdb.synonyms`).Scan(pq.Array(&synonyms))
+	if err == sql.ErrNoRows {
+		return readSynonymsFile()
+	}
+	return synonyms, err
+}
+
+// readSynonymsFile reads the rules of the synonyms file, skipping blank lines and comments
+func readSynonymsFile() ([]string, error) {
+	file, err := os.Open(utils.SynonymsFile)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	synonyms := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		synonyms = append(synonyms, line)
+	}
+	return synonyms, scanner.Err()
+}
+
+// ValidateSynonyms checks that every rule is either a comma separated list of equivalent terms or an explicit a, b => c mapping
+func ValidateSynonyms(synonyms []string) error {
+	if len(synonyms) > maxSynonymRules {
+		return fmt.Errorf("at most %d synonym rules are allowed", maxSynonymRules)
+	}
+	for i, rule := range synonyms {
+		if strings.ContainsAny(rule, "\n\r#") {
+			return fmt.Errorf("synonym rule %d must not contain line breaks or #", i+1)
+		}
+		sides := strings.Split(rule, "=>")
+		if len(sides) > 2 {
+			return fmt.Errorf("synonym rule %d has more than one =>", i+1)
+		}
+		for _, side := range sides {
+			for _, term := range strings.Split(side, ",") {
+				if strings.TrimSpace(term) == "" {
+					return fmt.Errorf("synonym rule %d has an empty term", i+1)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// UpdateSynonyms saves the synonym rules and applies them to the movie index. When the index reads them from the
+// synonyms file, the file is rewritten and the search analyzers reloaded before the rules are committed, and put back
+// when either fails. Otherwise the rules are part of the index settings and it reports that a reindex is needed,
+// they apply once a reindex started after the save moved the alias.
+func UpdateSynonyms(synonyms []string) (reindex bool, err error) {
+	ctx := context.Background()
+	updateable, err := synonymsUpdateable(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := utils.PgDB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`SELECT pg_advisory_xact_lock($1)`, synonymsLockID)
+	if err != nil {
+		return false, err
+	}
+	previous, err := ReadSynonyms()
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.Exec(`INSERT INTO imdb.synonyms(id, rules, updated_at) VALUES(true, $1, $2)
+	ON CONFLICT (id) DO UPDATE SET rules=EXCLUDED.rules, updated_at=EXCLUDED.updated_at`, pq.Array(synonyms), time.Now().Unix())
+	if err != nil {
+		return false, err
+	}
+	if !updateable {
+		return true, tx.Commit()
+	}
+	err = applySynonyms(ctx, synonyms)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		// the rules are not saved, the index goes back to the saved ones
+		restoreErr := applySynonyms(ctx, previous)
+		if restoreErr != nil {
+			log.Println("cannot restore the saved synonyms of the movie index:", restoreErr)
+		}
+		return false, err
+	}
+	return false, nil
+}
+
+// applySynonyms rewrites the synonyms file and reloads the search analyzers of the movie index
+func applySynonyms(ctx context.Context, synonyms []string) error {
+	err := writeSynonyms(synonyms)
+	if err != nil {
+		return err
+	}
+	return reloadSearchAnalyzers(ctx)
+}
+
+// synonymsReloadable reports whether the cluster can reload search analyzers, which elasticsearch can since 7.3
+func synonymsReloadable() bool {
+	if utils.ElasticDistribution != "elasticsearch" {
+		return false
+	}
+	parts := strings.SplitN(utils.ElasticVersion, ".", 3)
+	major, _ := strconv.Atoi(parts[0])
+	minor := 0
+	if len(parts) > 1 {
+		minor, _ = strconv.Atoi(parts[1])
+	}
+	return major > 7 || major == 7 && minor >= 3
+}
+
+// synonymsUpdateable reports whether the live movie index reads the synonyms from the synonyms file with an
+// updateable filter
+func synonymsUpdateable(ctx context.Context) (bool, error) {
+	if !synonymsReloadable() {
+		return false, nil
+	}
+	index, _, err := resolveMovieIndex(ctx)
+	if err != nil {
+		return false, err
+	}
+	settings, err := utils.Elasticconn.IndexGetSettings(index).Do(ctx)
+	if err != nil {
+		return false, err
+	}
+	indexSettings, ok := settings[index]
+	if !ok {
+		return false, fmt.Errorf("index %s has no settings", index)
+	}
+	filter := nestedSetting(indexSettings.Settings, "index", "analysis", "filter", "movie_synonyms")
+	return filter != nil && fmt.Sprint(filter["updateable"]) == "true", nil
+}
+
+// nestedSetting returns the object at the given path of index settings, nil when there is none
+func nestedSetting(settings map[string]interface{}, path ...string) map[string]interface{} {
+	for _, key := range path {
+		next, ok := settings[key].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		settings = next
+	}
+	return settings
+}
+
+// reloadSearchAnalyzers makes every node reload the synonyms file in the search analyzer of the movie index
+func reloadSearchAnalyzers(ctx context.Context) error {
+	response, err := utils.Elasticconn.PerformRequest(ctx, "POST", "/"+url.PathEscape(utils.MovieIndex)+"/_reload_search_analyzers", nil, nil)
+	if err != nil {
+		return err
+	}
+	var reload struct {
+		Shards struct {
+			Failed   int               `json:"failed"`
+			Failures []json.RawMessage `json:"failures"`
+		} `json:"_shards"`
+	}
+	err = json.Unmarshal(response.Body, &reload)
+	if err != nil {
+		return err
+	}
+	if reload.Shards.Failed > 0 {
+		return fmt.Errorf("the search analyzers of %d shards could not be reloaded: %s", reload.Shards.Failed, reload.Shards.Failures)
+	}
+	return nil
+}
+
+// writeSynonyms replaces the synonyms file, going through a temporary file so that readers never see a partial file
+func writeSynonyms(synonyms []string) error {
+	content := "# Managed by the imdb service, edit through PUT /v1/update/synonyms\n" + strings.Join(synonyms, "\n") + "\n"
+	tmp, err := ioutil.TempFile(filepath.Dir(utils.SynonymsFile), ".synonyms")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.WriteString(content)
+	if err == nil {
+		err = tmp.Close()
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), utils.SynonymsFile)
+}
@@ -0,0 +1,211 @@
+package dbConnections
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/raazcrzy/imdb/models"
+	"github.com/raazcrzy/imdb/utils"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+/*
+Movies searched in Elasticsearch are also kept in the imdb.movies table, which is the reference for them and which
+other tables can point to. A write changes the row and records an event in imdb.movie_outbox in the same transaction,
+then the outbox worker applies the event to the movie index, retrying it until it succeeds.
+
+Every write bumps the version of the movie, and documents are written with it as their external version. Applying
+an event twice, or after a later one, leaves the document at the latest version, so events are retried freely and
+several workers, one per running server, can share the outbox.
+*/
+
+// Outbox event operations
+const (
+	OutboxUpsert = "upsert"
+	OutboxDelete = "delete"
+)
+
+const (
+	outboxBatch = 100
+	outboxPoll  = time.Second
+	// claimed events are claimed again after outboxLease, in case their worker died while applying them
+	outboxLease      = time.Minute
+	outboxMaxBackoff = 10 * time.Minute
+	// delivered events are kept for outboxRetention, the repair command looks for deletes among them
+	outboxRetention = 7 * 24 * time.Hour
+)
+
+// outboxWake lets writes start a delivery right away instead of waiting for the next poll
+var outboxWake = make(chan struct{}, 1)
+
+type outboxEvent struct {
+	ID        int64
+	MovieID   string
+	Version   int64
+	Operation string
+	Document  json.RawMessage
+	Attempts  int
+}
+
+// EnqueueMovieEvent records within tx that a movie was written at version, movie is nil for a delete
+func EnqueueMovieEvent(tx *sql.Tx, movieID string, version int64, movie *models.Movie) error {
+	operation, document := OutboxDelete, sql.NullString{}
+	if movie != nil {
+		data, err := json.Marshal(movie)
+		if err != nil {
+			return err
+		}
+		operation, document = OutboxUpsert, sql.NullString{String: string(data), Valid: true}
+	}
+	now := time.Now().Unix()
+	_, err := tx.Exec(`INSERT INTO imdb.movie_outbox(movie_id, version, operation, document, next_attempt_at, created_at) VALUES($1, $2, $3, $4, $5, $5)`,
+		movieID, version, operation, document, now)
+	return err
+}
+
+// WakeOutbox asks the outbox worker of the process to deliver the pending events now
+func WakeOutbox() {
+	select {
+	case outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// RunOutboxWorker delivers the outbox events as they are recorded, it never returns
+func RunOutboxWorker() {
+	ticker := time.NewTicker(outboxPoll)
+	defer ticker.Stop()
+	var pruned time.Time
+	for {
+		for {
+			claimed, err := DeliverOutbox()
+			if err != nil {
+				log.Println("outbox delivery failed:", err)
+				break
+			}
+			if claimed < outboxBatch {
+				break
+			}
+		}
+		if time.Since(pruned) > time.Hour {
+			_, err := utils.PgDB.Exec(`DELETE FROM imdb.movie_outbox WHERE delivered_at < $1`, time.Now().Add(-outboxRetention).Unix())
+			if err != nil {
+				log.Println("cannot prune the outbox:", err)
+			}
+			pruned = time.Now()
+		}
+		select {
+		case <-ticker.C:
+		case <-outboxWake:
+		}
+	}
+}
+
+// DeliverOutbox claims a batch of due events and applies them to the movie index, it returns the number of claimed events.
+// Only the latest claimed event of a movie is applied, the earlier ones are delivered along with it.
+func DeliverOutbox() (int, error) {
+	now := time.Now().Unix()
+	rows, err := utils.PgDB.Query(`UPDATE imdb.movie_outbox SET attempts=attempts+1, next_attempt_at=$1 WHERE id IN (
+		SELECT id FROM imdb.movie_outbox WHERE delivered_at IS NULL AND next_attempt_at <= $2 ORDER BY id LIMIT $3 FOR UPDATE SKIP LOCKED
+	) RETURNING id, movie_id, version, operation, document, attempts`, now+int64(outboxLease.Seconds()), now, outboxBatch)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	claimed := 0
+	latest := map[string]outboxEvent{}
+	for rows.Next() {
+		event := outboxEvent{}
+		var document []byte
+		err = rows.Scan(&event.ID, &event.MovieID, &event.Version, &event.Operation, &document, &event.Attempts)
+		if err != nil {
+			return claimed, err
+		}
+		event.Document = document
+		claimed++
+		if event.Version > latest[event.MovieID].Version {
+			latest[event.MovieID] = event
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return claimed, err
+	}
+
+	for _, event := range latest {
+		err = applyMovieEvent(context.Background(), event)
+		if err != nil {
+			log.Println("outbox event", event.ID, "of movie", event.MovieID, "failed, attempt", event.Attempts, ":", err)
+			_, err = utils.PgDB.Exec(`UPDATE imdb.movie_outbox SET last_error=$1, next_attempt_at=$2 WHERE movie_id=$3 AND version<=$4 AND delivered_at IS NULL`,
+				err.Error(), time.Now().Add(outboxBackoff(event.Attempts)).Unix(), event.MovieID, event.Version)
+		} else {
+			_, err = utils.PgDB.Exec(`UPDATE imdb.movie_outbox SET delivered_at=$1, last_error=NULL WHERE movie_id=$2 AND version<=$3 AND delivered_at IS NULL`,
+				time.Now().Unix(), event.MovieID, event.Version)
+		}
+		if err != nil {
+			return claimed, err
+		}
+	}
+	return claimed, nil
+}
+
+// PendingOutboxEvents counts the events not delivered yet
+func PendingOutboxEvents() (int64, error) {
+	var pending int64
+	err := utils.PgDB.QueryRow(`SELECT count(*) FROM imdb.movie_outbox WHERE delivered_at IS NULL`).Scan(&pending)
+	return pending, err
+}
+
+// outboxBackoff is the delay before the next attempt of an event, doubling from one second up to outboxMaxBackoff
+func outboxBackoff(attempts int) time.Duration {
+	backoff := time.Second
+	for i := 1; i < attempts && backoff < outboxMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > outboxMaxBackoff {
+		return outboxMaxBackoff
+	}
+	return backoff
+}
+
+// applyMovieEvent writes an event to the movie index, and to the target index of the running reindex if any
+func applyMovieEvent(ctx context.Context, event outboxEvent) error {
+	err := writeMovieEvent(ctx, utils.MovieIndex, event)
+	if err != nil {
+		return err
+	}
+	job, err := ActiveReindex()
+	if err != nil {
+		return err
+	}
+	if job == nil {
+		return nil
+	}
+	if event.Operation == OutboxDelete {
+		err = RecordReindexDelete(job.ID, event.MovieID)
+		if err != nil {
+			return err
+		}
+	}
+	// the event is retried until the target has it too, so the job does not have to fail
+	return writeMovieEvent(ctx, job.TargetIndex, event)
+}
+
+// writeMovieEvent writes an event to an index, an index already holding the event or a later one is left as is
+func writeMovieEvent(ctx context.Context, index string, event outboxEvent) error {
+	var err error
+	if event.Operation == OutboxDelete {
+		err = DeleteDocument(ctx, index, event.MovieID, event.Version)
+		if elastic.IsNotFound(err) {
+			return nil
+		}
+	} else {
+		err = IndexDocument(ctx, index, event.MovieID, event.Version, event.Document)
+	}
+	if elastic.IsConflict(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,367 @@
+package dbConnections
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/raazcrzy/imdb/models"
+	"github.com/raazcrzy/imdb/utils"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+/*
+The service reads and writes movies through MovieIndex, which is an alias of a versioned index.
+A reindex copies the index behind the alias into a new index created with the current settings and mapping,
+then atomically moves the alias to it. Writes made while the copy runs go to both indices, so nothing is lost,
+and the old index is kept so that the alias can be moved back to it.
+
+Indices created before aliases were used are concrete indices named MovieIndex. An alias cannot share their name,
+so reindexing one first copies it into a versioned backup index, then deletes it and adds the alias to the new index
+in a single _aliases request. The backup becomes the source index of the job, which the alias can be moved back to.
+Movies written while the backup is copied may be missing from it.
+*/
+
+// Reindex job statuses
+const (
+	ReindexRunning    = "running"
+	ReindexDone       = "done"
+	ReindexFailed     = "failed"
+	ReindexRolledBack = "rolled_back"
+)
+
+// Errors returned for the reindex requests that cannot be fulfilled
+var (
+	ErrReindexRunning     = errors.New("a reindex is already running")
+	ErrReindexJobNotFound = errors.New("reindex job not found")
+	ErrRollbackRefused    = errors.New("reindex job cannot be rolled back")
+)
+
+// reindexHeartbeat is how often a running job records that it is alive,
+// a job that has not done so for reindexStaleAfter is considered abandoned, for example after a crash
+const (
+	reindexHeartbeat  = 30 * time.Second
+	reindexStaleAfter = 5 * time.Minute
+)
+
+// newMovieIndexName returns the name of a new concrete index for the movie alias
+func newMovieIndexName() string {
+	return fmt.Sprintf("%s_v%d_%d", utils.MovieIndex, MovieMappingVersion, time.Now().Unix())
+}
+
+// resolveMovieIndex returns the concrete index MovieIndex points to, legacy is true when MovieIndex is that index itself
+func resolveMovieIndex(ctx context.Context) (string, bool, error) {
+	aliases, err := indexAliases(ctx, utils.MovieIndex)
+	if err != nil {
+		return "", false, err
+	}
+	indices := []string{}
+	for index, names := range aliases {
+		for _, name := range names {
+			if name == utils.MovieIndex {
+				indices = append(indices, index)
+			}
+		}
+	}
+	if len(indices) == 1 {
+		return indices[0], false, nil
+	}
+	if len(indices) > 1 {
+		return "", false, fmt.Errorf("alias %s points to more than one index: %v", utils.MovieIndex, indices)
+	}
+	if _, ok := aliases[utils.MovieIndex]; ok {
+		return utils.MovieIndex, true, nil
+	}
+	return "", false, fmt.Errorf("index %s not found", utils.MovieIndex)
+}
+
+// StartReindex creates the target index of a new reindex job and records the job, from then on writes go to both indices.
+// The copy itself is done by RunReindex.
+func StartReindex() (models.ReindexJob, error) {
+	ctx := context.Background()
+	_, err := utils.PgDB.Exec(`UPDATE imdb.reindex_jobs SET status=$1, error='abandoned', finished_at=$2 WHERE status=$3 AND updated_at < $4`,
+		ReindexFailed, time.Now().Unix(), ReindexRunning, time.Now().Add(-reindexStaleAfter).Unix())
+	if err != nil {
+		return models.ReindexJob{}, err
+	}
+	active, err := ActiveReindex()
+	if err != nil {
+		return models.ReindexJob{}, err
+	}
+	if active != nil {
+		return models.ReindexJob{}, ErrReindexRunning
+	}
+
+	source, _, err := resolveMovieIndex(ctx)
+	if err != nil {
+		return models.ReindexJob{}, err
+	}
+	job := models.ReindexJob{
+		SourceIndex: source,
+		TargetIndex: newMovieIndexName(),
+		Status:      ReindexRunning,
+		CreatedAt:   time.Now().Unix(),
+		UpdatedAt:   time.Now().Unix(),
+	}
+	body, err := movieIndexBody()
+	if err != nil {
+		return models.ReindexJob{}, err
+	}
+	_, err = utils.Elasticconn.CreateIndex(job.TargetIndex).BodyJson(body).Do(ctx)
+	if err != nil {
+		return models.ReindexJob{}, err
+	}
+	err = utils.PgDB.QueryRow(`INSERT INTO imdb.reindex_jobs(source_index, target_index, status, copied, created_at, updated_at) VALUES($1, $2, $3, 0, $4, $5) RETURNING id`,
+		job.SourceIndex, job.TargetIndex, job.Status, job.CreatedAt, job.UpdatedAt).Scan(&job.ID)
+	if err != nil {
+		utils.Elasticconn.DeleteIndex(job.TargetIndex).Do(ctx)
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return models.ReindexJob{}, ErrReindexRunning
+		}
+		return models.ReindexJob{}, err
+	}
+	return job, nil
+}
+
+// RunReindex copies the source index of a started job into its target and moves the alias to the target.
+// On failure before the alias moved, the job is marked as failed and the target index is deleted, the alias is left
+// untouched. Once the alias moved the target is live and is never deleted.
+func RunReindex(job models.ReindexJob) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(reindexHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				utils.PgDB.Exec(`UPDATE imdb.reindex_jobs SET updated_at=$1 WHERE id=$2 AND status=$3`, time.Now().Unix(), job.ID, ReindexRunning)
+			}
+		}
+	}()
+
+	swapped, err := copyMovieIndex(job)
+	if err != nil && swapped {
+		log.Println("reindex", job.ID, "moved", utils.MovieIndex, "to", job.TargetIndex, "but could not be marked as done:", err)
+		return err
+	}
+	if err != nil {
+		log.Println("reindex", job.ID, "failed:", err)
+		FailReindex(job.ID, err)
+		utils.Elasticconn.DeleteIndex(job.TargetIndex).Do(context.Background())
+		return err
+	}
+	log.Println("reindex", job.ID, "moved", utils.MovieIndex, "from", job.SourceIndex, "to", job.TargetIndex)
+	return nil
+}
+
+// removeIndexAction is the remove_index action of an _aliases request, which deletes an index along with the
+// other actions of the request
+type removeIndexAction string
+
+func (index removeIndexAction) Source() (interface{}, error) {
+	return map[string]interface{}{"remove_index": map[string]interface{}{"index": string(index)}}, nil
+}
+
+// copyMovieIndex copies the source index of a job into its target and moves the alias to the target, swapped tells
+// whether the alias was moved, in which case the target must be kept whatever the error
+func copyMovieIndex(job models.ReindexJob) (swapped bool, err error) {
+	ctx := context.Background()
+	// movie_id is the copy of the document id searches are sorted on, documents written before it existed lack it
+	script := PainlessScript("ctx._source.movie_id = ctx._id")
+	response, err := utils.Elasticconn.Reindex().
+		Source(elastic.NewReindexSource().Index(job.SourceIndex)).
+		// documents keep their version, so a copy never replaces a later version written to the target meanwhile
+		Destination(elastic.NewReindexDestination().Index(job.TargetIndex).VersionType("external")).
+		Script(script).
+		ProceedOnVersionConflict().
+		WaitForCompletion(true).
+		Do(ctx)
+	if err != nil {
+		return false, err
+	}
+	if len(response.Failures) > 0 {
+		return false, fmt.Errorf("%d documents could not be copied, first failure: %v", len(response.Failures), response.Failures[0])
+	}
+	_, err = utils.PgDB.Exec(`UPDATE imdb.reindex_jobs SET copied=$1, updated_at=$2 WHERE id=$3`, response.Created, time.Now().Unix(), job.ID)
+	if err != nil {
+		return false, err
+	}
+
+	// a movie deleted while the copy ran may have been copied after being deleted from the target
+	rows, err := utils.PgDB.Query(`SELECT movie_id FROM imdb.reindex_deletes WHERE job_id=$1`, job.ID)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var movieID string
+		err = rows.Scan(&movieID)
+		if err != nil {
+			return false, err
+		}
+		err = DeleteDocument(ctx, job.TargetIndex, movieID, 0)
+		if err != nil && !elastic.IsNotFound(err) {
+			return false, err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return false, err
+	}
+	_, err = utils.Elasticconn.Refresh(job.TargetIndex).Do(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	// a dual write may have failed the job in the meantime, in which case the target is incomplete
+	current, err := GetReindexJob(job.ID)
+	if err != nil {
+		return false, err
+	}
+	if current == nil || current.Status != ReindexRunning {
+		return false, fmt.Errorf("job is no longer running")
+	}
+	rollbackIndex := job.SourceIndex
+	aliases := utils.Elasticconn.Alias()
+	if job.SourceIndex == utils.MovieIndex {
+		rollbackIndex, err = backupLegacyIndex(ctx, job.SourceIndex)
+		if err != nil {
+			return false, err
+		}
+		aliases = aliases.Action(removeIndexAction(job.SourceIndex), elastic.NewAliasAddAction(utils.MovieIndex).Index(job.TargetIndex))
+	} else {
+		aliases = aliases.Remove(job.SourceIndex, utils.MovieIndex).Add(job.TargetIndex, utils.MovieIndex)
+	}
+	_, err = aliases.Do(ctx)
+	if err != nil {
+		if rollbackIndex != job.SourceIndex {
+			utils.Elasticconn.DeleteIndex(rollbackIndex).Do(ctx)
+		}
+		return false, err
+	}
+	_, err = utils.PgDB.Exec(`UPDATE imdb.reindex_jobs SET status=$1, source_index=$2, updated_at=$3, finished_at=$3 WHERE id=$4`,
+		ReindexDone, rollbackIndex, time.Now().Unix(), job.ID)
+	return true, err
+}
+
+// backupLegacyIndex copies a legacy index into a new versioned index, which the alias can be moved back to once the
+// legacy index is deleted, and returns its name
+func backupLegacyIndex(ctx context.Context, legacy string) (string, error) {
+	backup := fmt.Sprintf("%s_legacy_%d", legacy, time.Now().Unix())
+	// the backup gets the analysis settings and the mapping of the legacy index, so that rolling back restores them
+	mapping, err := utils.Elasticconn.GetMapping().Index(legacy).Do(ctx)
+	if err != nil {
+		return "", err
+	}
+	settings, err := utils.Elasticconn.IndexGetSettings(legacy).Do(ctx)
+	if err != nil {
+		return "", err
+	}
+	body := map[string]interface{}{}
+	if indexMapping, ok := mapping[legacy].(map[string]interface{}); ok {
+		body["mappings"] = indexMapping["mappings"]
+	}
+	if indexSettings, ok := settings[legacy]; ok {
+		if index, ok := indexSettings.Settings["index"].(map[string]interface{}); ok {
+			body["settings"] = map[string]interface{}{"analysis": index["analysis"], "number_of_shards": index["number_of_shards"]}
+		}
+	}
+	_, err = utils.Elasticconn.CreateIndex(backup).BodyJson(body).Do(ctx)
+	if err != nil {
+		return "", err
+	}
+	response, err := utils.Elasticconn.Reindex().
+		Source(elastic.NewReindexSource().Index(legacy)).
+		Destination(elastic.NewReindexDestination().Index(backup).VersionType("external")).
+		WaitForCompletion(true).
+		Do(ctx)
+	if err == nil && len(response.Failures) > 0 {
+		err = fmt.Errorf("%d documents could not be backed up, first failure: %v", len(response.Failures), response.Failures[0])
+	}
+	if err != nil {
+		utils.Elasticconn.DeleteIndex(backup).Do(ctx)
+		return "", err
+	}
+	return backup, nil
+}
+
+// ActiveReindex returns the running reindex job, or nil when there is none
+func ActiveReindex() (*models.ReindexJob, error) {
+	return queryReindexJob(`WHERE status=$1`, ReindexRunning)
+}
+
+// GetReindexJob returns the reindex job with the given id, or nil when there is none
+func GetReindexJob(id int64) (*models.ReindexJob, error) {
+	return queryReindexJob(`WHERE id=$1`, id)
+}
+
+func queryReindexJob(where string, arg interface{}) (*models.ReindexJob, error) {
+	row := utils.PgDB.QueryRow(`SELECT id, source_index, target_index, status, copied, error, created_at, updated_at, finished_at FROM imdb.reindex_jobs `+where, arg)
+
+	job := models.ReindexJob{}
+	var jobError sql.NullString
+	var finishedAt sql.NullInt64
+	err := row.Scan(&job.ID, &job.SourceIndex, &job.TargetIndex, &job.Status, &job.Copied, &jobError, &job.CreatedAt, &job.UpdatedAt, &finishedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	job.Error = jobError.String
+	job.FinishedAt = finishedAt.Int64
+	return &job, nil
+}
+
+// FailReindex marks a running job as failed, its alias swap will not happen
+func FailReindex(id int64, cause error) {
+	_, err := utils.PgDB.Exec(`UPDATE imdb.reindex_jobs SET status=$1, error=$2, updated_at=$3, finished_at=$3 WHERE id=$4 AND status=$5`,
+		ReindexFailed, cause.Error(), time.Now().Unix(), id, ReindexRunning)
+	if err != nil {
+		log.Println("cannot mark reindex", id, "as failed:", err)
+	}
+}
+
+// RecordReindexDelete remembers a movie deleted while a job runs, so that it is deleted again once the copy is over
+func RecordReindexDelete(id int64, movieID string) error {
+	_, err := utils.PgDB.Exec(`INSERT INTO imdb.reindex_deletes(job_id, movie_id) VALUES($1, $2) ON CONFLICT DO NOTHING`, id, movieID)
+	return err
+}
+
+// RollbackReindex moves the alias of a finished job back to its source index.
+// Movies written since the job finished are only present in the target index.
+func RollbackReindex(id int64) error {
+	job, err := GetReindexJob(id)
+	if err != nil {
+		return err
+	}
+	if job == nil {
+		return fmt.Errorf("%w: %d", ErrReindexJobNotFound, id)
+	}
+	if job.Status != ReindexDone {
+		return fmt.Errorf("%w: only done jobs can be, job %d is %s", ErrRollbackRefused, id, job.Status)
+	}
+	if job.SourceIndex == utils.MovieIndex {
+		return fmt.Errorf("%w: job %d replaced the legacy index %s without a backup", ErrRollbackRefused, id, job.SourceIndex)
+	}
+	ctx := context.Background()
+	current, _, err := resolveMovieIndex(ctx)
+	if err != nil {
+		return err
+	}
+	if current != job.TargetIndex {
+		return fmt.Errorf("%w: %s no longer points to %s", ErrRollbackRefused, utils.MovieIndex, job.TargetIndex)
+	}
+	_, err = utils.Elasticconn.Alias().Remove(job.TargetIndex, utils.MovieIndex).Add(job.SourceIndex, utils.MovieIndex).Do(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = utils.PgDB.Exec(`UPDATE imdb.reindex_jobs SET status=$1, updated_at=$2 WHERE id=$3`, ReindexRolledBack, time.Now().Unix(), id)
+	return err
+}
@@ -0,0 +1,243 @@
+package dbConnections
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/raazcrzy/imdb/utils"
+)
+
+/*
+Recording a movie event in imdb.movie_events queues a delivery of it in imdb.webhook_deliveries for every webhook
+subscribed to its type, in the same transaction. The webhook worker posts the due deliveries to their webhook,
+retrying failed ones with a growing backoff. A delivery failing webhookMaxAttempts times is moved to
+imdb.webhook_dead_letters, from where it can be queued again once the receiver is fixed.
+
+Each webhook receives its events in order: only the oldest delivery of a webhook is claimed, so a later one waits
+while an earlier one is posted or waits for its retry. A delivery moved to the dead letters no longer holds the later
+ones, and is posted after them when it is queued again.
+
+Deliveries are claimed for webhookLease, so several workers, one per running server, can share them. A worker
+dying after posting a delivery but before recording it makes it posted again, receivers tell duplicates by the
+X-IMDB-Delivery header.
+*/
+
+// Headers of the webhook requests
+const (
+	WebhookEventHeader     = "X-IMDB-Event"
+	WebhookDeliveryHeader  = "X-IMDB-Delivery"
+	WebhookTimestampHeader = "X-IMDB-Timestamp"
+	WebhookSignatureHeader = "X-IMDB-Signature"
+)
+
+const (
+	webhookBatch        = 100
+	webhookPoll         = time.Second
+	webhookLease        = time.Minute
+	webhookTimeout      = 10 * time.Second
+	webhookMaxAttempts  = 10
+	webhookFirstBackoff = 10 * time.Second
+	webhookMaxBackoff   = time.Hour
+	// events are kept for eventRetention, the /v1/events stream can resume from any of them
+	eventRetention = 7 * 24 * time.Hour
+)
+
+// webhookWake lets new events start a delivery right away instead of waiting for the next poll
+var webhookWake = make(chan struct{}, 1)
+
+// webhookClient posts the deliveries, redirects are not followed and count as failures
+var webhookClient = &http.Client{
+	Timeout: webhookTimeout,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+type webhookDelivery struct {
+	ID        int64
+	EventID   int64
+	Payload   []byte
+	Attempts  int
+	WebhookID int64
+	URL       string
+	Secret    string
+}
+
+// WakeWebhooks asks the webhook worker of the process to deliver the pending events now
+func WakeWebhooks() {
+	select {
+	case webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// RunWebhookWorker delivers the webhook deliveries as they are queued and prunes old events, it never returns
+func RunWebhookWorker() {
+	ticker := time.NewTicker(webhookPoll)
+	defer ticker.Stop()
+	var pruned time.Time
+	for {
+		for {
+			claimed, err := DeliverWebhooks()
+			if err != nil {
+				log.Println("webhook delivery failed:", err)
+				break
+			}
+			if claimed == 0 {
+				break
+			}
+		}
+		if time.Since(pruned) > time.Hour {
+			_, err := utils.PgDB.Exec(`DELETE FROM imdb.movie_events WHERE created_at < $1`, time.Now().Add(-eventRetention).Unix())
+			if err != nil {
+				log.Println("cannot prune the movie events:", err)
+			}
+			pruned = time.Now()
+		}
+		select {
+		case <-ticker.C:
+		case <-webhookWake:
+		}
+	}
+}
+
+// DeliverWebhooks claims the oldest delivery of each webhook, when it is due, and posts them at once, it returns the
+// number of claimed deliveries. A batch holds at most one delivery per webhook, so each webhook gets its events in
+// order.
+func DeliverWebhooks() (int, error) {
+	now := time.Now().Unix()
+	rows, err := utils.PgDB.Query(`UPDATE imdb.webhook_deliveries d SET attempts=d.attempts+1, next_attempt_at=$1 FROM imdb.webhooks w
+	WHERE w.id=d.webhook_id AND d.id IN (
+		SELECT id FROM imdb.webhook_deliveries p WHERE next_attempt_at <= $2 AND NOT EXISTS (
+			SELECT 1 FROM imdb.webhook_deliveries e WHERE e.webhook_id=p.webhook_id AND e.id < p.id
+		) ORDER BY id LIMIT $3 FOR UPDATE SKIP LOCKED
+	) RETURNING d.id, d.event_id, d.payload, d.attempts, w.id, w.url, w.secret`, now+int64(webhookLease.Seconds()), now, webhookBatch)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	deliveries := []webhookDelivery{}
+	for rows.Next() {
+		delivery := webhookDelivery{}
+		err = rows.Scan(&delivery.ID, &delivery.EventID, &delivery.Payload, &delivery.Attempts, &delivery.WebhookID, &delivery.URL, &delivery.Secret)
+		if err != nil {
+			return len(deliveries), err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if err = rows.Err(); err != nil {
+		return len(deliveries), err
+	}
+
+	errs := make([]error, len(deliveries))
+	wg := sync.WaitGroup{}
+	for i := range deliveries {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = postWebhook(deliveries[i])
+		}(i)
+	}
+	wg.Wait()
+	for i, delivery := range deliveries {
+		err = recordWebhookDelivery(delivery, errs[i])
+		if err != nil {
+			return len(deliveries), err
+		}
+	}
+	return len(deliveries), nil
+}
+
+// recordWebhookDelivery deletes a delivery that succeeded, and schedules the next attempt of one that failed or
+// moves it to the dead letters when it was the last
+func recordWebhookDelivery(delivery webhookDelivery, deliveryErr error) error {
+	if deliveryErr == nil {
+		_, err := utils.PgDB.Exec(`DELETE FROM imdb.webhook_deliveries WHERE id=$1`, delivery.ID)
+		return err
+	}
+	log.Println("delivery", delivery.ID, "of event", delivery.EventID, "to webhook", delivery.WebhookID, "failed, attempt", delivery.Attempts, ":", deliveryErr)
+	if delivery.Attempts < webhookMaxAttempts {
+		_, err := utils.PgDB.Exec(`UPDATE imdb.webhook_deliveries SET last_error=$1, next_attempt_at=$2 WHERE id=$3`,
+			deliveryErr.Error(), time.Now().Add(webhookBackoff(delivery.Attempts)).Unix(), delivery.ID)
+		return err
+	}
+	tx, err := utils.PgDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`INSERT INTO imdb.webhook_dead_letters(webhook_id, event_id, payload, attempts, last_error, failed_at)
+	SELECT webhook_id, event_id, payload, attempts, $1, $2 FROM imdb.webhook_deliveries WHERE id=$3`, deliveryErr.Error(), time.Now().Unix(), delivery.ID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM imdb.webhook_deliveries WHERE id=$1`, delivery.ID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// postWebhook posts the event of a delivery to its webhook, a response status other than 2xx is a failure
+func postWebhook(delivery webhookDelivery) error {
+	var event struct {
+		Type string `json:"type"`
+	}
+	err := json.Unmarshal(delivery.Payload, &event)
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	req, err := http.NewRequest("POST", delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "imdb-webhooks")
+	req.Header.Set(WebhookEventHeader, event.Type)
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(delivery.Secret, timestamp, delivery.Payload))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// SignWebhook returns the X-IMDB-Signature of a webhook request: sha256= followed by the hex encoded HMAC-SHA256,
+// keyed with the secret of the webhook, of the timestamp, a dot and the body
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff is the delay before the next attempt of a delivery, doubling from webhookFirstBackoff up to webhookMaxBackoff
+func webhookBackoff(attempts int) time.Duration {
+	backoff := webhookFirstBackoff
+	for i := 1; i < attempts && backoff < webhookMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > webhookMaxBackoff {
+		return webhookMaxBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,90 @@
+package dbConnections
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestSignWebhook checks the signature receivers verify against vectors computed independently, with
+// printf '%s' "$timestamp.$body" | openssl dgst -sha256 -hmac "$secret"
+func TestSignWebhook(t *testing.T) {
+	tests := []struct {
+		secret    string
+		timestamp int64
+		body      string
+		signature string
+	}{
+		{"whsec_test", 1700000000, `{"type":"movie.created","movie_id":"a"}`,
+			"sha256=9a63fd26933fcfae87f59db55aea4661b9d5a85d58e2c5c08a80ef46c63b32c3"},
+		{"", 0, "", "sha256=b849d5a581847b281957065739df36df2463d1977ea8d6e1e4e6cf33fadc68c3"},
+	}
+	for _, test := range tests {
+		if signature := SignWebhook(test.secret, test.timestamp, []byte(test.body)); signature != test.signature {
+			t.Errorf("%q at %d: got %s, want %s", test.body, test.timestamp, signature, test.signature)
+		}
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		backoff  time.Duration
+	}{
+		{0, 10 * time.Second},
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{9, 2560 * time.Second},
+		{10, time.Hour},
+		{11, time.Hour},
+		{1000, time.Hour},
+	}
+	for _, test := range tests {
+		if backoff := webhookBackoff(test.attempts); backoff != test.backoff {
+			t.Errorf("attempt %d: got %s, want %s", test.attempts, backoff, test.backoff)
+		}
+	}
+}
+
+// TestPostWebhook posts a delivery to a receiver checking its headers and signature, the way the README tells
+// receivers to
+func TestPostWebhook(t *testing.T) {
+	payload := `{"type":"movie.deleted","movie_id":"a"}`
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timestamp, err := strconv.ParseInt(r.Header.Get(WebhookTimestampHeader), 10, 64)
+		switch {
+		case err != nil || time.Since(time.Unix(timestamp, 0)) > time.Minute:
+			t.Errorf("got timestamp %q", r.Header.Get(WebhookTimestampHeader))
+		case r.Header.Get(WebhookSignatureHeader) != SignWebhook("s3cret", timestamp, []byte(payload)):
+			t.Errorf("got signature %q", r.Header.Get(WebhookSignatureHeader))
+		case r.Header.Get(WebhookEventHeader) != "movie.deleted" || r.Header.Get(WebhookDeliveryHeader) != "42":
+			t.Errorf("got event %q of delivery %q", r.Header.Get(WebhookEventHeader), r.Header.Get(WebhookDeliveryHeader))
+		}
+		if status == http.StatusFound {
+			w.Header().Set("Location", "/elsewhere")
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	delivery := webhookDelivery{ID: 42, EventID: 7, Payload: []byte(payload), Attempts: 1, WebhookID: 3, URL: srv.URL, Secret: "s3cret"}
+
+	for _, test := range []struct {
+		status int
+		failed bool
+	}{
+		{http.StatusNoContent, false},
+		{http.StatusOK, false},
+		{http.StatusFound, true},
+		{http.StatusBadRequest, true},
+		{http.StatusInternalServerError, true},
+	} {
+		status = test.status
+		if err := postWebhook(delivery); (err != nil) != test.failed {
+			t.Errorf("status %d: got %v", test.status, err)
+		}
+	}
+}
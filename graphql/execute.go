@@ -0,0 +1,777 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// Request is a GraphQL request, as sent in the body of a POST request
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+	// ReadOnly refuses mutations, for requests that must not change anything such as GET requests
+	ReadOnly bool `json:"-"`
+}
+
+// Response is the result of a request, Data is only present once the request was executed
+type Response struct {
+	Data     interface{}
+	Errors   []*Error
+	executed bool
+}
+
+// Executed tells whether the request was valid and executed, even if some of its fields failed
+func (r *Response) Executed() bool {
+	return r.executed
+}
+
+func (r *Response) MarshalJSON() ([]byte, error) {
+	out := &orderedMap{}
+	if len(r.Errors) > 0 {
+		out.set("errors", r.Errors)
+	}
+	if r.executed {
+		out.set("data", r.Data)
+	}
+	return out.MarshalJSON()
+}
+
+// orderedMap is a JSON object whose keys keep the order of the selection
+type orderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (m *orderedMap) set(key string, value interface{}) {
+	if m.values == nil {
+		m.values = map[string]interface{}{}
+	}
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	buf := bytes.Buffer{}
+	buf.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		buf.Write(name)
+		buf.WriteByte(':')
+		value, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Execute validates a request against the schema and executes it. Fields are resolved one after
+// the other, in the order of the request.
+func (s *Schema) Execute(ctx context.Context, req Request) *Response {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return &Response{Errors: []*Error{err.(*Error)}}
+	}
+	op, err := doc.operation(req.OperationName)
+	if err != nil {
+		return &Response{Errors: []*Error{err.(*Error)}}
+	}
+	root := s.Query
+	if op.kind == "mutation" {
+		if s.Mutation == nil {
+			return &Response{Errors: []*Error{newError("the schema has no mutations", op.loc)}}
+		}
+		if req.ReadOnly {
+			return &Response{Errors: []*Error{newError("mutations are only allowed in POST requests", op.loc)}}
+		}
+		root = s.Mutation
+	}
+
+	p := &planner{schema: s, types: s.types(), doc: doc, visiting: map[string]bool{}, fragments: map[string][]*plannedField{}}
+	p.variables(op, req.Variables)
+	if len(p.errs) > 0 {
+		return &Response{Errors: p.errs}
+	}
+	fields := p.selection(root, op.selection, 1)
+	if len(p.errs) > 0 {
+		return &Response{Errors: p.errs}
+	}
+	if s.MaxComplexity > 0 {
+		if c := complexity(fields, s.MaxComplexity); c > s.MaxComplexity {
+			message := fmt.Sprintf("the query has a complexity over %d, the limit, ask for fewer fields or smaller pages", s.MaxComplexity)
+			return &Response{Errors: []*Error{newError(message, op.loc)}}
+		}
+	}
+
+	e := &executor{ctx: ctx, schema: s}
+	resp := &Response{executed: true}
+	data, _ := e.object(root, nil, fields, []interface{}{})
+	if data != nil {
+		resp.Data = data
+	}
+	resp.Errors = e.errs
+	return resp
+}
+
+// operation returns the operation to execute, the only one of the document when name is ""
+func (doc *document) operation(name string) (*operation, error) {
+	names := map[string]bool{}
+	for _, op := range doc.operations {
+		if op.name != "" && names[op.name] {
+			return nil, newError(fmt.Sprintf("there can be only one operation named %q", op.name), op.loc)
+		}
+		names[op.name] = true
+	}
+	if len(doc.operations) == 0 {
+		return nil, &Error{Message: "the document has no operation"}
+	}
+	if name == "" {
+		if len(doc.operations) > 1 {
+			return nil, newError("operationName is required when the document has several operations", doc.operations[1].loc)
+		}
+		return doc.operations[0], nil
+	}
+	for _, op := range doc.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, &Error{Message: fmt.Sprintf("unknown operation %q", name)}
+}
+
+// plannedField is a field of a valid request, with its arguments coerced and its fragments expanded
+type plannedField struct {
+	key      string
+	name     string
+	field    *Field // nil for __typename
+	args     map[string]interface{}
+	children []*plannedField
+	loc      Location
+}
+
+// maxPlanned bounds the number of fields and fragment spreads a request plans, whatever its depth and complexity
+const maxPlanned = 10000
+
+// planner validates a request and plans its fields. A fragment is planned once per depth it is spread at,
+// fragments is the cache of the fields it planned to.
+type planner struct {
+	schema    *Schema
+	types     map[string]Type
+	doc       *document
+	vars      map[string]interface{}
+	defined   map[string]bool
+	visiting  map[string]bool
+	fragments map[string][]*plannedField
+	planned   int
+	tooDeep   bool
+	tooLarge  bool
+	errs      []*Error
+}
+
+func (p *planner) fail(message string, loc Location) {
+	p.errs = append(p.errs, newError(message, loc))
+}
+
+// variables coerces the variables of the request to the types of their definitions
+func (p *planner) variables(op *operation, given map[string]interface{}) {
+	p.vars, p.defined = map[string]interface{}{}, map[string]bool{}
+	for _, def := range op.variables {
+		if p.defined[def.name] {
+			p.fail(fmt.Sprintf("there can be only one variable named $%s", def.name), def.loc)
+			continue
+		}
+		p.defined[def.name] = true
+		t, err := p.inputType(def.typ)
+		if err != nil {
+			p.fail(fmt.Sprintf("variable $%s: %s", def.name, err), def.loc)
+			continue
+		}
+		if v, ok := given[def.name]; ok {
+			coerced, err := coerceInput(v, t)
+			if err != nil {
+				p.fail(fmt.Sprintf("variable $%s got an invalid value: %s", def.name, err), def.loc)
+				continue
+			}
+			p.vars[def.name] = coerced
+			continue
+		}
+		if def.defValue.kind != "" {
+			coerced, _, err := p.literal(def.defValue, t)
+			if err != nil {
+				p.fail(fmt.Sprintf("variable $%s has an invalid default value: %s", def.name, err), def.loc)
+				continue
+			}
+			p.vars[def.name] = coerced
+			continue
+		}
+		if _, ok := t.(*NonNull); ok {
+			p.fail(fmt.Sprintf("variable $%s of required type %s was not provided", def.name, t), def.loc)
+		}
+	}
+}
+
+// inputType resolves the type of a variable definition
+func (p *planner) inputType(ref typeRef) (Type, error) {
+	var t Type
+	if ref.list != nil {
+		of, err := p.inputType(*ref.list)
+		if err != nil {
+			return nil, err
+		}
+		t = &List{Of: of}
+	} else {
+		t = p.types[ref.name]
+		switch t.(type) {
+		case *Scalar, *Enum, *InputObject:
+		case nil:
+			return nil, fmt.Errorf("unknown type %s", ref.name)
+		default:
+			return nil, fmt.Errorf("%s is not an input type", ref.name)
+		}
+	}
+	if ref.nonNull {
+		t = &NonNull{Of: t}
+	}
+	return t, nil
+}
+
+// selection plans the fields of a selection set on an object
+func (p *planner) selection(parent *Object, nodes []selectionNode, depth int) []*plannedField {
+	if p.schema.MaxDepth > 0 && depth > p.schema.MaxDepth {
+		if !p.tooDeep {
+			p.tooDeep = true
+			p.fail(fmt.Sprintf("the query is deeper than %d levels, the limit", p.schema.MaxDepth), nodes[0].loc)
+		}
+		return nil
+	}
+	fields := []*plannedField{}
+	for _, node := range nodes {
+		if !p.count(node.loc) {
+			return fields
+		}
+		if !p.included(node.directives) {
+			continue
+		}
+		switch {
+		case node.spread:
+			f, ok := p.doc.fragments[node.name]
+			if !ok {
+				p.fail(fmt.Sprintf("unknown fragment %q", node.name), node.loc)
+				continue
+			}
+			if p.visiting[node.name] {
+				p.fail(fmt.Sprintf("fragment %q spreads itself", node.name), node.loc)
+				continue
+			}
+			if f.on != parent.Name {
+				p.fail(fmt.Sprintf("fragment %q on %s cannot be spread on %s", node.name, f.on, parent.Name), node.loc)
+				continue
+			}
+			for _, child := range p.fragment(parent, f, depth) {
+				fields = p.merge(fields, child)
+			}
+		case node.inline:
+			if node.on != "" && node.on != parent.Name {
+				p.fail(fmt.Sprintf("a fragment on %s cannot be spread on %s", node.on, parent.Name), node.loc)
+				continue
+			}
+			for _, child := range p.selection(parent, node.selection, depth) {
+				fields = p.merge(fields, child)
+			}
+		default:
+			if f := p.field(parent, node, depth); f != nil {
+				fields = p.merge(fields, f)
+			}
+		}
+	}
+	return fields
+}
+
+// fragment plans the fields of a named fragment spread at a depth, once per request
+func (p *planner) fragment(parent *Object, f *fragment, depth int) []*plannedField {
+	key := fmt.Sprintf("%s@%d", f.name, depth)
+	if fields, ok := p.fragments[key]; ok {
+		return fields
+	}
+	p.visiting[f.name] = true
+	fields := p.selection(parent, f.selection, depth)
+	p.visiting[f.name] = false
+	p.fragments[key] = fields
+	return fields
+}
+
+// count records a planned node, and fails the request once it planned more than maxPlanned
+func (p *planner) count(loc Location) bool {
+	p.planned++
+	if p.planned <= maxPlanned {
+		return true
+	}
+	if !p.tooLarge {
+		p.tooLarge = true
+		p.fail(fmt.Sprintf("the query has more than %d fields and fragment spreads, the limit", maxPlanned), loc)
+	}
+	return false
+}
+
+// field plans a field of an object
+func (p *planner) field(parent *Object, node selectionNode, depth int) *plannedField {
+	f := &plannedField{key: node.name, name: node.name, loc: node.loc}
+	if node.alias != "" {
+		f.key = node.alias
+	}
+	if node.name == "__typename" {
+		if len(node.selection) > 0 || len(node.arguments) > 0 {
+			p.fail("__typename takes no arguments nor selection", node.loc)
+			return nil
+		}
+		return f
+	}
+	field, ok := parent.Fields[node.name]
+	if !ok {
+		p.fail(fmt.Sprintf("cannot query field %q on type %s", node.name, parent.Name), node.loc)
+		return nil
+	}
+	f.field = field
+	f.args = p.arguments(field.Args, node.arguments, fmt.Sprintf("%s.%s", parent.Name, node.name), node.loc)
+	object, isObject := namedType(field.Type).(*Object)
+	switch {
+	case isObject && len(node.selection) == 0:
+		p.fail(fmt.Sprintf("field %q of type %s must have a selection of subfields", node.name, field.Type), node.loc)
+		return nil
+	case !isObject && len(node.selection) > 0:
+		p.fail(fmt.Sprintf("field %q of type %s cannot have a selection of subfields", node.name, field.Type), node.loc)
+		return nil
+	case isObject:
+		f.children = p.selection(object, node.selection, depth+1)
+	}
+	return f
+}
+
+// merge adds a field to the fields of a selection, the fields sharing its response key are merged. The planned fields
+// of fragments are shared, so a field is copied before its children change.
+func (p *planner) merge(fields []*plannedField, f *plannedField) []*plannedField {
+	if !p.count(f.loc) {
+		return fields
+	}
+	for i, existing := range fields {
+		if existing.key != f.key {
+			continue
+		}
+		if existing.name != f.name || !reflect.DeepEqual(existing.args, f.args) {
+			p.fail(fmt.Sprintf("fields %q conflict, they select different fields or arguments, use aliases", f.key), f.loc)
+			return fields
+		}
+		if len(f.children) == 0 {
+			return fields
+		}
+		merged := *existing
+		merged.children = append([]*plannedField{}, existing.children...)
+		for _, child := range f.children {
+			merged.children = p.merge(merged.children, child)
+		}
+		fields[i] = &merged
+		return fields
+	}
+	return append(fields, f)
+}
+
+// included evaluates the @include and @skip directives of a selection
+func (p *planner) included(directives []directive) bool {
+	included := true
+	for _, d := range directives {
+		if d.name != "include" && d.name != "skip" {
+			p.fail(fmt.Sprintf("unknown directive @%s", d.name), d.loc)
+			continue
+		}
+		args := p.arguments(map[string]*Argument{"if": {Type: &NonNull{Of: Boolean}}}, d.arguments, "@"+d.name, d.loc)
+		condition, ok := args["if"].(bool)
+		if !ok {
+			continue
+		}
+		if condition == (d.name == "skip") {
+			included = false
+		}
+	}
+	return included
+}
+
+// arguments coerces the arguments given to a field or a directive, applying the defaults
+func (p *planner) arguments(defs map[string]*Argument, given map[string]value, where string, loc Location) map[string]interface{} {
+	args := map[string]interface{}{}
+	for name, v := range given {
+		if _, ok := defs[name]; !ok {
+			p.fail(fmt.Sprintf("unknown argument %q of %s", name, where), v.loc)
+		}
+	}
+	for name, def := range defs {
+		if v, ok := given[name]; ok {
+			coerced, present, err := p.literal(v, def.Type)
+			if err != nil {
+				p.fail(fmt.Sprintf("argument %q of %s got an invalid value: %s", name, where, err), v.loc)
+				continue
+			}
+			if present {
+				args[name] = coerced
+				continue
+			}
+		}
+		if def.Default != nil {
+			args[name] = def.Default
+			continue
+		}
+		if _, ok := def.Type.(*NonNull); ok {
+			p.fail(fmt.Sprintf("argument %q of %s of type %s is required", name, where, def.Type), loc)
+		}
+	}
+	return args
+}
+
+// literal coerces a value of the request to a type, present is false for a variable that was not provided
+func (p *planner) literal(v value, t Type) (coerced interface{}, present bool, err error) {
+	if v.kind == "variable" {
+		if !p.defined[v.variable] {
+			return nil, false, fmt.Errorf("variable $%s is not defined", v.variable)
+		}
+		value, ok := p.vars[v.variable]
+		if !ok {
+			if _, nonNull := t.(*NonNull); nonNull {
+				return nil, false, fmt.Errorf("variable $%s was not provided", v.variable)
+			}
+			return nil, false, nil
+		}
+		coerced, err = coerceInput(value, t)
+		return coerced, true, err
+	}
+	if nonNull, ok := t.(*NonNull); ok {
+		if v.kind == "null" {
+			return nil, true, fmt.Errorf("expected a value of type %s, found null", t)
+		}
+		return p.literal(v, nonNull.Of)
+	}
+	if v.kind == "null" {
+		return nil, true, nil
+	}
+	switch t := t.(type) {
+	case *List:
+		if v.kind != "list" {
+			item, present, err := p.literal(v, t.Of)
+			if !present || err != nil {
+				return nil, present, err
+			}
+			return []interface{}{item}, true, nil
+		}
+		items := []interface{}{}
+		for _, item := range v.list {
+			coerced, _, err := p.literal(item, t.Of)
+			if err != nil {
+				return nil, true, err
+			}
+			items = append(items, coerced)
+		}
+		return items, true, nil
+	case *Scalar:
+		var raw interface{}
+		var err error
+		switch v.kind {
+		case "int":
+			var n int64
+			n, err = strconv.ParseInt(v.raw, 10, 64)
+			raw = int(n)
+		case "float":
+			raw, err = strconv.ParseFloat(v.raw, 64)
+		case "string":
+			raw = v.raw
+		case "boolean":
+			raw = v.raw == "true"
+		default:
+			return nil, true, fmt.Errorf("expected a value of type %s, found %s", t, v.raw)
+		}
+		if err != nil {
+			return nil, true, fmt.Errorf("%s is not a valid %s", v.raw, t)
+		}
+		coerced, err = t.ParseValue(raw)
+		return coerced, true, err
+	case *Enum:
+		if v.kind != "enum" || !t.has(v.raw) {
+			return nil, true, fmt.Errorf("expected a value of enum %s, found %s", t, v.raw)
+		}
+		return v.raw, true, nil
+	case *InputObject:
+		if v.kind != "object" {
+			return nil, true, fmt.Errorf("expected an object of type %s", t)
+		}
+		object := map[string]interface{}{}
+		for name := range v.fields {
+			if _, ok := t.Fields[name]; !ok {
+				return nil, true, fmt.Errorf("unknown field %q of %s", name, t)
+			}
+		}
+		for name, def := range t.Fields {
+			if fieldValue, ok := v.fields[name]; ok {
+				coerced, present, err := p.literal(fieldValue, def.Type)
+				if err != nil {
+					return nil, true, fmt.Errorf("field %q: %s", name, err)
+				}
+				if present {
+					object[name] = coerced
+					continue
+				}
+			}
+			if def.Default != nil {
+				object[name] = def.Default
+			} else if _, ok := def.Type.(*NonNull); ok {
+				return nil, true, fmt.Errorf("field %q of %s of type %s is required", name, t, def.Type)
+			}
+		}
+		return object, true, nil
+	}
+	return nil, true, fmt.Errorf("%s is not an input type", t)
+}
+
+// coerceInput coerces a variable, decoded from JSON, to a type
+func coerceInput(v interface{}, t Type) (interface{}, error) {
+	if nonNull, ok := t.(*NonNull); ok {
+		if v == nil {
+			return nil, fmt.Errorf("expected a value of type %s, found null", t)
+		}
+		return coerceInput(v, nonNull.Of)
+	}
+	if v == nil {
+		return nil, nil
+	}
+	switch t := t.(type) {
+	case *List:
+		items, ok := v.([]interface{})
+		if !ok {
+			item, err := coerceInput(v, t.Of)
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{item}, nil
+		}
+		coerced := []interface{}{}
+		for i, item := range items {
+			c, err := coerceInput(item, t.Of)
+			if err != nil {
+				return nil, fmt.Errorf("item %d: %s", i, err)
+			}
+			coerced = append(coerced, c)
+		}
+		return coerced, nil
+	case *Scalar:
+		return t.ParseValue(v)
+	case *Enum:
+		name, ok := v.(string)
+		if !ok || !t.has(name) {
+			return nil, fmt.Errorf("expected a value of enum %s, found %v", t, v)
+		}
+		return name, nil
+	case *InputObject:
+		fields, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected an object of type %s", t)
+		}
+		for name := range fields {
+			if _, ok := t.Fields[name]; !ok {
+				return nil, fmt.Errorf("unknown field %q of %s", name, t)
+			}
+		}
+		object := map[string]interface{}{}
+		for name, def := range t.Fields {
+			fieldValue, ok := fields[name]
+			if !ok {
+				if def.Default != nil {
+					object[name] = def.Default
+				} else if _, ok := def.Type.(*NonNull); ok {
+					return nil, fmt.Errorf("field %q of %s of type %s is required", name, t, def.Type)
+				}
+				continue
+			}
+			c, err := coerceInput(fieldValue, def.Type)
+			if err != nil {
+				return nil, fmt.Errorf("field %q: %s", name, err)
+			}
+			object[name] = c
+		}
+		return object, nil
+	}
+	return nil, fmt.Errorf("%s is not an input type", t)
+}
+
+// complexity counts the fields a selection resolves, a field with a multiplier counts its
+// subfields that many times. Counting stops past limit.
+func complexity(fields []*plannedField, limit int) int {
+	total := 0
+	for _, f := range fields {
+		if f.field == nil {
+			continue
+		}
+		cost := 1
+		if len(f.children) > 0 {
+			multiplier := 1
+			if f.field.Multiplier != nil {
+				multiplier = f.field.Multiplier(f.args)
+			}
+			children := complexity(f.children, limit)
+			if multiplier > 0 && children > (limit+1)/multiplier {
+				return limit + 1
+			}
+			cost += multiplier * children
+		}
+		total += cost
+		if total > limit {
+			return limit + 1
+		}
+	}
+	return total
+}
+
+// namedType unwraps the lists and non null types of a type
+func namedType(t Type) Type {
+	for {
+		switch wrapper := t.(type) {
+		case *List:
+			t = wrapper.Of
+		case *NonNull:
+			t = wrapper.Of
+		default:
+			return t
+		}
+	}
+}
+
+// executor resolves the planned fields of a request
+type executor struct {
+	ctx    context.Context
+	schema *Schema
+	errs   []*Error
+}
+
+func (e *executor) fail(err error, loc Location, path []interface{}) {
+	gqlErr := &Error{Message: err.Error(), Locations: []Location{loc}, Path: append([]interface{}{}, path...)}
+	if e.schema.FormatError != nil {
+		gqlErr.Message, gqlErr.Extensions = e.schema.FormatError(err)
+	}
+	e.errs = append(e.errs, gqlErr)
+}
+
+// object resolves the fields of an object, failed is true when it is null because of an error
+func (e *executor) object(parent *Object, source interface{}, fields []*plannedField, path []interface{}) (result *orderedMap, failed bool) {
+	result = &orderedMap{}
+	for _, f := range fields {
+		fieldPath := append(path[:len(path):len(path)], f.key)
+		if f.field == nil {
+			result.set(f.key, parent.Name)
+			continue
+		}
+		value, err := e.resolve(f, source)
+		var completed interface{}
+		if err != nil {
+			e.fail(err, f.loc, fieldPath)
+			failed = true
+		} else {
+			completed, failed = e.complete(f.field.Type, value, f, fieldPath)
+		}
+		if _, nonNull := f.field.Type.(*NonNull); nonNull && completed == nil {
+			if !failed {
+				e.fail(fmt.Errorf("cannot return null for non-nullable field %s.%s", parent.Name, f.name), f.loc, fieldPath)
+			}
+			return nil, true
+		}
+		result.set(f.key, completed)
+	}
+	return result, false
+}
+
+// resolve calls the resolver of a field, turning its panics into errors
+func (e *executor) resolve(f *plannedField, source interface{}) (value interface{}, err error) {
+	if f.field.Resolve == nil {
+		if m, ok := source.(map[string]interface{}); ok {
+			return m[f.name], nil
+		}
+		return nil, nil
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			value, err = nil, fmt.Errorf("%s panicked: %v", f.name, r)
+		}
+	}()
+	return f.field.Resolve(e.ctx, source, f.args)
+}
+
+// complete turns a resolved value into its response value, failed is true when it is null because of an error
+func (e *executor) complete(t Type, value interface{}, f *plannedField, path []interface{}) (interface{}, bool) {
+	if nonNull, ok := t.(*NonNull); ok {
+		return e.complete(nonNull.Of, value, f, path)
+	}
+	if isNil(value) {
+		return nil, false
+	}
+	switch t := t.(type) {
+	case *List:
+		items := reflect.ValueOf(value)
+		if items.Kind() != reflect.Slice && items.Kind() != reflect.Array {
+			e.fail(fmt.Errorf("%s resolved to a %T instead of a list", f.name, value), f.loc, path)
+			return nil, true
+		}
+		completed := make([]interface{}, 0, items.Len())
+		for i := 0; i < items.Len(); i++ {
+			itemPath := append(path[:len(path):len(path)], i)
+			item, failed := e.complete(t.Of, items.Index(i).Interface(), f, itemPath)
+			if _, nonNull := t.Of.(*NonNull); nonNull && item == nil {
+				if !failed {
+					e.fail(fmt.Errorf("cannot return null for a non-nullable item of %s", f.name), f.loc, itemPath)
+				}
+				return nil, true
+			}
+			completed = append(completed, item)
+		}
+		return completed, false
+	case *Scalar:
+		serialized, err := t.Serialize(value)
+		if err != nil {
+			e.fail(err, f.loc, path)
+			return nil, true
+		}
+		return serialized, false
+	case *Enum:
+		name := fmt.Sprint(value)
+		if !t.has(name) {
+			e.fail(fmt.Errorf("%s is not a value of enum %s", name, t), f.loc, path)
+			return nil, true
+		}
+		return name, false
+	case *Object:
+		object, failed := e.object(t, value, f.children, path)
+		if object == nil {
+			return nil, failed
+		}
+		return object, false
+	}
+	e.fail(fmt.Errorf("%s is not an output type", t), f.loc, path)
+	return nil, true
+}
+
+func isNil(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSchema is a small catalogue: movies have a director, who has movies
+func testSchema() *Schema {
+	movie := &Object{Name: "Movie", Fields: map[string]*Field{
+		"name": {Type: String},
+	}}
+	director := &Object{Name: "Director", Fields: map[string]*Field{
+		"name": {Type: String},
+		"movies": {Type: &List{Of: movie}, Args: map[string]*Argument{"size": {Type: Int, Default: 2}},
+			Multiplier: func(args map[string]interface{}) int { return args["size"].(int) },
+			Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				movies := []interface{}{}
+				for i := 0; i < args["size"].(int); i++ {
+					movies = append(movies, map[string]interface{}{"name": fmt.Sprintf("%s %d", source.(map[string]interface{})["name"], i)})
+				}
+				return movies, nil
+			}},
+	}}
+	movie.Fields["director"] = &Field{Type: director, Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+		return map[string]interface{}{"name": "Hitchcock"}, nil
+	}}
+	query := &Object{Name: "Query", Fields: map[string]*Field{
+		"a": {Type: String, Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return "a", nil
+		}},
+		"echo": {Type: String, Args: map[string]*Argument{"text": {Type: &NonNull{Of: String}}},
+			Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				return args["text"], nil
+			}},
+		"movie": {Type: movie, Args: map[string]*Argument{"id": {Type: &NonNull{Of: ID}}},
+			Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				return map[string]interface{}{"name": "Psycho " + args["id"].(string)}, nil
+			}},
+	}}
+	return &Schema{Query: query, MaxDepth: 5, MaxComplexity: 50}
+}
+
+// execute runs a query on the test schema and returns its response as JSON
+func execute(t *testing.T, query string, variables map[string]interface{}) string {
+	t.Helper()
+	resp := testSchema().Execute(context.Background(), Request{Query: query, Variables: variables})
+	data, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestExecute(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		variables map[string]interface{}
+		want      string
+	}{
+		{"fields", `{ a movie(id: 1) { name } }`, nil,
+			`{"data":{"a":"a","movie":{"name":"Psycho 1"}}}`},
+		{"aliases", `{ first: movie(id: "1") { name } second: movie(id: "2") { title: name } }`, nil,
+			`{"data":{"first":{"name":"Psycho 1"},"second":{"title":"Psycho 2"}}}`},
+		{"variables", `query ($text: String!) { echo(text: $text) }`, map[string]interface{}{"text": "hi"},
+			`{"data":{"echo":"hi"}}`},
+		{"variable default", `query ($text: String = "default") { echo(text: $text) }`, nil,
+			`{"data":{"echo":"default"}}`},
+		{"missing variable", `query ($text: String!) { echo(text: $text) }`, nil,
+			`{"errors":[{"message":"variable $text of required type String! was not provided","locations":[{"line":1,"column":8}]}]}`},
+		{"fragments", `{ movie(id: 1) { ...M } } fragment M on Movie { name director { name } }`, nil,
+			`{"data":{"movie":{"name":"Psycho 1","director":{"name":"Hitchcock"}}}}`},
+		{"inline fragments", `{ movie(id: 1) { ... on Movie { name } } }`, nil,
+			`{"data":{"movie":{"name":"Psycho 1"}}}`},
+		{"merged fragments", `{ movie(id: 1) { ...N ...D } } fragment N on Movie { director { name } } fragment D on Movie { director { movies(size: 1) { name } } }`, nil,
+			`{"data":{"movie":{"director":{"name":"Hitchcock","movies":[{"name":"Hitchcock 0"}]}}}}`},
+		{"skip and include", `query ($yes: Boolean!) { a @skip(if: $yes) echo(text: "x") @include(if: $yes) }`, map[string]interface{}{"yes": true},
+			`{"data":{"echo":"x"}}`},
+		{"typename", `{ movie(id: 1) { __typename } }`, nil,
+			`{"data":{"movie":{"__typename":"Movie"}}}`},
+		{"unknown field", `{ nope }`, nil,
+			`{"errors":[{"message":"cannot query field \"nope\" on type Query","locations":[{"line":1,"column":3}]}]}`},
+		{"self spread", `{ movie(id: 1) { ...M } } fragment M on Movie { ...M }`, nil,
+			`{"errors":[{"message":"fragment \"M\" spreads itself","locations":[{"line":1,"column":49}]}]}`},
+		{"too deep", `{ movie(id: 1) { director { movies { director { movies { director { name } } } } } } }`, nil,
+			`{"errors":[{"message":"the query is deeper than 5 levels, the limit","locations":[{"line":1,"column":58}]}]}`},
+		{"too complex", `{ movie(id: 1) { director { movies(size: 30) { name director { name } } } } }`, nil,
+			`{"errors":[{"message":"the query has a complexity over 50, the limit, ask for fewer fields or smaller pages","locations":[{"line":1,"column":1}]}]}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := execute(t, test.query, test.variables)
+			if got != test.want {
+				t.Errorf("got  %s\nwant %s", got, test.want)
+			}
+		})
+	}
+}
+
+// TestFragmentBlowUp checks that a fragment spread twice by each of the fragments before it is planned once,
+// instead of 2^n times
+func TestFragmentBlowUp(t *testing.T) {
+	const levels = 40
+	query := strings.Builder{}
+	query.WriteString("{ ...F0 }\n")
+	for i := 0; i < levels; i++ {
+		fmt.Fprintf(&query, "fragment F%d on Query { ...F%d ...F%d }\n", i, i+1, i+1)
+	}
+	fmt.Fprintf(&query, "fragment F%d on Query { a }\n", levels)
+
+	done := make(chan string, 1)
+	go func() {
+		done <- execute(t, query.String(), nil)
+	}()
+	select {
+	case got := <-done:
+		if want := `{"data":{"a":"a"}}`; got != want {
+			t.Errorf("got  %s\nwant %s", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the query is still being planned after 5 seconds")
+	}
+}
+
+// TestMaxPlanned checks that a query spreading many fragments fails once it planned maxPlanned nodes
+func TestMaxPlanned(t *testing.T) {
+	query := strings.Builder{}
+	query.WriteString("{ ")
+	for i := 0; i < maxPlanned; i++ {
+		fmt.Fprintf(&query, "a%d: a ", i)
+	}
+	query.WriteString("}")
+	got := execute(t, query.String(), nil)
+	if !strings.Contains(got, fmt.Sprintf("the query has more than %d fields and fragment spreads, the limit", maxPlanned)) {
+		t.Errorf("got %.200s", got)
+	}
+}
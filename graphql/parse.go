@@ -0,0 +1,503 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// document is a parsed request, fragments are keyed by name
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+type operation struct {
+	kind      string // query or mutation
+	name      string
+	variables []*variableDefinition
+	selection []selectionNode
+	loc       Location
+}
+
+type variableDefinition struct {
+	name     string
+	typ      typeRef
+	defValue value
+	loc      Location
+}
+
+// typeRef is a type as written in a variable definition
+type typeRef struct {
+	name    string
+	list    *typeRef
+	nonNull bool
+}
+
+func (t typeRef) String() string {
+	s := t.name
+	if t.list != nil {
+		s = "[" + t.list.String() + "]"
+	}
+	if t.nonNull {
+		s += "!"
+	}
+	return s
+}
+
+type fragment struct {
+	name      string
+	on        string
+	selection []selectionNode
+	loc       Location
+}
+
+// selectionNode is a field, a fragment spread or an inline fragment
+type selectionNode struct {
+	alias      string
+	name       string // field name, or fragment name for a spread
+	arguments  map[string]value
+	directives []directive
+	selection  []selectionNode
+	spread     bool
+	inline     bool
+	on         string // type condition of an inline fragment, "" when none
+	loc        Location
+}
+
+type directive struct {
+	name      string
+	arguments map[string]value
+	loc       Location
+}
+
+// value is a literal or a variable of the request
+type value struct {
+	kind     string // variable, int, float, string, boolean, null, enum, list, object
+	raw      string
+	list     []value
+	fields   map[string]value
+	loc      Location
+	variable string
+}
+
+type token struct {
+	kind  string // punct, name, int, float, string, eof
+	value string
+	loc   Location
+}
+
+type parser struct {
+	source string
+	pos    int
+	line   int
+	col    int
+	tok    token
+}
+
+// parse parses a request document
+func parse(source string) (doc *document, err error) {
+	p := &parser{source: source, line: 1, col: 1}
+	defer func() {
+		if r := recover(); r != nil {
+			parseErr, ok := r.(*Error)
+			if !ok {
+				panic(r)
+			}
+			doc, err = nil, parseErr
+		}
+	}()
+	p.next()
+	doc = &document{fragments: map[string]*fragment{}}
+	if p.tok.kind == "eof" {
+		p.fail("the document has no operation")
+	}
+	for p.tok.kind != "eof" {
+		switch {
+		case p.peek("punct", "{"):
+			// the location is read before the selection set moves past it
+			op := &operation{kind: "query", loc: p.tok.loc}
+			op.selection = p.selectionSet()
+			doc.operations = append(doc.operations, op)
+		case p.peek("name", "query") || p.peek("name", "mutation"):
+			doc.operations = append(doc.operations, p.operation())
+		case p.peek("name", "subscription"):
+			p.fail("subscriptions are not supported")
+		case p.peek("name", "fragment"):
+			f := p.fragment()
+			if _, ok := doc.fragments[f.name]; ok {
+				panic(newError(fmt.Sprintf("there can be only one fragment named %q", f.name), f.loc))
+			}
+			doc.fragments[f.name] = f
+		default:
+			p.fail(fmt.Sprintf("unexpected %s", p.describe()))
+		}
+	}
+	return doc, nil
+}
+
+func (p *parser) operation() *operation {
+	op := &operation{kind: p.tok.value, loc: p.tok.loc}
+	p.next()
+	if p.tok.kind == "name" {
+		op.name = p.tok.value
+		p.next()
+	}
+	if p.skip("punct", "(") {
+		for !p.skip("punct", ")") {
+			def := &variableDefinition{loc: p.tok.loc}
+			p.expect("punct", "$")
+			def.name = p.expect("name", "").value
+			p.expect("punct", ":")
+			def.typ = p.typeRef()
+			if p.skip("punct", "=") {
+				def.defValue = p.value(true)
+			}
+			op.variables = append(op.variables, def)
+		}
+	}
+	p.directives()
+	op.selection = p.selectionSet()
+	return op
+}
+
+func (p *parser) fragment() *fragment {
+	f := &fragment{loc: p.tok.loc}
+	p.next()
+	f.name = p.expect("name", "").value
+	if f.name == "on" {
+		p.fail("a fragment cannot be named on")
+	}
+	p.expect("name", "on")
+	f.on = p.expect("name", "").value
+	p.directives()
+	f.selection = p.selectionSet()
+	return f
+}
+
+func (p *parser) typeRef() typeRef {
+	var t typeRef
+	if p.skip("punct", "[") {
+		inner := p.typeRef()
+		t.list = &inner
+		p.expect("punct", "]")
+	} else {
+		t.name = p.expect("name", "").value
+	}
+	t.nonNull = p.skip("punct", "!")
+	return t
+}
+
+func (p *parser) selectionSet() []selectionNode {
+	open := p.expect("punct", "{")
+	selection := []selectionNode{}
+	for !p.skip("punct", "}") {
+		selection = append(selection, p.selection())
+	}
+	if len(selection) == 0 {
+		panic(newError("syntax error: a selection set cannot be empty", open.loc))
+	}
+	return selection
+}
+
+func (p *parser) selection() selectionNode {
+	node := selectionNode{loc: p.tok.loc}
+	if p.skip("punct", "...") {
+		if p.tok.kind == "name" && p.tok.value != "on" {
+			node.spread = true
+			node.name = p.tok.value
+			p.next()
+			node.directives = p.directives()
+			return node
+		}
+		node.inline = true
+		if p.skip("name", "on") {
+			node.on = p.expect("name", "").value
+		}
+		node.directives = p.directives()
+		node.selection = p.selectionSet()
+		return node
+	}
+	node.name = p.expect("name", "").value
+	if p.skip("punct", ":") {
+		node.alias = node.name
+		node.name = p.expect("name", "").value
+	}
+	node.arguments = p.arguments(false)
+	node.directives = p.directives()
+	if p.peek("punct", "{") {
+		node.selection = p.selectionSet()
+	}
+	return node
+}
+
+func (p *parser) arguments(constant bool) map[string]value {
+	arguments := map[string]value{}
+	if !p.skip("punct", "(") {
+		return arguments
+	}
+	for !p.skip("punct", ")") {
+		loc := p.tok.loc
+		name := p.expect("name", "").value
+		p.expect("punct", ":")
+		if _, ok := arguments[name]; ok {
+			panic(newError(fmt.Sprintf("there can be only one argument named %q", name), loc))
+		}
+		arguments[name] = p.value(constant)
+	}
+	return arguments
+}
+
+func (p *parser) directives() []directive {
+	directives := []directive{}
+	for p.peek("punct", "@") {
+		loc := p.tok.loc
+		p.next()
+		name := p.expect("name", "").value
+		directives = append(directives, directive{name: name, arguments: p.arguments(false), loc: loc})
+	}
+	return directives
+}
+
+func (p *parser) value(constant bool) value {
+	v := value{loc: p.tok.loc}
+	switch {
+	case p.peek("punct", "$"):
+		if constant {
+			p.fail("variables cannot be used in default values")
+		}
+		p.next()
+		v.kind, v.variable = "variable", p.expect("name", "").value
+	case p.tok.kind == "int" || p.tok.kind == "float" || p.tok.kind == "string":
+		v.kind, v.raw = p.tok.kind, p.tok.value
+		p.next()
+	case p.tok.kind == "name":
+		switch p.tok.value {
+		case "true", "false":
+			v.kind = "boolean"
+		case "null":
+			v.kind = "null"
+		default:
+			v.kind = "enum"
+		}
+		v.raw = p.tok.value
+		p.next()
+	case p.skip("punct", "["):
+		v.kind, v.list = "list", []value{}
+		for !p.skip("punct", "]") {
+			v.list = append(v.list, p.value(constant))
+		}
+	case p.skip("punct", "{"):
+		v.kind, v.fields = "object", map[string]value{}
+		for !p.skip("punct", "}") {
+			name := p.expect("name", "").value
+			p.expect("punct", ":")
+			v.fields[name] = p.value(constant)
+		}
+	default:
+		p.fail(fmt.Sprintf("expected a value, found %s", p.describe()))
+	}
+	return v
+}
+
+// peek reports whether the current token is of the given kind and value, any value when value is ""
+func (p *parser) peek(kind, value string) bool {
+	return p.tok.kind == kind && (value == "" || p.tok.value == value)
+}
+
+// skip consumes the current token when it matches
+func (p *parser) skip(kind, value string) bool {
+	if p.peek(kind, value) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+// expect consumes the current token, failing when it does not match
+func (p *parser) expect(kind, value string) token {
+	if !p.peek(kind, value) {
+		expected := value
+		if expected == "" {
+			expected = "a " + kind
+		} else {
+			expected = strconv.Quote(expected)
+		}
+		p.fail(fmt.Sprintf("expected %s, found %s", expected, p.describe()))
+	}
+	tok := p.tok
+	p.next()
+	return tok
+}
+
+func (p *parser) describe() string {
+	if p.tok.kind == "eof" {
+		return "the end of the document"
+	}
+	return strconv.Quote(p.tok.value)
+}
+
+func (p *parser) fail(message string) {
+	panic(newError("syntax error: "+message, p.tok.loc))
+}
+
+// next reads the next token, skipping white space, commas and comments
+func (p *parser) next() {
+	for p.pos < len(p.source) {
+		c := p.source[p.pos]
+		if c == '#' {
+			for p.pos < len(p.source) && p.source[p.pos] != '\n' {
+				p.advance(1)
+			}
+			continue
+		}
+		if strings.HasPrefix(p.source[p.pos:], "\uFEFF") {
+			p.advance(len("\uFEFF"))
+			continue
+		}
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' && c != ',' {
+			break
+		}
+		p.advance(1)
+	}
+	loc := Location{Line: p.line, Column: p.col}
+	if p.pos >= len(p.source) {
+		p.tok = token{kind: "eof", loc: loc}
+		return
+	}
+	rest := p.source[p.pos:]
+	c := rest[0]
+	switch {
+	case strings.HasPrefix(rest, "..."):
+		p.tok = token{kind: "punct", value: "...", loc: loc}
+		p.advance(3)
+	case strings.ContainsRune("!$()=:@[]{}|&", rune(c)):
+		p.tok = token{kind: "punct", value: string(c), loc: loc}
+		p.advance(1)
+	case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		end := 1
+		for end < len(rest) && (rest[end] == '_' || (rest[end] >= 'a' && rest[end] <= 'z') || (rest[end] >= 'A' && rest[end] <= 'Z') || (rest[end] >= '0' && rest[end] <= '9')) {
+			end++
+		}
+		p.tok = token{kind: "name", value: rest[:end], loc: loc}
+		p.advance(end)
+	case c == '-' || (c >= '0' && c <= '9'):
+		p.tok = p.number(rest, loc)
+	case c == '"':
+		p.tok = p.string(rest, loc)
+	default:
+		r, _ := utf8.DecodeRuneInString(rest)
+		panic(newError(fmt.Sprintf("syntax error: unexpected character %q", r), loc))
+	}
+}
+
+func (p *parser) number(rest string, loc Location) token {
+	end := 0
+	if rest[end] == '-' {
+		end++
+	}
+	digits := func() int {
+		start := end
+		for end < len(rest) && rest[end] >= '0' && rest[end] <= '9' {
+			end++
+		}
+		return end - start
+	}
+	if digits() == 0 {
+		panic(newError("syntax error: invalid number", loc))
+	}
+	kind := "int"
+	if end < len(rest) && rest[end] == '.' {
+		end++
+		kind = "float"
+		if digits() == 0 {
+			panic(newError("syntax error: invalid number", loc))
+		}
+	}
+	if end < len(rest) && (rest[end] == 'e' || rest[end] == 'E') {
+		end++
+		kind = "float"
+		if end < len(rest) && (rest[end] == '+' || rest[end] == '-') {
+			end++
+		}
+		if digits() == 0 {
+			panic(newError("syntax error: invalid number", loc))
+		}
+	}
+	p.advance(end)
+	return token{kind: kind, value: rest[:end], loc: loc}
+}
+
+func (p *parser) string(rest string, loc Location) token {
+	if strings.HasPrefix(rest, `"""`) {
+		end := strings.Index(rest[3:], `"""`)
+		if end < 0 {
+			panic(newError("syntax error: unterminated string", loc))
+		}
+		raw := rest[3 : 3+end]
+		p.advance(end + 6)
+		return token{kind: "string", value: strings.TrimSpace(strings.Replace(raw, `\"""`, `"""`, -1)), loc: loc}
+	}
+	var b strings.Builder
+	i := 1
+	for {
+		if i >= len(rest) || rest[i] == '\n' {
+			panic(newError("syntax error: unterminated string", loc))
+		}
+		c := rest[i]
+		if c == '"' {
+			break
+		}
+		if c != '\\' {
+			b.WriteByte(c)
+			i++
+			continue
+		}
+		if i+1 >= len(rest) {
+			panic(newError("syntax error: unterminated string", loc))
+		}
+		switch rest[i+1] {
+		case '"', '\\', '/':
+			b.WriteByte(rest[i+1])
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'u':
+			if i+6 > len(rest) {
+				panic(newError("syntax error: invalid unicode escape", loc))
+			}
+			code, err := strconv.ParseUint(rest[i+2:i+6], 16, 32)
+			if err != nil {
+				panic(newError("syntax error: invalid unicode escape", loc))
+			}
+			b.WriteRune(rune(code))
+			i += 4
+		default:
+			panic(newError(fmt.Sprintf("syntax error: invalid escape \\%c", rest[i+1]), loc))
+		}
+		i += 2
+	}
+	p.advance(i + 1)
+	return token{kind: "string", value: b.String(), loc: loc}
+}
+
+// advance moves n bytes forward, keeping track of the line and column
+func (p *parser) advance(n int) {
+	for _, c := range p.source[p.pos : p.pos+n] {
+		if c == '\n' {
+			p.line++
+			p.col = 1
+		} else {
+			p.col++
+		}
+	}
+	p.pos += n
+}
@@ -0,0 +1,119 @@
+package graphql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	doc, err := parse(`# a comment
+query Movies($id: ID!, $sizes: [Int!] = [1, 2]) {
+  first: movie(id: $id) @include(if: true) { name, ...M }
+  ... on Query { a }
+}
+fragment M on Movie { director { name } }
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.operations) != 1 {
+		t.Fatalf("got %d operations", len(doc.operations))
+	}
+	op := doc.operations[0]
+	if op.kind != "query" || op.name != "Movies" || op.loc != (Location{Line: 2, Column: 1}) {
+		t.Errorf("got operation %s %s at %v", op.kind, op.name, op.loc)
+	}
+	if len(op.variables) != 2 || op.variables[0].typ.String() != "ID!" || op.variables[1].typ.String() != "[Int!]" {
+		t.Fatalf("got variables %+v", op.variables)
+	}
+	if def := op.variables[1].defValue; def.kind != "list" || len(def.list) != 2 || def.list[1].raw != "2" {
+		t.Errorf("got default value %+v", def)
+	}
+
+	field := op.selection[0]
+	if field.alias != "first" || field.name != "movie" || field.loc != (Location{Line: 3, Column: 3}) {
+		t.Errorf("got field %s: %s at %v", field.alias, field.name, field.loc)
+	}
+	if arg := field.arguments["id"]; arg.kind != "variable" || arg.variable != "id" {
+		t.Errorf("got argument %+v", arg)
+	}
+	if len(field.directives) != 1 || field.directives[0].name != "include" || field.directives[0].arguments["if"].kind != "boolean" {
+		t.Errorf("got directives %+v", field.directives)
+	}
+	if len(field.selection) != 2 || !field.selection[1].spread || field.selection[1].name != "M" {
+		t.Errorf("got selection %+v", field.selection)
+	}
+	if inline := op.selection[1]; !inline.inline || inline.on != "Query" || inline.selection[0].name != "a" {
+		t.Errorf("got inline fragment %+v", inline)
+	}
+	if f := doc.fragments["M"]; f == nil || f.on != "Movie" || f.selection[0].selection[0].name != "name" {
+		t.Errorf("got fragment %+v", f)
+	}
+}
+
+func TestParseValues(t *testing.T) {
+	tests := []struct {
+		source string
+		kind   string
+		raw    string
+	}{
+		{`1`, "int", "1"},
+		{`-1.5e3`, "float", "-1.5e3"},
+		{`"a \"b\" é\n"`, "string", "a \"b\" é\n"},
+		{`"""  block "quoted" """`, "string", `block "quoted"`},
+		{`true`, "boolean", "true"},
+		{`null`, "null", "null"},
+		{`DRAMA`, "enum", "DRAMA"},
+	}
+	for _, test := range tests {
+		doc, err := parse(`{ echo(text: ` + test.source + `) }`)
+		if err != nil {
+			t.Errorf("%s: %s", test.source, err)
+			continue
+		}
+		got := doc.operations[0].selection[0].arguments["text"]
+		if got.kind != test.kind || got.raw != test.raw {
+			t.Errorf("%s: got %s %q, want %s %q", test.source, got.kind, got.raw, test.kind, test.raw)
+		}
+	}
+
+	doc, err := parse(`{ a(filter: {genres: [DRAMA], score: {gte: 8}}) }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := doc.operations[0].selection[0].arguments["filter"]
+	if filter.kind != "object" || filter.fields["genres"].list[0].raw != "DRAMA" || filter.fields["score"].fields["gte"].raw != "8" {
+		t.Errorf("got %+v", filter)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		source  string
+		message string
+		loc     Location
+	}{
+		{``, "syntax error: the document has no operation", Location{1, 1}},
+		{`{ }`, "syntax error: a selection set cannot be empty", Location{1, 1}},
+		{`{ a`, "syntax error: expected a name, found the end of the document", Location{1, 4}},
+		{"{\n  movie(id: ) { name } }", `syntax error: expected a value, found ")"`, Location{2, 13}},
+		{`{ a(x: 1, x: 2) }`, `there can be only one argument named "x"`, Location{1, 11}},
+		{`{ a(x: "abc) }`, "syntax error: unterminated string", Location{1, 8}},
+		{`{ a(x: "\q") }`, `syntax error: invalid escape \q`, Location{1, 8}},
+		{`{ a(x: 1.) }`, "syntax error: invalid number", Location{1, 8}},
+		{`{ a ? }`, `syntax error: unexpected character '?'`, Location{1, 5}},
+		{`query ($a: Int = $b) { a }`, "syntax error: variables cannot be used in default values", Location{1, 18}},
+		{`{ ...M } fragment M on Movie { a } fragment M on Movie { b }`, `there can be only one fragment named "M"`, Location{1, 36}},
+	}
+	for _, test := range tests {
+		_, err := parse(test.source)
+		if err == nil {
+			t.Errorf("%q: no error", test.source)
+			continue
+		}
+		got := err.(*Error)
+		if got.Message != test.message || !reflect.DeepEqual(got.Locations, []Location{test.loc}) {
+			t.Errorf("%q: got %q at %v, want %q at %v", test.source, got.Message, got.Locations, test.message, test.loc)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+// Package graphql executes GraphQL queries and mutations against a schema defined in Go.
+// It covers the parts of the language an API needs: operations, variables, fragments,
+// @include and @skip, aliases and __typename. Interfaces, unions, subscriptions and
+// introspection queries are not supported, Schema.SDL describes the schema instead.
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Location is a position in a request, lines and columns start at 1
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Error is an error of a response
+type Error struct {
+	Message    string                 `json:"message"`
+	Locations  []Location             `json:"locations,omitempty"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func newError(message string, loc Location) *Error {
+	return &Error{Message: message, Locations: []Location{loc}}
+}
+
+// Type is a scalar, an enum, an object, an input object, a list or a non null type
+type Type interface {
+	String() string
+}
+
+// Scalar is a leaf value, ParseValue coerces an input and Serialize an output
+type Scalar struct {
+	Name        string
+	Description string
+	ParseValue  func(value interface{}) (interface{}, error)
+	Serialize   func(value interface{}) (interface{}, error)
+}
+
+func (t *Scalar) String() string { return t.Name }
+
+// Enum is a leaf value among a set of names
+type Enum struct {
+	Name        string
+	Description string
+	Values      []string
+}
+
+func (t *Enum) String() string { return t.Name }
+
+func (t *Enum) has(name string) bool {
+	for _, value := range t.Values {
+		if value == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Object is an output type with fields
+type Object struct {
+	Name        string
+	Description string
+	Fields      map[string]*Field
+}
+
+func (t *Object) String() string { return t.Name }
+
+// InputObject is an argument made of fields
+type InputObject struct {
+	Name        string
+	Description string
+	Fields      map[string]*Argument
+}
+
+func (t *InputObject) String() string { return t.Name }
+
+// List is a list of a type
+type List struct {
+	Of Type
+}
+
+func (t *List) String() string { return "[" + t.Of.String() + "]" }
+
+// NonNull is a type that cannot be null
+type NonNull struct {
+	Of Type
+}
+
+func (t *NonNull) String() string { return t.Of.String() + "!" }
+
+// ResolveFunc returns the value of a field of source, args hold the given arguments and the defaults
+type ResolveFunc func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error)
+
+// Field is a field of an object, without a resolver its value is source[name] when source is a map[string]interface{}
+type Field struct {
+	Description string
+	Type        Type
+	Args        map[string]*Argument
+	Resolve     ResolveFunc
+	// Multiplier tells how many times the selection of the field is resolved, for the complexity of a
+	// request. It is the page size of a list field, 1 when nil.
+	Multiplier func(args map[string]interface{}) int
+}
+
+// Argument is an argument of a field or a field of an input object
+type Argument struct {
+	Description string
+	Type        Type
+	Default     interface{}
+}
+
+// Schema is the root types of a GraphQL API and the limits of its requests
+type Schema struct {
+	Query    *Object
+	Mutation *Object
+	// MaxDepth is the deepest nesting of selection sets, 0 for no limit
+	MaxDepth int
+	// MaxComplexity is the highest complexity of a request, the number of fields it resolves
+	// counting the multipliers of list fields, 0 for no limit
+	MaxComplexity int
+	// FormatError turns an error of a resolver into the message and extensions of a response error,
+	// the message of the error is used when nil
+	FormatError func(err error) (string, map[string]interface{})
+}
+
+// types returns the named types reachable from the root types
+func (s *Schema) types() map[string]Type {
+	types := map[string]Type{}
+	for _, scalar := range []*Scalar{String, Int, Float, Boolean, ID} {
+		types[scalar.Name] = scalar
+	}
+	var walk func(t Type)
+	walk = func(t Type) {
+		switch t := t.(type) {
+		case *List:
+			walk(t.Of)
+		case *NonNull:
+			walk(t.Of)
+		case *Object:
+			if t == nil || types[t.Name] != nil {
+				return
+			}
+			types[t.Name] = t
+			for _, field := range t.Fields {
+				walk(field.Type)
+				for _, arg := range field.Args {
+					walk(arg.Type)
+				}
+			}
+		case *InputObject:
+			if types[t.Name] != nil {
+				return
+			}
+			types[t.Name] = t
+			for _, field := range t.Fields {
+				walk(field.Type)
+			}
+		case *Scalar:
+			types[t.Name] = t
+		case *Enum:
+			types[t.Name] = t
+		}
+	}
+	walk(s.Query)
+	if s.Mutation != nil {
+		walk(s.Mutation)
+	}
+	return types
+}
+
+// String is a UTF-8 text
+var String = &Scalar{
+	Name: "String",
+	ParseValue: func(value interface{}) (interface{}, error) {
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("String cannot represent a non string value")
+		}
+		return s, nil
+	},
+	Serialize: func(value interface{}) (interface{}, error) {
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case fmt.Stringer:
+			return v.String(), nil
+		}
+		return fmt.Sprint(value), nil
+	},
+}
+
+// ID is a unique identifier, serialized as a string
+var ID = &Scalar{
+	Name: "ID",
+	ParseValue: func(value interface{}) (interface{}, error) {
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case int:
+			return strconv.Itoa(v), nil
+		}
+		return nil, fmt.Errorf("ID cannot represent a non string and non integer value")
+	},
+	Serialize: func(value interface{}) (interface{}, error) {
+		return fmt.Sprint(value), nil
+	},
+}
+
+// Int is a signed 32 bit integer
+var Int = &Scalar{
+	Name: "Int",
+	ParseValue: func(value interface{}) (interface{}, error) {
+		switch v := value.(type) {
+		case int:
+			if v > math.MaxInt32 || v < math.MinInt32 {
+				return nil, fmt.Errorf("Int cannot represent a non 32 bit integer")
+			}
+			return v, nil
+		case float64:
+			if v != math.Trunc(v) || v > math.MaxInt32 || v < math.MinInt32 {
+				return nil, fmt.Errorf("Int cannot represent a non 32 bit integer")
+			}
+			return int(v), nil
+		}
+		return nil, fmt.Errorf("Int cannot represent a non integer value")
+	},
+	Serialize: func(value interface{}) (interface{}, error) {
+		switch v := value.(type) {
+		case int:
+			return v, nil
+		case int32:
+			return int(v), nil
+		case int64:
+			return int(v), nil
+		}
+		return nil, fmt.Errorf("Int cannot represent %v", value)
+	},
+}
+
+// Float is a double precision number
+var Float = &Scalar{
+	Name: "Float",
+	ParseValue: func(value interface{}) (interface{}, error) {
+		switch v := value.(type) {
+		case int:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		}
+		return nil, fmt.Errorf("Float cannot represent a non numeric value")
+	},
+	Serialize: func(value interface{}) (interface{}, error) {
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case float32:
+			// through the shortest decimal of the float32, so that 7.9 is not 7.900000095367432
+			f, _ := strconv.ParseFloat(strconv.FormatFloat(float64(v), 'g', -1, 32), 64)
+			return f, nil
+		case int:
+			return float64(v), nil
+		}
+		return nil, fmt.Errorf("Float cannot represent %v", value)
+	},
+}
+
+// Boolean is true or false
+var Boolean = &Scalar{
+	Name: "Boolean",
+	ParseValue: func(value interface{}) (interface{}, error) {
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("Boolean cannot represent a non boolean value")
+		}
+		return b, nil
+	},
+	Serialize: func(value interface{}) (interface{}, error) {
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("Boolean cannot represent %v", value)
+		}
+		return b, nil
+	},
+}
@@ -0,0 +1,117 @@
+package graphql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SDL returns the schema in the GraphQL schema definition language, its types sorted by name
+func (s *Schema) SDL() string {
+	b := &strings.Builder{}
+	b.WriteString("schema {\n  query: " + s.Query.Name + "\n")
+	if s.Mutation != nil {
+		b.WriteString("  mutation: " + s.Mutation.Name + "\n")
+	}
+	b.WriteString("}\n")
+
+	types := s.types()
+	names := []string{}
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		switch t := types[name].(type) {
+		case *Object:
+			b.WriteString("\n")
+			writeDescription(b, "", t.Description)
+			fmt.Fprintf(b, "type %s {\n", t.Name)
+			for _, fieldName := range sortedKeys(t.Fields) {
+				field := t.Fields[fieldName]
+				writeDescription(b, "  ", field.Description)
+				fmt.Fprintf(b, "  %s%s: %s\n", fieldName, sdlArguments(field.Args), field.Type)
+			}
+			b.WriteString("}\n")
+		case *InputObject:
+			b.WriteString("\n")
+			writeDescription(b, "", t.Description)
+			fmt.Fprintf(b, "input %s {\n", t.Name)
+			for _, fieldName := range sortedKeys(t.Fields) {
+				writeDescription(b, "  ", t.Fields[fieldName].Description)
+				fmt.Fprintf(b, "  %s\n", sdlArgument(fieldName, t.Fields[fieldName]))
+			}
+			b.WriteString("}\n")
+		case *Enum:
+			b.WriteString("\n")
+			writeDescription(b, "", t.Description)
+			fmt.Fprintf(b, "enum %s {\n  %s\n}\n", t.Name, strings.Join(t.Values, "\n  "))
+		case *Scalar:
+			if t != String && t != Int && t != Float && t != Boolean && t != ID {
+				b.WriteString("\n")
+				writeDescription(b, "", t.Description)
+				fmt.Fprintf(b, "scalar %s\n", t.Name)
+			}
+		}
+	}
+	return b.String()
+}
+
+func sdlArguments(args map[string]*Argument) string {
+	if len(args) == 0 {
+		return ""
+	}
+	list := []string{}
+	for _, name := range sortedKeys(args) {
+		list = append(list, sdlArgument(name, args[name]))
+	}
+	return "(" + strings.Join(list, ", ") + ")"
+}
+
+func sdlArgument(name string, arg *Argument) string {
+	s := name + ": " + arg.Type.String()
+	if arg.Default != nil {
+		s += " = " + sdlValue(arg.Default, arg.Type)
+	}
+	return s
+}
+
+// sdlValue writes a default value as a literal
+func sdlValue(v interface{}, t Type) string {
+	switch v := v.(type) {
+	case string:
+		if _, ok := namedType(t).(*Enum); ok {
+			return v
+		}
+		return fmt.Sprintf("%q", v)
+	case []interface{}:
+		items := []string{}
+		for _, item := range v {
+			items = append(items, sdlValue(item, t))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	return fmt.Sprint(v)
+}
+
+func writeDescription(b *strings.Builder, indent, description string) {
+	if description != "" {
+		fmt.Fprintf(b, "%s%q\n", indent, description)
+	}
+}
+
+func sortedKeys(m interface{}) []string {
+	keys := []string{}
+	switch m := m.(type) {
+	case map[string]*Field:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[string]*Argument:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
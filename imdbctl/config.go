@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/raazcrzy/imdb/client"
+)
+
+/*
+Credentials come from a profile of the config file, ~/.imdbctl by default, or the file named by the IMDBCTL_CONFIG
+env var or the -config flag. Each profile is a section of key = value lines:
+
+	[default]
+	url = http://localhost:8000
+	user_name = pnc_raj
+	password = alpha_Imdb
+
+	[prod]
+	url = https://imdb.example.com
+	user_name = ...
+	password = ...
+	api_key = ...
+
+url is required, along with user_name and password, which the API checks, or a token. token and api_key are for
+gateways in front of the API: token is sent as a bearer token instead of basic auth, api_key in the X-API-Key header
+along with it. The profile is picked with the -profile flag or the IMDBCTL_PROFILE env var, default otherwise.
+*/
+
+// profile is a section of the config file
+type profile map[string]string
+
+// configPath returns the path of the config file, flagValue when it is set
+func configPath(flagValue string) (string, error) {
+	if flagValue != "" {
+		return flagValue, nil
+	}
+	if path := os.Getenv("IMDBCTL_CONFIG"); path != "" {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".imdbctl"), nil
+}
+
+// readProfile reads a profile of the config file
+func readProfile(path, name string) (profile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the config file: %w", err)
+	}
+	defer file.Close()
+
+	profiles := map[string]profile{}
+	var current profile
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			current = profile{}
+			profiles[strings.TrimSpace(line[1:len(line)-1])] = current
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || current == nil {
+			return nil, fmt.Errorf("%s:%d: expected [profile] or key = value", path, n)
+		}
+		current[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	selected, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("profile %q not found in %s", name, path)
+	}
+	if selected["url"] == "" {
+		return nil, fmt.Errorf("profile %q of %s has no url", name, path)
+	}
+	return selected, nil
+}
+
+// newClient returns a client of the API of a profile
+func newClient(p profile) (*client.Client, error) {
+	options := []client.Option{client.WithUserAgent("imdbctl")}
+	switch {
+	case p["token"] != "":
+		options = append(options, client.WithToken(p["token"]))
+	case p["user_name"] != "" && p["password"] != "":
+		options = append(options, client.WithBasicAuth(p["user_name"], p["password"]))
+	default:
+		return nil, fmt.Errorf("the profile needs user_name and password, or a token")
+	}
+	if p["api_key"] != "" {
+		options = append(options, client.WithAPIKey(p["api_key"]))
+	}
+	return client.New(p["url"], options...)
+}
@@ -0,0 +1,167 @@
+// imdbctl manages the movies and users of the catalogue through its API, with the credentials of a config file profile.
+//
+//	imdbctl movies search [flags]          searches movies, 20 by default, -all for every match
+//	imdbctl movies get ID                  prints a movie
+//	imdbctl movies add [flags]             adds a movie from flags or a JSON file and prints its id
+//	imdbctl movies update [flags] ID       changes the fields of a movie given as flags
+//	imdbctl movies delete ID...            deletes movies
+//	imdbctl movies import [flags] FILE     adds the movies of a JSON array or JSON lines file, - for stdin
+//	imdbctl movies export [flags]          prints the movies matching a search as JSON lines
+//	imdbctl users add [flags]              adds a user, -role admin for an admin
+//	imdbctl users delete EMAIL...          deletes users
+//
+// Every command takes -profile, -config and -o table|json, before its arguments.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/raazcrzy/imdb/client"
+)
+
+// command is a subcommand, run with the arguments that follow its name
+type command struct {
+	usage string
+	run   func(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error
+}
+
+var commands = map[string]command{
+	"movies search": {"[flags]", searchMoviesCommand},
+	"movies get":    {"[flags] ID", getMovieCommand},
+	"movies add":    {"[flags]", addMovieCommand},
+	"movies update": {"[flags] ID", updateMovieCommand},
+	"movies delete": {"[flags] ID...", deleteMoviesCommand},
+	"movies import": {"[flags] FILE", importMoviesCommand},
+	"movies export": {"[flags]", exportMoviesCommand},
+	"users add":     {"[flags]", addUserCommand},
+	"users delete":  {"[flags] EMAIL...", deleteUsersCommand},
+}
+
+func main() {
+	logger := log.New(os.Stderr, "imdbctl: ", 0)
+	args := os.Args[1:]
+	if len(args) < 2 {
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+	cmd, ok := commands[args[0]+" "+args[1]]
+	if !ok {
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+	err := cmd.run(context.Background(), args[2:], os.Stdin, os.Stdout)
+	if err == flag.ErrHelp {
+		os.Exit(2)
+	}
+	if err != nil {
+		logger.Fatalln(err)
+	}
+}
+
+func usage(w io.Writer) {
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w, "usage:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  imdbctl %s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintln(w, "run a command with -h for its flags")
+}
+
+// globalFlags are the flags of every command
+type globalFlags struct {
+	config  string
+	profile string
+	output  string
+}
+
+// newFlagSet returns the flag set of a command, with the global flags registered
+func newFlagSet(name string) (*flag.FlagSet, *globalFlags) {
+	fs := flag.NewFlagSet("imdbctl "+name, flag.ContinueOnError)
+	g := &globalFlags{}
+	defaultProfile := os.Getenv("IMDBCTL_PROFILE")
+	if defaultProfile == "" {
+		defaultProfile = "default"
+	}
+	fs.StringVar(&g.config, "config", "", "config file, IMDBCTL_CONFIG or ~/.imdbctl by default")
+	fs.StringVar(&g.profile, "profile", defaultProfile, "profile of the config file, IMDBCTL_PROFILE or default by default")
+	fs.StringVar(&g.output, "o", "table", "output format: table or json")
+	return fs, g
+}
+
+// client returns a client of the API of the selected profile
+func (g *globalFlags) client() (*client.Client, error) {
+	if g.output != "table" && g.output != "json" {
+		return nil, fmt.Errorf("-o must be table or json")
+	}
+	path, err := configPath(g.config)
+	if err != nil {
+		return nil, err
+	}
+	p, err := readProfile(path, g.profile)
+	if err != nil {
+		return nil, err
+	}
+	return newClient(p)
+}
+
+// parseFlags parses the flags of a command and checks the number of its other arguments, -1 for any but none
+func parseFlags(fs *flag.FlagSet, args []string, want int) ([]string, error) {
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	rest := fs.Args()
+	if (want >= 0 && len(rest) != want) || (want < 0 && len(rest) == 0) {
+		return nil, fmt.Errorf("%s: wrong number of arguments, run it with -h for its usage", fs.Name())
+	}
+	return rest, nil
+}
+
+// stringList is a flag that can be repeated, each value may hold several comma separated items
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// optionalFloat is a number flag that tells whether it was set
+type optionalFloat struct {
+	value *float64
+}
+
+func (f *optionalFloat) String() string {
+	if f.value == nil {
+		return ""
+	}
+	return fmt.Sprint(*f.value)
+}
+
+func (f *optionalFloat) Set(value string) error {
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("not a number")
+	}
+	f.value = &n
+	return nil
+}
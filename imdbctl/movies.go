@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/raazcrzy/imdb/client"
+	"github.com/raazcrzy/imdb/models"
+)
+
+// searchFlags registers the flags of a movie search
+func searchFlags(fs *flag.FlagSet) func() client.SearchParams {
+	params := client.SearchParams{}
+	var genres, excluded stringList
+	var minScore, maxScore, minPopularity, maxPopularity optionalFloat
+	fs.StringVar(&params.Name, "name", "", "text searched in the movie names")
+	fs.StringVar(&params.Director, "director", "", "text searched in the director names")
+	fs.Var(&genres, "genre", "genre of the movies, can be repeated or comma separated")
+	fs.BoolVar(&params.MatchAll, "all-genres", false, "movies need every -genre instead of any")
+	fs.Var(&excluded, "exclude-genre", "genre the movies must not have, can be repeated or comma separated")
+	fs.Var(&minScore, "min-score", "lowest imdb_score")
+	fs.Var(&maxScore, "max-score", "highest imdb_score")
+	fs.Var(&minPopularity, "min-popularity", "lowest 99popularity")
+	fs.Var(&maxPopularity, "max-popularity", "highest 99popularity")
+	fs.StringVar(&params.Query, "q", "", `query language, for example 'director:"george lucas" score>=8'`)
+	fs.StringVar(&params.Sort, "sort", "", "comma separated field:order pairs")
+	fs.StringVar(&params.Ranking, "ranking", "", "ranking profile")
+	return func() client.SearchParams {
+		params.Genres, params.ExcludeGenres = genres, excluded
+		params.MinScore, params.MaxScore = minScore.value, maxScore.value
+		params.MinPopularity, params.MaxPopularity = minPopularity.value, maxPopularity.value
+		return params
+	}
+}
+
+// searchMoviesCommand prints the movies matching a search, up to -limit of them
+func searchMoviesCommand(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	fs, g := newFlagSet("movies search")
+	search := searchFlags(fs)
+	limit := fs.Int("limit", 20, "number of movies to print")
+	all := fs.Bool("all", false, "print every matching movie")
+	_, err := parseFlags(fs, args, 0)
+	if err != nil {
+		return err
+	}
+	c, err := g.client()
+	if err != nil {
+		return err
+	}
+	params := search()
+	params.Size = 100
+	if !*all && *limit < 100 {
+		params.Size = *limit
+	}
+	movies := []models.Movie{}
+	it := c.Movies.Iterate(ctx, params)
+	for (*all || len(movies) < *limit) && it.Next() {
+		movies = append(movies, it.Movie().Movie)
+	}
+	if err = it.Err(); err != nil {
+		return err
+	}
+	return printMovies(stdout, g.output, movies)
+}
+
+// exportMoviesCommand prints the movies matching a search as JSON lines, which movies import reads
+func exportMoviesCommand(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	fs, g := newFlagSet("movies export")
+	search := searchFlags(fs)
+	_, err := parseFlags(fs, args, 0)
+	if err != nil {
+		return err
+	}
+	c, err := g.client()
+	if err != nil {
+		return err
+	}
+	params := search()
+	params.Size = 100
+	writer := bufio.NewWriter(stdout)
+	encoder := json.NewEncoder(writer)
+	it := c.Movies.Iterate(ctx, params)
+	for it.Next() {
+		err = encoder.Encode(it.Movie().Movie)
+		if err != nil {
+			return err
+		}
+	}
+	if err = it.Err(); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// getMovieCommand prints a movie
+func getMovieCommand(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	fs, g := newFlagSet("movies get")
+	rest, err := parseFlags(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	c, err := g.client()
+	if err != nil {
+		return err
+	}
+	movie, err := c.Movies.Get(ctx, rest[0])
+	if err != nil {
+		return err
+	}
+	if g.output == "json" {
+		return printJSON(stdout, movie)
+	}
+	return printMovies(stdout, g.output, []models.Movie{movie})
+}
+
+// movieFlags registers the flags of the fields of a movie, apply sets the fields whose flag was given and tells whether there were any
+func movieFlags(fs *flag.FlagSet) (apply func(movie *models.Movie) bool) {
+	name := fs.String("name", "", "name of the movie")
+	director := fs.String("director", "", "director of the movie")
+	var genres stringList
+	fs.Var(&genres, "genre", "genre of the movie, can be repeated or comma separated")
+	score := fs.Float64("score", 0, "imdb_score, from 0 to 10")
+	popularity := fs.Float64("popularity", 0, "99popularity, from 0 to 99")
+	return func(movie *models.Movie) bool {
+		changed := false
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "name":
+				movie.Name = *name
+			case "director":
+				movie.Director = *director
+			case "genre":
+				movie.Genre = genres
+			case "score":
+				movie.IMDBScore = float32(*score)
+			case "popularity":
+				movie.Popularity = float32(*popularity)
+			default:
+				return
+			}
+			changed = true
+		})
+		return changed
+	}
+}
+
+// addMovieCommand adds a movie read from -file, with the fields given as flags, and prints its id
+func addMovieCommand(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	fs, g := newFlagSet("movies add")
+	apply := movieFlags(fs)
+	file := fs.String("file", "", "JSON file of the movie, - for stdin, flags override its fields")
+	_, err := parseFlags(fs, args, 0)
+	if err != nil {
+		return err
+	}
+	c, err := g.client()
+	if err != nil {
+		return err
+	}
+	movie := models.Movie{}
+	if *file != "" {
+		movies, err := readMovies(*file, stdin)
+		if err != nil {
+			return err
+		}
+		if len(movies) != 1 {
+			return fmt.Errorf("%s holds %d movies, use movies import to add several", *file, len(movies))
+		}
+		movie = movies[0]
+	}
+	apply(&movie)
+	id, err := c.Movies.Create(ctx, movie)
+	if err != nil {
+		return err
+	}
+	return printMessage(stdout, g.output, "movie_id", id, id)
+}
+
+// updateMovieCommand changes the fields of a movie given as flags, the others keep their value
+func updateMovieCommand(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	fs, g := newFlagSet("movies update")
+	apply := movieFlags(fs)
+	rest, err := parseFlags(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	c, err := g.client()
+	if err != nil {
+		return err
+	}
+	movie, err := c.Movies.Get(ctx, rest[0])
+	if err != nil {
+		return err
+	}
+	if !apply(&movie) {
+		return fmt.Errorf("nothing to update, give the new values as flags")
+	}
+	err = c.Movies.Update(ctx, movie)
+	if err != nil {
+		return err
+	}
+	return printMessage(stdout, g.output, "movie_id", movie.ID, "updated "+movie.ID)
+}
+
+// deleteMoviesCommand deletes movies, stopping at the first that cannot be deleted
+func deleteMoviesCommand(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	fs, g := newFlagSet("movies delete")
+	ids, err := parseFlags(fs, args, -1)
+	if err != nil {
+		return err
+	}
+	c, err := g.client()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		err = c.Movies.Delete(ctx, id)
+		if err != nil {
+			return fmt.Errorf("cannot delete %s: %w", id, err)
+		}
+		if g.output == "table" {
+			fmt.Fprintln(stdout, "deleted", id)
+		}
+	}
+	if g.output == "json" {
+		return printJSON(stdout, map[string]interface{}{"deleted": ids})
+	}
+	return nil
+}
+
+// importResult is the outcome of importing a movie of a file
+type importResult struct {
+	Index int    `json:"index"`
+	ID    string `json:"movie_id,omitempty"`
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+}
+
+// importMoviesCommand adds the movies of a file, or updates the ones with a movie_id with -update.
+// Every movie is tried, the command fails when one of them could not be imported.
+func importMoviesCommand(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	fs, g := newFlagSet("movies import")
+	update := fs.Bool("update", false, "update the movies having a movie_id instead of adding them")
+	rest, err := parseFlags(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	c, err := g.client()
+	if err != nil {
+		return err
+	}
+	movies, err := readMovies(rest[0], stdin)
+	if err != nil {
+		return err
+	}
+	results := []importResult{}
+	failed := 0
+	for i, movie := range movies {
+		result := importResult{Index: i, ID: movie.ID, Name: movie.Name}
+		if *update && movie.ID != "" {
+			err = c.Movies.Update(ctx, movie)
+		} else {
+			result.ID, err = c.Movies.Create(ctx, movie)
+		}
+		if err != nil {
+			failed++
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	if g.output == "json" {
+		err = printJSON(stdout, results)
+	} else {
+		rows := [][]string{}
+		for _, result := range results {
+			status := "ok"
+			if result.Error != "" {
+				status = result.Error
+			}
+			rows = append(rows, []string{strconv.Itoa(result.Index), result.ID, result.Name, status})
+		}
+		err = printTable(stdout, []string{"#", "ID", "NAME", "RESULT"}, rows)
+	}
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d movies could not be imported", failed, len(movies))
+	}
+	return nil
+}
+
+// readMovies reads the movies of a file holding a JSON array of movies or one JSON movie per line, - is stdin
+func readMovies(path string, stdin io.Reader) ([]models.Movie, error) {
+	reader := stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		reader = file
+	}
+	buffered := bufio.NewReader(reader)
+	decoder := json.NewDecoder(buffered)
+	decoder.DisallowUnknownFields()
+	movies := []models.Movie{}
+	first, err := firstByte(buffered)
+	if err != nil {
+		return nil, err
+	}
+	if first == '[' {
+		err = decoder.Decode(&movies)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return movies, nil
+	}
+	for decoder.More() {
+		movie := models.Movie{}
+		err = decoder.Decode(&movie)
+		if err != nil {
+			return nil, fmt.Errorf("%s: movie %d: %w", path, len(movies), err)
+		}
+		movies = append(movies, movie)
+	}
+	return movies, nil
+}
+
+// firstByte returns the first byte of a reader that is not white space, without consuming it
+func firstByte(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.Peek(1)
+		if err == io.EOF {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if b[0] != ' ' && b[0] != '\t' && b[0] != '\n' && b[0] != '\r' {
+			return b[0], nil
+		}
+		reader.ReadByte()
+	}
+}
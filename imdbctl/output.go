@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/raazcrzy/imdb/models"
+)
+
+// printJSON prints a value as indented JSON
+func printJSON(w io.Writer, value interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// printTable prints rows aligned in columns under a header
+func printTable(w io.Writer, header []string, rows [][]string) error {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(table, strings.Join(row, "\t"))
+	}
+	return table.Flush()
+}
+
+// printMovies prints movies as a table, or as a JSON array
+func printMovies(w io.Writer, output string, movies []models.Movie) error {
+	if output == "json" {
+		return printJSON(w, movies)
+	}
+	rows := [][]string{}
+	for _, movie := range movies {
+		rows = append(rows, []string{movie.ID, movie.Name, movie.Director, strings.Join(movie.Genre, ", "),
+			formatNumber(movie.IMDBScore), formatNumber(movie.Popularity)})
+	}
+	return printTable(w, []string{"ID", "NAME", "DIRECTOR", "GENRE", "SCORE", "POPULARITY"}, rows)
+}
+
+// printMessage prints the outcome of a change, as {"key": value} in JSON
+func printMessage(w io.Writer, output, key string, value interface{}, message string) error {
+	if output == "json" {
+		return printJSON(w, map[string]interface{}{key: value})
+	}
+	_, err := fmt.Fprintln(w, message)
+	return err
+}
+
+func formatNumber(n float32) string {
+	return strconv.FormatFloat(float64(n), 'f', -1, 32)
+}
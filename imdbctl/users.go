@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/raazcrzy/imdb/models"
+)
+
+// addUserCommand adds a user, the password is read from stdin when -password is not given
+func addUserCommand(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	fs, g := newFlagSet("users add")
+	user := models.User{}
+	fs.StringVar(&user.Email, "email", "", "email of the user")
+	fs.StringVar(&user.Name, "name", "", "name of the user")
+	fs.StringVar(&user.UserName, "user-name", "", "user name the user logs in with")
+	fs.StringVar(&user.UserPassword, "password", "", "password of the user, read from the first line of stdin when not given")
+	fs.StringVar(&user.Role, "role", "user", "role of the user: user or admin, only admins can add admins")
+	_, err := parseFlags(fs, args, 0)
+	if err != nil {
+		return err
+	}
+	c, err := g.client()
+	if err != nil {
+		return err
+	}
+	if user.UserPassword == "" {
+		line, err := bufio.NewReader(stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		user.UserPassword = strings.TrimRight(line, "\r\n")
+	}
+	err = c.Users.Create(ctx, user)
+	if err != nil {
+		return err
+	}
+	return printMessage(stdout, g.output, "email", user.Email, fmt.Sprintf("added %s as %s", user.Email, user.Role))
+}
+
+// deleteUsersCommand deletes users, stopping at the first that cannot be deleted
+func deleteUsersCommand(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	fs, g := newFlagSet("users delete")
+	emails, err := parseFlags(fs, args, -1)
+	if err != nil {
+		return err
+	}
+	c, err := g.client()
+	if err != nil {
+		return err
+	}
+	for _, email := range emails {
+		err = c.Users.Delete(ctx, email)
+		if err != nil {
+			return fmt.Errorf("cannot delete %s: %w", email, err)
+		}
+		if g.output == "table" {
+			fmt.Fprintln(stdout, "deleted", email)
+		}
+	}
+	if g.output == "json" {
+		return printJSON(stdout, map[string]interface{}{"deleted": emails})
+	}
+	return nil
+}
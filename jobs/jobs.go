@@ -0,0 +1,568 @@
+// Package jobs runs long operations in the background, from a queue kept in the imdb.jobs table.
+//
+// Every server runs workers claiming the queued jobs of the types it knows. At most one job of a type runs at a
+// time across all servers: a unique index on the type of the running jobs makes a second claim fail. A running
+// job records a heartbeat every few seconds, and one whose heartbeat stopped, because its server died, is queued
+// again or failed. Claiming a job increments its attempts, which fence the updates of an attempt: an attempt whose
+// job was reaped meanwhile changes nothing, and stops at its next heartbeat. Failed jobs are retried with the policy
+// of their type, and cancelled at the next heartbeat when a cancellation is requested while they run.
+//
+// Besides its result, an attempt can write an output of any size, kept in chunks in the imdb.job_output table and
+// read once the job succeeded. A job can also be queued with an input of any size, kept in chunks in the
+// imdb.job_input table, which its attempts read.
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/raazcrzy/imdb/models"
+)
+
+// Job statuses
+const (
+	Queued    = "queued"
+	Running   = "running"
+	Succeeded = "succeeded"
+	Failed    = "failed"
+	Canceled  = "canceled"
+)
+
+// Errors returned for the requests that cannot be fulfilled
+var (
+	ErrUnknownType = errors.New("unknown job type")
+	ErrFinished    = errors.New("the job is finished")
+	// errLostClaim is returned by the updates of an attempt whose job was reaped, and maybe claimed again, meanwhile
+	errLostClaim = errors.New("the attempt lost its claim on the job")
+)
+
+const (
+	poll = time.Second
+	// heartbeat is how often a running job records that it is alive and looks for a cancellation,
+	// a job that has not done so for staleAfter is considered abandoned
+	heartbeat  = 5 * time.Second
+	staleAfter = time.Minute
+	// finished jobs are kept for retention
+	retention = 30 * 24 * time.Hour
+	// inputChunkSize is the size of the chunks the input of a job is stored in
+	inputChunkSize = 1 << 20
+)
+
+// Policy tells how the jobs of a type are retried. A failed attempt is retried until MaxAttempts were made,
+// after Backoff, doubled after each attempt. An attempt running longer than Timeout fails, 0 means no limit.
+type Policy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	Timeout     time.Duration
+}
+
+// Type is a kind of job. Validate checks the params of a job before it is queued, Run does the work and returns
+// the result of the job, which is marshalled to JSON. Run should return when its context is done, which happens
+// when the job is cancelled or times out.
+type Type struct {
+	Policy
+	Validate func(params json.RawMessage) error
+	Run      func(ctx context.Context, run *Run) (interface{}, error)
+}
+
+// Run is an attempt of a job, passed to the Run function of its type
+type Run struct {
+	Job    models.Job
+	queue  *Queue
+	chunks int
+}
+
+// Params decodes the params of the job
+func (r *Run) Params(v interface{}) error {
+	return json.Unmarshal(r.Job.Params, v)
+}
+
+// Progress records how much of the job is done, it fails once the attempt lost its claim on the job
+func (r *Run) Progress(done, total int64) error {
+	return r.queue.update(r.Job, `done=$1, total=$2, updated_at=$3, heartbeat_at=$3`, done, total, time.Now().Unix())
+}
+
+// WriteOutput appends a chunk to the output of the attempt, it fails once the attempt lost its claim on the job
+func (r *Run) WriteOutput(chunk []byte) error {
+	result, err := r.queue.db.Exec(`INSERT INTO imdb.job_output(job_id, attempt, seq, data)
+	SELECT id, attempts, $1, $2 FROM imdb.jobs WHERE id=$3 AND status=$4 AND attempts=$5`, r.chunks, chunk, r.Job.ID, Running, r.Job.Attempts)
+	if err != nil {
+		return err
+	}
+	written, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if written == 0 {
+		return errLostClaim
+	}
+	r.chunks++
+	return nil
+}
+
+// Input returns a reader of the input the job was queued with, empty when it has none
+func (r *Run) Input() io.Reader {
+	return &inputReader{db: r.queue.db, jobID: r.Job.ID}
+}
+
+// inputReader reads the input of a job a chunk at a time
+type inputReader struct {
+	db    *sql.DB
+	jobID int64
+	seq   int
+	chunk []byte
+}
+
+func (r *inputReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		err := r.db.QueryRow(`SELECT data FROM imdb.job_input WHERE job_id=$1 AND seq=$2`, r.jobID, r.seq).Scan(&r.chunk)
+		if err == sql.ErrNoRows {
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, err
+		}
+		r.seq++
+	}
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+// permanentError is an error of a job not worth retrying
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+// Permanent marks an error returned by Run as not worth retrying, the job fails right away
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// Queue queues the jobs in Postgres and runs them
+type Queue struct {
+	db    *sql.DB
+	types map[string]Type
+	wake  chan struct{}
+}
+
+// NewQueue returns a queue of the imdb.jobs table of db, without types
+func NewQueue(db *sql.DB) *Queue {
+	return &Queue{db: db, types: map[string]Type{}, wake: make(chan struct{}, 1)}
+}
+
+// Register adds a type of job the queue accepts and runs
+func (q *Queue) Register(name string, jobType Type) {
+	if jobType.MaxAttempts < 1 {
+		jobType.MaxAttempts = 1
+	}
+	q.types[name] = jobType
+}
+
+// Types returns the names of the registered types, sorted
+func (q *Queue) Types() []string {
+	names := []string{}
+	for name := range q.types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+const jobColumns = `id, type, status, params, done, total, result, error, attempts, max_attempts, cancel_requested,
+	run_at, created_at, started_at, updated_at, finished_at`
+
+func scanJob(row interface{ Scan(...interface{}) error }) (models.Job, error) {
+	job := models.Job{}
+	var params, result []byte
+	var jobError sql.NullString
+	var startedAt, finishedAt sql.NullInt64
+	err := row.Scan(&job.ID, &job.Type, &job.Status, &params, &job.Done, &job.Total, &result, &jobError, &job.Attempts,
+		&job.MaxAttempts, &job.CancelRequested, &job.RunAt, &job.CreatedAt, &startedAt, &job.UpdatedAt, &finishedAt)
+	job.Params, job.Result = params, result
+	job.Error, job.StartedAt, job.FinishedAt = jobError.String, startedAt.Int64, finishedAt.Int64
+	return job, err
+}
+
+// Enqueue validates the params of a job of a registered type and queues it
+func (q *Queue) Enqueue(name string, params json.RawMessage) (models.Job, error) {
+	jobType, ok := q.types[name]
+	if !ok {
+		return models.Job{}, ErrUnknownType
+	}
+	if len(params) == 0 || string(params) == "null" {
+		params = json.RawMessage(`{}`)
+	}
+	if jobType.Validate != nil {
+		err := jobType.Validate(params)
+		if err != nil {
+			return models.Job{}, err
+		}
+	}
+	job, err := insertJob(q.db, name, params, jobType.MaxAttempts)
+	if err != nil {
+		return models.Job{}, err
+	}
+	q.Wake()
+	return job, nil
+}
+
+// EnqueueInput queues a job of a registered type along with an input read until EOF, which its attempts read with
+// Run.Input. The job is only visible once the whole input is stored, an error reading it queues nothing and is
+// returned, so the caller can check the input as it is read. The params are not validated, the caller builds them.
+func (q *Queue) EnqueueInput(name string, params json.RawMessage, input io.Reader) (models.Job, error) {
+	jobType, ok := q.types[name]
+	if !ok {
+		return models.Job{}, ErrUnknownType
+	}
+	if len(params) == 0 || string(params) == "null" {
+		params = json.RawMessage(`{}`)
+	}
+	tx, err := q.db.Begin()
+	if err != nil {
+		return models.Job{}, err
+	}
+	defer tx.Rollback()
+	job, err := insertJob(tx, name, params, jobType.MaxAttempts)
+	if err != nil {
+		return models.Job{}, err
+	}
+	chunk := make([]byte, inputChunkSize)
+	for seq := 0; ; seq++ {
+		n, err := io.ReadFull(input, chunk)
+		if n > 0 {
+			_, insertErr := tx.Exec(`INSERT INTO imdb.job_input(job_id, seq, data) VALUES($1, $2, $3)`, job.ID, seq, chunk[:n])
+			if insertErr != nil {
+				return models.Job{}, insertErr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return models.Job{}, err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return models.Job{}, err
+	}
+	q.Wake()
+	return job, nil
+}
+
+// jobQuerier runs the statement inserting a job, either directly or within a transaction
+type jobQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// insertJob inserts a queued job
+func insertJob(q jobQuerier, name string, params json.RawMessage, maxAttempts int) (models.Job, error) {
+	now := time.Now().Unix()
+	return scanJob(q.QueryRow(`INSERT INTO imdb.jobs(type, status, params, max_attempts, run_at, created_at, updated_at)
+	VALUES($1, $2, $3, $4, $5, $5, $5) RETURNING `+jobColumns, name, Queued, string(params), maxAttempts, now))
+}
+
+// Get returns the job with the given id, nil when there is none
+func (q *Queue) Get(id int64) (*models.Job, error) {
+	job, err := scanJob(q.db.QueryRow(`SELECT `+jobColumns+` FROM imdb.jobs WHERE id=$1`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// List returns the latest jobs, newest first, of a type and a status when they are not empty
+func (q *Queue) List(name, status string, limit int) ([]models.Job, error) {
+	rows, err := q.db.Query(`SELECT `+jobColumns+` FROM imdb.jobs WHERE ($1 = '' OR type=$1) AND ($2 = '' OR status=$2)
+	ORDER BY id DESC LIMIT $3`, name, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []models.Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, job)
+	}
+	return list, rows.Err()
+}
+
+// Cancel cancels a queued job, or requests the cancellation of a running one, which its worker notices at its next
+// heartbeat. It returns nil when there is no such job and fails with ErrFinished when the job is finished.
+func (q *Queue) Cancel(id int64) (*models.Job, error) {
+	now := time.Now().Unix()
+	job, err := scanJob(q.db.QueryRow(`UPDATE imdb.jobs SET
+		status=CASE WHEN status=$1 THEN $2 ELSE status END,
+		finished_at=CASE WHEN status=$1 THEN $3 ELSE finished_at END,
+		cancel_requested=true, updated_at=$3
+	WHERE id=$4 AND status IN ($1, $5) RETURNING `+jobColumns, Queued, Canceled, now, id, Running))
+	if err == sql.ErrNoRows {
+		existing, err := q.Get(id)
+		if err != nil || existing == nil {
+			return nil, err
+		}
+		return existing, ErrFinished
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Output calls fn with each chunk of the output written by the attempt of a job, in order. The output is complete
+// once the job succeeded.
+func (q *Queue) Output(job models.Job, fn func(chunk []byte) error) error {
+	rows, err := q.db.Query(`SELECT data FROM imdb.job_output WHERE job_id=$1 AND attempt=$2 ORDER BY seq`, job.ID, job.Attempts)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var chunk []byte
+		err = rows.Scan(&chunk)
+		if err != nil {
+			return err
+		}
+		err = fn(chunk)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Wake asks the workers of the process to look for queued jobs now
+func (q *Queue) Wake() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Work runs workers jobs at a time as they are queued, it never returns
+func (q *Queue) Work(workers int) {
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	ticker := time.NewTicker(staleAfter)
+	defer ticker.Stop()
+	for ; ; <-ticker.C {
+		err := q.reap()
+		if err != nil {
+			log.Println("cannot reap the abandoned jobs:", err)
+		}
+	}
+}
+
+// work runs the claimed jobs one after the other
+func (q *Queue) work() {
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for {
+		job, err := q.claim()
+		if err != nil {
+			log.Println("cannot claim a job:", err)
+		}
+		if job != nil {
+			q.execute(*job)
+			continue
+		}
+		select {
+		case <-ticker.C:
+		case <-q.wake:
+		}
+	}
+}
+
+// claim marks the oldest due job of a type without a running job as running, it returns nil when there is none
+func (q *Queue) claim() (*models.Job, error) {
+	now := time.Now().Unix()
+	job, err := scanJob(q.db.QueryRow(`UPDATE imdb.jobs SET status=$1, attempts=attempts+1, started_at=$2, heartbeat_at=$2, updated_at=$2,
+		cancel_requested=false
+	WHERE id = (
+		SELECT id FROM imdb.jobs j WHERE status=$3 AND run_at <= $2 AND type = ANY($4)
+		AND NOT EXISTS (SELECT 1 FROM imdb.jobs r WHERE r.type=j.type AND r.status=$1)
+		ORDER BY run_at, id LIMIT 1 FOR UPDATE SKIP LOCKED
+	) RETURNING `+jobColumns, Running, now, Queued, pq.Array(q.Types())))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	// another server claimed a job of the same type at the same time
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// execute runs an attempt of a claimed job and records how it ended
+func (q *Queue) execute(job models.Job) {
+	jobType := q.types[job.Type]
+	ctx := context.Background()
+	if jobType.Timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, jobType.Timeout)
+		defer cancelTimeout()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var canceled int32
+	alive := time.Now()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			var cancelRequested bool
+			err := q.db.QueryRow(`UPDATE imdb.jobs SET heartbeat_at=$1 WHERE id=$2 AND status=$3 AND attempts=$4 RETURNING cancel_requested`,
+				time.Now().Unix(), job.ID, Running, job.Attempts).Scan(&cancelRequested)
+			switch {
+			case err == sql.ErrNoRows:
+				log.Println("job", job.ID, "attempt", job.Attempts, "lost its claim, stopping it")
+				cancel()
+				return
+			case err != nil:
+				log.Println("job", job.ID, "heartbeat failed:", err)
+				// the reaper of another server may already have queued the job again
+				if time.Since(alive) > staleAfter {
+					log.Println("job", job.ID, "attempt", job.Attempts, "missed its heartbeats for", staleAfter, ", stopping it")
+					cancel()
+					return
+				}
+				continue
+			}
+			alive = time.Now()
+			if cancelRequested {
+				atomic.StoreInt32(&canceled, 1)
+				cancel()
+			}
+		}
+	}()
+
+	// the output of the earlier attempts is incomplete
+	_, err := q.db.Exec(`DELETE FROM imdb.job_output WHERE job_id=$1 AND attempt < $2`, job.ID, job.Attempts)
+	if err != nil {
+		log.Println("cannot delete the output of the earlier attempts of job", job.ID, ":", err)
+	}
+	result, err := runAttempt(ctx, jobType, &Run{Job: job, queue: q})
+	if err == nil && ctx.Err() == context.DeadlineExceeded {
+		err = ctx.Err()
+	}
+	if err == context.DeadlineExceeded {
+		err = fmt.Errorf("the attempt timed out after %s", jobType.Timeout)
+	}
+	err = q.finish(job, jobType.Policy, result, err, atomic.LoadInt32(&canceled) == 1)
+	if err == errLostClaim {
+		log.Println("job", job.ID, "attempt", job.Attempts, "lost its claim, its outcome is discarded")
+		return
+	}
+	if err != nil {
+		log.Println("cannot record the end of job", job.ID, ":", err)
+	}
+}
+
+// runAttempt calls the Run function of a job type, a panic fails the attempt
+func runAttempt(ctx context.Context, jobType Type, run *Run) (result interface{}, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("job panicked: %v", recovered)
+		}
+	}()
+	return jobType.Run(ctx, run)
+}
+
+// finish records the end of an attempt: the job succeeded, was cancelled, failed, or is queued to be retried
+func (q *Queue) finish(job models.Job, policy Policy, result interface{}, runErr error, canceled bool) error {
+	now := time.Now()
+	if runErr == nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			runErr = Permanent(err)
+		} else {
+			return q.update(job, `status=$1, result=$2, error=NULL, updated_at=$3, finished_at=$3`, Succeeded, string(data), now.Unix())
+		}
+	}
+	if canceled {
+		return q.update(job, `status=$1, error=$2, updated_at=$3, finished_at=$3`, Canceled, "canceled while running", now.Unix())
+	}
+	log.Println("job", job.ID, "of type", job.Type, "failed, attempt", job.Attempts, "of", job.MaxAttempts, ":", runErr)
+	_, permanent := runErr.(permanentError)
+	if permanent || job.Attempts >= job.MaxAttempts {
+		return q.update(job, `status=$1, error=$2, updated_at=$3, finished_at=$3`, Failed, runErr.Error(), now.Unix())
+	}
+	return q.update(job, `status=$1, error=$2, run_at=$3, updated_at=$4`,
+		Queued, runErr.Error(), now.Add(backoff(policy.Backoff, job.Attempts)).Unix(), now.Unix())
+}
+
+// update sets columns of the job of a running attempt, set numbers its params from $1. It fails with errLostClaim
+// when the job is no longer running this attempt.
+func (q *Queue) update(job models.Job, set string, args ...interface{}) error {
+	n := len(args)
+	args = append(args, job.ID, Running, job.Attempts)
+	result, err := q.db.Exec(fmt.Sprintf(`UPDATE imdb.jobs SET %s WHERE id=$%d AND status=$%d AND attempts=$%d`, set, n+1, n+2, n+3), args...)
+	if err != nil {
+		return err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return errLostClaim
+	}
+	return nil
+}
+
+// reap queues again, or fails when they made every attempt, the running jobs whose heartbeat stopped, and deletes
+// the jobs finished for longer than retention
+func (q *Queue) reap() error {
+	now := time.Now().Unix()
+	_, err := q.db.Exec(`UPDATE imdb.jobs SET
+		status=CASE WHEN attempts < max_attempts AND NOT cancel_requested THEN $1 WHEN cancel_requested THEN $2 ELSE $3 END,
+		finished_at=CASE WHEN attempts < max_attempts AND NOT cancel_requested THEN NULL ELSE $4 END,
+		error='abandoned', run_at=$4, updated_at=$4
+	WHERE status=$5 AND heartbeat_at < $6`, Queued, Canceled, Failed, now, Running, now-int64(staleAfter.Seconds()))
+	if err != nil {
+		return err
+	}
+	_, err = q.db.Exec(`DELETE FROM imdb.jobs WHERE finished_at < $1`, time.Now().Add(-retention).Unix())
+	return err
+}
+
+// backoff is the delay before the next attempt of a job, first doubled after each attempt
+func backoff(first time.Duration, attempts int) time.Duration {
+	delay := first
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+	}
+	return delay
+}
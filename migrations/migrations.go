@@ -0,0 +1,237 @@
+// Package migrations versions the Postgres schema of the service.
+//
+// Every change to the schema is a pair of numbered SQL files in sql/, for example
+// 0002_add_users_name.up.sql and 0002_add_users_name.down.sql. The files are embedded in the binary
+// and the versions applied to a database are recorded in imdb.schema_migrations.
+// Applied migrations must never be edited, a change to the schema is always a new migration.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// lockID is the key of the advisory lock held while migrating, so that instances starting together
+// do not apply the same migration twice
+const lockID = 727170216
+
+var fileRegexp = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is a numbered change to the schema
+type Migration struct {
+	Version int    `json:"version"`
+	Name    string `json:"name"`
+	Up      string `json:"-"`
+	Down    string `json:"-"`
+}
+
+// State is a migration along with when it was applied, AppliedAt is 0 when it is pending
+type State struct {
+	Migration
+	AppliedAt int64 `json:"applied_at"`
+}
+
+// Load reads the embedded migrations in version order
+func Load() ([]Migration, error) {
+	entries, err := files.ReadDir("sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := fileRegexp.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration file %s is not named <version>_<name>.<up|down>.sql", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		content, err := files.ReadFile(path.Join("sql", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := []Migration{}
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Up applies up to n pending migrations in version order, all of them when n is 0.
+// It returns the migrations it applied.
+func Up(db *sql.DB, n int) ([]Migration, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	applied := []Migration{}
+	err = withLock(db, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			if n > 0 && len(applied) == n {
+				break
+			}
+			if _, ok := versions[migration.Version]; ok {
+				continue
+			}
+			err = run(conn, migration.Up, `INSERT INTO imdb.schema_migrations(version, name, applied_at) VALUES($1, $2, $3)`,
+				migration.Version, migration.Name, time.Now().Unix())
+			if err != nil {
+				return fmt.Errorf("migration %d_%s failed: %v", migration.Version, migration.Name, err)
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the last n applied migrations, latest first. n must be at least 1.
+// It returns the migrations it reverted.
+func Down(db *sql.DB, n int) ([]Migration, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("the number of migrations to revert must be at least 1")
+	}
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	reverted := []Migration{}
+	err = withLock(db, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0 && len(reverted) < n; i-- {
+			migration := migrations[i]
+			if _, ok := versions[migration.Version]; !ok {
+				continue
+			}
+			err = run(conn, migration.Down, `DELETE FROM imdb.schema_migrations WHERE version=$1`, migration.Version)
+			if err != nil {
+				return fmt.Errorf("reverting migration %d_%s failed: %v", migration.Version, migration.Name, err)
+			}
+			reverted = append(reverted, migration)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Status lists every known migration along with when it was applied
+func Status(db *sql.DB) ([]State, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	states := []State{}
+	err = withLock(db, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			states = append(states, State{Migration: migration, AppliedAt: versions[migration.Version]})
+		}
+		return nil
+	})
+	return states, err
+}
+
+// withLock runs fn on a single connection holding the migrations advisory lock
+func withLock(db *sql.DB, fn func(conn *sql.Conn) error) error {
+	ctx := context.Background()
+	// advisory locks belong to a session, so the lock and the migrations must share a connection
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID)
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, lockID)
+
+	_, err = conn.ExecContext(ctx, `
+	CREATE SCHEMA IF NOT EXISTS imdb;
+	CREATE TABLE IF NOT EXISTS imdb.schema_migrations (
+		version integer NOT NULL PRIMARY KEY,
+		name varchar(255) NOT NULL,
+		applied_at integer NOT NULL
+	);`)
+	if err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// appliedVersions returns when each applied version was applied
+func appliedVersions(conn *sql.Conn) (map[int]int64, error) {
+	rows, err := conn.QueryContext(context.Background(), `SELECT version, applied_at FROM imdb.schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	versions := map[int]int64{}
+	for rows.Next() {
+		var version int
+		var appliedAt int64
+		err = rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, err
+		}
+		versions[version] = appliedAt
+	}
+	return versions, rows.Err()
+}
+
+// run executes a migration script and the statement recording it in the same transaction
+func run(conn *sql.Conn, script, record string, args ...interface{}) error {
+	ctx := context.Background()
+	t, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	_, err = t.Exec(script)
+	if err != nil {
+		t.Rollback()
+		return err
+	}
+	_, err = t.Exec(record, args...)
+	if err != nil {
+		t.Rollback()
+		return err
+	}
+	return t.Commit()
+}
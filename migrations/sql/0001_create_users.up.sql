@@ -0,0 +1,9 @@
+CREATE SCHEMA IF NOT EXISTS imdb;
+
+CREATE TABLE IF NOT EXISTS imdb.users (
+	email VARCHAR(500) NOT NULL PRIMARY KEY,
+	created_at integer NOT NULL,
+	user_password VARCHAR(32) NOT NULL,
+	user_id varchar(32) NOT NULL UNIQUE,
+	role varchar(6) NOT NULL
+);
@@ -0,0 +1 @@
+ALTER TABLE imdb.users DROP COLUMN IF EXISTS name;
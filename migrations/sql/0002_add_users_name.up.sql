@@ -0,0 +1 @@
+ALTER TABLE imdb.users ADD COLUMN IF NOT EXISTS name VARCHAR(500) NOT NULL DEFAULT '';
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS imdb.ranking_profiles (
+	name varchar(64) NOT NULL PRIMARY KEY,
+	profile text NOT NULL,
+	updated_at integer NOT NULL
+);
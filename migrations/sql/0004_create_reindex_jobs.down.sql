@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS imdb.reindex_deletes;
+DROP TABLE IF EXISTS imdb.reindex_jobs;
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS imdb.reindex_jobs (
+	id serial PRIMARY KEY,
+	source_index varchar(255) NOT NULL,
+	target_index varchar(255) NOT NULL,
+	status varchar(16) NOT NULL,
+	copied bigint NOT NULL,
+	error text,
+	created_at integer NOT NULL,
+	updated_at integer NOT NULL,
+	finished_at integer
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS reindex_jobs_running ON imdb.reindex_jobs (status) WHERE status = 'running';
+
+CREATE TABLE IF NOT EXISTS imdb.reindex_deletes (
+	job_id integer NOT NULL REFERENCES imdb.reindex_jobs (id) ON DELETE CASCADE,
+	movie_id varchar(255) NOT NULL,
+	PRIMARY KEY (job_id, movie_id)
+);
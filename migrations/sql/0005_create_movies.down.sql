@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS imdb.movies;
+DROP FUNCTION IF EXISTS imdb.movies_search_update();
+DROP FUNCTION IF EXISTS imdb.normalize_genre(text);
@@ -0,0 +1,37 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- genres are compared case and punctuation insensitively, so that sci-fi matches Sci-Fi and sci fi
+CREATE OR REPLACE FUNCTION imdb.normalize_genre(genre text) RETURNS text AS $$
+	SELECT lower(trim(regexp_replace(genre, '[^[:alnum:]]+', ' ', 'g')));
+$$ LANGUAGE sql IMMUTABLE;
+
+CREATE TABLE IF NOT EXISTS imdb.movies (
+	id varchar(32) NOT NULL PRIMARY KEY,
+	name text NOT NULL,
+	director text NOT NULL,
+	genre text[] NOT NULL DEFAULT '{}',
+	imdb_score real NOT NULL,
+	popularity real NOT NULL,
+	name_tsv tsvector NOT NULL,
+	director_tsv tsvector NOT NULL,
+	genre_keys text[] NOT NULL
+);
+
+CREATE OR REPLACE FUNCTION imdb.movies_search_update() RETURNS trigger AS $$
+BEGIN
+	NEW.name_tsv := to_tsvector('english', NEW.name);
+	NEW.director_tsv := to_tsvector('simple', NEW.director);
+	NEW.genre_keys := ARRAY(SELECT imdb.normalize_genre(g) FROM unnest(NEW.genre) g);
+	RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER movies_search_update BEFORE INSERT OR UPDATE ON imdb.movies
+	FOR EACH ROW EXECUTE PROCEDURE imdb.movies_search_update();
+
+CREATE INDEX IF NOT EXISTS movies_name_tsv ON imdb.movies USING gin (name_tsv);
+CREATE INDEX IF NOT EXISTS movies_director_tsv ON imdb.movies USING gin (director_tsv);
+CREATE INDEX IF NOT EXISTS movies_name_trgm ON imdb.movies USING gin (name gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS movies_genre_keys ON imdb.movies USING gin (genre_keys);
+CREATE INDEX IF NOT EXISTS movies_imdb_score ON imdb.movies (imdb_score);
+CREATE INDEX IF NOT EXISTS movies_popularity ON imdb.movies (popularity);
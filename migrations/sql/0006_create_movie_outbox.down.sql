@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS imdb.movie_outbox;
+ALTER TABLE imdb.movies DROP COLUMN IF EXISTS version;
@@ -0,0 +1,17 @@
+ALTER TABLE imdb.movies ADD COLUMN IF NOT EXISTS version bigint NOT NULL DEFAULT 1;
+
+CREATE TABLE IF NOT EXISTS imdb.movie_outbox (
+	id bigserial PRIMARY KEY,
+	movie_id varchar(32) NOT NULL,
+	version bigint NOT NULL,
+	operation varchar(16) NOT NULL,
+	document jsonb,
+	attempts integer NOT NULL DEFAULT 0,
+	last_error text,
+	next_attempt_at integer NOT NULL,
+	created_at integer NOT NULL,
+	delivered_at integer
+);
+
+CREATE INDEX IF NOT EXISTS movie_outbox_pending ON imdb.movie_outbox (next_attempt_at) WHERE delivered_at IS NULL;
+CREATE INDEX IF NOT EXISTS movie_outbox_movie ON imdb.movie_outbox (movie_id, version);
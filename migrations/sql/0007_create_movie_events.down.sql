@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS imdb.webhook_dead_letters;
+DROP TABLE IF EXISTS imdb.webhook_deliveries;
+DROP TABLE IF EXISTS imdb.webhooks;
+DROP TABLE IF EXISTS imdb.movie_events;
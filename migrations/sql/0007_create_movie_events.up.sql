@@ -0,0 +1,42 @@
+CREATE TABLE IF NOT EXISTS imdb.movie_events (
+	id bigserial PRIMARY KEY,
+	type varchar(32) NOT NULL,
+	movie_id varchar(32) NOT NULL,
+	movie jsonb,
+	created_at integer NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS movie_events_created ON imdb.movie_events (created_at);
+
+CREATE TABLE IF NOT EXISTS imdb.webhooks (
+	id serial PRIMARY KEY,
+	url text NOT NULL,
+	secret varchar(128) NOT NULL,
+	events text[] NOT NULL,
+	created_at integer NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS imdb.webhook_deliveries (
+	id bigserial PRIMARY KEY,
+	webhook_id integer NOT NULL REFERENCES imdb.webhooks (id) ON DELETE CASCADE,
+	event_id bigint NOT NULL,
+	payload jsonb NOT NULL,
+	attempts integer NOT NULL DEFAULT 0,
+	last_error text,
+	next_attempt_at integer NOT NULL,
+	created_at integer NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due ON imdb.webhook_deliveries (next_attempt_at);
+
+CREATE TABLE IF NOT EXISTS imdb.webhook_dead_letters (
+	id bigserial PRIMARY KEY,
+	webhook_id integer NOT NULL REFERENCES imdb.webhooks (id) ON DELETE CASCADE,
+	event_id bigint NOT NULL,
+	payload jsonb NOT NULL,
+	attempts integer NOT NULL,
+	last_error text NOT NULL,
+	failed_at integer NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS webhook_dead_letters_webhook ON imdb.webhook_dead_letters (webhook_id, id);
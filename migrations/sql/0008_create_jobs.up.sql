@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS imdb.jobs (
+	id bigserial PRIMARY KEY,
+	type varchar(64) NOT NULL,
+	status varchar(16) NOT NULL,
+	params jsonb NOT NULL,
+	done bigint NOT NULL DEFAULT 0,
+	total bigint NOT NULL DEFAULT 0,
+	result jsonb,
+	error text,
+	attempts integer NOT NULL DEFAULT 0,
+	max_attempts integer NOT NULL,
+	cancel_requested boolean NOT NULL DEFAULT false,
+	run_at integer NOT NULL,
+	heartbeat_at integer,
+	created_at integer NOT NULL,
+	started_at integer,
+	updated_at integer NOT NULL,
+	finished_at integer
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS jobs_running_type ON imdb.jobs (type) WHERE status = 'running';
+CREATE INDEX IF NOT EXISTS jobs_queued ON imdb.jobs (run_at, id) WHERE status = 'queued';
+CREATE INDEX IF NOT EXISTS jobs_type ON imdb.jobs (type, id);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS imdb.webhook_deliveries_webhook;
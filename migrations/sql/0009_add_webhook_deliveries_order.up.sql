@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook ON imdb.webhook_deliveries (webhook_id, id);
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS imdb.synonyms (
+	id boolean PRIMARY KEY DEFAULT true CHECK (id),
+	rules text[] NOT NULL,
+	updated_at integer NOT NULL
+);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS imdb.job_output (
+	job_id bigint NOT NULL REFERENCES imdb.jobs (id) ON DELETE CASCADE,
+	attempt integer NOT NULL,
+	seq integer NOT NULL,
+	data bytea NOT NULL,
+	PRIMARY KEY (job_id, attempt, seq)
+);
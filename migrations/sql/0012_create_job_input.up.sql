@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS imdb.job_input (
+	job_id bigint NOT NULL REFERENCES imdb.jobs (id) ON DELETE CASCADE,
+	seq integer NOT NULL,
+	data bytea NOT NULL,
+	PRIMARY KEY (job_id, seq)
+);
@@ -1,19 +1,144 @@
 package models
 
+import "encoding/json"
+
+// User is a user of the service, the validate tags are checked by the validation package on the users sent to the API
 type User struct {
-	Email        string `json:"email"`
-	Name         string `json:"name"`
-	Role         string `json:"role"`
+	Email        string `json:"email" validate:"required,email"`
+	Name         string `json:"name" validate:"required,maxlen=128"`
+	Role         string `json:"role" validate:"oneof=admin user"`
 	CreatedAt    int64  `json:"created_at"`
-	UserName     string `json:"user_name"`
-	UserPassword string `json:"user_password"`
+	UserName     string `json:"user_name" validate:"required,maxlen=32"`
+	UserPassword string `json:"user_password" validate:"required,maxlen=32"`
 }
 
+// Movie is a movie of the catalog, the validate tags are checked by the validation package on the movies sent to the API
 type Movie struct {
 	ID         string   `json:"movie_id,omitempty"`
-	Name       string   `json:"name"`
-	Popularity float32  `json:"99popularity"`
-	Director   string   `json:"director"`
-	Genre      []string `json:"genre"`
-	IMDBScore  float32  `json:"imdb_score"`
+	Name       string   `json:"name" validate:"required,maxlen=256"`
+	Popularity float32  `json:"99popularity" validate:"min=0,max=99"`
+	Director   string   `json:"director" validate:"required,maxlen=256"`
+	Genre      []string `json:"genre" validate:"required,in=genres"`
+	IMDBScore  float32  `json:"imdb_score" validate:"min=0,max=10"`
+}
+
+// Genres are the IMDb genres a movie can have
+var Genres = []string{
+	"Action", "Adult", "Adventure", "Animation", "Biography", "Comedy", "Crime", "Documentary", "Drama", "Family",
+	"Fantasy", "Film-Noir", "Game-Show", "History", "Horror", "Music", "Musical", "Mystery", "News", "Reality-TV",
+	"Romance", "Sci-Fi", "Short", "Sport", "Talk-Show", "Thriller", "War", "Western",
+}
+
+// RankingProfile describes how search results are boosted when it is picked with the ranking search param
+type RankingProfile struct {
+	Name              string             `json:"name"`
+	FieldBoosts       map[string]float64 `json:"field_boosts,omitempty"`
+	FieldValueFactors []FieldValueFactor `json:"field_value_factors,omitempty"`
+	Decays            []DecayFunction    `json:"decays,omitempty"`
+	ScoreMode         string             `json:"score_mode,omitempty"`
+	BoostMode         string             `json:"boost_mode,omitempty"`
+	UpdatedAt         int64              `json:"updated_at,omitempty"`
+}
+
+// FieldValueFactor boosts a movie by the value of one of its numeric fields
+type FieldValueFactor struct {
+	Field    string  `json:"field"`
+	Factor   float64 `json:"factor,omitempty"`
+	Modifier string  `json:"modifier,omitempty"`
+	Missing  float64 `json:"missing,omitempty"`
+	Weight   float64 `json:"weight,omitempty"`
+}
+
+// DecayFunction boosts a movie by how close one of its numeric fields is to an origin
+type DecayFunction struct {
+	Function string  `json:"function"`
+	Field    string  `json:"field"`
+	Origin   float64 `json:"origin"`
+	Scale    float64 `json:"scale"`
+	Offset   float64 `json:"offset,omitempty"`
+	Decay    float64 `json:"decay,omitempty"`
+	Weight   float64 `json:"weight,omitempty"`
+}
+
+// ReindexJob tracks the copy of the movie index into a new index behind the movie alias
+type ReindexJob struct {
+	ID          int64  `json:"job_id"`
+	SourceIndex string `json:"source_index"`
+	TargetIndex string `json:"target_index"`
+	Status      string `json:"status"`
+	Copied      int64  `json:"copied"`
+	Error       string `json:"error,omitempty"`
+	CreatedAt   int64  `json:"created_at"`
+	UpdatedAt   int64  `json:"updated_at"`
+	FinishedAt  int64  `json:"finished_at,omitempty"`
+}
+
+// MovieRepair counts what the reconciliation of the movie index with imdb.movies found and fixed
+type MovieRepair struct {
+	Movies    int64 `json:"movies"`
+	Documents int64 `json:"documents"`
+	Missing   int64 `json:"missing"`
+	Changed   int64 `json:"changed"`
+	Deleted   int64 `json:"deleted"`
+	Imported  int64 `json:"imported"`
+}
+
+// Movie event types
+const (
+	MovieCreated = "movie.created"
+	MovieUpdated = "movie.updated"
+	MovieDeleted = "movie.deleted"
+)
+
+// MovieEventTypes are the types of the movie events
+var MovieEventTypes = []string{MovieCreated, MovieUpdated, MovieDeleted}
+
+// MovieEvent records a change of the catalogue, it is sent on the /v1/events stream and to the webhooks.
+// Movie is the movie as written, nil for a delete.
+type MovieEvent struct {
+	ID        int64  `json:"id"`
+	Type      string `json:"type"`
+	MovieID   string `json:"movie_id"`
+	Movie     *Movie `json:"movie,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// Webhook is a URL the movie events of the given types are posted to, signed with its secret
+type Webhook struct {
+	ID        int64    `json:"webhook_id"`
+	URL       string   `json:"url" validate:"required,url,maxlen=2048"`
+	Events    []string `json:"events" validate:"in=events"`
+	Secret    string   `json:"secret,omitempty" validate:"maxlen=128"`
+	CreatedAt int64    `json:"created_at"`
+}
+
+// WebhookDeadLetter is an event a webhook failed to receive after every attempt
+type WebhookDeadLetter struct {
+	ID        int64      `json:"dead_letter_id"`
+	WebhookID int64      `json:"webhook_id"`
+	Event     MovieEvent `json:"event"`
+	Attempts  int        `json:"attempts"`
+	LastError string     `json:"last_error"`
+	FailedAt  int64      `json:"failed_at"`
+}
+
+// Job is a long running operation run in the background by the job workers, Done and Total count its progress.
+// Params and Result are JSON objects whose fields depend on its type.
+type Job struct {
+	ID              int64           `json:"job_id"`
+	Type            string          `json:"type"`
+	Status          string          `json:"status"`
+	Params          json.RawMessage `json:"params"`
+	Done            int64           `json:"done"`
+	Total           int64           `json:"total"`
+	Result          json.RawMessage `json:"result,omitempty"`
+	Error           string          `json:"error,omitempty"`
+	Attempts        int             `json:"attempts"`
+	MaxAttempts     int             `json:"max_attempts"`
+	CancelRequested bool            `json:"cancel_requested"`
+	RunAt           int64           `json:"run_at"`
+	CreatedAt       int64           `json:"created_at"`
+	StartedAt       int64           `json:"started_at,omitempty"`
+	UpdatedAt       int64           `json:"updated_at"`
+	FinishedAt      int64           `json:"finished_at,omitempty"`
 }
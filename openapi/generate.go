@@ -0,0 +1,163 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/raazcrzy/imdb/validation"
+)
+
+// Generator builds the schemas of Go types from their json tags and the rules of their validate tags.
+// Named struct types are added to Schemas and referenced by name.
+type Generator struct {
+	Schemas map[string]*Schema
+}
+
+// NewGenerator returns a generator without schemas
+func NewGenerator() *Generator {
+	return &Generator{Schemas: map[string]*Schema{}}
+}
+
+// Schema returns the schema of the type of a value
+func (g *Generator) Schema(value interface{}) *Schema {
+	return g.schemaOf(reflect.TypeOf(value))
+}
+
+func (g *Generator) schemaOf(t reflect.Type) *Schema {
+	// json.RawMessage holds any value, like interface{}
+	if t == reflect.TypeOf(json.RawMessage{}) {
+		return &Schema{}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := g.schemaOf(t.Elem())
+		if schema.Ref == "" {
+			schema.Nullable = true
+		}
+		return schema
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64", Minimum: float(0)}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: g.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		if _, ok := g.Schemas[t.Name()]; !ok {
+			// registered before its fields are walked, so that a type referencing itself ends
+			g.Schemas[t.Name()] = &Schema{}
+			*g.Schemas[t.Name()] = *g.structSchema(t)
+		}
+		return Ref(t.Name())
+	}
+	// interface{} holds any value
+	return &Schema{}
+}
+
+// structSchema returns the schema of the exported fields of a struct, the fields of embedded structs included
+func (g *Generator) structSchema(t reflect.Type) *Schema {
+	schema := Object(map[string]*Schema{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || (field.PkgPath != "" && !field.Anonymous) {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				inner := g.structSchema(embedded)
+				for property, propertySchema := range inner.Properties {
+					schema.Properties[property] = propertySchema
+				}
+				schema.Required = append(schema.Required, inner.Required...)
+				continue
+			}
+		}
+		if name == "" {
+			name = field.Name
+		}
+		property := g.schemaOf(field.Type)
+		rules := validation.Rules(field.Tag.Get("validate"))
+		applyRules(property, rules)
+		if _, ok := rules["required"]; ok {
+			schema.Required = append(schema.Required, name)
+		}
+		schema.Properties[name] = property
+	}
+	return schema
+}
+
+// applyRules adds the constraints of validate rules to the schema of a field
+func applyRules(schema *Schema, rules map[string]string) {
+	if schema.Ref != "" {
+		return
+	}
+	if _, ok := rules["required"]; ok {
+		switch schema.Type {
+		case "string":
+			schema.MinLength = length(1)
+		case "array":
+			schema.MinItems = length(1)
+		}
+	}
+	if _, ok := rules["email"]; ok {
+		schema.Format = "email"
+	}
+	if _, ok := rules["url"]; ok {
+		schema.Format = "uri"
+	}
+	if value, ok := rules["maxlen"]; ok {
+		n, _ := strconv.Atoi(value)
+		schema.MaxLength = length(n)
+	}
+	if value, ok := rules["min"]; ok {
+		n, _ := strconv.ParseFloat(value, 64)
+		schema.Minimum = float(n)
+	}
+	if value, ok := rules["max"]; ok {
+		n, _ := strconv.ParseFloat(value, 64)
+		schema.Maximum = float(n)
+	}
+	if value, ok := rules["oneof"]; ok {
+		for _, allowed := range strings.Fields(value) {
+			schema.Enum = append(schema.Enum, allowed)
+		}
+	}
+	if set, ok := rules["in"]; ok {
+		// the values are compared ignoring case, which an enum cannot tell
+		target := schema
+		if schema.Type == "array" {
+			target = schema.Items
+		}
+		target.Description = "One of the " + set + ", ignoring case: " + strings.Join(validation.Sets[set], ", ")
+	}
+}
+
+func float(n float64) *float64 {
+	return &n
+}
+
+func length(n int) *int {
+	return &n
+}
@@ -0,0 +1,134 @@
+package openapi
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validate checks a JSON value, as decoded by encoding/json into an interface{}, against a schema whose references
+// point to the schemas of the document. It returns a description of every mismatch, prefixed with where it is.
+func (d *Document) Validate(schema *Schema, value interface{}) []string {
+	return d.validate("body", schema, value)
+}
+
+func (d *Document) validate(path string, schema *Schema, value interface{}) []string {
+	if schema.Ref != "" {
+		resolved, ok := d.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+		if !ok {
+			return []string{fmt.Sprintf("%s: unknown schema %s", path, schema.Ref)}
+		}
+		return d.validate(path, resolved, value)
+	}
+	if value == nil {
+		if schema.Nullable || schema.Type == "" {
+			return nil
+		}
+		return []string{fmt.Sprintf("%s: is null, expected %s", path, schema.Type)}
+	}
+
+	problems := []string{}
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: is %s, expected object", path, jsonType(value))}
+		}
+		for _, name := range schema.Required {
+			if _, ok := object[name]; !ok {
+				problems = append(problems, fmt.Sprintf("%s.%s: is missing", path, name))
+			}
+		}
+		names := []string{}
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := schema.Properties[name]
+			if !ok {
+				property, ok = schema.AdditionalProperties.(*Schema)
+			}
+			if !ok {
+				if schema.AdditionalProperties == false {
+					problems = append(problems, fmt.Sprintf("%s.%s: is not in the schema", path, name))
+				}
+				continue
+			}
+			problems = append(problems, d.validate(path+"."+name, property, object[name])...)
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: is %s, expected array", path, jsonType(value))}
+		}
+		if schema.MinItems != nil && len(items) < *schema.MinItems {
+			problems = append(problems, fmt.Sprintf("%s: has %d items, expected at least %d", path, len(items), *schema.MinItems))
+		}
+		if schema.Items != nil {
+			for i, item := range items {
+				problems = append(problems, d.validate(fmt.Sprintf("%s[%d]", path, i), schema.Items, item)...)
+			}
+		}
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			return []string{fmt.Sprintf("%s: is %s, expected string", path, jsonType(value))}
+		}
+		if schema.MinLength != nil && utf8.RuneCountInString(text) < *schema.MinLength {
+			problems = append(problems, fmt.Sprintf("%s: is shorter than %d characters", path, *schema.MinLength))
+		}
+		if schema.MaxLength != nil && utf8.RuneCountInString(text) > *schema.MaxLength {
+			problems = append(problems, fmt.Sprintf("%s: is longer than %d characters", path, *schema.MaxLength))
+		}
+	case "number", "integer":
+		number, ok := value.(float64)
+		if !ok {
+			return []string{fmt.Sprintf("%s: is %s, expected %s", path, jsonType(value), schema.Type)}
+		}
+		if schema.Type == "integer" && number != math.Trunc(number) {
+			problems = append(problems, fmt.Sprintf("%s: is %v, expected an integer", path, number))
+		}
+		if schema.Minimum != nil && number < *schema.Minimum {
+			problems = append(problems, fmt.Sprintf("%s: is %v, expected at least %v", path, number, *schema.Minimum))
+		}
+		if schema.Maximum != nil && number > *schema.Maximum {
+			problems = append(problems, fmt.Sprintf("%s: is %v, expected at most %v", path, number, *schema.Maximum))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{fmt.Sprintf("%s: is %s, expected boolean", path, jsonType(value))}
+		}
+	}
+	if len(schema.Enum) > 0 {
+		found := false
+		for _, allowed := range schema.Enum {
+			if fmt.Sprint(allowed) == fmt.Sprint(value) {
+				found = true
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("%s: is %v, expected one of %v", path, value, schema.Enum))
+		}
+	}
+	return problems
+}
+
+// jsonType names the JSON type of a decoded value
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "a boolean"
+	}
+	return "null"
+}
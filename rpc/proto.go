@@ -0,0 +1,256 @@
+package rpc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Protocol buffers wire types
+const (
+	WireVarint  = 0
+	WireFixed64 = 1
+	WireBytes   = 2
+	WireFixed32 = 5
+)
+
+// Encoder writes a protocol buffers message. Scalars holding their zero value are left out, as proto3 does.
+type Encoder struct {
+	buf []byte
+}
+
+// Bytes returns the encoded message
+func (e *Encoder) Bytes() []byte {
+	return e.buf
+}
+
+func (e *Encoder) tag(field, wire int) {
+	e.buf = appendVarint(e.buf, uint64(field)<<3|uint64(wire))
+}
+
+// String writes a string field
+func (e *Encoder) String(field int, v string) {
+	if v != "" {
+		e.tag(field, WireBytes)
+		e.buf = appendVarint(e.buf, uint64(len(v)))
+		e.buf = append(e.buf, v...)
+	}
+}
+
+// Strings writes a repeated string field
+func (e *Encoder) Strings(field int, values []string) {
+	for _, v := range values {
+		e.tag(field, WireBytes)
+		e.buf = appendVarint(e.buf, uint64(len(v)))
+		e.buf = append(e.buf, v...)
+	}
+}
+
+// Message writes an embedded message, even an empty one
+func (e *Encoder) Message(field int, m []byte) {
+	e.tag(field, WireBytes)
+	e.buf = appendVarint(e.buf, uint64(len(m)))
+	e.buf = append(e.buf, m...)
+}
+
+// Int64 writes an int64 or int32 field
+func (e *Encoder) Int64(field int, v int64) {
+	if v != 0 {
+		e.tag(field, WireVarint)
+		e.buf = appendVarint(e.buf, uint64(v))
+	}
+}
+
+// Bool writes a bool field
+func (e *Encoder) Bool(field int, v bool) {
+	if v {
+		e.tag(field, WireVarint)
+		e.buf = append(e.buf, 1)
+	}
+}
+
+// Float writes a float field
+func (e *Encoder) Float(field int, v float32) {
+	if v != 0 {
+		e.tag(field, WireFixed32)
+		e.buf = appendFixed32(e.buf, math.Float32bits(v))
+	}
+}
+
+// Double writes a double field, an optional one is written when v is not nil
+func (e *Encoder) Double(field int, v *float64) {
+	if v != nil {
+		e.tag(field, WireFixed64)
+		e.buf = appendFixed64(e.buf, math.Float64bits(*v))
+	}
+}
+
+func appendVarint(buf []byte, v uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutUvarint(b[:], v)]...)
+}
+
+func appendFixed32(buf []byte, v uint32) []byte {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	return append(buf, b[:]...)
+}
+
+func appendFixed64(buf []byte, v uint64) []byte {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	return append(buf, b[:]...)
+}
+
+// Decoder reads the fields of a protocol buffers message:
+//
+//	d := rpc.NewDecoder(data)
+//	for d.Next() {
+//		switch d.Field() {
+//		case 1:
+//			m.Name = d.String()
+//		default:
+//			d.Skip()
+//		}
+//	}
+//	return d.Err()
+type Decoder struct {
+	buf   []byte
+	field int
+	wire  int
+	err   error
+}
+
+// NewDecoder returns a decoder of a message
+func NewDecoder(data []byte) *Decoder {
+	return &Decoder{buf: data}
+}
+
+// Next reads the tag of the next field, it returns false at the end of the message or after an error
+func (d *Decoder) Next() bool {
+	if d.err != nil || len(d.buf) == 0 {
+		return false
+	}
+	tag := d.varint()
+	if d.err != nil {
+		return false
+	}
+	d.field, d.wire = int(tag>>3), int(tag&7)
+	if d.field == 0 {
+		d.fail("invalid field number 0")
+		return false
+	}
+	return true
+}
+
+// Field returns the number of the current field
+func (d *Decoder) Field() int {
+	return d.field
+}
+
+// Err returns the first error met, nil when the message was valid
+func (d *Decoder) Err() error {
+	return d.err
+}
+
+func (d *Decoder) fail(format string, args ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("invalid message: "+format, args...)
+	}
+	d.buf = nil
+}
+
+// expect checks the wire type of the current field
+func (d *Decoder) expect(wire int) bool {
+	if d.wire != wire {
+		d.fail("field %d has wire type %d, expected %d", d.field, d.wire, wire)
+		return false
+	}
+	return true
+}
+
+func (d *Decoder) varint() uint64 {
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.fail("truncated varint")
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *Decoder) fixed(size int) []byte {
+	if len(d.buf) < size {
+		d.fail("truncated field %d", d.field)
+		return make([]byte, size)
+	}
+	b := d.buf[:size]
+	d.buf = d.buf[size:]
+	return b
+}
+
+// Bytes reads a length delimited field, a string or an embedded message
+func (d *Decoder) Bytes() []byte {
+	if !d.expect(WireBytes) {
+		return nil
+	}
+	n := d.varint()
+	if n > uint64(len(d.buf)) {
+		d.fail("truncated field %d", d.field)
+		return nil
+	}
+	return d.fixed(int(n))
+}
+
+// String reads a string field
+func (d *Decoder) String() string {
+	return string(d.Bytes())
+}
+
+// Int64 reads an int64 or int32 field
+func (d *Decoder) Int64() int64 {
+	if !d.expect(WireVarint) {
+		return 0
+	}
+	return int64(d.varint())
+}
+
+// Bool reads a bool field
+func (d *Decoder) Bool() bool {
+	if !d.expect(WireVarint) {
+		return false
+	}
+	return d.varint() != 0
+}
+
+// Float reads a float field
+func (d *Decoder) Float() float32 {
+	if !d.expect(WireFixed32) {
+		return 0
+	}
+	return math.Float32frombits(binary.LittleEndian.Uint32(d.fixed(4)))
+}
+
+// Double reads a double field
+func (d *Decoder) Double() float64 {
+	if !d.expect(WireFixed64) {
+		return 0
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(d.fixed(8)))
+}
+
+// Skip skips the value of a field the message does not know
+func (d *Decoder) Skip() {
+	switch d.wire {
+	case WireVarint:
+		d.varint()
+	case WireFixed64:
+		d.fixed(8)
+	case WireBytes:
+		d.Bytes()
+	case WireFixed32:
+		d.fixed(4)
+	default:
+		d.fail("unsupported wire type %d", d.wire)
+	}
+}
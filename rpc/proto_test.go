@@ -0,0 +1,147 @@
+package rpc
+
+import (
+	"reflect"
+	"testing"
+)
+
+// fixture is a message with a field of every kind, as protoc generated code encodes it
+const fixture = "\x0a\x02hi" + // 1: string "hi"
+	"\x10\x96\x01" + // 2: int64 150
+	"\x18\xff\xff\xff\xff\xff\xff\xff\xff\xff\x01" + // 3: int64 -1, ten bytes long
+	"\x20\x01" + // 4: bool true
+	"\x2d\x00\x00\xc0\x3f" + // 5: float 1.5
+	"\x31\x00\x00\x00\x00\x00\x00\x04\x40" + // 6: optional double 2.5
+	"\x39\x00\x00\x00\x00\x00\x00\x00\x00" + // 7: optional double 0, set
+	"\x42\x01a\x42\x00" + // 8: repeated string "a", ""
+	"\x82\x01\x02\x08\x01" // 16: message with 1: int64 1
+
+// fixtureMessage is the message of fixture
+type fixtureMessage struct {
+	text     string
+	number   int64
+	negative int64
+	flag     bool
+	float    float32
+	double   *float64
+	zero     *float64
+	texts    []string
+	nested   int64
+}
+
+func decodeFixture(data []byte) (fixtureMessage, error) {
+	m := fixtureMessage{}
+	d := NewDecoder(data)
+	for d.Next() {
+		switch d.Field() {
+		case 1:
+			m.text = d.String()
+		case 2:
+			m.number = d.Int64()
+		case 3:
+			m.negative = d.Int64()
+		case 4:
+			m.flag = d.Bool()
+		case 5:
+			m.float = d.Float()
+		case 6:
+			v := d.Double()
+			m.double = &v
+		case 7:
+			v := d.Double()
+			m.zero = &v
+		case 8:
+			m.texts = append(m.texts, d.String())
+		case 16:
+			nested := NewDecoder(d.Bytes())
+			for nested.Next() {
+				m.nested = nested.Int64()
+			}
+			if nested.Err() != nil {
+				return m, nested.Err()
+			}
+		default:
+			d.Skip()
+		}
+	}
+	return m, d.Err()
+}
+
+func TestEncoder(t *testing.T) {
+	double, zero := 2.5, 0.0
+	nested := &Encoder{}
+	nested.Int64(1, 1)
+	e := &Encoder{}
+	e.String(1, "hi")
+	e.Int64(2, 150)
+	e.Int64(3, -1)
+	e.Bool(4, true)
+	e.Float(5, 1.5)
+	e.Double(6, &double)
+	e.Double(7, &zero)
+	e.Strings(8, []string{"a", ""})
+	e.Message(16, nested.Bytes())
+	if got := string(e.Bytes()); got != fixture {
+		t.Errorf("got  %q\nwant %q", got, fixture)
+	}
+
+	// proto3 leaves out the scalars holding their zero value, but not an empty message
+	empty := &Encoder{}
+	empty.String(1, "")
+	empty.Int64(2, 0)
+	empty.Bool(4, false)
+	empty.Float(5, 0)
+	empty.Double(6, nil)
+	empty.Strings(8, nil)
+	empty.Message(16, nil)
+	if got := string(empty.Bytes()); got != "\x82\x01\x00" {
+		t.Errorf("got %q for zero values", got)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	double, zero := 2.5, 0.0
+	want := fixtureMessage{text: "hi", number: 150, negative: -1, flag: true, float: 1.5, double: &double, zero: &zero,
+		texts: []string{"a", ""}, nested: 1}
+	got, err := decodeFixture([]byte(fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// fields of a later version of the message are skipped, whatever their wire type
+	unknown := "\x48\x96\x01" + // 9: varint
+		"\x55\x01\x02\x03\x04" + // 10: fixed32
+		"\x59\x01\x02\x03\x04\x05\x06\x07\x08" + // 11: fixed64
+		"\x62\x03abc" // 12: bytes
+	got, err = decodeFixture([]byte(unknown + fixture + unknown))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v with unknown fields, want %+v", got, want)
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{"\x0a\x05hi", "invalid message: truncated field 1"},
+		{"\x10\x96", "invalid message: truncated varint"},
+		{"\x2d\x00\x00", "invalid message: truncated field 5"},
+		{"\x00\x01", "invalid message: invalid field number 0"},
+		{"\x0d\x00\x00\x00\x00", "invalid message: field 1 has wire type 5, expected 2"},
+		{"\x10\x01\x12\x00", "invalid message: field 2 has wire type 2, expected 0"},
+		{"\x4b\x4c", "invalid message: unsupported wire type 3"},
+	}
+	for _, test := range tests {
+		_, err := decodeFixture([]byte(test.data))
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%q: got %v, want %s", test.data, err, test.err)
+		}
+	}
+}
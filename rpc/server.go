@@ -0,0 +1,203 @@
+// Package rpc serves gRPC calls with net/http, without the gRPC library. net/http speaks HTTP/2, which gRPC runs
+// on, over TLS, so the server must be started with ListenAndServeTLS. Unary and server streaming calls are
+// supported, messages are encoded with the Encoder and Decoder of the package and cannot be compressed.
+package rpc
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxMessageBytes is the size limit of a request message, the default of gRPC servers
+const MaxMessageBytes = 4 << 20
+
+// Call is an incoming call. The metadata sent by the client are the headers of Request.
+type Call struct {
+	Method  string
+	Request *http.Request
+	Message []byte
+}
+
+// UnaryHandler answers a call with a message
+type UnaryHandler func(ctx context.Context, call *Call) ([]byte, error)
+
+// StreamHandler answers a call with the messages it sends, until it returns
+type StreamHandler func(ctx context.Context, call *Call, send func(message []byte) error) error
+
+// Server dispatches calls to the handlers of their method, named /package.Service/Method
+type Server struct {
+	unary  map[string]UnaryHandler
+	stream map[string]StreamHandler
+}
+
+// NewServer returns a server without methods
+func NewServer() *Server {
+	return &Server{unary: map[string]UnaryHandler{}, stream: map[string]StreamHandler{}}
+}
+
+// Unary registers the handler of a unary method
+func (s *Server) Unary(method string, handler UnaryHandler) {
+	s.unary[method] = handler
+}
+
+// ServerStream registers the handler of a server streaming method
+func (s *Server) ServerStream(method string, handler StreamHandler) {
+	s.stream[method] = handler
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+	if r.ProtoMajor != 2 {
+		http.Error(w, "gRPC needs HTTP/2", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Accept-Encoding", "identity")
+	rw := &responseWriter{w: w}
+
+	ctx := r.Context()
+	if timeout, ok := parseTimeout(r.Header.Get("Grpc-Timeout")); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	rw.finish(ctx, s.serve(ctx, r, rw))
+}
+
+// serve reads the request message and calls the handler of the method
+func (s *Server) serve(ctx context.Context, r *http.Request, rw *responseWriter) (err error) {
+	unary, isUnary := s.unary[r.URL.Path]
+	stream, isStream := s.stream[r.URL.Path]
+	if !isUnary && !isStream {
+		return Errorf(Unimplemented, "unknown method %s", r.URL.Path)
+	}
+	if encoding := r.Header.Get("Grpc-Encoding"); encoding != "" && encoding != "identity" {
+		return Errorf(Unimplemented, "%s compression is not supported", encoding)
+	}
+	message, err := readMessage(r.Body)
+	if err != nil {
+		return err
+	}
+	call := &Call{Method: r.URL.Path, Request: r, Message: message}
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = Errorf(Internal, "%s panicked: %v", call.Method, recovered)
+		}
+	}()
+	if isUnary {
+		response, err := unary(ctx, call)
+		if err != nil {
+			return err
+		}
+		return rw.send(response)
+	}
+	return stream(ctx, call, func(message []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return rw.send(message)
+	})
+}
+
+// readMessage reads the only message of a request body
+func readMessage(body io.Reader) ([]byte, error) {
+	header := make([]byte, 5)
+	_, err := io.ReadFull(body, header)
+	if err != nil {
+		return nil, Errorf(InvalidArgument, "the request has no message")
+	}
+	if header[0] != 0 {
+		return nil, Errorf(Unimplemented, "compressed messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > MaxMessageBytes {
+		return nil, Errorf(ResourceExhausted, "the request message is larger than %d bytes", MaxMessageBytes)
+	}
+	message := make([]byte, size)
+	_, err = io.ReadFull(body, message)
+	if err != nil {
+		return nil, Errorf(InvalidArgument, "the request message is truncated")
+	}
+	return message, nil
+}
+
+// parseTimeout parses a grpc-timeout header, such as 100m for 100 milliseconds
+func parseTimeout(value string) (time.Duration, bool) {
+	if len(value) < 2 || len(value) > 9 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	units := map[byte]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second, 'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond}
+	unit, ok := units[value[len(value)-1]]
+	if !ok {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// responseWriter writes the messages of a response and its status
+type responseWriter struct {
+	w     http.ResponseWriter
+	wrote bool
+}
+
+// send writes a length prefixed message
+func (rw *responseWriter) send(message []byte) error {
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	_, err := rw.w.Write(append(frame, message...))
+	if err != nil {
+		return err
+	}
+	rw.wrote = true
+	if flusher, ok := rw.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// finish writes the status of the call, in the trailers after messages, or in the headers of a response without any
+func (rw *responseWriter) finish(ctx context.Context, err error) {
+	status := &Status{Code: OK}
+	switch e := err.(type) {
+	case nil:
+	case *Status:
+		status = e
+	default:
+		switch {
+		case ctx.Err() == context.DeadlineExceeded:
+			status = Errorf(DeadlineExceeded, "deadline exceeded")
+		case ctx.Err() == context.Canceled:
+			status = Errorf(Canceled, "call canceled")
+		default:
+			status = Errorf(Unknown, "%s", err)
+		}
+	}
+	prefix := ""
+	if rw.wrote {
+		prefix = http.TrailerPrefix
+	}
+	header := rw.w.Header()
+	header.Set(prefix+"Grpc-Status", strconv.Itoa(int(status.Code)))
+	if status.Message != "" {
+		header.Set(prefix+"Grpc-Message", encodeMessage(status.Message))
+	}
+	if status.Code != OK {
+		header.Set(prefix+"Grpc-Status-Details-Bin", status.details())
+	}
+	if !rw.wrote {
+		rw.w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,215 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// newTestServer serves a server with an echo, a counting stream and failing methods over HTTP/2 and TLS
+func newTestServer(t *testing.T) *httptest.Server {
+	s := NewServer()
+	s.Unary("/test.Test/Echo", func(ctx context.Context, call *Call) ([]byte, error) {
+		return call.Message, nil
+	})
+	s.Unary("/test.Test/Invalid", func(ctx context.Context, call *Call) ([]byte, error) {
+		return nil, &Status{Code: InvalidArgument, Message: "bad", Violations: []FieldViolation{{Field: "name", Description: "is required"}}}
+	})
+	s.Unary("/test.Test/Panic", func(ctx context.Context, call *Call) ([]byte, error) {
+		panic("boom")
+	})
+	s.Unary("/test.Test/Wait", func(ctx context.Context, call *Call) ([]byte, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	s.ServerStream("/test.Test/Count", func(ctx context.Context, call *Call, send func([]byte) error) error {
+		for i := 1; i <= 3; i++ {
+			if err := send(bytes.Repeat(call.Message, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	s.ServerStream("/test.Test/Abort", func(ctx context.Context, call *Call, send func([]byte) error) error {
+		if err := send(call.Message); err != nil {
+			return err
+		}
+		return Errorf(Aborted, "%s", "café 100%")
+	})
+	srv := httptest.NewUnstartedServer(s)
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// frame returns a message with the prefix of an uncompressed gRPC message
+func frame(message string) []byte {
+	prefix := make([]byte, 5)
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(message)))
+	return append(prefix, message...)
+}
+
+// response is what a call got back, the status from the trailers or from the headers of a response without messages
+type response struct {
+	messages []string
+	status   string
+	message  string
+	details  string
+}
+
+// call sends the body to a method and reads the response up to its trailers
+func call(t *testing.T, srv *httptest.Server, method string, body []byte, header http.Header) response {
+	t.Helper()
+	r, err := http.NewRequest("POST", srv.URL+method, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/grpc")
+	r.Header.Set("TE", "trailers")
+	for key, values := range header {
+		r.Header[key] = values
+	}
+	resp, err := srv.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 || resp.ProtoMajor != 2 {
+		t.Fatalf("%s: got %s over %s", method, resp.Status, resp.Proto)
+	}
+
+	got := response{messages: []string{}}
+	prefix := make([]byte, 5)
+	for {
+		_, err := io.ReadFull(resp.Body, prefix)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		message := make([]byte, binary.BigEndian.Uint32(prefix[1:]))
+		_, err = io.ReadFull(resp.Body, message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got.messages = append(got.messages, string(message))
+	}
+	status := resp.Trailer
+	if len(got.messages) == 0 {
+		status = resp.Header
+	}
+	got.status = status.Get("Grpc-Status")
+	got.message = status.Get("Grpc-Message")
+	got.details = status.Get("Grpc-Status-Details-Bin")
+	return got
+}
+
+func TestCalls(t *testing.T) {
+	srv := newTestServer(t)
+	tooLarge := make([]byte, 5)
+	binary.BigEndian.PutUint32(tooLarge[1:], MaxMessageBytes+1)
+	tests := []struct {
+		name     string
+		method   string
+		body     []byte
+		header   http.Header
+		messages []string
+		status   string
+		message  string
+	}{
+		{"unary", "/test.Test/Echo", frame("\x0a\x02hi"), nil, []string{"\x0a\x02hi"}, "0", ""},
+		{"empty message", "/test.Test/Echo", frame(""), nil, []string{""}, "0", ""},
+		{"stream", "/test.Test/Count", frame("ab"), nil, []string{"ab", "abab", "ababab"}, "0", ""},
+		{"error after a message", "/test.Test/Abort", frame("ab"), nil, []string{"ab"}, "10", "caf%C3%A9 100%25"},
+		{"error", "/test.Test/Invalid", frame(""), nil, []string{}, "3", "bad"},
+		{"unknown method", "/test.Test/Missing", frame(""), nil, []string{}, "12", "unknown method /test.Test/Missing"},
+		{"panic", "/test.Test/Panic", frame(""), nil, []string{}, "13", "/test.Test/Panic panicked: boom"},
+		{"deadline", "/test.Test/Wait", frame(""), http.Header{"Grpc-Timeout": {"20m"}}, []string{}, "4", "deadline exceeded"},
+		{"no message", "/test.Test/Echo", nil, nil, []string{}, "3", "the request has no message"},
+		{"truncated message", "/test.Test/Echo", frame("hi")[:6], nil, []string{}, "3", "the request message is truncated"},
+		{"compressed message", "/test.Test/Echo", append([]byte{1}, frame("hi")[1:]...), nil, []string{}, "12",
+			"compressed messages are not supported"},
+		{"compression", "/test.Test/Echo", frame("hi"), http.Header{"Grpc-Encoding": {"gzip"}}, []string{}, "12",
+			"gzip compression is not supported"},
+		{"message too large", "/test.Test/Echo", tooLarge, nil, []string{}, "8", "the request message is larger than 4194304 bytes"},
+	}
+	for _, test := range tests {
+		got := call(t, srv, test.method, test.body, test.header)
+		if !reflect.DeepEqual(got.messages, test.messages) {
+			t.Errorf("%s: got messages %q, want %q", test.name, got.messages, test.messages)
+		}
+		if got.status != test.status || got.message != test.message {
+			t.Errorf("%s: got status %s %q, want %s %q", test.name, got.status, got.message, test.status, test.message)
+		}
+		if (got.details == "") != (test.status == "0") {
+			t.Errorf("%s: got details %q with status %s", test.name, got.details, got.status)
+		}
+	}
+}
+
+// TestStatusDetails checks the google.rpc.Status of an error, with the google.rpc.BadRequest of its violations
+func TestStatusDetails(t *testing.T) {
+	srv := newTestServer(t)
+	got := call(t, srv, "/test.Test/Invalid", frame(""), nil)
+	details, err := base64.RawStdEncoding.DecodeString(got.details)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "\x08\x03" + // 1: code 3
+		"\x12\x03bad" + // 2: message
+		"\x1a\x42" + // 3: details, an Any
+		"\x0a\x29type.googleapis.com/google.rpc.BadRequest" +
+		"\x12\x15" + // 2: value, a BadRequest
+		"\x0a\x13" + // 1: field violation
+		"\x0a\x04name" +
+		"\x12\x0bis required"
+	if string(details) != want {
+		t.Errorf("got  %q\nwant %q", details, want)
+	}
+}
+
+// TestNotGRPC checks the requests answered with an HTTP error, which gRPC clients cannot read a status from
+func TestNotGRPC(t *testing.T) {
+	srv := newTestServer(t)
+	http1 := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: srv.Client().Transport.(*http.Transport).TLSClientConfig,
+		TLSNextProto:    map[string]func(string, *tls.Conn) http.RoundTripper{},
+	}}
+	tests := []struct {
+		name        string
+		client      *http.Client
+		method      string
+		contentType string
+		status      int
+	}{
+		{"HTTP/1.1", http1, "POST", "application/grpc", 505},
+		{"GET", srv.Client(), "GET", "application/grpc", 415},
+		{"JSON", srv.Client(), "POST", "application/json", 415},
+	}
+	for _, test := range tests {
+		r, err := http.NewRequest(test.method, srv.URL+"/test.Test/Echo", bytes.NewReader(frame("")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", test.contentType)
+		resp, err := test.client.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: got %s, want %d", test.name, resp.Status, test.status)
+		}
+	}
+}
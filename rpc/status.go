@@ -0,0 +1,102 @@
+package rpc
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// Code is a gRPC status code
+type Code int
+
+// gRPC status codes
+const (
+	OK                 Code = 0
+	Canceled           Code = 1
+	Unknown            Code = 2
+	InvalidArgument    Code = 3
+	DeadlineExceeded   Code = 4
+	NotFound           Code = 5
+	AlreadyExists      Code = 6
+	PermissionDenied   Code = 7
+	ResourceExhausted  Code = 8
+	FailedPrecondition Code = 9
+	Aborted            Code = 10
+	OutOfRange         Code = 11
+	Unimplemented      Code = 12
+	Internal           Code = 13
+	Unavailable        Code = 14
+	DataLoss           Code = 15
+	Unauthenticated    Code = 16
+)
+
+// String returns the name of a code, as gRPC tools print it
+func (c Code) String() string {
+	names := []string{"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED", "NOT_FOUND", "ALREADY_EXISTS",
+		"PERMISSION_DENIED", "RESOURCE_EXHAUSTED", "FAILED_PRECONDITION", "ABORTED", "OUT_OF_RANGE", "UNIMPLEMENTED",
+		"INTERNAL", "UNAVAILABLE", "DATA_LOSS", "UNAUTHENTICATED"}
+	if int(c) < 0 || int(c) >= len(names) {
+		return fmt.Sprintf("CODE(%d)", int(c))
+	}
+	return names[c]
+}
+
+// FieldViolation tells why a field of a request is invalid, sent as a google.rpc.BadRequest detail
+type FieldViolation struct {
+	Field       string
+	Description string
+}
+
+// Status is the error of a call, returned by handlers to answer with a code other than Internal
+type Status struct {
+	Code       Code
+	Message    string
+	Violations []FieldViolation
+}
+
+func (s *Status) Error() string {
+	return fmt.Sprintf("rpc error: code = %s desc = %s", s.Code, s.Message)
+}
+
+// Errorf returns a status with a formatted message
+func Errorf(code Code, format string, args ...interface{}) *Status {
+	return &Status{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// badRequestType is the type URL of the google.rpc.BadRequest details
+const badRequestType = "type.googleapis.com/google.rpc.BadRequest"
+
+// details encodes the status as a google.rpc.Status, the value of the grpc-status-details-bin trailer
+func (s *Status) details() string {
+	status := &Encoder{}
+	status.Int64(1, int64(s.Code))
+	status.String(2, s.Message)
+	if len(s.Violations) > 0 {
+		badRequest := &Encoder{}
+		for _, violation := range s.Violations {
+			v := &Encoder{}
+			v.String(1, violation.Field)
+			v.String(2, violation.Description)
+			badRequest.Message(1, v.Bytes())
+		}
+		detail := &Encoder{}
+		detail.String(1, badRequestType)
+		detail.Message(2, badRequest.Bytes())
+		status.Message(3, detail.Bytes())
+	}
+	return base64.RawStdEncoding.EncodeToString(status.Bytes())
+}
+
+// encodeMessage percent-encodes a status message for the grpc-message trailer
+func encodeMessage(message string) string {
+	b := strings.Builder{}
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c < ' ' || c > '~' || c == '%' {
+			b.WriteString(fmt.Sprintf("%%%02X", c))
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
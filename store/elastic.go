@@ -0,0 +1,350 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	elastic "gopkg.in/olivere/elastic.v5"
+
+	"github.com/raazcrzy/imdb/dbConnections"
+	"github.com/raazcrzy/imdb/models"
+)
+
+// ElasticMovieStore searches movies in the Elasticsearch index behind the movie alias.
+// Movies are written to the imdb.movies table, along with an outbox event the outbox worker applies to the index
+// and the movie event of the write.
+type ElasticMovieStore struct {
+	db    *sql.DB
+	index string
+}
+
+// NewElasticMovieStore returns a movie store writing through db and searching the given index or alias
+// through the connection opened by dbConnections.InitElasticsearch
+func NewElasticMovieStore(db *sql.DB, index string) *ElasticMovieStore {
+	return &ElasticMovieStore{db: db, index: index}
+}
+
+// AddMovie adds a movie and returns the id generated for it, which is also stored in its movie_id field
+func (s *ElasticMovieStore) AddMovie(movie models.Movie) (string, error) {
+	id, err := newMovieID()
+	if err != nil {
+		return "", err
+	}
+	movie.ID = id
+	err = s.write(models.MovieCreated, id, &movie, func(tx *sql.Tx) (int64, error) {
+		return 1, insertMovie(tx, id, movie)
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// EditMovie replaces every field of the movie with the id of the given one
+func (s *ElasticMovieStore) EditMovie(movie models.Movie) error {
+	return s.write(models.MovieUpdated, movie.ID, &movie, func(tx *sql.Tx) (int64, error) {
+		return updateMovie(tx, movie)
+	})
+}
+
+// DeleteMovie removes the movie with the given id
+func (s *ElasticMovieStore) DeleteMovie(id string) error {
+	return s.write(models.MovieDeleted, id, nil, func(tx *sql.Tx) (int64, error) {
+		version, err := deleteMovie(tx, id)
+		// the delete is a change of its own, the document must not be recreated by an earlier version
+		return version + 1, err
+	})
+}
+
+// GetMovie returns the movie with the given id from imdb.movies, which the index may not have caught up with yet
+func (s *ElasticMovieStore) GetMovie(id string) (models.Movie, error) {
+	return selectMovie(s.db, id)
+}
+
+// write runs a change of imdb.movies and records its outbox event and its movie event in the same transaction, then
+// wakes the outbox worker. change returns the version of the movie the outbox event carries, movie is nil for a delete.
+func (s *ElasticMovieStore) write(eventType, id string, movie *models.Movie, change func(tx *sql.Tx) (int64, error)) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	version, err := change(tx)
+	if err != nil {
+		return err
+	}
+	err = dbConnections.EnqueueMovieEvent(tx, id, version, movie)
+	if err != nil {
+		return err
+	}
+	_, err = appendEvent(tx, models.MovieEvent{Type: eventType, MovieID: id, Movie: movie, CreatedAt: time.Now().Unix()})
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	dbConnections.WakeOutbox()
+	return nil
+}
+
+// SearchMovies runs the query as an elasticsearch search
+func (s *ElasticMovieStore) SearchMovies(query MovieQuery) (MovieResults, error) {
+	source := elastic.NewSearchSource().Query(elasticQuery(query)).SortBy(elasticSorters(query.Sort)...).From(query.From).Size(query.Size)
+	if len(query.After) > 0 {
+		source = source.SearchAfter(query.After...)
+	}
+	if query.Highlight {
+		source = source.Highlight(elastic.NewHighlight().Fields(elastic.NewHighlighterField("name"), elastic.NewHighlighterField("director")))
+	}
+	if query.Explain {
+		source = source.Explain(true)
+	}
+	if query.Facets {
+		for name, agg := range movieFacets() {
+			source = source.Aggregation(name, agg)
+		}
+	}
+	response, err := dbConnections.SearchIndex(context.Background(), s.index, source)
+	if err != nil {
+		return MovieResults{}, err
+	}
+
+	results := MovieResults{Hits: []MovieHit{}, Total: response.Hits.TotalHits}
+	for _, hit := range response.Hits.Hits {
+		movie := MovieHit{}
+		err = json.Unmarshal(*hit.Source, &movie.Movie)
+		if err != nil {
+			return MovieResults{}, err
+		}
+		movie.ID = hit.Id
+		movie.Sort = hit.Sort
+		if query.Highlight {
+			movie.Highlight = hit.Highlight
+		}
+		if query.Explain {
+			movie.Score = hit.Score
+			movie.Explanation = hit.Explanation
+		}
+		results.Hits = append(results.Hits, movie)
+	}
+	if query.Facets {
+		results.Facets = readFacets(response.Aggregations)
+	}
+	return results, nil
+}
+
+// elasticQuery builds the bool query of a search, wrapped in the function_score query of its ranking profile if any
+func elasticQuery(query MovieQuery) elastic.Query {
+	var result elastic.Query = elastic.NewMatchAllQuery()
+	if len(query.Must)+len(query.Filter)+len(query.MustNot) > 0 {
+		boolQuery := elastic.NewBoolQuery()
+		for _, condition := range query.Must {
+			boolQuery.Must(elasticCondition(condition))
+		}
+		for _, condition := range query.Filter {
+			boolQuery.Filter(elasticCondition(condition))
+		}
+		for _, condition := range query.MustNot {
+			boolQuery.MustNot(elasticCondition(condition))
+		}
+		result = boolQuery
+	}
+	if query.Ranking != nil {
+		result = rankingQuery(result, query.Ranking)
+	}
+	return result
+}
+
+// elasticCondition translates a condition into the equivalent elasticsearch query
+func elasticCondition(condition Condition) elastic.Query {
+	switch condition.Kind {
+	case ConditionMatch, ConditionPhrase:
+		if len(condition.Fields) == 1 {
+			field := condition.Fields[0]
+			if condition.Kind == ConditionPhrase {
+				phrase := elastic.NewMatchPhraseQuery(field, condition.Value)
+				if boost, ok := condition.Boosts[field]; ok {
+					phrase.Boost(boost)
+				}
+				return phrase
+			}
+			match := elastic.NewMatchQuery(field, condition.Value)
+			if condition.AllWords {
+				match.Operator("and")
+			}
+			if boost, ok := condition.Boosts[field]; ok {
+				match.Boost(boost)
+			}
+			return match
+		}
+		multiMatch := elastic.NewMultiMatchQuery(condition.Value)
+		for _, field := range condition.Fields {
+			if boost, ok := condition.Boosts[field]; ok {
+				multiMatch.FieldWithBoost(field, boost)
+			} else {
+				multiMatch.Field(field)
+			}
+		}
+		if condition.Kind == ConditionPhrase {
+			multiMatch.Type("phrase")
+		}
+		if condition.AllWords {
+			multiMatch.Operator("and")
+		}
+		return multiMatch
+	case ConditionEquals:
+		field := condition.Fields[0]
+		// text fields are compared with their keyword subfield
+		if _, ok := condition.Value.(string); ok {
+			field += ".keyword"
+		}
+		return elastic.NewTermQuery(field, condition.Value)
+	case ConditionRange:
+		bounds := map[string]interface{}{}
+		if condition.Gte != nil {
+			bounds["gte"] = *condition.Gte
+		}
+		if condition.Lte != nil {
+			bounds["lte"] = *condition.Lte
+		}
+		if condition.Gt != nil {
+			bounds["gt"] = *condition.Gt
+		}
+		if condition.Lt != nil {
+			bounds["lt"] = *condition.Lt
+		}
+		return rangeQuery{field: condition.Fields[0], bounds: bounds}
+	}
+	anyQuery := elastic.NewBoolQuery().MinimumNumberShouldMatch(1)
+	for _, sub := range condition.Any {
+		anyQuery.Should(elasticCondition(sub))
+	}
+	return anyQuery
+}
+
+// rangeQuery is a range query written with gte, lte, gt and lt.
+// elastic.RangeQuery writes the from, to, include_lower and include_upper keys removed in elasticsearch 8.
+type rangeQuery struct {
+	field  string
+	bounds map[string]interface{}
+}
+
+// Source returns the JSON of the query
+func (q rangeQuery) Source() (interface{}, error) {
+	return map[string]interface{}{
+		"range": map[string]interface{}{q.field: q.bounds},
+	}, nil
+}
+
+// elasticSorters returns the sorters of a search, the movie id is appended as the last tie-breaker
+func elasticSorters(fields []SortField) []elastic.Sorter {
+	sorters := []elastic.Sorter{}
+	for _, field := range fields {
+		switch field.Field {
+		case "relevance":
+			sorters = append(sorters, elastic.NewScoreSort().Order(field.Ascending))
+		case "name":
+			sorters = append(sorters, elastic.NewFieldSort("name.keyword").Order(field.Ascending))
+		default:
+			sorters = append(sorters, elastic.NewFieldSort(field.Field).Order(field.Ascending))
+		}
+	}
+	return append(sorters, elastic.NewFieldSort("movie_id").Asc())
+}
+
+// rankingQuery wraps the search query in the function_score query described by the profile
+func rankingQuery(query elastic.Query, profile *models.RankingProfile) elastic.Query {
+	functionScore := elastic.NewFunctionScoreQuery().Query(query)
+	for _, factor := range profile.FieldValueFactors {
+		fn := elastic.NewFieldValueFactorFunction().Field(factor.Field).Missing(factor.Missing)
+		if factor.Factor != 0 {
+			fn.Factor(factor.Factor)
+		}
+		if factor.Modifier != "" {
+			fn.Modifier(factor.Modifier)
+		}
+		if factor.Weight != 0 {
+			fn.Weight(factor.Weight)
+		}
+		functionScore.AddScoreFunc(fn)
+	}
+	for _, decay := range profile.Decays {
+		functionScore.AddScoreFunc(decayFunction(decay))
+	}
+	if profile.ScoreMode != "" {
+		functionScore.ScoreMode(profile.ScoreMode)
+	}
+	if profile.BoostMode != "" {
+		functionScore.BoostMode(profile.BoostMode)
+	}
+	return functionScore
+}
+
+func decayFunction(decay models.DecayFunction) elastic.ScoreFunction {
+	switch decay.Function {
+	case "exp":
+		fn := elastic.NewExponentialDecayFunction().FieldName(decay.Field).Origin(decay.Origin).Scale(decay.Scale).Offset(decay.Offset)
+		if decay.Decay != 0 {
+			fn.Decay(decay.Decay)
+		}
+		if decay.Weight != 0 {
+			fn.Weight(decay.Weight)
+		}
+		return fn
+	case "linear":
+		fn := elastic.NewLinearDecayFunction().FieldName(decay.Field).Origin(decay.Origin).Scale(decay.Scale).Offset(decay.Offset)
+		if decay.Decay != 0 {
+			fn.Decay(decay.Decay)
+		}
+		if decay.Weight != 0 {
+			fn.Weight(decay.Weight)
+		}
+		return fn
+	}
+	fn := elastic.NewGaussDecayFunction().FieldName(decay.Field).Origin(decay.Origin).Scale(decay.Scale).Offset(decay.Offset)
+	if decay.Decay != 0 {
+		fn.Decay(decay.Decay)
+	}
+	if decay.Weight != 0 {
+		fn.Weight(decay.Weight)
+	}
+	return fn
+}
+
+// movieFacets returns the aggregations computed alongside a search when facets are requested
+func movieFacets() map[string]elastic.Aggregation {
+	return map[string]elastic.Aggregation{
+		"genre":        elastic.NewTermsAggregation().Field("genre.keyword").Size(50),
+		"director":     elastic.NewTermsAggregation().Field("director.keyword").Size(20),
+		"imdb_score":   elastic.NewHistogramAggregation().Field("imdb_score").Interval(1).MinDocCount(1),
+		"99popularity": elastic.NewHistogramAggregation().Field("99popularity").Interval(10).MinDocCount(1),
+	}
+}
+
+// readFacets converts the aggregations of a search response into facet buckets
+func readFacets(aggs elastic.Aggregations) map[string][]FacetBucket {
+	facets := map[string][]FacetBucket{}
+	for _, name := range []string{"genre", "director"} {
+		buckets := []FacetBucket{}
+		if terms, ok := aggs.Terms(name); ok {
+			for _, b := range terms.Buckets {
+				buckets = append(buckets, FacetBucket{Key: b.Key, Count: b.DocCount})
+			}
+		}
+		facets[name] = buckets
+	}
+	for _, name := range []string{"imdb_score", "99popularity"} {
+		buckets := []FacetBucket{}
+		if histogram, ok := aggs.Histogram(name); ok {
+			for _, b := range histogram.Buckets {
+				buckets = append(buckets, FacetBucket{Key: b.Key, Count: b.DocCount})
+			}
+		}
+		facets[name] = buckets
+	}
+	return facets
+}
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"sync"
+
+	"github.com/raazcrzy/imdb/models"
+)
+
+// EventStore records the movie events in order, for the /v1/events stream to replay them
+type EventStore interface {
+	// AppendEvent records an event and returns it with its id, which is greater than the id of every earlier event
+	AppendEvent(event models.MovieEvent) (models.MovieEvent, error)
+	// EventsAfter returns up to limit of the events recorded after the one with the given id, oldest first
+	EventsAfter(id int64, limit int) ([]models.MovieEvent, error)
+	// LastEventID returns the id of the latest event, 0 when there is none
+	LastEventID() (int64, error)
+}
+
+// memoryEventCapacity is the number of events kept by a MemoryEventStore
+const memoryEventCapacity = 1000
+
+// MemoryEventStore keeps the latest events in process, they are lost when the process exits
+type MemoryEventStore struct {
+	mu     sync.RWMutex
+	events []models.MovieEvent
+	lastID int64
+}
+
+// NewMemoryEventStore returns an empty in-memory event store
+func NewMemoryEventStore() *MemoryEventStore {
+	return &MemoryEventStore{}
+}
+
+// AppendEvent records an event, the oldest event is dropped once memoryEventCapacity are kept
+func (s *MemoryEventStore) AppendEvent(event models.MovieEvent) (models.MovieEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastID++
+	event.ID = s.lastID
+	s.events = append(s.events, event)
+	if len(s.events) > memoryEventCapacity {
+		s.events = append([]models.MovieEvent{}, s.events[len(s.events)-memoryEventCapacity:]...)
+	}
+	return event, nil
+}
+
+// EventsAfter returns up to limit of the kept events recorded after the one with the given id
+func (s *MemoryEventStore) EventsAfter(id int64, limit int) ([]models.MovieEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	events := []models.MovieEvent{}
+	for _, event := range s.events {
+		if event.ID > id && len(events) < limit {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// LastEventID returns the id of the latest event, 0 when there is none
+func (s *MemoryEventStore) LastEventID() (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastID, nil
+}
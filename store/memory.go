@@ -0,0 +1,201 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/raazcrzy/imdb/models"
+)
+
+// MemoryUserStore keeps users in process, they are lost when the process exits
+type MemoryUserStore struct {
+	mu    sync.RWMutex
+	users map[string]models.User
+}
+
+// NewMemoryUserStore returns an empty in-memory user store
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{users: map[string]models.User{}}
+}
+
+// EmailForCredentials returns the email of the user with the given user name and password, "" when there is none
+func (s *MemoryUserStore) EmailForCredentials(userName, password string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, user := range s.users {
+		if user.UserName == userName && user.UserPassword == password {
+			return user.Email, nil
+		}
+	}
+	return "", nil
+}
+
+// Role returns the role of the user with the given email, "" when there is none
+func (s *MemoryUserStore) Role(email string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.users[email].Role, nil
+}
+
+// HasUserName reports whether the user with the given email has the given user name
+func (s *MemoryUserStore) HasUserName(email, userName string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	user, ok := s.users[email]
+	return ok && user.UserName == userName, nil
+}
+
+// CreateUser adds a user, failing with ErrUserExists or ErrUserNameTaken when the email or user name is in use
+func (s *MemoryUserStore) CreateUser(user models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[user.Email]; ok {
+		return ErrUserExists
+	}
+	for _, existing := range s.users {
+		if existing.UserName == user.UserName {
+			return ErrUserNameTaken
+		}
+	}
+	s.users[user.Email] = user
+	return nil
+}
+
+// DeleteUser removes the user with the given email, if any
+func (s *MemoryUserStore) DeleteUser(email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.users, email)
+	return nil
+}
+
+// MemoryMovieStore keeps movies in process and searches them with basic text matching:
+// text is split into lowercase words, without the stemming and synonyms of the elasticsearch analyzers.
+// The event of a write is appended to events while the movies are locked, so events are in the order of the writes.
+type MemoryMovieStore struct {
+	mu     sync.RWMutex
+	movies map[string]models.Movie
+	events *MemoryEventStore
+}
+
+// NewMemoryMovieStore returns an empty in-memory movie store recording its events in the given event store
+func NewMemoryMovieStore(events *MemoryEventStore) *MemoryMovieStore {
+	return &MemoryMovieStore{movies: map[string]models.Movie{}, events: events}
+}
+
+// AddMovie adds a movie and returns the id generated for it
+func (s *MemoryMovieStore) AddMovie(movie models.Movie) (string, error) {
+	id, err := newMovieID()
+	if err != nil {
+		return "", err
+	}
+	movie.ID = id
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.movies[id] = movie
+	s.appendEvent(models.MovieCreated, id, &movie)
+	return id, nil
+}
+
+// EditMovie replaces every field of the movie with the id of the given one
+func (s *MemoryMovieStore) EditMovie(movie models.Movie) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.movies[movie.ID]; !ok {
+		return fmt.Errorf("%w: %s", ErrMovieNotFound, movie.ID)
+	}
+	s.movies[movie.ID] = movie
+	s.appendEvent(models.MovieUpdated, movie.ID, &movie)
+	return nil
+}
+
+// DeleteMovie removes the movie with the given id
+func (s *MemoryMovieStore) DeleteMovie(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.movies[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrMovieNotFound, id)
+	}
+	delete(s.movies, id)
+	s.appendEvent(models.MovieDeleted, id, nil)
+	return nil
+}
+
+// appendEvent records the event of a write, movie is nil for a delete
+func (s *MemoryMovieStore) appendEvent(eventType, id string, movie *models.Movie) {
+	s.events.AppendEvent(models.MovieEvent{Type: eventType, MovieID: id, Movie: movie, CreatedAt: time.Now().Unix()})
+}
+
+// GetMovie returns the movie with the given id
+func (s *MemoryMovieStore) GetMovie(id string) (models.Movie, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	movie, ok := s.movies[id]
+	if !ok {
+		return models.Movie{}, fmt.Errorf("%w: %s", ErrMovieNotFound, id)
+	}
+	return movie, nil
+}
+
+// SearchMovies scores every movie against the query and returns the requested page
+func (s *MemoryMovieStore) SearchMovies(query MovieQuery) (MovieResults, error) {
+	s.mu.RLock()
+	hits := []MovieHit{}
+	for _, movie := range s.movies {
+		score, ok := matchMovie(movie, query)
+		if !ok {
+			continue
+		}
+		if query.Ranking != nil {
+			score = rankMovie(movie, score, query.Ranking)
+		}
+		hit := MovieHit{Movie: movie, Sort: sortValues(movie, score, query.Sort)}
+		if query.Highlight {
+			hit.Highlight = highlightMovie(movie, query.Must)
+		}
+		if query.Explain {
+			hit.Score = &score
+			hit.Explanation = map[string]interface{}{
+				"value":       score,
+				"description": "in-memory text match, one point per matched word times its field boost",
+			}
+		}
+		hits = append(hits, hit)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(hits, func(i, j int) bool {
+		return compareSortValues(hits[i].Sort, hits[j].Sort, query.Sort) < 0
+	})
+	results := MovieResults{Hits: []MovieHit{}, Total: int64(len(hits))}
+	if query.Facets {
+		results.Facets = countFacets(hits)
+	}
+	if len(query.After) > 0 {
+		after := []MovieHit{}
+		for _, hit := range hits {
+			if compareSortValues(hit.Sort, query.After, query.Sort) > 0 {
+				after = append(after, hit)
+			}
+		}
+		hits = after
+	}
+	for i := query.From; i < len(hits) && i < query.From+query.Size; i++ {
+		results.Hits = append(results.Hits, hits[i])
+	}
+	return results, nil
+}
+
+// newMovieID returns a random url safe id, like the ones generated by elasticsearch
+func newMovieID() (string, error) {
+	random := make([]byte, 15)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
@@ -0,0 +1,439 @@
+package store
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/raazcrzy/imdb/models"
+)
+
+/*
+Search in the memory store mimics the elasticsearch one closely enough for tests and local development.
+Text is compared word by word, a match condition scores one point per matched word times the boost of
+its field, and ranking profiles are applied with the formulas of the function_score query.
+*/
+
+// matchMovie reports whether a movie matches the query along with its score
+func matchMovie(movie models.Movie, query MovieQuery) (float64, bool) {
+	for _, condition := range query.Filter {
+		if _, ok := matchCondition(movie, condition); !ok {
+			return 0, false
+		}
+	}
+	for _, condition := range query.MustNot {
+		if _, ok := matchCondition(movie, condition); ok {
+			return 0, false
+		}
+	}
+	if len(query.Must) == 0 {
+		return 1, true
+	}
+	total := 0.0
+	for _, condition := range query.Must {
+		score, ok := matchCondition(movie, condition)
+		if !ok {
+			return 0, false
+		}
+		total += score
+	}
+	return total, true
+}
+
+// matchCondition reports whether a movie matches a condition, text conditions also return a score
+func matchCondition(movie models.Movie, condition Condition) (float64, bool) {
+	switch condition.Kind {
+	case ConditionMatch, ConditionPhrase:
+		words := tokenize(stringValue(condition.Value))
+		if len(words) == 0 {
+			return 0, false
+		}
+		best := 0.0
+		for _, field := range condition.Fields {
+			boost, ok := condition.Boosts[field]
+			if !ok {
+				boost = 1
+			}
+			for _, text := range textValues(movie, field) {
+				tokens := tokenize(text)
+				matched := 0
+				if condition.Kind == ConditionPhrase {
+					if containsPhrase(tokens, words) {
+						matched = len(words)
+					}
+				} else {
+					matched = countMatched(tokens, words)
+					if condition.AllWords && matched < len(words) {
+						matched = 0
+					}
+				}
+				if score := float64(matched) * boost; score > best {
+					best = score
+				}
+			}
+		}
+		return best, best > 0
+	case ConditionEquals:
+		field := condition.Fields[0]
+		if number, ok := numberValue(movie, field); ok {
+			value, isNumber := condition.Value.(float64)
+			return 0, isNumber && number == float64(float32(value))
+		}
+		for _, text := range textValues(movie, field) {
+			if text == condition.Value {
+				return 0, true
+			}
+		}
+		return 0, false
+	case ConditionRange:
+		number, ok := numberValue(movie, condition.Fields[0])
+		if !ok {
+			return 0, false
+		}
+		inRange := (condition.Gte == nil || number >= float64(float32(*condition.Gte))) &&
+			(condition.Lte == nil || number <= float64(float32(*condition.Lte))) &&
+			(condition.Gt == nil || number > float64(float32(*condition.Gt))) &&
+			(condition.Lt == nil || number < float64(float32(*condition.Lt)))
+		return 0, inRange
+	}
+	for _, sub := range condition.Any {
+		if _, ok := matchCondition(movie, sub); ok {
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+// tokenize splits text into lowercase words
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// countMatched counts the words present in tokens
+func countMatched(tokens, words []string) int {
+	present := map[string]bool{}
+	for _, token := range tokens {
+		present[token] = true
+	}
+	matched := 0
+	for _, word := range words {
+		if present[word] {
+			matched++
+		}
+	}
+	return matched
+}
+
+// containsPhrase reports whether words appear in tokens next to each other and in order
+func containsPhrase(tokens, words []string) bool {
+	for start := 0; start+len(words) <= len(tokens); start++ {
+		found := true
+		for i, word := range words {
+			if tokens[start+i] != word {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// textValues returns the values of a text field of a movie
+func textValues(movie models.Movie, field string) []string {
+	switch field {
+	case "name":
+		return []string{movie.Name}
+	case "director":
+		return []string{movie.Director}
+	case "genre":
+		return movie.Genre
+	}
+	return nil
+}
+
+// numberValue returns the value of a numeric field of a movie, ok is false for other fields
+func numberValue(movie models.Movie, field string) (float64, bool) {
+	switch field {
+	case "imdb_score":
+		return float64(movie.IMDBScore), true
+	case "99popularity":
+		return float64(movie.Popularity), true
+	}
+	return 0, false
+}
+
+func stringValue(value interface{}) string {
+	text, _ := value.(string)
+	return text
+}
+
+// rankMovie applies the functions of a ranking profile to the score of a movie
+func rankMovie(movie models.Movie, score float64, profile *models.RankingProfile) float64 {
+	values := []float64{}
+	for _, factor := range profile.FieldValueFactors {
+		value, _ := numberValue(movie, factor.Field)
+		if factor.Factor != 0 {
+			value *= factor.Factor
+		}
+		value = applyModifier(value, factor.Modifier)
+		if factor.Weight != 0 {
+			value *= factor.Weight
+		}
+		values = append(values, value)
+	}
+	for _, decay := range profile.Decays {
+		value, _ := numberValue(movie, decay.Field)
+		value = decayValue(value, decay)
+		if decay.Weight != 0 {
+			value *= decay.Weight
+		}
+		values = append(values, value)
+	}
+	if len(values) == 0 {
+		return score
+	}
+
+	combined := values[0]
+	for _, value := range values[1:] {
+		switch profile.ScoreMode {
+		case "sum", "avg":
+			combined += value
+		case "first":
+		case "max":
+			combined = math.Max(combined, value)
+		case "min":
+			combined = math.Min(combined, value)
+		default:
+			combined *= value
+		}
+	}
+	if profile.ScoreMode == "avg" {
+		combined /= float64(len(values))
+	}
+
+	switch profile.BoostMode {
+	case "replace":
+		return combined
+	case "sum":
+		return score + combined
+	case "avg":
+		return (score + combined) / 2
+	case "max":
+		return math.Max(score, combined)
+	case "min":
+		return math.Min(score, combined)
+	}
+	return score * combined
+}
+
+// applyModifier applies a field_value_factor modifier
+func applyModifier(value float64, modifier string) float64 {
+	switch modifier {
+	case "log":
+		return math.Log10(value)
+	case "log1p":
+		return math.Log10(value + 1)
+	case "log2p":
+		return math.Log10(value + 2)
+	case "ln":
+		return math.Log(value)
+	case "ln1p":
+		return math.Log1p(value)
+	case "ln2p":
+		return math.Log(value + 2)
+	case "square":
+		return value * value
+	case "sqrt":
+		return math.Sqrt(value)
+	case "reciprocal":
+		return 1 / value
+	}
+	return value
+}
+
+// decayValue computes a decay function the way elasticsearch does
+func decayValue(value float64, decay models.DecayFunction) float64 {
+	rate := decay.Decay
+	if rate == 0 {
+		rate = 0.5
+	}
+	distance := math.Max(0, math.Abs(value-decay.Origin)-decay.Offset)
+	switch decay.Function {
+	case "exp":
+		return math.Exp(math.Log(rate) / decay.Scale * distance)
+	case "linear":
+		s := decay.Scale / (1 - rate)
+		return math.Max(0, (s-distance)/s)
+	}
+	variance := -decay.Scale * decay.Scale / (2 * math.Log(rate))
+	return math.Exp(-distance * distance / (2 * variance))
+}
+
+// sortValues returns the values a movie is sorted on, followed by its id
+func sortValues(movie models.Movie, score float64, fields []SortField) []interface{} {
+	values := []interface{}{}
+	for _, field := range fields {
+		switch field.Field {
+		case "relevance":
+			values = append(values, score)
+		case "name":
+			values = append(values, movie.Name)
+		default:
+			number, _ := numberValue(movie, field.Field)
+			values = append(values, number)
+		}
+	}
+	return append(values, movie.ID)
+}
+
+// compareSortValues orders two lists of sort values, the last value being the id which is always ascending.
+// Values coming back from a cursor may be json numbers.
+func compareSortValues(a, b []interface{}, fields []SortField) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		ascending := i >= len(fields) || fields[i].Ascending
+		result := compareValues(a[i], b[i])
+		if !ascending {
+			result = -result
+		}
+		if result != 0 {
+			return result
+		}
+	}
+	return 0
+}
+
+func compareValues(a, b interface{}) int {
+	if textA, ok := a.(string); ok {
+		textB, _ := b.(string)
+		return strings.Compare(textA, textB)
+	}
+	numberA, numberB := toFloat(a), toFloat(b)
+	switch {
+	case numberA < numberB:
+		return -1
+	case numberA > numberB:
+		return 1
+	}
+	return 0
+}
+
+func toFloat(value interface{}) float64 {
+	switch number := value.(type) {
+	case float64:
+		return number
+	case json.Number:
+		result, _ := number.Float64()
+		return result
+	}
+	return 0
+}
+
+// highlightMovie wraps the words of name and director matched by the scored conditions in <em> tags
+func highlightMovie(movie models.Movie, conditions []Condition) map[string][]string {
+	highlight := map[string][]string{}
+	for _, field := range []string{"name", "director"} {
+		words := map[string]bool{}
+		for _, condition := range conditions {
+			if condition.Kind != ConditionMatch && condition.Kind != ConditionPhrase {
+				continue
+			}
+			for _, conditionField := range condition.Fields {
+				if conditionField == field {
+					for _, word := range tokenize(stringValue(condition.Value)) {
+						words[word] = true
+					}
+				}
+			}
+		}
+		text := textValues(movie, field)[0]
+		highlighted, ok := highlightWords(text, words)
+		if ok {
+			highlight[field] = []string{highlighted}
+		}
+	}
+	return highlight
+}
+
+// highlightWords wraps the words of text present in words, ok is false when there is none
+func highlightWords(text string, words map[string]bool) (string, bool) {
+	var result strings.Builder
+	found := false
+	runes := []rune(text)
+	isWord := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }
+	for i := 0; i < len(runes); {
+		j := i
+		for j < len(runes) && isWord(runes[j]) == isWord(runes[i]) {
+			j++
+		}
+		part := string(runes[i:j])
+		if isWord(runes[i]) && words[strings.ToLower(part)] {
+			result.WriteString("<em>" + part + "</em>")
+			found = true
+		} else {
+			result.WriteString(part)
+		}
+		i = j
+	}
+	return result.String(), found
+}
+
+// countFacets counts the genre and director values and the imdb_score and 99popularity ranges of the hits
+func countFacets(hits []MovieHit) map[string][]FacetBucket {
+	genres, directors := map[string]int64{}, map[string]int64{}
+	scores, popularities := map[float64]int64{}, map[float64]int64{}
+	for _, hit := range hits {
+		seen := map[string]bool{}
+		for _, genre := range hit.Genre {
+			if !seen[genre] {
+				genres[genre]++
+				seen[genre] = true
+			}
+		}
+		directors[hit.Director]++
+		scores[math.Floor(float64(hit.IMDBScore))]++
+		popularities[math.Floor(float64(hit.Popularity)/10)*10]++
+	}
+	return map[string][]FacetBucket{
+		"genre":        termBuckets(genres, 50),
+		"director":     termBuckets(directors, 20),
+		"imdb_score":   histogramBuckets(scores),
+		"99popularity": histogramBuckets(popularities),
+	}
+}
+
+// termBuckets returns the size most frequent values, like a terms aggregation
+func termBuckets(counts map[string]int64, size int) []FacetBucket {
+	buckets := []FacetBucket{}
+	for key, count := range counts {
+		buckets = append(buckets, FacetBucket{Key: key, Count: count})
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		if buckets[i].Count != buckets[j].Count {
+			return buckets[i].Count > buckets[j].Count
+		}
+		return buckets[i].Key.(string) < buckets[j].Key.(string)
+	})
+	if len(buckets) > size {
+		buckets = buckets[:size]
+	}
+	return buckets
+}
+
+// histogramBuckets returns the ranges in ascending order, like a histogram aggregation
+func histogramBuckets(counts map[float64]int64) []FacetBucket {
+	buckets := []FacetBucket{}
+	for key, count := range counts {
+		buckets = append(buckets, FacetBucket{Key: key, Count: count})
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Key.(float64) < buckets[j].Key.(float64)
+	})
+	return buckets
+}
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"database/sql"
+
+	"github.com/lib/pq"
+	"github.com/raazcrzy/imdb/models"
+)
+
+// PostgresUserStore keeps users in the imdb.users table
+type PostgresUserStore struct {
+	db *sql.DB
+}
+
+// NewPostgresUserStore returns a user store reading and writing through db
+func NewPostgresUserStore(db *sql.DB) *PostgresUserStore {
+	return &PostgresUserStore{db: db}
+}
+
+// EmailForCredentials returns the email of the user with the given user name and password, "" when there is none
+func (s *PostgresUserStore) EmailForCredentials(userName, password string) (string, error) {
+	row := s.db.QueryRow(`SELECT email FROM imdb.users WHERE user_id=$1 AND user_password=$2`, userName, password)
+
+	var email string
+	err := row.Scan(&email)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return email, err
+}
+
+// Role returns the role of the user with the given email, "" when there is none
+func (s *PostgresUserStore) Role(email string) (string, error) {
+	row := s.db.QueryRow(`SELECT role FROM imdb.users WHERE email=$1`, email)
+
+	var role sql.NullString
+	err := row.Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role.String, err
+}
+
+// HasUserName reports whether the user with the given email has the given user name
+func (s *PostgresUserStore) HasUserName(email, userName string) (bool, error) {
+	row := s.db.QueryRow(`SELECT COUNT(*) FROM imdb.users WHERE email=$1 AND user_id=$2`, email, userName)
+
+	var num int
+	err := row.Scan(&num)
+	return num > 0, err
+}
+
+// CreateUser adds a user, failing with ErrUserExists or ErrUserNameTaken when the email or user name is in use
+func (s *PostgresUserStore) CreateUser(user models.User) error {
+	_, err := s.db.Exec(`INSERT INTO imdb.users(email, name, created_at, user_id, user_password, role) VALUES($1, $2, $3, $4, $5, $6);`, user.Email, user.Name, user.CreatedAt, user.UserName, user.UserPassword, user.Role)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		switch pqErr.Constraint {
+		case "users_pkey":
+			return ErrUserExists
+		case "users_user_id_key":
+			return ErrUserNameTaken
+		}
+	}
+	return err
+}
+
+// DeleteUser removes the user with the given email, if any
+func (s *PostgresUserStore) DeleteUser(email string) error {
+	_, err := s.db.Exec(`DELETE FROM imdb.users WHERE email=$1;`, email)
+	return err
+}
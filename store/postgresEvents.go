@@ -0,0 +1,103 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/raazcrzy/imdb/models"
+)
+
+// eventsLockID is the key of the advisory lock serializing the inserts of events. Ids are taken from a sequence
+// when a transaction inserts, but become visible when it commits, so without the lock a reader could see an event
+// before one with a lower id and skip it.
+const eventsLockID = 727170217
+
+// PostgresEventStore keeps the movie events in the imdb.movie_events table, which every server shares.
+// The movie stores writing imdb.movies record the event of a write in its transaction, with appendEvent.
+// Appending an event also queues its delivery to the webhooks subscribed to its type.
+type PostgresEventStore struct {
+	db *sql.DB
+}
+
+// NewPostgresEventStore returns an event store reading and writing through db
+func NewPostgresEventStore(db *sql.DB) *PostgresEventStore {
+	return &PostgresEventStore{db: db}
+}
+
+// AppendEvent records an event and queues a delivery of it for each webhook subscribed to its type
+func (s *PostgresEventStore) AppendEvent(event models.MovieEvent) (models.MovieEvent, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return event, err
+	}
+	defer tx.Rollback()
+	event, err = appendEvent(tx, event)
+	if err != nil {
+		return event, err
+	}
+	return event, tx.Commit()
+}
+
+// appendEvent records an event within a transaction, and queues a delivery of it for each webhook subscribed to its
+// type. The events lock is held until the transaction ends, so events are recorded in the order their transactions
+// commit.
+func appendEvent(tx *sql.Tx, event models.MovieEvent) (models.MovieEvent, error) {
+	movie := sql.NullString{}
+	if event.Movie != nil {
+		data, err := json.Marshal(event.Movie)
+		if err != nil {
+			return event, err
+		}
+		movie = sql.NullString{String: string(data), Valid: true}
+	}
+	_, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, eventsLockID)
+	if err != nil {
+		return event, err
+	}
+	err = tx.QueryRow(`INSERT INTO imdb.movie_events(type, movie_id, movie, created_at) VALUES($1, $2, $3, $4) RETURNING id`,
+		event.Type, event.MovieID, movie, event.CreatedAt).Scan(&event.ID)
+	if err != nil {
+		return event, err
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return event, err
+	}
+	_, err = tx.Exec(`INSERT INTO imdb.webhook_deliveries(webhook_id, event_id, payload, next_attempt_at, created_at)
+	SELECT id, $1, $2, $3, $3 FROM imdb.webhooks WHERE $4 = ANY(events)`, event.ID, string(payload), event.CreatedAt, event.Type)
+	return event, err
+}
+
+// EventsAfter returns up to limit of the events recorded after the one with the given id
+func (s *PostgresEventStore) EventsAfter(id int64, limit int) ([]models.MovieEvent, error) {
+	rows, err := s.db.Query(`SELECT id, type, movie_id, movie, created_at FROM imdb.movie_events WHERE id > $1 ORDER BY id LIMIT $2`, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []models.MovieEvent{}
+	for rows.Next() {
+		event := models.MovieEvent{}
+		var movie []byte
+		err = rows.Scan(&event.ID, &event.Type, &event.MovieID, &movie, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if movie != nil {
+			event.Movie = &models.Movie{}
+			err = json.Unmarshal(movie, event.Movie)
+			if err != nil {
+				return nil, err
+			}
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// LastEventID returns the id of the latest event, 0 when there is none
+func (s *PostgresEventStore) LastEventID() (int64, error) {
+	var id int64
+	err := s.db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM imdb.movie_events`).Scan(&id)
+	return id, err
+}
@@ -0,0 +1,627 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/raazcrzy/imdb/models"
+)
+
+/*
+PostgresMovieStore is an alternative to Elasticsearch for deployments that cannot run it. Movies are kept in
+the imdb.movies table, where a trigger maintains a tsvector per text field and the normalized genres.
+name is matched with full-text search, stemmed in english, and with pg_trgm so that misspelled names
+are still found, director with unstemmed full-text search, and genre against the normalized genre array.
+Scores are computed with ts_rank and similarity, ranking profiles with the formulas of the function_score query.
+*/
+
+// PostgresMovieStore keeps movies in the imdb.movies table, and records the movie event of a write in its transaction
+type PostgresMovieStore struct {
+	db *sql.DB
+}
+
+// NewPostgresMovieStore returns a movie store reading and writing through db
+func NewPostgresMovieStore(db *sql.DB) *PostgresMovieStore {
+	return &PostgresMovieStore{db: db}
+}
+
+// movieColumns maps the numeric and keyword fields of a movie to their columns
+var movieColumns = map[string]string{
+	"name":         "name",
+	"director":     "director",
+	"imdb_score":   "imdb_score",
+	"99popularity": "popularity",
+}
+
+// textConfigs are the text search configurations of the full-text searched fields
+var textConfigs = map[string]string{
+	"name":     "english",
+	"director": "simple",
+}
+
+// AddMovie adds a movie and returns the id generated for it
+func (s *PostgresMovieStore) AddMovie(movie models.Movie) (string, error) {
+	id, err := newMovieID()
+	if err != nil {
+		return "", err
+	}
+	movie.ID = id
+	err = s.write(models.MovieCreated, id, &movie, func(tx *sql.Tx) error {
+		return insertMovie(tx, id, movie)
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// EditMovie replaces every field of the movie with the id of the given one
+func (s *PostgresMovieStore) EditMovie(movie models.Movie) error {
+	return s.write(models.MovieUpdated, movie.ID, &movie, func(tx *sql.Tx) error {
+		_, err := updateMovie(tx, movie)
+		return err
+	})
+}
+
+// DeleteMovie removes the movie with the given id
+func (s *PostgresMovieStore) DeleteMovie(id string) error {
+	return s.write(models.MovieDeleted, id, nil, func(tx *sql.Tx) error {
+		_, err := deleteMovie(tx, id)
+		return err
+	})
+}
+
+// write runs a change of imdb.movies and records its movie event in the same transaction, movie is nil for a delete
+func (s *PostgresMovieStore) write(eventType, id string, movie *models.Movie, change func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = change(tx)
+	if err != nil {
+		return err
+	}
+	_, err = appendEvent(tx, models.MovieEvent{Type: eventType, MovieID: id, Movie: movie, CreatedAt: time.Now().Unix()})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetMovie returns the movie with the given id
+func (s *PostgresMovieStore) GetMovie(id string) (models.Movie, error) {
+	return selectMovie(s.db, id)
+}
+
+// movieQuerier runs the statements writing imdb.movies, either directly or within a transaction
+type movieQuerier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// movieRowColumns are the columns of a row of imdb.movies written by insertMovie and importMovie, in the order of the
+// values of movieRow
+const movieRowColumns = `id, name, director, genre, imdb_score, popularity, version`
+
+// movieRow returns the values of the row of a movie at a version
+func movieRow(id string, movie models.Movie, version int64) []interface{} {
+	return []interface{}{id, movie.Name, movie.Director, pq.Array(nonNilGenre(movie.Genre)), movie.IMDBScore, movie.Popularity, version}
+}
+
+// movieFields are the columns of the fields of a movie besides its id, read into movieFieldsDest
+const movieFields = `name, director, genre, imdb_score, popularity`
+
+// movieFieldsDest returns the scan destinations of movieFields
+func movieFieldsDest(movie *models.Movie) []interface{} {
+	return []interface{}{&movie.Name, &movie.Director, pq.Array(&movie.Genre), &movie.IMDBScore, &movie.Popularity}
+}
+
+// insertMovie adds a movie with the given id at version 1
+func insertMovie(q movieQuerier, id string, movie models.Movie) error {
+	_, err := q.Exec(`INSERT INTO imdb.movies(`+movieRowColumns+`) VALUES($1, $2, $3, $4, $5, $6, $7)`, movieRow(id, movie, 1)...)
+	return err
+}
+
+// importMovie adds a movie written elsewhere with its id and version, unless a movie with its id exists already
+func importMovie(q movieQuerier, movie models.Movie, version int64) error {
+	_, err := q.Exec(`INSERT INTO imdb.movies(`+movieRowColumns+`) VALUES($1, $2, $3, $4, $5, $6, $7) ON CONFLICT (id) DO NOTHING`,
+		movieRow(movie.ID, movie, version)...)
+	return err
+}
+
+// updateMovie replaces every field of a movie and returns its new version
+func updateMovie(q movieQuerier, movie models.Movie) (int64, error) {
+	var version int64
+	err := q.QueryRow(`UPDATE imdb.movies SET name=$2, director=$3, genre=$4, imdb_score=$5, popularity=$6, version=version+1 WHERE id=$1 RETURNING version`,
+		movie.ID, movie.Name, movie.Director, pq.Array(nonNilGenre(movie.Genre)), movie.IMDBScore, movie.Popularity).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("%w: %s", ErrMovieNotFound, movie.ID)
+	}
+	return version, err
+}
+
+// deleteMovie removes a movie and returns the version it had
+func deleteMovie(q movieQuerier, id string) (int64, error) {
+	var version int64
+	err := q.QueryRow(`DELETE FROM imdb.movies WHERE id=$1 RETURNING version`, id).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("%w: %s", ErrMovieNotFound, id)
+	}
+	return version, err
+}
+
+// selectMovie reads a movie of imdb.movies
+func selectMovie(q movieQuerier, id string) (models.Movie, error) {
+	movie := models.Movie{ID: id}
+	err := q.QueryRow(`SELECT `+movieFields+` FROM imdb.movies WHERE id=$1`, id).Scan(movieFieldsDest(&movie)...)
+	if err == sql.ErrNoRows {
+		return models.Movie{}, fmt.Errorf("%w: %s", ErrMovieNotFound, id)
+	}
+	return movie, err
+}
+
+func nonNilGenre(genre []string) []string {
+	if genre == nil {
+		return []string{}
+	}
+	return genre
+}
+
+// sqlArgs collects the arguments of a query as it is built
+type sqlArgs []interface{}
+
+// add appends an argument and returns its placeholder
+func (a *sqlArgs) add(value interface{}) string {
+	*a = append(*a, value)
+	return "$" + strconv.Itoa(len(*a))
+}
+
+// SearchMovies runs the query against the imdb.movies table
+func (s *PostgresMovieStore) SearchMovies(query MovieQuery) (MovieResults, error) {
+	args := sqlArgs{}
+	where, score := movieWhere(query, &args)
+	// every argument added so far is used by the where clause, the highlight expressions add theirs after it
+	whereArgs := len(args)
+	if query.Ranking != nil {
+		score = rankingExpression(score, query.Ranking)
+	}
+	matching := `SELECT id, name, director, genre, imdb_score, popularity, ` + score + ` AS score`
+	if query.Highlight {
+		matching += `, ` + highlightExpression("name", query.Must, &args) + ` AS name_highlight`
+		matching += `, ` + highlightExpression("director", query.Must, &args) + ` AS director_highlight`
+	}
+	matching += ` FROM imdb.movies WHERE ` + where
+
+	results := MovieResults{Hits: []MovieHit{}}
+	err := s.db.QueryRow(`SELECT count(*) FROM imdb.movies WHERE `+where, args[:whereArgs]...).Scan(&results.Total)
+	if err != nil {
+		return MovieResults{}, err
+	}
+
+	pageArgs := append(sqlArgs{}, args...)
+	page := `SELECT * FROM (` + matching + `) m`
+	if len(query.After) > 0 {
+		page += ` WHERE ` + afterExpression(query.Sort, query.After, &pageArgs)
+	}
+	page += ` ORDER BY ` + orderExpression(query.Sort)
+	page += ` OFFSET ` + pageArgs.add(query.From) + ` LIMIT ` + pageArgs.add(query.Size)
+	rows, err := s.db.Query(page, pageArgs...)
+	if err != nil {
+		return MovieResults{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		hit := MovieHit{}
+		var movieScore float64
+		var genre pq.StringArray
+		var nameHighlight, directorHighlight sql.NullString
+		dest := []interface{}{&hit.ID, &hit.Name, &hit.Director, &genre, &hit.IMDBScore, &hit.Popularity, &movieScore}
+		if query.Highlight {
+			dest = append(dest, &nameHighlight, &directorHighlight)
+		}
+		err = rows.Scan(dest...)
+		if err != nil {
+			return MovieResults{}, err
+		}
+		hit.Genre = []string(genre)
+		hit.Sort = sortValues(hit.Movie, movieScore, query.Sort)
+		if query.Highlight {
+			hit.Highlight = map[string][]string{}
+			if nameHighlight.Valid {
+				hit.Highlight["name"] = []string{nameHighlight.String}
+			}
+			if directorHighlight.Valid {
+				hit.Highlight["director"] = []string{directorHighlight.String}
+			}
+		}
+		if query.Explain {
+			hit.Score = &movieScore
+			hit.Explanation = map[string]interface{}{
+				"value":       movieScore,
+				"description": "sum of the ts_rank and name similarity of the matched conditions times their field boost",
+			}
+		}
+		results.Hits = append(results.Hits, hit)
+	}
+	if err = rows.Err(); err != nil {
+		return MovieResults{}, err
+	}
+
+	if query.Facets {
+		results.Facets, err = s.facets(matching, args)
+		if err != nil {
+			return MovieResults{}, err
+		}
+	}
+	return results, nil
+}
+
+// movieWhere returns the where clause and the score expression of a query
+func movieWhere(query MovieQuery, args *sqlArgs) (string, string) {
+	clauses := []string{}
+	scores := []string{}
+	for _, condition := range query.Must {
+		match, score := conditionSQL(condition, args)
+		clauses = append(clauses, match)
+		scores = append(scores, score)
+	}
+	for _, condition := range query.Filter {
+		match, _ := conditionSQL(condition, args)
+		clauses = append(clauses, match)
+	}
+	for _, condition := range query.MustNot {
+		match, _ := conditionSQL(condition, args)
+		clauses = append(clauses, "NOT ("+match+")")
+	}
+	where := "TRUE"
+	if len(clauses) > 0 {
+		where = strings.Join(clauses, " AND ")
+	}
+	score := "1.0::float8"
+	if len(scores) > 0 {
+		score = "(" + strings.Join(scores, " + ") + ")::float8"
+	}
+	return where, score
+}
+
+// conditionSQL translates a condition into a boolean expression and the expression of its score
+func conditionSQL(condition Condition, args *sqlArgs) (string, string) {
+	switch condition.Kind {
+	case ConditionMatch, ConditionPhrase:
+		matches, scores := []string{}, []string{}
+		for _, field := range condition.Fields {
+			boost, ok := condition.Boosts[field]
+			if !ok {
+				boost = 1
+			}
+			match, score := textMatchSQL(field, condition, args)
+			matches = append(matches, match)
+			scores = append(scores, fmt.Sprintf("CASE WHEN %s THEN %s * %s ELSE 0 END", match, formatFloat(boost), score))
+		}
+		if len(matches) == 0 {
+			return "FALSE", "0"
+		}
+		if len(scores) == 1 {
+			return matches[0], scores[0]
+		}
+		return "(" + strings.Join(matches, " OR ") + ")", "GREATEST(" + strings.Join(scores, ", ") + ")"
+	case ConditionEquals:
+		field := condition.Fields[0]
+		if field == "genre" {
+			return args.add(condition.Value) + " = ANY(genre)", "0"
+		}
+		column, ok := movieColumns[field]
+		if !ok {
+			return "FALSE", "0"
+		}
+		if _, isNumber := condition.Value.(float64); isNumber {
+			return column + " = " + args.add(condition.Value) + "::real", "0"
+		}
+		return column + " = " + args.add(condition.Value), "0"
+	case ConditionRange:
+		column, ok := movieColumns[condition.Fields[0]]
+		if !ok || column == "name" || column == "director" {
+			return "FALSE", "0"
+		}
+		bounds := []string{}
+		for _, bound := range []struct {
+			operator string
+			value    *float64
+		}{{">=", condition.Gte}, {"<=", condition.Lte}, {">", condition.Gt}, {"<", condition.Lt}} {
+			if bound.value != nil {
+				bounds = append(bounds, column+" "+bound.operator+" "+args.add(*bound.value)+"::real")
+			}
+		}
+		if len(bounds) == 0 {
+			return "TRUE", "0"
+		}
+		return "(" + strings.Join(bounds, " AND ") + ")", "0"
+	}
+	anyMatches := []string{}
+	for _, sub := range condition.Any {
+		match, _ := conditionSQL(sub, args)
+		anyMatches = append(anyMatches, match)
+	}
+	if len(anyMatches) == 0 {
+		return "FALSE", "0"
+	}
+	return "(" + strings.Join(anyMatches, " OR ") + ")", "0"
+}
+
+// textMatchSQL matches the value of a text condition against a single field
+func textMatchSQL(field string, condition Condition, args *sqlArgs) (string, string) {
+	value := stringValue(condition.Value)
+	if field == "genre" {
+		return "genre_keys @> ARRAY[imdb.normalize_genre(" + args.add(value) + ")]", "0"
+	}
+	config, ok := textConfigs[field]
+	if !ok {
+		return "FALSE", "0"
+	}
+	tsquery := textQuery(config, condition, args)
+	if tsquery == "" {
+		return "FALSE", "0"
+	}
+	match := field + "_tsv @@ " + tsquery
+	score := "ts_rank(" + field + "_tsv, " + tsquery + ")"
+	// misspelled names are matched by trigram similarity
+	if field == "name" && condition.Kind == ConditionMatch {
+		placeholder := args.add(value)
+		match = "(" + match + " OR name % " + placeholder + ")"
+		score = "(" + score + " + similarity(name, " + placeholder + "))"
+	}
+	return match, score
+}
+
+// textQuery returns the tsquery of a text condition, "" when it has no word to search for
+func textQuery(config string, condition Condition, args *sqlArgs) string {
+	value := stringValue(condition.Value)
+	switch {
+	case condition.Kind == ConditionPhrase:
+		return "phraseto_tsquery('" + config + "', " + args.add(value) + ")"
+	case condition.AllWords:
+		return "plainto_tsquery('" + config + "', " + args.add(value) + ")"
+	}
+	words := tokenize(value)
+	if len(words) == 0 {
+		return ""
+	}
+	// words only hold letters and digits, so they cannot break the tsquery syntax
+	return "to_tsquery('" + config + "', " + args.add(strings.Join(words, " | ")) + ")"
+}
+
+// highlightExpression wraps the words of a field matched by the scored conditions in <em> tags, it is null
+// when none of them matched
+func highlightExpression(field string, conditions []Condition, args *sqlArgs) string {
+	words := []string{}
+	for _, condition := range conditions {
+		if condition.Kind != ConditionMatch && condition.Kind != ConditionPhrase {
+			continue
+		}
+		for _, conditionField := range condition.Fields {
+			if conditionField == field {
+				words = append(words, tokenize(stringValue(condition.Value))...)
+			}
+		}
+	}
+	if len(words) == 0 {
+		return "NULL::text"
+	}
+	config := textConfigs[field]
+	tsquery := "to_tsquery('" + config + "', " + args.add(strings.Join(words, " | ")) + ")"
+	return fmt.Sprintf("CASE WHEN %s_tsv @@ %s THEN ts_headline('%s', %s, %s, 'StartSel=<em>, StopSel=</em>, HighlightAll=true') END",
+		field, tsquery, config, field, tsquery)
+}
+
+// sortColumns are the expressions search results are sorted on, name is compared byte by byte like a keyword field
+var sortColumns = map[string]string{
+	"relevance":    "score",
+	"imdb_score":   "imdb_score",
+	"99popularity": "popularity",
+	"name":         `name COLLATE "C"`,
+}
+
+// orderExpression returns the order by clause of a search, the id is appended as the last tie-breaker
+func orderExpression(fields []SortField) string {
+	orders := []string{}
+	for _, field := range fields {
+		order := " DESC"
+		if field.Ascending {
+			order = " ASC"
+		}
+		orders = append(orders, sortColumns[field.Field]+order)
+	}
+	return strings.Join(append(orders, `id COLLATE "C" ASC`), ", ")
+}
+
+// afterExpression keeps the rows sorted after the given sort values, the last of them being the id
+func afterExpression(fields []SortField, after []interface{}, args *sqlArgs) string {
+	if len(after) != len(fields)+1 {
+		return "FALSE"
+	}
+	columns, values := []string{}, []string{}
+	operators := []string{}
+	for i, field := range fields {
+		columns = append(columns, sortColumns[field.Field])
+		switch field.Field {
+		case "name":
+			values = append(values, args.add(stringValue(after[i])))
+		case "relevance":
+			values = append(values, args.add(toFloat(after[i]))+"::float8")
+		default:
+			values = append(values, args.add(toFloat(after[i]))+"::real")
+		}
+		if field.Ascending {
+			operators = append(operators, ">")
+		} else {
+			operators = append(operators, "<")
+		}
+	}
+	columns = append(columns, `id COLLATE "C"`)
+	values = append(values, args.add(stringValue(after[len(fields)])))
+	operators = append(operators, ">")
+
+	// (a > x) OR (a = x AND b < y) OR ..., as the directions of the columns may differ
+	alternatives := []string{}
+	for i := range columns {
+		parts := []string{}
+		for j := 0; j < i; j++ {
+			parts = append(parts, columns[j]+" = "+values[j])
+		}
+		parts = append(parts, columns[i]+" "+operators[i]+" "+values[i])
+		alternatives = append(alternatives, "("+strings.Join(parts, " AND ")+")")
+	}
+	return "(" + strings.Join(alternatives, " OR ") + ")"
+}
+
+// facets counts the genre and director values and the imdb_score and 99popularity ranges of the matching movies
+func (s *PostgresMovieStore) facets(matching string, args sqlArgs) (map[string][]FacetBucket, error) {
+	queries := map[string]string{
+		"genre":        `SELECT g, count(DISTINCT m.id) FROM (` + matching + `) m, unnest(m.genre) g GROUP BY g ORDER BY 2 DESC, 1 LIMIT 50`,
+		"director":     `SELECT director, count(*) FROM (` + matching + `) m GROUP BY director ORDER BY 2 DESC, 1 LIMIT 20`,
+		"imdb_score":   `SELECT floor(imdb_score)::float8, count(*) FROM (` + matching + `) m GROUP BY 1 ORDER BY 1`,
+		"99popularity": `SELECT (floor(popularity / 10) * 10)::float8, count(*) FROM (` + matching + `) m GROUP BY 1 ORDER BY 1`,
+	}
+	facets := map[string][]FacetBucket{}
+	for name, query := range queries {
+		rows, err := s.db.Query(query, args...)
+		if err != nil {
+			return nil, err
+		}
+		buckets := []FacetBucket{}
+		for rows.Next() {
+			bucket := FacetBucket{}
+			if name == "genre" || name == "director" {
+				var key string
+				err = rows.Scan(&key, &bucket.Count)
+				bucket.Key = key
+			} else {
+				var key float64
+				err = rows.Scan(&key, &bucket.Count)
+				bucket.Key = key
+			}
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			buckets = append(buckets, bucket)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+		facets[name] = buckets
+	}
+	return facets, nil
+}
+
+// rankingExpression applies the functions of a ranking profile to the score expression
+func rankingExpression(score string, profile *models.RankingProfile) string {
+	functions := []string{}
+	for _, factor := range profile.FieldValueFactors {
+		value := movieColumns[factor.Field] + "::float8"
+		if factor.Factor != 0 {
+			value = "(" + value + " * " + formatFloat(factor.Factor) + ")"
+		}
+		value = modifierExpression(value, factor.Modifier)
+		if factor.Weight != 0 {
+			value = "(" + value + " * " + formatFloat(factor.Weight) + ")"
+		}
+		functions = append(functions, value)
+	}
+	for _, decay := range profile.Decays {
+		value := decayExpression(movieColumns[decay.Field]+"::float8", decay)
+		if decay.Weight != 0 {
+			value = "(" + value + " * " + formatFloat(decay.Weight) + ")"
+		}
+		functions = append(functions, value)
+	}
+	if len(functions) == 0 {
+		return score
+	}
+
+	var combined string
+	switch profile.ScoreMode {
+	case "sum":
+		combined = "(" + strings.Join(functions, " + ") + ")"
+	case "avg":
+		combined = "((" + strings.Join(functions, " + ") + ") / " + strconv.Itoa(len(functions)) + ")"
+	case "first":
+		combined = functions[0]
+	case "max":
+		combined = "GREATEST(" + strings.Join(functions, ", ") + ")"
+	case "min":
+		combined = "LEAST(" + strings.Join(functions, ", ") + ")"
+	default:
+		combined = "(" + strings.Join(functions, " * ") + ")"
+	}
+
+	switch profile.BoostMode {
+	case "replace":
+		return combined
+	case "sum":
+		return "(" + score + " + " + combined + ")"
+	case "avg":
+		return "((" + score + " + " + combined + ") / 2)"
+	case "max":
+		return "GREATEST(" + score + ", " + combined + ")"
+	case "min":
+		return "LEAST(" + score + ", " + combined + ")"
+	}
+	return "(" + score + " * " + combined + ")"
+}
+
+// modifierExpression applies a field_value_factor modifier
+func modifierExpression(value, modifier string) string {
+	switch modifier {
+	case "log":
+		return "log(" + value + ")"
+	case "log1p":
+		return "log(" + value + " + 1)"
+	case "log2p":
+		return "log(" + value + " + 2)"
+	case "ln":
+		return "ln(" + value + ")"
+	case "ln1p":
+		return "ln(" + value + " + 1)"
+	case "ln2p":
+		return "ln(" + value + " + 2)"
+	case "square":
+		return "(" + value + " ^ 2)"
+	case "sqrt":
+		return "sqrt(" + value + ")"
+	case "reciprocal":
+		return "(1 / " + value + ")"
+	}
+	return value
+}
+
+// decayExpression computes a decay function the way elasticsearch does
+func decayExpression(value string, decay models.DecayFunction) string {
+	rate := decay.Decay
+	if rate == 0 {
+		rate = 0.5
+	}
+	distance := fmt.Sprintf("GREATEST(0, abs(%s - %s) - %s)", value, formatFloat(decay.Origin), formatFloat(decay.Offset))
+	switch decay.Function {
+	case "exp":
+		return fmt.Sprintf("exp(%s * %s)", formatFloat(math.Log(rate)/decay.Scale), distance)
+	case "linear":
+		s := decay.Scale / (1 - rate)
+		return fmt.Sprintf("GREATEST(0, (%s - %s) / %s)", formatFloat(s), distance, formatFloat(s))
+	}
+	variance := -decay.Scale * decay.Scale / (2 * math.Log(rate))
+	return fmt.Sprintf("exp(-(%s ^ 2) / %s)", distance, formatFloat(2*variance))
+}
+
+// formatFloat writes a number as a SQL literal, profiles are validated so it is always finite
+func formatFloat(value float64) string {
+	return "(" + strconv.FormatFloat(value, 'g', -1, 64) + "::float8)"
+}
@@ -0,0 +1,81 @@
+package store
+
+import (
+	"github.com/raazcrzy/imdb/models"
+)
+
+// Condition kinds
+const (
+	// ConditionMatch matches movies having any word of Value in one of Fields, or every word when AllWords is set
+	ConditionMatch = "match"
+	// ConditionPhrase matches movies having the words of Value in order in one of Fields
+	ConditionPhrase = "phrase"
+	// ConditionEquals matches movies whose field is exactly Value, a float64 for numeric fields and a string otherwise
+	ConditionEquals = "equals"
+	// ConditionRange matches movies whose numeric field is within the bounds that are set
+	ConditionRange = "range"
+	// ConditionAny matches movies matching at least one of Any
+	ConditionAny = "any"
+)
+
+// Condition is a single clause of a movie search, independent of the store it runs on.
+// Fields are movie fields: name, director, genre, imdb_score or 99popularity.
+type Condition struct {
+	Kind     string             `json:"kind"`
+	Fields   []string           `json:"fields,omitempty"`
+	Value    interface{}        `json:"value,omitempty"`
+	AllWords bool               `json:"all_words,omitempty"`
+	Boosts   map[string]float64 `json:"boosts,omitempty"`
+	Gte      *float64           `json:"gte,omitempty"`
+	Lte      *float64           `json:"lte,omitempty"`
+	Gt       *float64           `json:"gt,omitempty"`
+	Lt       *float64           `json:"lt,omitempty"`
+	Any      []Condition        `json:"any,omitempty"`
+}
+
+// SortField orders search results by relevance, imdb_score, 99popularity or name
+type SortField struct {
+	Field     string `json:"field"`
+	Ascending bool   `json:"ascending"`
+}
+
+// MovieQuery describes a movie search. Must conditions are scored text matches, Filter conditions
+// only restrict the results and movies matching any MustNot condition are left out.
+// Stores order results by Sort and then by movie id, so that the order is stable.
+// After holds the sort values of the last hit of the previous page, as returned in MovieHit.Sort.
+type MovieQuery struct {
+	Must      []Condition            `json:"must,omitempty"`
+	Filter    []Condition            `json:"filter,omitempty"`
+	MustNot   []Condition            `json:"must_not,omitempty"`
+	Sort      []SortField            `json:"sort"`
+	From      int                    `json:"from"`
+	Size      int                    `json:"size"`
+	After     []interface{}          `json:"after,omitempty"`
+	Facets    bool                   `json:"facets,omitempty"`
+	Highlight bool                   `json:"highlight,omitempty"`
+	Explain   bool                   `json:"explain,omitempty"`
+	Ranking   *models.RankingProfile `json:"ranking,omitempty"`
+}
+
+// MovieHit is a movie returned by a search along with the optional highlight and scoring details
+type MovieHit struct {
+	models.Movie
+	Highlight   map[string][]string `json:"highlight,omitempty"`
+	Score       *float64            `json:"score,omitempty"`
+	Explanation interface{}         `json:"explanation,omitempty"`
+	Sort        []interface{}       `json:"-"`
+}
+
+// FacetBucket is a single count shown in the search sidebar
+type FacetBucket struct {
+	Key   interface{} `json:"key"`
+	Count int64       `json:"count"`
+}
+
+// MovieResults is a page of search results. Facets, when asked for, count the genre and director values
+// and the imdb_score and 99popularity ranges, in steps of 1 and 10, over every matching movie.
+type MovieResults struct {
+	Hits   []MovieHit
+	Total  int64
+	Facets map[string][]FacetBucket
+}
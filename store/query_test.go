@@ -0,0 +1,237 @@
+package store
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/raazcrzy/imdb/models"
+	elastic "gopkg.in/olivere/elastic.v5"
+)
+
+// testMovies is the catalogue both query builders are checked on
+var testMovies = []models.Movie{
+	{ID: "a", Name: "Star Wars", Director: "George Lucas", Genre: []string{"Sci-Fi", "Action"}, IMDBScore: 8.6, Popularity: 88},
+	{ID: "b", Name: "Psycho", Director: "Alfred Hitchcock", Genre: []string{"Horror", "Thriller"}, IMDBScore: 8.5, Popularity: 85},
+	{ID: "c", Name: "Vertigo", Director: "Alfred Hitchcock", Genre: []string{"Mystery", "Thriller"}, IMDBScore: 8.3, Popularity: 83},
+	{ID: "d", Name: "The Birds", Director: "Alfred Hitchcock", Genre: []string{"Horror"}, IMDBScore: 7.7, Popularity: 83},
+	{ID: "e", Name: "Alien", Director: "Ridley Scott", Genre: []string{"Sci-Fi", "Horror"}, IMDBScore: 8.5, Popularity: 87},
+}
+
+// source returns the JSON of an elastic query or sorter, decoded into generic values
+func source(t *testing.T, value interface{ Source() (interface{}, error) }) interface{} {
+	t.Helper()
+	src, err := value.Source()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded interface{}
+	err = json.Unmarshal(data, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+// clauses returns the queries of a bool clause, which is a single query or a list of them
+func clauses(clause interface{}) []interface{} {
+	if list, ok := clause.([]interface{}); ok {
+		return list
+	}
+	if clause == nil {
+		return nil
+	}
+	return []interface{}{clause}
+}
+
+// keywordValues returns the values of a movie field as elasticsearch indexes them, numbers as float32
+func keywordValues(movie models.Movie, field string) []interface{} {
+	switch strings.TrimSuffix(field, ".keyword") {
+	case "name":
+		return []interface{}{movie.Name}
+	case "director":
+		return []interface{}{movie.Director}
+	case "genre":
+		values := []interface{}{}
+		for _, genre := range movie.Genre {
+			values = append(values, genre)
+		}
+		return values
+	case "imdb_score":
+		return []interface{}{float64(movie.IMDBScore)}
+	case "99popularity":
+		return []interface{}{float64(movie.Popularity)}
+	case "movie_id":
+		return []interface{}{movie.ID}
+	}
+	return nil
+}
+
+// esFilter evaluates the filter context of an elasticsearch query JSON on a movie, for the bool, term and range
+// queries the filters and sort of a search are built with
+func esFilter(t *testing.T, query interface{}, movie models.Movie) bool {
+	t.Helper()
+	for kind, body := range query.(map[string]interface{}) {
+		switch kind {
+		case "match_all":
+			return true
+		case "bool":
+			boolQuery := body.(map[string]interface{})
+			for _, clause := range append(clauses(boolQuery["must"]), clauses(boolQuery["filter"])...) {
+				if !esFilter(t, clause, movie) {
+					return false
+				}
+			}
+			for _, clause := range clauses(boolQuery["must_not"]) {
+				if esFilter(t, clause, movie) {
+					return false
+				}
+			}
+			should := clauses(boolQuery["should"])
+			if len(should) == 0 {
+				return true
+			}
+			for _, clause := range should {
+				if esFilter(t, clause, movie) {
+					return true
+				}
+			}
+			return false
+		case "term":
+			for field, value := range body.(map[string]interface{}) {
+				if number, ok := value.(float64); ok {
+					// float fields hold float32 values, so does the term
+					value = float64(float32(number))
+				}
+				for _, indexed := range keywordValues(movie, field) {
+					if indexed == value {
+						return true
+					}
+				}
+			}
+			return false
+		case "range":
+			for field, bounds := range body.(map[string]interface{}) {
+				number := keywordValues(movie, field)[0].(float64)
+				for op, bound := range bounds.(map[string]interface{}) {
+					bound := float64(float32(bound.(float64)))
+					if op == "gte" && number < bound || op == "lte" && number > bound || op == "gt" && number <= bound || op == "lt" && number >= bound {
+						return false
+					}
+				}
+			}
+			return true
+		}
+		t.Fatalf("unexpected %s query in a filter", kind)
+	}
+	return false
+}
+
+// esSort orders the movies with the sorters of an elasticsearch search, every movie scoring the same
+func esSort(t *testing.T, sorters []elastic.Sorter, movies []models.Movie) []string {
+	sorted := append([]models.Movie{}, movies...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		for _, sorter := range sorters {
+			for field, options := range source(t, sorter).(map[string]interface{}) {
+				if field == "_score" {
+					continue
+				}
+				a, b := keywordValues(sorted[i], field)[0], keywordValues(sorted[j], field)[0]
+				result := compareValues(a, b)
+				if options.(map[string]interface{})["order"] == "desc" {
+					result = -result
+				}
+				if result != 0 {
+					return result < 0
+				}
+			}
+		}
+		return false
+	})
+	ids := []string{}
+	for _, movie := range sorted {
+		ids = append(ids, movie.ID)
+	}
+	return ids
+}
+
+// memorySearch runs a query on a memory store holding testMovies and returns the ids of the hits in order
+func memorySearch(t *testing.T, query MovieQuery) []string {
+	t.Helper()
+	movies := NewMemoryMovieStore(NewMemoryEventStore())
+	for _, movie := range testMovies {
+		movies.movies[movie.ID] = movie
+	}
+	query.Size = len(testMovies)
+	results, err := movies.SearchMovies(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, hit := range results.Hits {
+		ids = append(ids, hit.ID)
+	}
+	return ids
+}
+
+func float(value float64) *float64 {
+	return &value
+}
+
+// TestQueryBuildersAgree checks that the memory store and the elasticsearch query keep the same movies for the
+// filters of a search, and order them the same way
+func TestQueryBuildersAgree(t *testing.T) {
+	horror := Condition{Kind: ConditionEquals, Fields: []string{"genre"}, Value: "Horror"}
+	thriller := Condition{Kind: ConditionEquals, Fields: []string{"genre"}, Value: "Thriller"}
+	tests := []struct {
+		name  string
+		query MovieQuery
+	}{
+		{"no filter", MovieQuery{}},
+		{"genre", MovieQuery{Filter: []Condition{horror}}},
+		{"all genres", MovieQuery{Filter: []Condition{horror, thriller}}},
+		{"any genre", MovieQuery{Filter: []Condition{{Kind: ConditionAny, Any: []Condition{horror, thriller}}}}},
+		{"not a genre", MovieQuery{MustNot: []Condition{horror}}},
+		{"director", MovieQuery{Filter: []Condition{{Kind: ConditionEquals, Fields: []string{"director"}, Value: "Alfred Hitchcock"}}}},
+		{"exact score", MovieQuery{Filter: []Condition{{Kind: ConditionEquals, Fields: []string{"imdb_score"}, Value: 8.5}}}},
+		{"score range", MovieQuery{Filter: []Condition{{Kind: ConditionRange, Fields: []string{"imdb_score"}, Gte: float(8.3), Lte: float(8.5)}}}},
+		{"exclusive popularity range", MovieQuery{Filter: []Condition{{Kind: ConditionRange, Fields: []string{"99popularity"}, Gt: float(83), Lt: float(88)}}}},
+		{"range and not a genre", MovieQuery{
+			Filter:  []Condition{{Kind: ConditionRange, Fields: []string{"imdb_score"}, Gte: float(8)}},
+			MustNot: []Condition{{Kind: ConditionAny, Any: []Condition{horror, {Kind: ConditionEquals, Fields: []string{"name"}, Value: "Vertigo"}}}},
+		}},
+	}
+	sorts := [][]SortField{
+		nil,
+		{{Field: "imdb_score"}},
+		{{Field: "imdb_score", Ascending: true}},
+		{{Field: "99popularity"}, {Field: "name", Ascending: true}},
+		{{Field: "name"}},
+		{{Field: "relevance"}, {Field: "99popularity", Ascending: true}},
+	}
+	for _, test := range tests {
+		for _, fields := range sorts {
+			query := test.query
+			query.Sort = fields
+			memory := memorySearch(t, query)
+
+			esQuery := source(t, elasticQuery(query))
+			kept := []models.Movie{}
+			for _, movie := range testMovies {
+				if esFilter(t, esQuery, movie) {
+					kept = append(kept, movie)
+				}
+			}
+			es := esSort(t, elasticSorters(query.Sort), kept)
+			if !reflect.DeepEqual(memory, es) {
+				t.Errorf("%s sorted by %v: memory store got %v, elasticsearch %v", test.name, fields, memory, es)
+			}
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/raazcrzy/imdb/dbConnections"
+	"github.com/raazcrzy/imdb/models"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+const repairPage = 500
+
+// movieRecord is a movie along with its version in imdb.movies or in the movie index
+type movieRecord struct {
+	movie   models.Movie
+	version int64
+}
+
+// movieCursor walks the movies of one store in id order, a page at a time
+type movieCursor struct {
+	fetch func(after string) ([]movieRecord, error)
+	page  []movieRecord
+	after string
+	done  bool
+}
+
+// peek returns the current movie, nil once every movie was walked
+func (c *movieCursor) peek() (*movieRecord, error) {
+	if len(c.page) == 0 && !c.done {
+		page, err := c.fetch(c.after)
+		if err != nil {
+			return nil, err
+		}
+		if len(page) == 0 {
+			c.done = true
+		} else {
+			c.page, c.after = page, page[len(page)-1].movie.ID
+		}
+	}
+	if len(c.page) == 0 {
+		return nil, nil
+	}
+	return &c.page[0], nil
+}
+
+func (c *movieCursor) pop() {
+	c.page = c.page[1:]
+}
+
+// movieRepair reconciles a movie index, or the alias of one, with the imdb.movies table of db
+type movieRepair struct {
+	db    *sql.DB
+	index string
+}
+
+// RepairMovies reconciles the movie index with imdb.movies, which is the reference.
+// Movies missing from the index or different in it are written again through the outbox.
+// Documents without a row are deleted again when the outbox holds their delete, otherwise they were written
+// before the outbox existed and are imported into imdb.movies.
+func RepairMovies(db *sql.DB, index string) (models.MovieRepair, error) {
+	r := movieRepair{db: db, index: index}
+	report := models.MovieRepair{}
+	stored := &movieCursor{fetch: r.storedMovies}
+	indexed := &movieCursor{fetch: r.indexedMovies}
+	for {
+		row, err := stored.peek()
+		if err != nil {
+			return report, err
+		}
+		document, err := indexed.peek()
+		if err != nil {
+			return report, err
+		}
+		switch {
+		case row == nil && document == nil:
+			return report, nil
+		case document == nil || (row != nil && row.movie.ID < document.movie.ID):
+			report.Movies++
+			report.Missing++
+			err = r.rewriteMovie(row.movie.ID, 0)
+			stored.pop()
+		case row == nil || document.movie.ID < row.movie.ID:
+			report.Documents++
+			var imported, deleted bool
+			imported, deleted, err = r.adoptDocument(*document)
+			if imported {
+				report.Imported++
+			}
+			if deleted {
+				report.Deleted++
+			}
+			indexed.pop()
+		default:
+			report.Movies++
+			report.Documents++
+			if !sameMovie(row.movie, document.movie) {
+				report.Changed++
+				err = r.rewriteMovie(row.movie.ID, document.version)
+			}
+			stored.pop()
+			indexed.pop()
+		}
+		if err != nil {
+			return report, err
+		}
+	}
+}
+
+// ImportElasticMovies imports the movies of the movie index into imdb.movies when both it and the outbox are empty,
+// which is the case on the first start after the outbox was introduced. It returns the number of imported movies.
+func ImportElasticMovies(db *sql.DB, index string) (int64, error) {
+	var count int64
+	err := db.QueryRow(`SELECT (SELECT count(*) FROM imdb.movies) + (SELECT count(*) FROM imdb.movie_outbox)`).Scan(&count)
+	if err != nil || count > 0 {
+		return 0, err
+	}
+	report, err := RepairMovies(db, index)
+	return report.Imported, err
+}
+
+func (r movieRepair) storedMovies(after string) ([]movieRecord, error) {
+	rows, err := r.db.Query(`SELECT id, `+movieFields+`, version FROM imdb.movies
+		WHERE id COLLATE "C" > $1 ORDER BY id COLLATE "C" LIMIT $2`, after, repairPage)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	records := []movieRecord{}
+	for rows.Next() {
+		record := movieRecord{}
+		dest := append([]interface{}{&record.movie.ID}, movieFieldsDest(&record.movie)...)
+		err = rows.Scan(append(dest, &record.version)...)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// indexedMovies pages through the movie index in movie_id order, which is the byte order of imdb.movies ids in the C collation
+func (r movieRepair) indexedMovies(after string) ([]movieRecord, error) {
+	source := elastic.NewSearchSource().Query(elastic.NewMatchAllQuery()).Sort("movie_id", true).Size(repairPage).Version(true)
+	if after != "" {
+		source = source.SearchAfter(after)
+	}
+	response, err := dbConnections.SearchIndex(context.Background(), r.index, source)
+	if err != nil {
+		return nil, err
+	}
+	records := []movieRecord{}
+	for _, hit := range response.Hits.Hits {
+		record := movieRecord{}
+		err = json.Unmarshal(*hit.Source, &record.movie)
+		if err != nil {
+			return nil, err
+		}
+		record.movie.ID = hit.Id
+		if hit.Version != nil {
+			record.version = *hit.Version
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func sameMovie(a, b models.Movie) bool {
+	if a.Name != b.Name || a.Director != b.Director || a.IMDBScore != b.IMDBScore || a.Popularity != b.Popularity || len(a.Genre) != len(b.Genre) {
+		return false
+	}
+	for i := range a.Genre {
+		if a.Genre[i] != b.Genre[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// rewriteMovie records an upsert of a movie at a version above the one of its document, indexedVersion,
+// so that the document is replaced even when it is ahead of imdb.movies
+func (r movieRepair) rewriteMovie(id string, indexedVersion int64) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	movie := models.Movie{ID: id}
+	var version int64
+	err = tx.QueryRow(`UPDATE imdb.movies SET version=GREATEST(version, $2)+1 WHERE id=$1
+		RETURNING `+movieFields+`, version`, id, indexedVersion).Scan(append(movieFieldsDest(&movie), &version)...)
+	if err == sql.ErrNoRows {
+		// deleted since it was read, the delete is in the outbox
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = dbConnections.EnqueueMovieEvent(tx, id, version, &movie)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// adoptDocument imports a document without a row into imdb.movies, unless the outbox holds a delete of the movie,
+// in which case the delete is recorded again. A row and its delete are committed together, so one of them is seen.
+func (r movieRepair) adoptDocument(document movieRecord) (imported bool, deleted bool, err error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, false, err
+	}
+	defer tx.Rollback()
+	var exists bool
+	var deletedVersion sql.NullInt64
+	err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM imdb.movies WHERE id=$1),
+		(SELECT max(version) FROM imdb.movie_outbox WHERE movie_id=$1 AND operation=$2)`, document.movie.ID, dbConnections.OutboxDelete).
+		Scan(&exists, &deletedVersion)
+	if err != nil {
+		return false, false, err
+	}
+	if exists {
+		// added since the index was read
+		return false, false, nil
+	}
+	if deletedVersion.Valid {
+		version := deletedVersion.Int64
+		if document.version >= version {
+			version = document.version + 1
+		}
+		err = dbConnections.EnqueueMovieEvent(tx, document.movie.ID, version, nil)
+		if err != nil {
+			return false, false, err
+		}
+		return false, true, tx.Commit()
+	}
+	// another repair may import it at the same time
+	err = importMovie(tx, document.movie, document.version)
+	if err != nil {
+		return false, false, err
+	}
+	return true, false, tx.Commit()
+}
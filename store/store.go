@@ -0,0 +1,45 @@
+// Package store defines how the service reads and writes users and movies, so that the handlers do not
+// depend on a particular database. Users are kept in Postgres and movies in Elasticsearch in production,
+// the memory stores keep everything in process for tests and local development.
+package store
+
+import (
+	"errors"
+
+	"github.com/raazcrzy/imdb/models"
+)
+
+// Errors returned by the stores for conditions the handlers report to clients
+var (
+	ErrUserExists    = errors.New("User already exists")
+	ErrUserNameTaken = errors.New("user_name not unique")
+	ErrMovieNotFound = errors.New("movie not found")
+)
+
+// UserStore reads and writes the users of the service
+type UserStore interface {
+	// EmailForCredentials returns the email of the user with the given user name and password, "" when there is none
+	EmailForCredentials(userName, password string) (string, error)
+	// Role returns the role of the user with the given email, "" when there is none
+	Role(email string) (string, error)
+	// HasUserName reports whether the user with the given email has the given user name
+	HasUserName(email, userName string) (bool, error)
+	// CreateUser adds a user, failing with ErrUserExists or ErrUserNameTaken when the email or user name is in use
+	CreateUser(user models.User) error
+	// DeleteUser removes the user with the given email, if any
+	DeleteUser(email string) error
+}
+
+// MovieStore reads, writes and searches the movies of the catalogue
+type MovieStore interface {
+	// AddMovie adds a movie and returns the id it was given, the id of the movie passed in is ignored
+	AddMovie(movie models.Movie) (string, error)
+	// EditMovie replaces every field of the movie with the id of the given one
+	EditMovie(movie models.Movie) error
+	// DeleteMovie removes the movie with the given id
+	DeleteMovie(id string) error
+	// GetMovie returns the movie with the given id, failing with ErrMovieNotFound when there is none
+	GetMovie(id string) (models.Movie, error)
+	// SearchMovies returns a page of the movies matching the query
+	SearchMovies(query MovieQuery) (MovieResults, error)
+}
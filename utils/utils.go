@@ -31,6 +31,15 @@ var ElasticURL string
 // Elasticconn is the client to connect to elasticsearch
 var Elasticconn *elastic.Client
 
+// ElasticDistribution is the distribution of the elasticsearch cluster, elasticsearch or opensearch
+var ElasticDistribution string
+
+// ElasticVersion is the version number of the elasticsearch cluster
+var ElasticVersion string
+
+// ElasticTypeless is set for clusters without mapping types: elasticsearch 7 and later, and opensearch
+var ElasticTypeless bool
+
 // LogLevel to set the log level. allowed levels are INFO, DEBUG, ERROR
 var LogLevel string
 
@@ -40,34 +49,96 @@ var Admins []string
 // MovieIndex is the name of elasticsearch index where movie data is saved
 var MovieIndex string
 
-// ReadEnvironmentVariables reads and sets the env vars
-func ReadEnvironmentVariables() {
+// CursorSecret is the key used to sign the search cursors handed out to clients
+var CursorSecret string
+
+// SynonymsFile is the path the service writes the managed synonyms file to, the synonyms are read from it until they
+// are first updated
+var SynonymsFile string
+
+// SynonymsPath is the path of the synonyms file in the config directory of the elasticsearch nodes
+var SynonymsPath string
+
+// GRPCAddress is the address the gRPC server listens on, it is not started when empty
+var GRPCAddress string
+
+// GRPCCertFile and GRPCKeyFile are the TLS certificate and key of the gRPC server, which needs HTTP/2 over TLS
+var GRPCCertFile, GRPCKeyFile string
+
+// Storage backends
+const (
+	PostgresStorage      = "postgres"
+	ElasticsearchStorage = "elasticsearch"
+	MemoryStorage        = "memory"
+)
+
+// UserStorage is where users are kept: postgres, the default, or memory
+var UserStorage string
+
+// MovieStorage is where movies are kept and searched: elasticsearch, the default, postgres or memory
+var MovieStorage string
+
+// UsesPostgres reports whether postgres is needed. Besides users, it holds ranking profiles and reindex jobs,
+// so only running entirely in memory does without it.
+func UsesPostgres() bool {
+	return UserStorage != MemoryStorage || MovieStorage != MemoryStorage
+}
+
+// MoviesInPostgres reports whether movies are written to postgres, which holds the movie events with them
+func MoviesInPostgres() bool {
+	return MovieStorage != MemoryStorage
+}
+
+// UsesElasticsearch reports whether movies are kept in elasticsearch
+func UsesElasticsearch() bool {
+	return MovieStorage == ElasticsearchStorage
+}
+
+// ReadEnvironmentVariables reads and sets the env vars, loading them from the given env files outside production
+func ReadEnvironmentVariables(envFiles []string) {
 	if os.Getenv("IMDB_ENV") != "PRODUCTION" {
-		filePath := os.Args[1:]
-		err := godotenv.Load(filePath...)
+		err := godotenv.Load(envFiles...)
 		if err != nil {
 			log.Fatal("Error loading .env file")
 		}
 	}
-	SQLHost = os.Getenv("SQLHost")
-	if SQLHost == "" {
-		log.Fatalln("SQLHost env var not set")
+	UserStorage = os.Getenv("UserStorage")
+	if UserStorage == "" {
+		UserStorage = PostgresStorage
 	}
-	SQLUser = os.Getenv("SQLUser")
-	if SQLUser == "" {
-		log.Fatalln("SQLUser env var not set")
+	if UserStorage != PostgresStorage && UserStorage != MemoryStorage {
+		log.Fatalln("UserStorage env var must be one of: postgres, memory")
 	}
-	SQLPassword = os.Getenv("SQLPassword")
-	if SQLPassword == "" {
-		log.Fatalln("SQLPassword env var not set")
+	MovieStorage = os.Getenv("MovieStorage")
+	if MovieStorage == "" {
+		MovieStorage = ElasticsearchStorage
 	}
-	SQLDb = os.Getenv("SQLDb")
-	if SQLDb == "" {
-		log.Fatalln("SQLDb env var not set")
+	if MovieStorage != ElasticsearchStorage && MovieStorage != PostgresStorage && MovieStorage != MemoryStorage {
+		log.Fatalln("MovieStorage env var must be one of: elasticsearch, postgres, memory")
+	}
+	if UsesPostgres() {
+		SQLHost = os.Getenv("SQLHost")
+		if SQLHost == "" {
+			log.Fatalln("SQLHost env var not set")
+		}
+		SQLUser = os.Getenv("SQLUser")
+		if SQLUser == "" {
+			log.Fatalln("SQLUser env var not set")
+		}
+		SQLPassword = os.Getenv("SQLPassword")
+		if SQLPassword == "" {
+			log.Fatalln("SQLPassword env var not set")
+		}
+		SQLDb = os.Getenv("SQLDb")
+		if SQLDb == "" {
+			log.Fatalln("SQLDb env var not set")
+		}
 	}
-	ElasticURL = os.Getenv("ElasticURL")
-	if ElasticURL == "" {
-		log.Fatalln("ElasticURL env var not set")
+	if UsesElasticsearch() {
+		ElasticURL = os.Getenv("ElasticURL")
+		if ElasticURL == "" {
+			log.Fatalln("ElasticURL env var not set")
+		}
 	}
 	LogLevel = os.Getenv("LogLevel")
 	if LogLevel == "" {
@@ -82,4 +153,22 @@ func ReadEnvironmentVariables() {
 	if LogLevel == "" {
 		log.Fatalln("MovieIndex env var not set")
 	}
+	SynonymsFile = os.Getenv("SynonymsFile")
+	if SynonymsFile == "" {
+		SynonymsFile = "synonyms.txt"
+	}
+	SynonymsPath = os.Getenv("SynonymsPath")
+	if SynonymsPath == "" {
+		SynonymsPath = "analysis/imdb_synonyms.txt"
+	}
+	CursorSecret = os.Getenv("CursorSecret")
+	if CursorSecret == "" {
+		log.Fatalln("CursorSecret env var not set")
+	}
+	GRPCAddress = os.Getenv("GRPCAddress")
+	GRPCCertFile = os.Getenv("GRPCCertFile")
+	GRPCKeyFile = os.Getenv("GRPCKeyFile")
+	if GRPCAddress != "" && (GRPCCertFile == "" || GRPCKeyFile == "") {
+		log.Fatalln("GRPCCertFile and GRPCKeyFile env vars not set, the gRPC server on GRPCAddress needs TLS")
+	}
 }
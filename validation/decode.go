@@ -0,0 +1,70 @@
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/raazcrzy/imdb/apierror"
+)
+
+// MaxBodyBytes is the size limit of request bodies
+const MaxBodyBytes = 1 << 20
+
+// DecodeBody decodes the JSON object of a request body into value. Unknown fields, data after the object
+// and bodies larger than MaxBodyBytes are rejected with an *apierror.Error.
+func DecodeBody(w http.ResponseWriter, r *http.Request, value interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxBodyBytes))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(value)
+	if err == nil {
+		if _, err = decoder.Token(); err == io.EOF {
+			return nil
+		}
+		if err == nil {
+			return apierror.Validation("request body must hold a single JSON object")
+		}
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case err == io.EOF:
+		return apierror.Validation("request body is empty")
+	// http.MaxBytesReader has no error type of its own
+	case err.Error() == "http: request body too large":
+		return apierror.New(apierror.PayloadTooLarge, "request body must not be larger than 1MB")
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return apierror.Validation("request body has an unknown field", apierror.Field(field, "is not a known field"))
+	case errors.As(err, &typeErr):
+		return apierror.Validation("request body has a field of the wrong type", apierror.Field(typeErr.Field, "must be "+jsonType(typeErr.Type)))
+	}
+	return apierror.Validation("Unable to decode request body")
+}
+
+// Check returns a validation_failed error listing every field of value that fails its rules, nil when none does
+func Check(value interface{}) error {
+	fields := Struct(value)
+	if len(fields) > 0 {
+		return apierror.Validation("one or more fields are invalid", fields...)
+	}
+	return nil
+}
+
+// jsonType names the JSON type of a Go type in error messages
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice:
+		return "an array of " + strings.TrimPrefix(strings.TrimPrefix(jsonType(t.Elem()), "a "), "an ") + "s"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	}
+	return "a number"
+}
@@ -0,0 +1,177 @@
+// Package validation checks the values sent to the API against the rules of their validate struct tags
+// and decodes request bodies strictly.
+package validation
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/raazcrzy/imdb/apierror"
+	"github.com/raazcrzy/imdb/models"
+)
+
+/*
+The validate tag of a field holds comma separated rules, each checked independently so that every error of a value
+is reported at once. Fields are named after their json tag in the errors.
+
+	required     the value is not empty: a non blank string, a non empty slice
+	email        the string is an email address
+	url          the string is an absolute http or https URL
+	maxlen=n     the string has at most n characters
+	min=n, max=n the number is within the bounds
+	oneof=a b    the string is one of the space separated values
+	in=set       the string, or every string of the slice, is one of a named set, compared ignoring case and surrounding spaces
+
+Rules other than required are not checked on empty values.
+*/
+
+var emailRegexp = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+
+// Sets are the named sets of the in rule
+var Sets = map[string][]string{
+	"genres": models.Genres,
+	"events": models.MovieEventTypes,
+}
+
+// Struct checks the fields of a struct, or of a pointer to one, and returns the errors of every failed rule
+func Struct(value interface{}) []apierror.FieldError {
+	v := reflect.Indirect(reflect.ValueOf(value))
+	t := v.Type()
+	fields := []apierror.FieldError{}
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("validate")
+		if tag == "" {
+			continue
+		}
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = t.Field(i).Name
+		}
+		fields = append(fields, checkField(name, v.Field(i), tag)...)
+	}
+	return fields
+}
+
+// Email reports whether a string is an email address
+func Email(value string) bool {
+	return emailRegexp.MatchString(value)
+}
+
+// URL reports whether a string is an absolute http or https URL
+func URL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// Rules returns the rules of a validate tag by name, along with their value, "" for rules without one
+func Rules(tag string) map[string]string {
+	rules := map[string]string{}
+	for _, rule := range strings.Split(tag, ",") {
+		parts := strings.SplitN(rule, "=", 2)
+		if len(parts) == 1 {
+			parts = append(parts, "")
+		}
+		rules[parts[0]] = parts[1]
+	}
+	return rules
+}
+
+func checkField(name string, value reflect.Value, tag string) []apierror.FieldError {
+	fields := []apierror.FieldError{}
+	rules := Rules(tag)
+	if isEmpty(value) {
+		if _, ok := rules["required"]; ok {
+			fields = append(fields, apierror.Field(name, "is required"))
+		}
+		return fields
+	}
+
+	if _, ok := rules["email"]; ok && !Email(value.String()) {
+		fields = append(fields, apierror.Field(name, "must be a valid email address"))
+	}
+	if _, ok := rules["url"]; ok && !URL(value.String()) {
+		fields = append(fields, apierror.Field(name, "must be an http or https URL"))
+	}
+	if limit, ok := rules["maxlen"]; ok {
+		if n, _ := strconv.Atoi(limit); utf8.RuneCountInString(value.String()) > n {
+			fields = append(fields, apierror.Field(name, fmt.Sprintf("must be at most %d characters", n)))
+		}
+	}
+	if message := checkBounds(value, rules); message != "" {
+		fields = append(fields, apierror.Field(name, message))
+	}
+	if values, ok := rules["oneof"]; ok {
+		allowed := strings.Fields(values)
+		if !contains(allowed, value.String(), false) {
+			fields = append(fields, apierror.Field(name, "must be one of: "+strings.Join(allowed, ", ")))
+		}
+	}
+	if set, ok := rules["in"]; ok {
+		if value.Kind() == reflect.Slice {
+			for i := 0; i < value.Len(); i++ {
+				if !contains(Sets[set], value.Index(i).String(), true) {
+					fields = append(fields, apierror.Field(fmt.Sprintf("%s[%d]", name, i), "must be one of the "+set+": "+strings.Join(Sets[set], ", ")))
+				}
+			}
+		} else if !contains(Sets[set], value.String(), true) {
+			fields = append(fields, apierror.Field(name, "must be one of the "+set+": "+strings.Join(Sets[set], ", ")))
+		}
+	}
+	return fields
+}
+
+// checkBounds returns the message of a number outside the bounds of the min and max rules, "" when it is within them
+func checkBounds(value reflect.Value, rules map[string]string) string {
+	minRule, hasMin := rules["min"]
+	maxRule, hasMax := rules["max"]
+	if !hasMin && !hasMax {
+		return ""
+	}
+	var number float64
+	switch value.Kind() {
+	case reflect.Float32, reflect.Float64:
+		number = value.Float()
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		number = float64(value.Int())
+	default:
+		return ""
+	}
+	min, _ := strconv.ParseFloat(minRule, 64)
+	max, _ := strconv.ParseFloat(maxRule, 64)
+	switch {
+	case hasMin && hasMax && (number < min || number > max):
+		return fmt.Sprintf("must be between %s and %s", minRule, maxRule)
+	case hasMin && !hasMax && number < min:
+		return "must be at least " + minRule
+	case hasMax && !hasMin && number > max:
+		return "must be at most " + maxRule
+	}
+	return ""
+}
+
+// isEmpty reports whether a value is blank, numbers are never empty as 0 is a valid value
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return value.IsNil()
+	}
+	return false
+}
+
+func contains(values []string, value string, fold bool) bool {
+	for _, allowed := range values {
+		if allowed == value || (fold && strings.EqualFold(allowed, strings.TrimSpace(value))) {
+			return true
+		}
+	}
+	return false
+}